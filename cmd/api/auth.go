@@ -21,6 +21,8 @@ const userContextKey = contextKey("user")
 // key.
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
 	ctx := context.WithValue(r.Context(), userContextKey, user)
+	// record the user for the access log
+	app.contextSetRequestUserID(r, user.ID)
 	//app.log.PrintInfo("set user in request context", map[string]string{"name": user.Name, "email": user.Email})
 	return r.WithContext(ctx)
 }
@@ -33,6 +35,30 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 	return user
 }
 
+// contextGetUserID() returns the ID of the authenticated user, or 0 for anonymous requests
+// and routes that don't authenticate
+func (app *application) contextGetUserID(r *http.Request) int32 {
+	user, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		return 0
+	}
+	return user.ID
+}
+
+// userHasPermission() reports whether the authenticated user has been granted the
+// permission. Anonymous users have no permissions.
+func (app *application) userHasPermission(r *http.Request, code string) (bool, error) {
+	user := app.contextGetUser(r)
+	if user.IsAnonymous() {
+		return false, nil
+	}
+	permissions, err := app.models.Auth.GetAllPermissionsForUser(r.Context(), user.ID)
+	if err != nil {
+		return false, err
+	}
+	return permissions.Include(code), nil
+}
+
 // we will have a middleware to check if they provided the correct API key
 // authenticate() is a middleware that checks if the user provided the correct API key
 // We read the API key from the request, get the user for the API key, if the user exists we continue
@@ -44,7 +70,6 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		w.Header().Add("Vary", "X-API-Key")
 		// get the API key from the request
 		authorizationHeader := r.Header.Get("ApiKey")
-		app.logger.Info("authenticating user", zap.String("Authorization Header", authorizationHeader))
 		if authorizationHeader == "" {
 			app.logger.Info("no authorization header found")
 			r = app.contextSetUser(r, data.AnonymousUser)
@@ -57,15 +82,17 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		v := validator.New()
 		if data.ValidateTokenPlaintext(v, apiKey); !v.Valid() {
 			app.logger.Info("invalid api key", zap.Any("error", v.Errors))
+			app.metrics.authFailures.WithLabelValues("malformed_api_key").Inc()
 			app.invalidAuthenticationTokenResponse(w, r)
 			return
 		}
 		// get the user for the API key
-		user, err := app.models.Auth.GetForApiKey(apiKey)
+		user, err := app.models.Auth.GetForApiKey(r.Context(), apiKey)
 		if err != nil {
 			app.logger.Info("authenticating use failed", zap.Error(err))
 			switch {
 			case err == data.ErrUserNotFound:
+				app.metrics.authFailures.WithLabelValues("invalid_api_key").Inc()
 				app.invalidAuthenticationTokenResponse(w, r)
 			default:
 				app.serverErrorResponse(w, r, err)
@@ -93,6 +120,7 @@ func (app *application) requireAuthenticatedUser(next http.Handler) http.Handler
 		// inform the client that they should authenticate before trying again.
 		if user.IsAnonymous() {
 			app.logger.Info("user", zap.Any("user is anonymous", user))
+			app.metrics.authFailures.WithLabelValues("missing_api_key").Inc()
 			app.authenticationRequiredResponse(w, r)
 			return
 		}
@@ -112,7 +140,7 @@ func (app *application) testHandler(w http.ResponseWriter, r *http.Request) {
 	user := &data.User{
 		Email: input.Email,
 	}
-	app.logger.Info("user", zap.String("Token", user.Email))
+	app.logger.Info("generating api key", zap.String("email", user.Email))
 	// generate a new api key
 	apiKey, err := app.models.Auth.GenerateToken()
 	if err != nil {
@@ -120,7 +148,6 @@ func (app *application) testHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	user.ApiKey = *apiKey
-	app.logger.Info("user", zap.Any("Your Api Key", user.ApiKey))
 	// output the hashed api key and user
 	err = app.writeJSON(w, http.StatusOK, envelope{"Your Api Key": user.ApiKey}, nil)
 	if err != nil {
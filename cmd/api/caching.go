@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// The Cache-Control policies of our routes. Error responses are never cached, whatever the
+// policy of their route, see errorResponse().
+const (
+	// cacheControlNoStore is for responses that are stale as soon as they are sent, e.g the
+	// health checks
+	cacheControlNoStore = "no-store"
+	// cacheControlPrivateNoStore is for responses holding personal data, e.g house holds,
+	// which must not be kept by the client or any shared cache
+	cacheControlPrivateNoStore = "private, no-store"
+	// cacheControlRevalidate is for responses that may be kept but change often, e.g program
+	// capacity, the client must revalidate them with their ETag before reusing them
+	cacheControlRevalidate = "no-cache"
+	// cacheControlPublic is for responses that are the same for every client and only change
+	// when we deploy, e.g the OpenAPI specification
+	cacheControlPublic = "public, max-age=300"
+)
+
+// cacheControl() returns a middleware that sets the Cache-Control policy of a route
+func (app *application) cacheControl(policy string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", policy)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// conditionalGET() is a middleware that lets clients skip re-downloading unchanged GET
+// responses. Successful responses are buffered and sent with a strong ETag, the hash of
+// their body, unless the handler set one. Clients sending a matching If-None-Match, or an
+// If-Modified-Since no older than the Last-Modified set by the handler, get a 304 without a
+// body. As in RFC 9110, If-Modified-Since is ignored when If-None-Match is sent.
+func (app *application) conditionalGET(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			next.ServeHTTP(w, r)
+			return
+		}
+		var response bytes.Buffer
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		ww.Tee(&response)
+		ww.Discard()
+		next.ServeHTTP(ww, r)
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		if status == http.StatusOK {
+			if w.Header().Get("ETag") == "" {
+				w.Header().Set("ETag", strongETag(response.Bytes()))
+			}
+			if notModified(r, w.Header()) {
+				// a 304 carries the validators and caching headers, but nothing describing a body
+				w.Header().Del("Content-Type")
+				w.Header().Del("Content-Length")
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		w.WriteHeader(status)
+		w.Write(response.Bytes())
+	})
+}
+
+// setValidators() sets the ETag conditionalGET() would send for the data and reports whether
+// the request will be answered with a 304. Handlers call it when they must know before
+// responding, e.g to only log a phone number reveal when the number is actually sent.
+// headers must already hold any Last-Modified.
+func setValidators(r *http.Request, headers http.Header, data envelope) (bool, error) {
+	js, err := encodeJSON(data)
+	if err != nil {
+		return false, err
+	}
+	headers.Set("ETag", strongETag(js))
+	return notModified(r, headers), nil
+}
+
+// strongETag() returns a strong entity tag for the body, the base64 encoded SHA-256 of it
+func strongETag(body []byte) string {
+	hash := sha256.Sum256(body)
+	return `"` + base64.RawURLEncoding.EncodeToString(hash[:]) + `"`
+}
+
+// notModified() reports whether the conditional headers of the request match the response,
+// so that the client's copy is still current
+func notModified(r *http.Request, header http.Header) bool {
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		etag := header.Get("ETag")
+		for _, candidate := range strings.Split(ifNoneMatch, ",") {
+			// If-None-Match uses the weak comparison, so a weak tag matches its strong one
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	ifModifiedSince, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	lastModified, err := http.ParseTime(header.Get("Last-Modified"))
+	if err != nil {
+		return false
+	}
+	// HTTP dates have a resolution of a second
+	return !lastModified.Truncate(time.Second).After(ifModifiedSince)
+}
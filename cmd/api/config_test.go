@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Blue-Davinci/SocialAid/internal/config"
+)
+
+// TestConfigFlagsPrecedence loads the configuration the way main() does, checking that each
+// layer overrides the ones below it: defaults < config file < environment < flags
+func TestConfigFlagsPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	file := "port: 5000\nenv: staging\nmetrics_port: 9100\nidempotency:\n  ttl: 2h\n"
+	if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(config.FileEnv, "")
+	t.Setenv("SOCIALAID_PORT", "6000")
+	t.Setenv("SOCIALAID_ENV", "production")
+	t.Setenv("SOCIALAID_CORS_TRUSTED_ORIGINS", "https://env.example.com")
+	args := []string{"-config", path, "-port", "7000", "-cors-trusted-origins", "https://a.example.com https://b.example.com"}
+
+	cfg, err := config.Load(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := flag.NewFlagSet("api", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	configFlags(fs, &cfg)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		got  any
+		want any
+	}{
+		{name: "port, from the flag over env and file", got: cfg.Port, want: 7000},
+		{name: "cors, from the flag over env", got: strings.Join(cfg.CORS.TrustedOrigins, " "), want: "https://a.example.com https://b.example.com"},
+		{name: "env, from env over file", got: cfg.Env, want: "production"},
+		{name: "metrics port, from the file", got: cfg.MetricsPort, want: 9100},
+		{name: "idempotency ttl, from the file", got: cfg.Idempotency.TTL, want: 2 * time.Hour},
+		{name: "webhook attempts, the default", got: cfg.Webhooks.MaxAttempts, want: config.Default().Webhooks.MaxAttempts},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
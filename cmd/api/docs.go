@@ -0,0 +1,39 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openAPISpec is the OpenAPI 3 specification of every route in routes(). Keep it in step
+// with the handlers' input structs and the data structs they send back.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// docsPage renders the specification with Redoc, loaded from its CDN
+const docsPage = `<!DOCTYPE html>
+<html>
+<head>
+	<title>SocialAid API</title>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+</head>
+<body>
+	<redoc spec-url="/v1/openapi.json"></redoc>
+	<script src="https://cdn.redoc.ly/redoc/v2.1.5/bundles/redoc.standalone.js"></script>
+</body>
+</html>
+`
+
+// openAPIHandler() serves the OpenAPI specification as is
+func (app *application) openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
+
+// docsHandler() serves the API documentation page
+func (app *application) docsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(docsPage))
+}
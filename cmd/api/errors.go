@@ -1,64 +1,201 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
 
+	"github.com/Blue-Davinci/SocialAid/internal/data"
+	"github.com/Blue-Davinci/SocialAid/internal/validator"
 	"go.uber.org/zap"
 )
 
-// The errorResponse() method is a generic helper for sending JSON-formatted error
-// messages to the client with a given status code.
-func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message any) {
-	env := envelope{"error": message}
+// Stable, machine-readable error codes. Clients should switch on these rather than on
+// the human readable messages, which may change.
+const (
+	errCodeBadRequest            = "BAD_REQUEST"
+	errCodeValidationFailed      = "VALIDATION_FAILED"
+	errCodeAuthenticationNeeded  = "AUTHENTICATION_REQUIRED"
+	errCodeInvalidAPIKey         = "INVALID_API_KEY"
+	errCodeNotFound              = "NOT_FOUND"
+	errCodeMethodNotAllowed      = "METHOD_NOT_ALLOWED"
+	errCodeInternalError         = "INTERNAL_ERROR"
+	errCodeConflict              = "CONFLICT"
+	errCodeReferenceNotFound     = "REFERENCE_NOT_FOUND"
+	errCodeDuplicateProgram      = "DUPLICATE_PROGRAM"
+	errCodeProgramNotFound       = "PROGRAM_NOT_FOUND"
+	errCodeDuplicateGeoLocation  = "DUPLICATE_GEOLOCATION"
+	errCodeGeoLocationNotFound   = "GEOLOCATION_NOT_FOUND"
+	errCodeHouseHoldNotFound     = "HOUSEHOLD_NOT_FOUND"
+	errCodeHouseHoldHeadExists   = "HOUSEHOLD_HEAD_EXISTS"
+	errCodeHouseHoldHeadNotFound = "HOUSEHOLD_HEAD_NOT_FOUND"
+	errCodeHouseHoldMemberExists = "HOUSEHOLD_MEMBER_EXISTS"
+	errCodeEnrollmentExists      = "ENROLLMENT_EXISTS"
+	errCodeEnrollmentNotFound    = "ENROLLMENT_NOT_FOUND"
+	errCodeEnrollmentExited      = "ENROLLMENT_EXITED"
+	errCodeQueryTimeout          = "QUERY_TIMEOUT"
+	errCodeIdempotencyKeyInUse   = "IDEMPOTENCY_KEY_IN_USE"
+	errCodeIdempotencyKeyReused  = "IDEMPOTENCY_KEY_REUSED"
+)
+
+// problemTypeBase is the prefix of the "type" URI of our problem details. Each error code
+// maps to its own type, e.g urn:socialaid:problem:household-not-found
+const problemTypeBase = "urn:socialaid:problem:"
+
+// problemType() converts an error code such as HOUSEHOLD_NOT_FOUND to its problem type URI
+func problemType(code string) string {
+	return problemTypeBase + strings.ReplaceAll(strings.ToLower(code), "_", "-")
+}
+
+// The errorResponse() method is a generic helper for sending error messages to the client
+// with a given status code and error code. We send application/problem+json to clients
+// whose Accept header allows it, and the original {"error": message} shape to v1 clients
+// asking only for e.g application/json. v is optional and carries field errors.
+func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, code string, detail string, v *validator.Validator) {
+	var env envelope
+	headers := make(http.Header)
+	// errors are never cached, whatever the policy of the route
+	headers.Set("Cache-Control", cacheControlNoStore)
+	// the body depends on the Accept header, on top of whatever else the response varies on
+	w.Header().Add("Vary", "Accept")
+	if acceptsProblemJSON(r) {
+		env = app.problemDetails(r, status, code, detail, v)
+		headers.Set("Content-Type", "application/problem+json")
+	} else {
+		env = legacyErrorEnvelope(detail, v)
+	}
 	// Write the response using the writeJSON() helper. If this happens to return an
 	// error then log it, and fall back to sending the client an empty response with a
 	// 500 Internal Server Error status code.
-	err := app.writeJSON(w, status, env, nil)
+	err := app.writeJSON(w, status, env, headers)
 	if err != nil {
 		app.logError(r, err)
 		w.WriteHeader(500)
 	}
 }
 
+// problemDetails() builds an RFC 7807 problem details object. Besides the standard members
+// we add our stable error code, the request's correlation ID and, for validation failures,
+// the field errors under the "errors" extension.
+func (app *application) problemDetails(r *http.Request, status int, code string, detail string, v *validator.Validator) envelope {
+	env := envelope{
+		"type":     problemType(code),
+		"title":    http.StatusText(status),
+		"status":   status,
+		"instance": r.URL.Path,
+		"code":     code,
+	}
+	if detail != "" {
+		env["detail"] = detail
+	}
+	if requestID := app.contextGetRequestID(r); requestID != "" {
+		env["request_id"] = requestID
+	}
+	if v != nil {
+		env["errors"] = v.FieldErrors()
+	}
+	return env
+}
+
+// acceptsProblemJSON() reports whether the request's Accept header allows
+// application/problem+json, either by name or through application/* or */*. Requests
+// without an Accept header allow anything.
+func acceptsProblemJSON(r *http.Request) bool {
+	accept := strings.Join(r.Header.Values("Accept"), ",")
+	if strings.TrimSpace(accept) == "" {
+		return true
+	}
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(mediaRange)
+		if err != nil {
+			continue
+		}
+		// a quality of 0 means "not acceptable"
+		if q, ok := params["q"]; ok {
+			if quality, err := strconv.ParseFloat(q, 64); err != nil || quality <= 0 {
+				continue
+			}
+		}
+		switch mediaType {
+		case "application/problem+json", "application/*", "*/*":
+			return true
+		}
+	}
+	return false
+}
+
+// legacyErrorEnvelope() builds the error body v1 clients were written against: a string
+// message, or the field errors map and their codes for validation failures.
+func legacyErrorEnvelope(detail string, v *validator.Validator) envelope {
+	if v != nil {
+		return envelope{"error": v.Errors, "error_codes": v.Codes}
+	}
+	return envelope{"error": detail}
+}
+
 // The authenticationRequiredResponse() method will return 403 authentication required error, that
 // is the client needs to register + auth their account to proceed.
 func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
 	message := "you must be authenticated to access this resource"
-	app.errorResponse(w, r, http.StatusUnauthorized, message)
+	app.errorResponse(w, r, http.StatusUnauthorized, errCodeAuthenticationNeeded, message, nil)
 }
 
 // The invalidAuthenticationTokenResponse() method will return invalid token error
 func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("WWW-Authenticate", "Bearer")
 	message := "invalid or missing authentication token"
-	app.errorResponse(w, r, http.StatusUnauthorized, message)
+	app.errorResponse(w, r, http.StatusUnauthorized, errCodeInvalidAPIKey, message, nil)
 }
 
 // The logError() method is a generic helper that we can use to log an error message at
 // the ERROR level.
 func (app *application) logError(r *http.Request, err error) {
 	// Use the PrintError() method to log the error message, and include the current
-	// request method and URL as properties in the log entry.
-	app.logger.Error(err.Error(), zap.String("request_method", r.Method), zap.String("request_url", r.URL.String()))
+	// request ID, method and URL as properties in the log entry.
+	app.logger.Error(err.Error(),
+		zap.String("request_id", app.contextGetRequestID(r)),
+		zap.String("request_method", r.Method),
+		zap.String("request_url", r.URL.String()))
 
 }
 
 // The badRequestResponse() method will be used to send a 400 Bad Request status code and
 // JSON response to the client.
 func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Request, err error) {
-	app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+	app.errorResponse(w, r, http.StatusBadRequest, errCodeBadRequest, err.Error(), nil)
 }
 
 // The failedValidationResponse() method will be used to send a 422 Unprocessable Entity
 // status code and JSON response to the client.
-func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
-	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
+func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, v *validator.Validator) {
+	message := "the request contains invalid fields"
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, errCodeValidationFailed, message, v)
 }
 
-// The editConflictResponse() method will be used to send a 409 Conflict status code and
-// JSON response to the client.
-func (app *application) conflictResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
-	app.errorResponse(w, r, http.StatusConflict, errors)
+// The conflictResponse() method will be used to send a 409 Conflict status code and
+// JSON response to the client. The code identifies the exact conflict, e.g DUPLICATE_PROGRAM.
+func (app *application) conflictResponse(w http.ResponseWriter, r *http.Request, code string, v *validator.Validator) {
+	message := "the request conflicts with the current state of the resource"
+	app.errorResponse(w, r, http.StatusConflict, code, message, v)
+}
+
+// The dataErrorResponse() method is used in the default branch of handlers that write to the
+// database. Constraint violations the handler has no specific case for are sent as 409
+// Conflict or 422 Unprocessable Entity rather than 500, everything else is a server error.
+func (app *application) dataErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, data.ErrUniqueViolation):
+		app.conflictResponse(w, r, errCodeConflict, nil)
+	case errors.Is(err, data.ErrForeignKeyViolation):
+		app.conflictResponse(w, r, errCodeReferenceNotFound, nil)
+	case errors.Is(err, data.ErrCheckViolation):
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, errCodeValidationFailed, data.ErrCheckViolation.Error(), nil)
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
 }
 
 // The serverErrorResponse() method will be used when our application encounters an
@@ -66,14 +203,74 @@ func (app *application) conflictResponse(w http.ResponseWriter, r *http.Request,
 // errorResponse() helper to send a 500 Internal Server Error status code and JSON
 // response (containing a generic error message) to the client.
 func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, data.ErrQueryCanceled):
+		app.queryCanceledResponse(r, err)
+		return
+	case errors.Is(err, data.ErrQueryTimeout):
+		app.queryTimeoutResponse(w, r, err)
+		return
+	}
 	app.logError(r, err)
 	message := "the server encountered a problem and could not process your request"
-	app.errorResponse(w, r, http.StatusInternalServerError, message)
+	app.errorResponse(w, r, http.StatusInternalServerError, errCodeInternalError, message, nil)
+}
+
+// The queryCanceledResponse() method handles queries cancelled because the client went away
+// or the server is shutting down. That isn't a server fault, so we log it as a warning, and
+// as nobody is waiting for the response we don't send one.
+func (app *application) queryCanceledResponse(r *http.Request, err error) {
+	app.logger.Warn("query cancelled",
+		zap.String("request_id", app.contextGetRequestID(r)),
+		zap.String("request_method", r.Method),
+		zap.String("request_url", r.URL.String()),
+		zap.String("error", err.Error()))
+}
+
+// The queryTimeoutResponse() method logs a query that hit its model's timeout and sends a
+// 503 Service Unavailable, as the request may well succeed when retried.
+func (app *application) queryTimeoutResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.logger.Warn("query timed out",
+		zap.String("request_id", app.contextGetRequestID(r)),
+		zap.String("request_method", r.Method),
+		zap.String("request_url", r.URL.String()),
+		zap.String("error", err.Error()))
+	message := "the server took too long to process your request, please try again"
+	app.errorResponse(w, r, http.StatusServiceUnavailable, errCodeQueryTimeout, message, nil)
 }
 
 // The notFoundResponse() method will be used to send a 404 Not Found status code and
 // JSON response to the client.
 func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request) {
 	message := "the requested resource could not be found"
-	app.errorResponse(w, r, http.StatusNotFound, message)
+	app.errorResponse(w, r, http.StatusNotFound, errCodeNotFound, message, nil)
+}
+
+// The resourceNotFoundResponse() method sends a 404 Not Found for a specific resource,
+// e.g HOUSEHOLD_NOT_FOUND, so that clients can tell missing resources apart.
+func (app *application) resourceNotFoundResponse(w http.ResponseWriter, r *http.Request, code string, message string) {
+	app.errorResponse(w, r, http.StatusNotFound, code, message, nil)
+}
+
+// The methodNotAllowedResponse() method will be used to send a 405 Method Not Allowed
+// status code and JSON response to the client.
+func (app *application) methodNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
+	message := fmt.Sprintf("the %s method is not supported for this resource", r.Method)
+	app.errorResponse(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, message, nil)
+}
+
+// The idempotencyKeyInUseResponse() method sends a 409 Conflict when a request arrives while
+// another one with the same Idempotency-Key is still being processed. The client should
+// retry shortly, when it will be sent the stored response.
+func (app *application) idempotencyKeyInUseResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", "1")
+	message := "a request with this idempotency key is still being processed"
+	app.errorResponse(w, r, http.StatusConflict, errCodeIdempotencyKeyInUse, message, nil)
+}
+
+// The idempotencyKeyReusedResponse() method sends a 422 Unprocessable Entity when an
+// Idempotency-Key is reused for a request that differs from the original.
+func (app *application) idempotencyKeyReusedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the idempotency key was already used for a different request"
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, errCodeIdempotencyKeyReused, message, nil)
 }
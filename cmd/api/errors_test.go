@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAcceptsProblemJSON(t *testing.T) {
+	tests := []struct {
+		accept []string
+		want   bool
+	}{
+		{accept: nil, want: true},
+		{accept: []string{""}, want: true},
+		{accept: []string{"application/problem+json"}, want: true},
+		{accept: []string{"application/json, application/problem+json;q=0.5"}, want: true},
+		{accept: []string{"application/json", "application/problem+json"}, want: true},
+		{accept: []string{"application/*"}, want: true},
+		{accept: []string{"*/*"}, want: true},
+		{accept: []string{"application/json, text/plain, */*"}, want: true},
+		{accept: []string{"application/json"}, want: false},
+		{accept: []string{"text/html"}, want: false},
+		{accept: []string{"application/problem+json;q=0"}, want: false},
+		{accept: []string{"application/json, */*;q=0"}, want: false},
+		{accept: []string{"not a media type"}, want: false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		for _, accept := range tt.accept {
+			r.Header.Add("Accept", accept)
+		}
+		if got := acceptsProblemJSON(r); got != tt.want {
+			t.Errorf("acceptsProblemJSON(%q) = %t, want %t", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestErrorResponseNegotiation(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	body := map[string]any{"name": "", "category": "cash transfer", "description": "stipend"}
+
+	// clients accepting problem details get them
+	res := ts.do(t, http.MethodPost, "/v1/programs", http.Header{"Accept": {"application/problem+json"}}, body)
+	p := checkProblem(t, res, http.StatusUnprocessableEntity, errCodeValidationFailed)
+	if res.header.Get("Content-Type") != "application/problem+json" || p.Errors["name"].Code == "" {
+		t.Errorf("Content-Type = %q, errors %v", res.header.Get("Content-Type"), p.Errors)
+	}
+	if res.header.Get("Vary") == "" {
+		t.Error("no Vary header")
+	}
+
+	// v1 clients asking for JSON alone get the legacy shape from the same server
+	res = ts.do(t, http.MethodPost, "/v1/programs", http.Header{"Accept": {"application/json"}}, body)
+	if res.status != http.StatusUnprocessableEntity || res.header.Get("Content-Type") != "application/json" {
+		t.Fatalf("status = %d, Content-Type %q\n%s", res.status, res.header.Get("Content-Type"), res.body)
+	}
+	var legacy struct {
+		Error      map[string]string `json:"error"`
+		ErrorCodes map[string]string `json:"error_codes"`
+	}
+	res.decode(t, &legacy)
+	if legacy.Error["name"] == "" || legacy.ErrorCodes["name"] == "" {
+		t.Errorf("legacy body = %s", res.body)
+	}
+
+	res = ts.do(t, http.MethodGet, "/v1/no-such-route", http.Header{"Accept": {"application/json"}}, nil)
+	var notFound struct {
+		Error string `json:"error"`
+	}
+	res.decode(t, &notFound)
+	if res.status != http.StatusNotFound || notFound.Error == "" {
+		t.Errorf("status = %d\n%s", res.status, res.body)
+	}
+}
@@ -13,10 +13,12 @@ import (
 // and output the geo location to the client
 func (app *application) createNewGeoLocationHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		County      string `json:"county"`
-		SubCounty   string `json:"sub_county"`
-		Location    string `json:"location"`
-		SubLocation string `json:"sub_location"`
+		County      string   `json:"county"`
+		SubCounty   string   `json:"sub_county"`
+		Location    string   `json:"location"`
+		SubLocation string   `json:"sub_location"`
+		Latitude    *float64 `json:"latitude"`
+		Longitude   *float64 `json:"longitude"`
 	}
 	// read the request to the input struct
 	err := app.readJSON(w, r, &input)
@@ -30,22 +32,24 @@ func (app *application) createNewGeoLocationHandler(w http.ResponseWriter, r *ht
 		SubCounty:   input.SubCounty,
 		Location:    input.Location,
 		SubLocation: input.SubLocation,
+		Latitude:    input.Latitude,
+		Longitude:   input.Longitude,
 	}
 	// validate the geo location struct
 	v := validator.New()
 	if data.ValidateGeoLocation(v, geoLocation); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 	// we are good now, lets create the geo location
-	err = app.models.GeoLocation.CreateNewGeoLocation(geoLocation)
+	err = app.models.GeoLocation.CreateNewGeoLocation(r.Context(), geoLocation)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrDuplicateGeoLocation):
-			v.AddError("county", "a geo location with this sub-location already exists")
-			app.conflictResponse(w, r, v.Errors)
+			v.AddFieldError("county", validator.CodeAlreadyExists, "a geo location with this sub-location already exists")
+			app.conflictResponse(w, r, errCodeDuplicateGeoLocation, v)
 		default:
-			app.serverErrorResponse(w, r, err)
+			app.dataErrorResponse(w, r, err)
 		}
 		return
 	}
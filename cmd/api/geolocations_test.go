@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/Blue-Davinci/SocialAid/internal/data"
+)
+
+func TestCreateNewGeoLocation(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	input := map[string]any{
+		"county":       "Nairobi",
+		"sub_county":   "Westlands",
+		"location":     "Parklands",
+		"sub_location": "Highridge",
+		"latitude":     -1.26,
+		"longitude":    36.81,
+	}
+
+	res := ts.do(t, http.MethodPost, "/v1/geo_locations", nil, input)
+	if res.status != http.StatusCreated {
+		t.Fatalf("status = %d, want %d\n%s", res.status, http.StatusCreated, res.body)
+	}
+	var created struct {
+		GeoLocation data.GeoLocation `json:"geo_location"`
+	}
+	res.decode(t, &created)
+	if created.GeoLocation.ID == 0 || created.GeoLocation.SubLocation != "Highridge" {
+		t.Errorf("geo location = %+v", created.GeoLocation)
+	}
+
+	tests := []struct {
+		name   string
+		body   map[string]any
+		status int
+		code   string
+		field  string
+	}{
+		{
+			// sub locations are unique across every county
+			name:   "duplicate sub location",
+			body:   map[string]any{"county": "Kiambu", "sub_county": "s", "location": "l", "sub_location": "Highridge"},
+			status: http.StatusConflict,
+			code:   errCodeDuplicateGeoLocation,
+			field:  "county",
+		},
+		{
+			name:   "missing county",
+			body:   map[string]any{"sub_county": "s", "location": "l", "sub_location": "Karura"},
+			status: http.StatusUnprocessableEntity,
+			code:   errCodeValidationFailed,
+			field:  "county",
+		},
+		{
+			name:   "latitude without longitude",
+			body:   map[string]any{"county": "Nairobi", "sub_county": "s", "location": "l", "sub_location": "Karura", "latitude": -1.2},
+			status: http.StatusUnprocessableEntity,
+			code:   errCodeValidationFailed,
+			field:  "longitude",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ts.do(t, http.MethodPost, "/v1/geo_locations", nil, tt.body)
+			p := checkProblem(t, res, tt.status, tt.code)
+			if _, ok := p.Errors[tt.field]; !ok {
+				t.Errorf("no error for %s: %s", tt.field, res.body)
+			}
+		})
+	}
+}
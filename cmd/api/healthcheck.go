@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/Blue-Davinci/SocialAid/internal/data"
+	"go.uber.org/zap"
+)
+
+// version is the application version, set at build time with
+// -ldflags "-X main.version=1.2.3"
+var version = "dev"
+
+// readinessDBTimeout bounds how long the readiness probe waits on the database
+const readinessDBTimeout = 2 * time.Second
+
+// readinessCheck is the outcome of one of the readiness checks. The versions are only
+// reported by the migrations check. The probe is public, so Error is always one of a fixed
+// set of messages, the underlying error is only logged.
+type readinessCheck struct {
+	Status          string `json:"status"`
+	Error           string `json:"error,omitempty"`
+	CurrentVersion  *int64 `json:"current_version,omitempty"`
+	ExpectedVersion *int64 `json:"expected_version,omitempty"`
+}
+
+// healthcheckHandler() is the liveness probe. It reports that the process is up along with
+// the environment, version and build information, without touching any dependency.
+func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Request) {
+	env := envelope{
+		"status": "available",
+		"system_info": map[string]any{
+			"environment": app.config.Env,
+			"version":     version,
+			"api_name":    app.config.API.Name,
+			"api_author":  app.config.API.Author,
+			"build":       buildInfo(),
+		},
+	}
+	err := app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readinessHandler() is the readiness probe. We report ready only when the database answers
+// a ping, the encryption key is a valid AES key and the schema is at the expected migration.
+// While the server is shutting down we always report 503, so that traffic drains first.
+func (app *application) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]readinessCheck{
+		"database":       app.checkDatabase(r.Context()),
+		"encryption_key": app.checkEncryptionKey(),
+		"migrations":     app.checkMigrations(r.Context()),
+	}
+	ready := !app.shuttingDown.Load()
+	for _, check := range checks {
+		ready = ready && check.Status == "ok"
+	}
+	status, statusCode := "ready", http.StatusOK
+	if !ready {
+		status, statusCode = "not ready", http.StatusServiceUnavailable
+	}
+	env := envelope{
+		"status":        status,
+		"shutting_down": app.shuttingDown.Load(),
+		"checks":        checks,
+	}
+	err := app.writeJSON(w, statusCode, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) checkDatabase(ctx context.Context) readinessCheck {
+	ctx, cancel := context.WithTimeout(ctx, readinessDBTimeout)
+	defer cancel()
+	if err := app.db.PingContext(ctx); err != nil {
+		return app.failedReadinessCheck("database", "database unreachable", err)
+	}
+	return readinessCheck{Status: "ok"}
+}
+
+func (app *application) checkEncryptionKey() readinessCheck {
+	if err := data.CheckEncryptionKey(app.config.Encryption.Key); err != nil {
+		return app.failedReadinessCheck("encryption_key", "encryption key invalid", err)
+	}
+	return readinessCheck{Status: "ok"}
+}
+
+// checkMigrations() compares the database's schema version to the latest embedded migration,
+// asking goose exactly as the startup check does.
+func (app *application) checkMigrations(ctx context.Context) readinessCheck {
+	ctx, cancel := context.WithTimeout(ctx, readinessDBTimeout)
+	defer cancel()
+	currentVersion, expectedVersion, pending, err := schemaVersions(ctx, app.migrations)
+	if err != nil {
+		return app.failedReadinessCheck("migrations", "migration version unavailable", err)
+	}
+	check := readinessCheck{Status: "ok", CurrentVersion: &currentVersion, ExpectedVersion: &expectedVersion}
+	if pending {
+		check.Status = "behind"
+	}
+	return check
+}
+
+// failedReadinessCheck() logs why the check failed and returns it with the fixed message,
+// so that driver errors, hosts and schema details never reach the public response.
+func (app *application) failedReadinessCheck(name, message string, err error) readinessCheck {
+	app.logger.Warn("readiness check failed",
+		zap.String("check", name),
+		zap.String("error", err.Error()))
+	return readinessCheck{Status: "error", Error: message}
+}
+
+// buildInfo() returns the Go version and, when built from a git checkout, the commit the
+// binary was built from.
+func buildInfo() map[string]string {
+	info := map[string]string{"go_version": runtime.Version()}
+	buildInfo, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	for _, setting := range buildInfo.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			info["commit"] = setting.Value
+		case "vcs.time":
+			info["commit_time"] = setting.Value
+		case "vcs.modified":
+			info["modified"] = setting.Value
+		}
+	}
+	return info
+}
@@ -6,12 +6,18 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 
+	"github.com/Blue-Davinci/SocialAid/internal/data"
+	"github.com/Blue-Davinci/SocialAid/internal/validator"
 	"github.com/go-chi/chi/v5"
 )
 
+// maxRequestBodyBytes is the largest request body we accept, 1MB
+const maxRequestBodyBytes = 1_048_576
+
 // Define an envelope type.
 type envelope map[string]any
 
@@ -20,28 +26,38 @@ type envelope map[string]any
 // The function returns an error if there was a problem encoding the data to JSON, or writing the response.
 func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
 	// Encode the data to JSON, returning the error if there was one.
-	js, err := json.MarshalIndent(data, "", "\t")
+	js, err := encodeJSON(data)
 	if err != nil {
 		return err
 	}
-	// Append a newline to make it easier to view in terminal applications.
-	js = append(js, '\n')
 	// At this point, we know that we won't encounter any more errors before writing the
 	// response, so it's safe to add any headers that we want to include.
 	for key, value := range headers {
 		w.Header()[key] = value
 	}
-	// Add the "Content-Type: application/json" header, then write the status code
-	w.Header().Set("Content-Type", "application/json")
+	// Add the "Content-Type: application/json" header unless the caller supplied a more
+	// specific one (e.g. application/geo+json), then write the status code
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
 	w.WriteHeader(status)
 	w.Write(js)
 	return nil
 }
 
+// encodeJSON() encodes the data exactly as writeJSON() sends it
+func encodeJSON(data envelope) ([]byte, error) {
+	js, err := json.MarshalIndent(data, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	// Append a newline to make it easier to view in terminal applications.
+	return append(js, '\n'), nil
+}
+
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any) error {
 	// Use http.MaxBytesReader() to limit the size of the request body to 1MB.
-	maxBytes := 1_048_576
-	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodyBytes)
 	// Initialize the json.Decoder, and call the DisallowUnknownFields() method on it
 	// before decoding. This means that if the JSON from the client now includes any
 	// field which cannot be mapped to the target destination, the decoder will return
@@ -122,3 +138,116 @@ func (app *application) readIDParam(r *http.Request, parameterName string) (int6
 	}
 	return id, nil
 }
+
+// readString() returns a string value from the query string, or the provided
+// default value if no matching key could be found.
+func (app *application) readString(qs url.Values, key string, defaultValue string) string {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+	return s
+}
+
+// readCSV() reads a comma separated list from the query string, trimming the values and
+// dropping empty ones. If no matching key could be found it returns the provided default.
+func (app *application) readCSV(qs url.Values, key string, defaultValue []string) []string {
+	csv := qs.Get(key)
+	if csv == "" {
+		return defaultValue
+	}
+	values := []string{}
+	for _, value := range strings.Split(csv, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// selectFields() returns a sparse fieldset of the value, a JSON object holding only the
+// listed fields of its JSON encoding. Without any fields the value is returned as is.
+func selectFields(value any, fields []string) (any, error) {
+	if len(fields) == 0 {
+		return value, nil
+	}
+	js, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(js, &object); err != nil {
+		return nil, err
+	}
+	selected := make(map[string]json.RawMessage, len(fields))
+	for _, field := range fields {
+		// omitted fields, e.g related records that weren't included, stay omitted
+		if raw, ok := object[field]; ok {
+			selected[field] = raw
+		}
+	}
+	return selected, nil
+}
+
+// readInt() reads a string value from the query string and converts it to an
+// integer before returning. If no matching key could be found it returns the provided
+// default value. If the value couldn't be converted to an integer, then we record an
+// error message in the provided Validator instance.
+func (app *application) readInt(qs url.Values, key string, defaultValue int, v *validator.Validator) int {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		v.AddFieldError(key, validator.CodeInvalidFormat, "must be an integer value")
+		return defaultValue
+	}
+	return i
+}
+
+// readOptionalFloat() reads an optional float from the query string. We return nil when the
+// key is absent so callers can tell "not supplied" from zero. If the value couldn't be
+// converted to a float, then we record an error message in the provided Validator instance.
+func (app *application) readOptionalFloat(qs url.Values, key string, v *validator.Validator) *float64 {
+	s := qs.Get(key)
+	if s == "" {
+		return nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		v.AddFieldError(key, validator.CodeInvalidFormat, "must be a number")
+		return nil
+	}
+	return &f
+}
+
+// readPage() reads the keyset pagination of a list from the query string: the cursor of the
+// previous page, absent for the first page, and the limit on the page size. Invalid values
+// are recorded in the provided Validator instance.
+func (app *application) readPage(qs url.Values, v *validator.Validator) data.Page {
+	limit := app.readInt(qs, "limit", data.DefaultPageLimit, v)
+	// check the range before converting, so that huge limits can't wrap round into it
+	data.ValidatePageLimit(v, limit)
+	page := data.Page{
+		Limit: int32(limit),
+	}
+	if encoded := qs.Get("cursor"); encoded != "" {
+		cursor, err := data.DecodeCursor(encoded)
+		if err != nil {
+			v.AddFieldError("cursor", validator.CodeInvalidFormat, "must be a cursor returned by a previous page")
+		}
+		page.After = cursor
+	}
+	return page
+}
+
+// nextPageLink() returns an RFC 8288 Link header pointing at the next page of a list, the
+// request's URL with its cursor replaced by the next one. The reference is relative, so
+// that it resolves against whatever host and scheme the client reached us on.
+func nextPageLink(r *http.Request, next *data.Cursor) string {
+	qs := r.URL.Query()
+	qs.Set("cursor", next.Encode())
+	nextURL := url.URL{Path: r.URL.Path, RawQuery: qs.Encode()}
+	return fmt.Sprintf(`<%s>; rel="next"`, nextURL.String())
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"net/http"
+	"slices"
 
 	"github.com/Blue-Davinci/SocialAid/internal/data"
 	"github.com/Blue-Davinci/SocialAid/internal/validator"
@@ -13,9 +14,11 @@ import (
 // and output the house hold to the client
 func (app *application) createNewHouseHoldHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		ProgramID     int32  `json:"program_id"`
-		GeoLocationID int32  `json:"geo_location_id"`
-		Name          string `json:"name"`
+		ProgramID     int32    `json:"program_id"`
+		GeoLocationID int32    `json:"geo_location_id"`
+		Name          string   `json:"name"`
+		Latitude      *float64 `json:"latitude"`
+		Longitude     *float64 `json:"longitude"`
 	}
 	// read the request to the input struct
 	err := app.readJSON(w, r, &input)
@@ -28,28 +31,33 @@ func (app *application) createNewHouseHoldHandler(w http.ResponseWriter, r *http
 		ProgramID:     input.ProgramID,
 		GeoLocationID: input.GeoLocationID,
 		Name:          input.Name,
+		Latitude:      input.Latitude,
+		Longitude:     input.Longitude,
 	}
 	// validate the house hold struct
 	v := validator.New()
 	if data.ValidateHouseHold(v, houseHold); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 	// we are good now, lets create the house hold
-	err = app.models.HouseHold.CreateNewHouseHold(houseHold)
+	err = app.models.HouseHold.CreateNewHouseHold(r.Context(), houseHold)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrGeoLocationDoesNotExist):
-			v.AddError("geo_location_id", "geo location does not exist")
-			app.conflictResponse(w, r, v.Errors)
+			v.AddFieldError("geo_location_id", validator.CodeNotFound, "geo location does not exist")
+			app.conflictResponse(w, r, errCodeGeoLocationNotFound, v)
 		case errors.Is(err, data.ErrProgramDoesNotExist):
-			v.AddError("program_id", "program does not exist")
-			app.conflictResponse(w, r, v.Errors)
+			v.AddFieldError("program_id", validator.CodeNotFound, "program does not exist")
+			app.conflictResponse(w, r, errCodeProgramNotFound, v)
+		case isEnrollmentLimitError(err):
+			app.enrollmentLimitResponse(w, r, v, err, "geo_location_id")
 		default:
-			app.serverErrorResponse(w, r, err)
+			app.dataErrorResponse(w, r, err)
 		}
 		return
 	}
+	app.metrics.houseHoldsCreated.Inc()
 	// output to client
 	err = app.writeJSON(w, http.StatusCreated, envelope{"house_hold": houseHold}, nil)
 	if err != nil {
@@ -60,7 +68,11 @@ func (app *application) createNewHouseHoldHandler(w http.ResponseWriter, r *http
 
 // getHouseHoldInformationHandler() is a handler that gets the information of a house hold
 // We get the ID from the request as a URL parameter, validate the input. If everything is okay,
-// we pass down the house hold information to the client
+// we pass down the house hold information to the client. The head's phone number is masked
+// unless the user has the pii:read permission, in which case we log it being revealed. A
+// 304 sends no phone number, so nothing is logged for it.
+// ?include= adds the related records and ?fields= trims the house hold to the fields listed,
+// always keeping its ID.
 func (app *application) getHouseHoldInformationHandler(w http.ResponseWriter, r *http.Request) {
 	// get the house hold id from the URL parameter
 	houseHoldID, err := app.readIDParam(r, "householdID")
@@ -68,29 +80,181 @@ func (app *application) getHouseHoldInformationHandler(w http.ResponseWriter, r
 		app.notFoundResponse(w, r)
 		return
 	}
-	// validate the household ID
+	// validate the household ID, the includes and the fields
+	v := validator.New()
+	qs := r.URL.Query()
+	data.ValidateURLID(v, houseHoldID, "householdID")
+	includes := data.ParseHouseHoldIncludes(v, app.readCSV(qs, "include", nil))
+	fields := app.readCSV(qs, "fields", nil)
+	for _, field := range fields {
+		validator.OneOf(v, "fields", field, data.EnrichedHouseHoldFields...)
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+	if len(fields) > 0 {
+		fields = append(fields, "house_hold_id")
+	}
+	// get the house hold information
+	houseHold, err := app.models.HouseHold.GetHouseHoldInformation(r.Context(), int32(houseHoldID), includes, app.config.Encryption.Key)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrHouseHoldDoesNotExist):
+			app.resourceNotFoundResponse(w, r, errCodeHouseHoldNotFound, "house hold does not exist")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// a sparse fieldset may leave the phone number out, in which case nothing is revealed
+	reveal := false
+	if len(fields) == 0 || slices.Contains(fields, "phone_number") || (includes.Head && slices.Contains(fields, "head")) {
+		canReadPII, err := app.userHasPermission(r, data.PermissionPIIRead)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !canReadPII {
+			houseHold.MaskPhoneNumbers()
+		}
+		reveal = canReadPII
+	}
+	response, err := selectFields(houseHold, fields)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// let the client revalidate its copy with If-None-Match or If-Modified-Since
+	env := envelope{"house_hold": response}
+	headers := make(http.Header)
+	headers.Set("Last-Modified", houseHold.UpdatedAt.UTC().Format(http.TimeFormat))
+	notModified, err := setValidators(r, headers, env)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// the phone number is only revealed when the body is sent, not with a 304
+	if reveal && !notModified {
+		if err := app.recordPhoneNumberReveal(r, houseHold, data.PIIRevealSourcePermission, ""); err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	// output to client, conditionalGET() answers with the 304
+	err = app.writeJSON(w, http.StatusOK, env, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+
+}
+
+// revealHouseHoldPhoneNumberHandler() returns the house hold information with the head's
+// phone number in full to any authenticated user who gives a reason, which we log along with
+// who asked and when before responding
+func (app *application) revealHouseHoldPhoneNumberHandler(w http.ResponseWriter, r *http.Request) {
+	// get the house hold id from the URL parameter
+	houseHoldID, err := app.readIDParam(r, "householdID")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	var input struct {
+		Reason string `json:"reason"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	// validate the household ID and the reason
 	v := validator.New()
-	if data.ValidateURLID(v, houseHoldID, "householdID"); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+	data.ValidateURLID(v, houseHoldID, "householdID")
+	if data.ValidatePIIRevealReason(v, input.Reason); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 	// get the house hold information
-	houseHold, err := app.models.HouseHold.GetHouseHoldInformation(int32(houseHoldID), app.config.encryption.key)
+	houseHold, err := app.models.HouseHold.GetHouseHoldInformation(r.Context(), int32(houseHoldID), data.HouseHoldIncludes{}, app.config.Encryption.Key)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrHouseHoldDoesNotExist):
-			app.notFoundResponse(w, r)
+			app.resourceNotFoundResponse(w, r, errCodeHouseHoldNotFound, "house hold does not exist")
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
+	if err := app.recordPhoneNumberReveal(r, houseHold, data.PIIRevealSourceEndpoint, input.Reason); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 	// output to client
 	err = app.writeJSON(w, http.StatusOK, envelope{"house_hold": houseHold}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
+}
 
+// recordPhoneNumberReveal() logs the house hold head's phone number being returned in full
+// to the user. Erased heads have no phone number, so there is nothing to log for them.
+func (app *application) recordPhoneNumberReveal(r *http.Request, houseHold *data.EnrichedHouseHold, source string, reason string) error {
+	if houseHold.PhoneNumber == "" {
+		return nil
+	}
+	return app.models.PIIReveal.RecordPIIReveal(r.Context(), &data.PIIReveal{
+		UserID:      app.contextGetUser(r).ID,
+		HouseHoldID: houseHold.HouseHoldID,
+		Source:      source,
+		Reason:      reason,
+		RequestID:   app.contextGetRequestID(r),
+	})
+}
+
+// getHouseHoldsGeoJSONHandler() is a handler that returns house holds as a GeoJSON FeatureCollection
+// The results can be filtered by program and geography via the query string, and narrowed down
+// to a radius around a point using latitude, longitude and radius_km. The house holds are
+// paginated with limit and cursor, the Link header pointing at the next page.
+func (app *application) getHouseHoldsGeoJSONHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+	// check the IDs' range before converting, so that huge IDs can't wrap round into it
+	programID := app.readInt(qs, "program_id", 0, v)
+	data.ValidateHouseHoldGeoFilterID(v, "program_id", programID)
+	geoLocationID := app.readInt(qs, "geo_location_id", 0, v)
+	data.ValidateHouseHoldGeoFilterID(v, "geo_location_id", geoLocationID)
+	// read the filters from the query string
+	filter := &data.HouseHoldGeoFilter{
+		ProgramID:     int32(programID),
+		GeoLocationID: int32(geoLocationID),
+		County:        app.readString(qs, "county", ""),
+		SubCounty:     app.readString(qs, "sub_county", ""),
+		Latitude:      app.readOptionalFloat(qs, "latitude", v),
+		Longitude:     app.readOptionalFloat(qs, "longitude", v),
+		RadiusKm:      app.readOptionalFloat(qs, "radius_km", v),
+	}
+	page := app.readPage(qs, v)
+	// validate the filters
+	if data.ValidateHouseHoldGeoFilter(v, filter); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+	// get the feature collection
+	featureCollection, next, err := app.models.HouseHold.GetHouseHoldsGeoJSON(r.Context(), filter, page)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// output to client, GeoJSON is not wrapped in an envelope
+	headers := make(http.Header)
+	headers.Set("Content-Type", "application/geo+json")
+	if next != nil {
+		headers.Set("Link", nextPageLink(r, next))
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"type": featureCollection.Type, "features": featureCollection.Features}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
 }
 
 // createNewHouseholdHeadHandler() is a handler that creates a new house hold head
@@ -98,11 +262,12 @@ func (app *application) getHouseHoldInformationHandler(w http.ResponseWriter, r
 // new household head as well as the encryption key to be saved in the database
 func (app *application) createNewHouseholdHeadHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		HouseHoldID int32  `json:"house_hold_id"`
-		Name        string `json:"name"`
-		NationalID  string `json:"national_id"`
-		PhoneNumber string `json:"phone_number"`
-		Age         int32  `json:"age"`
+		HouseHoldID    int32  `json:"house_hold_id"`
+		Name           string `json:"name"`
+		NationalID     string `json:"national_id"`
+		NationalIDType string `json:"national_id_type"`
+		PhoneNumber    string `json:"phone_number"`
+		Age            int32  `json:"age"`
 	}
 	// read the request to the input struct
 	err := app.readJSON(w, r, &input)
@@ -110,36 +275,47 @@ func (app *application) createNewHouseholdHeadHandler(w http.ResponseWriter, r *
 		app.badRequestResponse(w, r, err)
 		return
 	}
+	// heads identify with a Kenyan national ID unless told otherwise
+	if input.NationalIDType == "" {
+		input.NationalIDType = validator.IDTypeNationalID
+	}
 	// create a new HouseHoldHead struct and read the input struct to it
 	houseHoldHead := &data.HouseHoldHead{
-		HouseHoldID: input.HouseHoldID,
-		Name:        input.Name,
-		NationalID:  input.NationalID,
-		PhoneNumber: input.PhoneNumber,
-		Age:         input.Age,
+		HouseHoldID:    input.HouseHoldID,
+		Name:           input.Name,
+		NationalID:     validator.NormalizeNationalID(input.NationalID),
+		NationalIDType: input.NationalIDType,
+		PhoneNumber:    input.PhoneNumber,
+		Age:            input.Age,
 	}
 	// validate the house hold head struct
 	v := validator.New()
 	if data.ValidateHouseHoldHead(v, houseHoldHead); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+	// normalize the phone number to E.164 so that we only ever encrypt canonical numbers
+	if data.NormalizeHouseHoldHeadPhoneNumber(v, app.phoneNormalizer, houseHoldHead); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 	// we are good now, lets create the house hold head
-	err = app.models.HouseHold.CreateNewHouseholdHead(houseHoldHead, app.config.encryption.key)
+	err = app.models.HouseHold.CreateNewHouseholdHead(r.Context(), houseHoldHead, app.config.Encryption.Key)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrHouseHoldDoesNotExist):
-			v.AddError("house_hold_id", "house hold does not exist")
-			app.conflictResponse(w, r, v.Errors)
+			v.AddFieldError("house_hold_id", validator.CodeNotFound, "house hold does not exist")
+			app.conflictResponse(w, r, errCodeHouseHoldNotFound, v)
 		case errors.Is(err, data.ErrHouseHoldAlreadyExists):
-			v.AddError("house_hold_id", "house hold already exists and has a head")
-			app.conflictResponse(w, r, v.Errors)
+			v.AddFieldError("house_hold_id", validator.CodeAlreadyExists, "house hold already exists and has a head")
+			app.conflictResponse(w, r, errCodeHouseHoldHeadExists, v)
 		default:
-			app.serverErrorResponse(w, r, err)
+			app.dataErrorResponse(w, r, err)
 		}
 		return
 	}
-
+	// the head is added as a member by the database trigger
+	app.metrics.membersCreated.Inc()
 	// output to client
 	err = app.writeJSON(w, http.StatusCreated, envelope{"house_hold_head": houseHoldHead}, nil)
 	if err != nil {
@@ -173,18 +349,18 @@ func (app *application) createNewHouseholdMemberHandler(w http.ResponseWriter, r
 	// validate the house hold member struct
 	v := validator.New()
 	if data.ValidateHouseHoldMember(v, houseHoldMember); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 	// check if the household head has been created for this household
 	// if not, we return an error stating that the household head has not been created yet.
 	// This enhances data integrity
-	_, err = app.models.HouseHold.GetHouseholdHeadByHouseholdId(houseHoldMember.HouseHoldID)
+	_, err = app.models.HouseHold.GetHouseholdHeadByHouseholdId(r.Context(), houseHoldMember.HouseHoldID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrHouseHoldHeadDoesNotExist):
-			v.AddError("house_hold_id", "house hold or its head does not exist")
-			app.conflictResponse(w, r, v.Errors)
+			v.AddFieldError("house_hold_id", validator.CodeNotFound, "house hold or its head does not exist")
+			app.conflictResponse(w, r, errCodeHouseHoldHeadNotFound, v)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
@@ -192,24 +368,161 @@ func (app *application) createNewHouseholdMemberHandler(w http.ResponseWriter, r
 	}
 
 	// we are good now, lets create the house hold member
-	err = app.models.HouseHold.CreateNewHouseholdMember(houseHoldMember)
+	err = app.models.HouseHold.CreateNewHouseholdMember(r.Context(), houseHoldMember)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrHouseHoldDoesNotExist):
-			v.AddError("house_hold_id", "house hold does not exist")
-			app.conflictResponse(w, r, v.Errors)
+			v.AddFieldError("house_hold_id", validator.CodeNotFound, "house hold does not exist")
+			app.conflictResponse(w, r, errCodeHouseHoldNotFound, v)
 		case errors.Is(err, data.ErrHouseHoldMemberExists):
-			v.AddError("house_hold_id", "house hold member already exists")
-			app.conflictResponse(w, r, v.Errors)
+			v.AddFieldError("house_hold_id", validator.CodeAlreadyExists, "house hold member already exists")
+			app.conflictResponse(w, r, errCodeHouseHoldMemberExists, v)
 		default:
-			app.serverErrorResponse(w, r, err)
+			app.dataErrorResponse(w, r, err)
 		}
 		return
 	}
-
+	app.metrics.membersCreated.Inc()
 	// output to client
 	err = app.writeJSON(w, http.StatusCreated, envelope{"house_hold_member": houseHoldMember}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// enrollHouseHoldHandler() is a handler that enrolls an existing house hold in another
+// program, so that a house hold receiving several programs is only registered once.
+// The program must be open for enrollment and have room for the house hold.
+func (app *application) enrollHouseHoldHandler(w http.ResponseWriter, r *http.Request) {
+	// get the house hold id from the URL parameter
+	houseHoldID, err := app.readIDParam(r, "householdID")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	var input struct {
+		ProgramID int32 `json:"program_id"`
+	}
+	// read the request to the input struct
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	enrollment := &data.Enrollment{
+		HouseHoldID: int32(houseHoldID),
+		ProgramID:   input.ProgramID,
+	}
+	// validate the household ID and the enrollment
+	v := validator.New()
+	data.ValidateURLID(v, houseHoldID, "householdID")
+	if data.ValidateEnrollment(v, enrollment); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+	// we are good now, lets enroll the house hold
+	err = app.models.HouseHold.EnrollHouseHold(r.Context(), enrollment)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrHouseHoldDoesNotExist):
+			app.resourceNotFoundResponse(w, r, errCodeHouseHoldNotFound, "house hold does not exist")
+		case errors.Is(err, data.ErrProgramDoesNotExist):
+			v.AddFieldError("program_id", validator.CodeNotFound, "program does not exist")
+			app.conflictResponse(w, r, errCodeProgramNotFound, v)
+		case errors.Is(err, data.ErrHouseHoldAlreadyEnrolled):
+			v.AddFieldError("program_id", validator.CodeAlreadyExists, "house hold is already enrolled in the program")
+			app.conflictResponse(w, r, errCodeEnrollmentExists, v)
+		case isEnrollmentLimitError(err):
+			app.enrollmentLimitResponse(w, r, v, err, "program_id")
+		default:
+			app.dataErrorResponse(w, r, err)
+		}
+		return
+	}
+	// output to client
+	err = app.writeJSON(w, http.StatusCreated, envelope{"enrollment": enrollment}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateEnrollmentStatusHandler() is a handler that changes the status of a house hold's
+// enrollment, e.g suspending it or recording the house hold exiting the program and why.
+// Exiting is final, an exited enrollment can't be changed.
+func (app *application) updateEnrollmentStatusHandler(w http.ResponseWriter, r *http.Request) {
+	// get the house hold and enrollment ids from the URL parameters
+	houseHoldID, err := app.readIDParam(r, "householdID")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	enrollmentID, err := app.readIDParam(r, "enrollmentID")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	var input struct {
+		Status     string `json:"status"`
+		ExitReason string `json:"exit_reason"`
+	}
+	// read the request to the input struct
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	enrollment := &data.Enrollment{
+		ID:          int32(enrollmentID),
+		HouseHoldID: int32(houseHoldID),
+		Status:      input.Status,
+		ExitReason:  input.ExitReason,
+	}
+	// validate the IDs and the new status
+	v := validator.New()
+	data.ValidateURLID(v, houseHoldID, "householdID")
+	data.ValidateURLID(v, enrollmentID, "enrollmentID")
+	if data.ValidateEnrollmentStatus(v, enrollment); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+	// update the enrollment
+	err = app.models.HouseHold.UpdateEnrollmentStatus(r.Context(), enrollment)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEnrollmentDoesNotExist):
+			app.resourceNotFoundResponse(w, r, errCodeEnrollmentNotFound, "enrollment does not exist")
+		case errors.Is(err, data.ErrEnrollmentExited):
+			v.AddFieldError("status", validator.CodeNotPermitted, err.Error())
+			app.conflictResponse(w, r, errCodeEnrollmentExited, v)
+		default:
+			app.dataErrorResponse(w, r, err)
+		}
+		return
+	}
+	// output to client
+	err = app.writeJSON(w, http.StatusOK, envelope{"enrollment": enrollment}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// isEnrollmentLimitError() reports whether err is one of the reasons a program can't take a
+// house hold: its enrollment window, its cap or its quota for the house hold's county
+func isEnrollmentLimitError(err error) bool {
+	return errors.Is(err, data.ErrEnrollmentNotOpen) || errors.Is(err, data.ErrEnrollmentClosed) ||
+		errors.Is(err, data.ErrProgramFull) || errors.Is(err, data.ErrCountyQuotaFull)
+}
+
+// enrollmentLimitResponse() sends the 422 for a house hold the program can't take, with the
+// error on program_id, or on countyField when the county's quota is full
+func (app *application) enrollmentLimitResponse(w http.ResponseWriter, r *http.Request, v *validator.Validator, err error, countyField string) {
+	switch {
+	case errors.Is(err, data.ErrProgramFull):
+		v.AddFieldError("program_id", validator.CodeCapacityReached, err.Error())
+	case errors.Is(err, data.ErrCountyQuotaFull):
+		v.AddFieldError(countyField, validator.CodeCapacityReached, err.Error())
+	default:
+		v.AddFieldError("program_id", validator.CodeEnrollmentClosed, err.Error())
+	}
+	app.failedValidationResponse(w, r, v)
+}
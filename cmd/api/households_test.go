@@ -0,0 +1,479 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Blue-Davinci/SocialAid/internal/data"
+	"github.com/Blue-Davinci/SocialAid/internal/validator"
+)
+
+func TestHouseHoldRoutesRequireAuthentication(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	res := ts.do(t, http.MethodGet, "/v1/house_holds/1", nil, nil)
+	checkProblem(t, res, http.StatusUnauthorized, errCodeAuthenticationNeeded)
+
+	headers := http.Header{}
+	headers.Set("ApiKey", "NOTAREGISTEREDKEY")
+	res = ts.do(t, http.MethodGet, "/v1/house_holds/1", headers, nil)
+	checkProblem(t, res, http.StatusUnauthorized, errCodeInvalidAPIKey)
+}
+
+func TestCreateNewHouseHold(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	program := seedProgram(t, app, "Inua Jamii")
+	geoLocation := seedGeoLocation(t, app, "Nairobi", "Highridge")
+
+	res := ts.do(t, http.MethodPost, "/v1/house_holds", authHeaders(), map[string]any{
+		"program_id": program.ID, "geo_location_id": geoLocation.ID, "name": "Otieno",
+	})
+	if res.status != http.StatusCreated {
+		t.Fatalf("status = %d, want %d\n%s", res.status, http.StatusCreated, res.body)
+	}
+
+	tests := []struct {
+		name   string
+		body   map[string]any
+		status int
+		code   string
+		field  string
+	}{
+		{
+			name:   "unknown geo location",
+			body:   map[string]any{"program_id": program.ID, "geo_location_id": 999, "name": "Otieno"},
+			status: http.StatusConflict,
+			code:   errCodeGeoLocationNotFound,
+			field:  "geo_location_id",
+		},
+		{
+			name:   "unknown program",
+			body:   map[string]any{"program_id": 999, "geo_location_id": geoLocation.ID, "name": "Otieno"},
+			status: http.StatusConflict,
+			code:   errCodeProgramNotFound,
+			field:  "program_id",
+		},
+		{
+			name:   "missing name",
+			body:   map[string]any{"program_id": program.ID, "geo_location_id": geoLocation.ID},
+			status: http.StatusUnprocessableEntity,
+			code:   errCodeValidationFailed,
+			field:  "name",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ts.do(t, http.MethodPost, "/v1/house_holds", authHeaders(), tt.body)
+			p := checkProblem(t, res, tt.status, tt.code)
+			if _, ok := p.Errors[tt.field]; !ok {
+				t.Errorf("no error for %s: %s", tt.field, res.body)
+			}
+		})
+	}
+}
+
+func TestCreateNewHouseholdHeadAndMember(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	program := seedProgram(t, app, "Inua Jamii")
+	geoLocation := seedGeoLocation(t, app, "Nairobi", "Highridge")
+	houseHold := seedHouseHold(t, app, program.ID, geoLocation.ID)
+	head := map[string]any{
+		"house_hold_id": houseHold.ID,
+		"name":          "Akinyi Otieno",
+		"national_id":   "12345678",
+		"phone_number":  "0712 345 678",
+		"age":           42,
+	}
+	member := map[string]any{"house_hold_id": houseHold.ID, "name": "Baraka Otieno", "age": 9, "relation": "Son"}
+
+	// members can only be added once the house hold has a head
+	res := ts.do(t, http.MethodPost, "/v1/house_holds/member", authHeaders(), member)
+	checkProblem(t, res, http.StatusConflict, errCodeHouseHoldHeadNotFound)
+
+	res = ts.do(t, http.MethodPost, "/v1/house_holds/head", authHeaders(), head)
+	if res.status != http.StatusCreated {
+		t.Fatalf("status = %d, want %d\n%s", res.status, http.StatusCreated, res.body)
+	}
+	var created struct {
+		Head data.HouseHoldHead `json:"house_hold_head"`
+	}
+	res.decode(t, &created)
+	if created.Head.PhoneNumber != "+254712345678" {
+		t.Errorf("phone number = %q, want it normalized to E.164", created.Head.PhoneNumber)
+	}
+
+	// one head per house hold
+	res = ts.do(t, http.MethodPost, "/v1/house_holds/head", authHeaders(), head)
+	checkProblem(t, res, http.StatusConflict, errCodeHouseHoldHeadExists)
+
+	res = ts.do(t, http.MethodPost, "/v1/house_holds/member", authHeaders(), member)
+	if res.status != http.StatusCreated {
+		t.Fatalf("status = %d, want %d\n%s", res.status, http.StatusCreated, res.body)
+	}
+	res = ts.do(t, http.MethodPost, "/v1/house_holds/member", authHeaders(), member)
+	checkProblem(t, res, http.StatusConflict, errCodeHouseHoldMemberExists)
+	// the head was added as a member, so their name is taken too
+	res = ts.do(t, http.MethodPost, "/v1/house_holds/member", authHeaders(), map[string]any{
+		"house_hold_id": houseHold.ID, "name": "Akinyi Otieno", "age": 42, "relation": "Aunt",
+	})
+	checkProblem(t, res, http.StatusConflict, errCodeHouseHoldMemberExists)
+
+	tests := []struct {
+		name   string
+		body   map[string]any
+		status int
+		code   string
+		field  string
+	}{
+		{
+			name:   "unknown house hold",
+			body:   map[string]any{"house_hold_id": 999, "name": "N", "national_id": "23456789", "phone_number": "0722345678", "age": 30},
+			status: http.StatusConflict,
+			code:   errCodeHouseHoldNotFound,
+			field:  "house_hold_id",
+		},
+		{
+			name:   "invalid national id",
+			body:   map[string]any{"house_hold_id": houseHold.ID, "name": "N", "national_id": "12AB", "phone_number": "0722345678", "age": 30},
+			status: http.StatusUnprocessableEntity,
+			code:   errCodeValidationFailed,
+			field:  "national_id",
+		},
+		{
+			name:   "national id holder under 18",
+			body:   map[string]any{"house_hold_id": houseHold.ID, "name": "N", "national_id": "23456789", "phone_number": "0722345678", "age": 16},
+			status: http.StatusUnprocessableEntity,
+			code:   errCodeValidationFailed,
+			field:  "age",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ts.do(t, http.MethodPost, "/v1/house_holds/head", authHeaders(), tt.body)
+			p := checkProblem(t, res, tt.status, tt.code)
+			if _, ok := p.Errors[tt.field]; !ok {
+				t.Errorf("no error for %s: %s", tt.field, res.body)
+			}
+		})
+	}
+}
+
+func TestGetHouseHoldInformation(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	program := seedProgram(t, app, "Inua Jamii")
+	geoLocation := seedGeoLocation(t, app, "Nairobi", "Highridge")
+	houseHold := seedHouseHold(t, app, program.ID, geoLocation.ID)
+	head := &data.HouseHoldHead{
+		HouseHoldID:    houseHold.ID,
+		Name:           "Akinyi Otieno",
+		NationalID:     "12345678",
+		NationalIDType: validator.IDTypeNationalID,
+		PhoneNumber:    "+254712345678",
+		Age:            42,
+	}
+	if err := app.models.HouseHold.CreateNewHouseholdHead(context.Background(), head, app.config.Encryption.Key); err != nil {
+		t.Fatal(err)
+	}
+	path := fmt.Sprintf("/v1/house_holds/%d", houseHold.ID)
+
+	get := func(t *testing.T) data.EnrichedHouseHold {
+		t.Helper()
+		res := ts.do(t, http.MethodGet, path, authHeaders(), nil)
+		if res.status != http.StatusOK {
+			t.Fatalf("status = %d, want %d\n%s", res.status, http.StatusOK, res.body)
+		}
+		var got struct {
+			HouseHold data.EnrichedHouseHold `json:"house_hold"`
+		}
+		res.decode(t, &got)
+		return got.HouseHold
+	}
+
+	got := get(t)
+	if got.HouseHoldHeadName != "Akinyi Otieno" || got.County != "Nairobi" || got.HouseHoldMemberCount != 1 {
+		t.Errorf("house hold = %+v", got)
+	}
+	if got.PhoneNumber != data.MaskPhoneNumber(head.PhoneNumber) {
+		t.Errorf("phone number = %q, want it masked without pii:read", got.PhoneNumber)
+	}
+
+	user, err := app.models.Auth.GetUserByEmail(context.Background(), "officer@socialaid.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.models.Auth.AddPermissionForUser(context.Background(), user.ID, data.PermissionPIIRead); err != nil {
+		t.Fatal(err)
+	}
+	if got := get(t); got.PhoneNumber != head.PhoneNumber {
+		t.Errorf("phone number = %q, want %q with pii:read", got.PhoneNumber, head.PhoneNumber)
+	}
+
+	res := ts.do(t, http.MethodGet, "/v1/house_holds/999", authHeaders(), nil)
+	checkProblem(t, res, http.StatusNotFound, errCodeHouseHoldNotFound)
+	res = ts.do(t, http.MethodGet, path+"?fields=budget", authHeaders(), nil)
+	checkProblem(t, res, http.StatusUnprocessableEntity, errCodeValidationFailed)
+}
+
+func TestHouseHoldEnrollments(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	program := seedProgram(t, app, "Inua Jamii")
+	other := seedProgram(t, app, "Kazi Mtaani")
+	geoLocation := seedGeoLocation(t, app, "Nairobi", "Highridge")
+	houseHold := seedHouseHold(t, app, program.ID, geoLocation.ID)
+	path := fmt.Sprintf("/v1/house_holds/%d/enrollments", houseHold.ID)
+
+	res := ts.do(t, http.MethodPost, path, authHeaders(), map[string]any{"program_id": other.ID})
+	if res.status != http.StatusCreated {
+		t.Fatalf("status = %d, want %d\n%s", res.status, http.StatusCreated, res.body)
+	}
+	var created struct {
+		Enrollment data.Enrollment `json:"enrollment"`
+	}
+	res.decode(t, &created)
+	if created.Enrollment.Status != data.EnrollmentStatusActive {
+		t.Errorf("enrollment = %+v", created.Enrollment)
+	}
+
+	// the house hold is already enrolled in both programs
+	for _, programID := range []int32{program.ID, other.ID} {
+		res = ts.do(t, http.MethodPost, path, authHeaders(), map[string]any{"program_id": programID})
+		checkProblem(t, res, http.StatusConflict, errCodeEnrollmentExists)
+	}
+	res = ts.do(t, http.MethodPost, path, authHeaders(), map[string]any{"program_id": 999})
+	checkProblem(t, res, http.StatusConflict, errCodeProgramNotFound)
+	res = ts.do(t, http.MethodPost, "/v1/house_holds/999/enrollments", authHeaders(), map[string]any{"program_id": other.ID})
+	checkProblem(t, res, http.StatusNotFound, errCodeHouseHoldNotFound)
+
+	enrollmentPath := fmt.Sprintf("%s/%d", path, created.Enrollment.ID)
+	res = ts.do(t, http.MethodPatch, enrollmentPath, authHeaders(), map[string]any{"status": data.EnrollmentStatusExited})
+	p := checkProblem(t, res, http.StatusUnprocessableEntity, errCodeValidationFailed)
+	if _, ok := p.Errors["exit_reason"]; !ok {
+		t.Errorf("no error for exit_reason: %s", res.body)
+	}
+	res = ts.do(t, http.MethodPatch, enrollmentPath, authHeaders(), map[string]any{"status": data.EnrollmentStatusExited, "exit_reason": "relocated"})
+	if res.status != http.StatusOK {
+		t.Fatalf("status = %d, want %d\n%s", res.status, http.StatusOK, res.body)
+	}
+	var updated struct {
+		Enrollment data.Enrollment `json:"enrollment"`
+	}
+	res.decode(t, &updated)
+	if updated.Enrollment.ExitedAt == nil || updated.Enrollment.ProgramID != other.ID {
+		t.Errorf("enrollment = %+v", updated.Enrollment)
+	}
+	// exiting is final
+	res = ts.do(t, http.MethodPatch, enrollmentPath, authHeaders(), map[string]any{"status": data.EnrollmentStatusActive})
+	checkProblem(t, res, http.StatusConflict, errCodeEnrollmentExited)
+	res = ts.do(t, http.MethodPatch, path+"/999", authHeaders(), map[string]any{"status": data.EnrollmentStatusSuspended})
+	checkProblem(t, res, http.StatusNotFound, errCodeEnrollmentNotFound)
+}
+
+func TestGetHouseHoldInformationRevealsOnlyWithBody(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	program := seedProgram(t, app, "Inua Jamii")
+	geoLocation := seedGeoLocation(t, app, "Nairobi", "Highridge")
+	houseHold := seedHouseHold(t, app, program.ID, geoLocation.ID)
+	head := &data.HouseHoldHead{
+		HouseHoldID:    houseHold.ID,
+		Name:           "Akinyi Otieno",
+		NationalID:     "12345678",
+		NationalIDType: validator.IDTypeNationalID,
+		PhoneNumber:    "+254712345678",
+		Age:            42,
+	}
+	ctx := context.Background()
+	if err := app.models.HouseHold.CreateNewHouseholdHead(ctx, head, app.config.Encryption.Key); err != nil {
+		t.Fatal(err)
+	}
+	user, err := app.models.Auth.GetUserByEmail(ctx, "officer@socialaid.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.models.Auth.AddPermissionForUser(ctx, user.ID, data.PermissionPIIRead); err != nil {
+		t.Fatal(err)
+	}
+	path := fmt.Sprintf("/v1/house_holds/%d", houseHold.ID)
+	reveals := func() int {
+		t.Helper()
+		reveals, err := app.models.PIIReveal.GetPIIReveals(ctx, &data.PIIRevealFilter{HouseHoldID: houseHold.ID, Limit: 100})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(reveals)
+	}
+
+	res := ts.do(t, http.MethodGet, path, authHeaders(), nil)
+	if res.status != http.StatusOK {
+		t.Fatalf("status = %d, want %d\n%s", res.status, http.StatusOK, res.body)
+	}
+	if got := reveals(); got != 1 {
+		t.Fatalf("%d reveals recorded, want 1", got)
+	}
+
+	// revalidating sends no phone number, so no reveal is recorded
+	for _, conditional := range []http.Header{
+		authHeaders("If-None-Match", res.header.Get("ETag")),
+		authHeaders("If-Modified-Since", res.header.Get("Last-Modified")),
+	} {
+		res := ts.do(t, http.MethodGet, path, conditional, nil)
+		if res.status != http.StatusNotModified || len(res.body) != 0 {
+			t.Fatalf("status = %d, want %d\n%s", res.status, http.StatusNotModified, res.body)
+		}
+	}
+	if got := reveals(); got != 1 {
+		t.Errorf("%d reveals recorded after revalidating, want 1", got)
+	}
+
+	// a stale copy is sent the body again, which is a reveal
+	res = ts.do(t, http.MethodGet, path, authHeaders("If-None-Match", `"stale"`), nil)
+	if res.status != http.StatusOK {
+		t.Fatalf("status = %d, want %d\n%s", res.status, http.StatusOK, res.body)
+	}
+	if got := reveals(); got != 2 {
+		t.Errorf("%d reveals recorded, want 2", got)
+	}
+}
+
+func TestGetHouseHoldsGeoJSONLimit(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	// 4294967297 would wrap round to a limit of 1 if it were narrowed to an int32 first
+	for _, limit := range []string{"0", "1001", "4294967297", "-4294967295"} {
+		res := ts.do(t, http.MethodGet, "/v1/house_holds.geojson?limit="+limit, authHeaders(), nil)
+		problem := checkProblem(t, res, http.StatusUnprocessableEntity, errCodeValidationFailed)
+		if _, ok := problem.Errors["limit"]; !ok {
+			t.Errorf("limit=%s: no error for limit, got %v", limit, problem.Errors)
+		}
+	}
+}
+
+func TestGetHouseHoldsGeoJSONIDFilters(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	program := seedProgram(t, app, "Inua Jamii")
+	seedHouseHold(t, app, program.ID, seedGeoLocation(t, app, "Nairobi", "Highridge").ID)
+	// 4294967297 would wrap round to program and geo location 1 if it were narrowed first
+	for _, query := range []string{"program_id=4294967297", "program_id=-1", "geo_location_id=4294967297", "geo_location_id=2147483648"} {
+		key := query[:strings.Index(query, "=")]
+		res := ts.do(t, http.MethodGet, "/v1/house_holds.geojson?"+query, authHeaders(), nil)
+		problem := checkProblem(t, res, http.StatusUnprocessableEntity, errCodeValidationFailed)
+		if _, ok := problem.Errors[key]; !ok {
+			t.Errorf("%s: no error for %s, got %v", query, key, problem.Errors)
+		}
+	}
+}
+
+func TestGetHouseHoldsGeoJSONProgramFilter(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	program := seedProgram(t, app, "Inua Jamii")
+	other := seedProgram(t, app, "Hunger Safety Net")
+	geoLocation := seedGeoLocation(t, app, "Nairobi", "Highridge")
+	latitude, longitude := -1.286, 36.817
+	houseHold := &data.HouseHold{ProgramID: program.ID, GeoLocationID: geoLocation.ID, Name: "Otieno", Latitude: &latitude, Longitude: &longitude}
+	if err := app.models.HouseHold.CreateNewHouseHold(context.Background(), houseHold); err != nil {
+		t.Fatal(err)
+	}
+	mapped := func(t *testing.T, programID int32) bool {
+		t.Helper()
+		res := ts.do(t, http.MethodGet, fmt.Sprintf("/v1/house_holds.geojson?program_id=%d", programID), authHeaders(), nil)
+		if res.status != http.StatusOK {
+			t.Fatalf("status = %d, want %d\n%s", res.status, http.StatusOK, res.body)
+		}
+		var collection data.GeoJSONFeatureCollection
+		res.decode(t, &collection)
+		for _, feature := range collection.Features {
+			if feature.Properties.HouseHoldID == houseHold.ID {
+				return true
+			}
+		}
+		return false
+	}
+	if !mapped(t, program.ID) || mapped(t, other.ID) {
+		t.Fatal("the house hold is not mapped under only the program it registered under")
+	}
+
+	// enrolling in a second program maps the house hold under both
+	path := fmt.Sprintf("/v1/house_holds/%d/enrollments", houseHold.ID)
+	res := ts.do(t, http.MethodPost, path, authHeaders(), map[string]any{"program_id": other.ID})
+	if res.status != http.StatusCreated {
+		t.Fatalf("status = %d, want %d\n%s", res.status, http.StatusCreated, res.body)
+	}
+	var created struct {
+		Enrollment data.Enrollment `json:"enrollment"`
+	}
+	res.decode(t, &created)
+	if !mapped(t, program.ID) || !mapped(t, other.ID) {
+		t.Error("the house hold is not mapped under both programs it is enrolled in")
+	}
+
+	// exiting a program drops the house hold from that program's map only
+	enrollmentPath := fmt.Sprintf("%s/%d", path, created.Enrollment.ID)
+	res = ts.do(t, http.MethodPatch, enrollmentPath, authHeaders(), map[string]any{"status": data.EnrollmentStatusExited, "exit_reason": "relocated"})
+	if res.status != http.StatusOK {
+		t.Fatalf("status = %d, want %d\n%s", res.status, http.StatusOK, res.body)
+	}
+	if !mapped(t, program.ID) || mapped(t, other.ID) {
+		t.Error("the house hold is still mapped under the program it exited")
+	}
+}
+
+func TestGetHouseHoldsGeoJSONRadiusScanCap(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	program := seedProgram(t, app, "Inua Jamii")
+	geoLocation := seedGeoLocation(t, app, "Nairobi", "Highridge")
+	ctx := context.Background()
+	seedAt := func(latitude, longitude float64) *data.HouseHold {
+		t.Helper()
+		houseHold := &data.HouseHold{ProgramID: program.ID, GeoLocationID: geoLocation.ID, Name: "Otieno", Latitude: &latitude, Longitude: &longitude}
+		if err := app.models.HouseHold.CreateNewHouseHold(ctx, houseHold); err != nil {
+			t.Fatal(err)
+		}
+		return houseHold
+	}
+	// a dense corner of the bounding box, about 6km away so outside the 5km radius, with a
+	// single house hold at the centre after it
+	for range 30 {
+		seedAt(-1.246, 36.857)
+	}
+	want := seedAt(-1.286, 36.817)
+
+	path := "/v1/house_holds.geojson?latitude=-1.286&longitude=36.817&radius_km=5&limit=1"
+	var features []data.GeoJSONFeature
+	pages := 0
+	for path != "" {
+		res := ts.do(t, http.MethodGet, path, authHeaders(), nil)
+		if res.status != http.StatusOK {
+			t.Fatalf("status = %d, want %d\n%s", res.status, http.StatusOK, res.body)
+		}
+		var page struct {
+			Features []data.GeoJSONFeature `json:"features"`
+		}
+		res.decode(t, &page)
+		features = append(features, page.Features...)
+		if pages++; pages > 10 {
+			t.Fatal("the pages never ended")
+		}
+		path = ""
+		if link := res.header.Get("Link"); link != "" {
+			path = link[strings.Index(link, "<")+1 : strings.Index(link, ">")]
+		}
+	}
+	// the first page stops reading short of the centre, so it is empty but has a next page
+	if pages < 2 {
+		t.Errorf("got %d pages, want the radius search to stop reading before the last house hold", pages)
+	}
+	if len(features) != 1 || features[0].Properties.HouseHoldID != want.ID {
+		t.Errorf("features = %+v, want only house hold %d", features, want.ID)
+	}
+}
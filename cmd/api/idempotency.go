@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/Blue-Davinci/SocialAid/internal/data"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.uber.org/zap"
+)
+
+const (
+	// idempotencyKeyHeader is the header clients send a unique key for a POST in, so that
+	// it can be retried safely
+	idempotencyKeyHeader = "Idempotency-Key"
+	// idempotentReplayedHeader marks a response replayed from an earlier request
+	idempotentReplayedHeader = "Idempotent-Replayed"
+	// idempotencyPurgeInterval is how often we delete the expired idempotency keys
+	idempotencyPurgeInterval = time.Hour
+	// idempotencyReserveAttempts bounds how often we try to reserve a key that keeps
+	// expiring or being released between our reads
+	idempotencyReserveAttempts = 3
+)
+
+// rejectIdempotencyKey() is a middleware for the POST routes that don't take idempotency
+// keys: the public routes, whose anonymous callers would all share user 0's keys, and the
+// phone number reveal, which is logged every time and never stored for replay. Rather than
+// silently running a retry again, we refuse the key so that clients know.
+func (app *application) rejectIdempotencyKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && r.Header.Get(idempotencyKeyHeader) != "" {
+			app.badRequestResponse(w, r, errors.New("Idempotency-Key is not supported on this route"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// idempotencyKeyRX accepts up to 255 printable ASCII characters, e.g a UUID
+var idempotencyKeyRX = regexp.MustCompile(`^[\x21-\x7E]{1,255}$`)
+
+// idempotent() is a middleware that makes POST requests sent with an Idempotency-Key header
+// safe to retry. The first request reserves the key along with a hash of the request and,
+// once handled, stores the response. Retries with the same key and request are sent the
+// stored response, a different request with the same key gets a 422, and a retry arriving
+// while the first request is still being processed gets a 409. The reservation is a lease,
+// once it runs out a retry reclaims the key from a request that crashed or timed out rather
+// than getting a 409 until the key expires. Keys are scoped to the user,
+// so it must run after authenticate() and requireAuthenticatedUser(). Anonymous requests
+// would all share user 0's keys and could be sent each other's responses, so a key sent
+// without an API key is rejected.
+func (app *application) idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if r.Method != http.MethodPost || key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if app.contextGetUserID(r) == 0 {
+			app.badRequestResponse(w, r, errors.New("Idempotency-Key is only accepted on authenticated requests"))
+			return
+		}
+		if !idempotencyKeyRX.MatchString(key) {
+			app.badRequestResponse(w, r, errors.New("Idempotency-Key must be 1 to 255 printable ASCII characters"))
+			return
+		}
+		// read the body to hash it, then hand the handler a fresh reader over it
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes))
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				err = fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+			}
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		now := time.Now()
+		lockedUntil := now.Add(app.config.Idempotency.Lease)
+		record := &data.IdempotencyRecord{
+			UserID:      app.contextGetUserID(r),
+			Key:         key,
+			RequestHash: hashRequest(r, body),
+			ExpiresAt:   now.Add(app.config.Idempotency.TTL),
+			LockedUntil: &lockedUntil,
+		}
+		for attempt := 0; attempt < idempotencyReserveAttempts; attempt++ {
+			err := app.models.Idempotency.ReserveIdempotencyKey(r.Context(), record)
+			if err == nil {
+				app.serveIdempotent(w, r, next, record)
+				return
+			}
+			if !errors.Is(err, data.ErrIdempotencyKeyExists) {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			stored, err := app.models.Idempotency.GetIdempotencyKey(r.Context(), record.UserID, key)
+			if err != nil {
+				// the record expired or was released since we tried to reserve it
+				if errors.Is(err, data.ErrIdempotencyKeyNotFound) {
+					continue
+				}
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			switch {
+			// the lease ran out since we tried to reserve it, so try again
+			case stored.Abandoned():
+				continue
+			case !bytes.Equal(stored.RequestHash, record.RequestHash):
+				app.idempotencyKeyReusedResponse(w, r)
+			case stored.InProgress():
+				app.idempotencyKeyInUseResponse(w, r)
+			default:
+				replayIdempotentResponse(w, stored)
+			}
+			return
+		}
+		app.serverErrorResponse(w, r, fmt.Errorf("could not reserve idempotency key after %d attempts", idempotencyReserveAttempts))
+	})
+}
+
+// serveIdempotent() runs the handler for a reserved key and stores its response. Server
+// errors, cancelled requests and panics aren't stored, we release the key instead so that
+// the client can retry.
+func (app *application) serveIdempotent(w http.ResponseWriter, r *http.Request, next http.Handler, record *data.IdempotencyRecord) {
+	// the key must be settled even if the client has gone away
+	ctx := context.WithoutCancel(r.Context())
+	completed := false
+	defer func() {
+		if completed {
+			return
+		}
+		if err := app.models.Idempotency.ReleaseIdempotencyKey(ctx, record.ID); err != nil {
+			app.logger.Error("error releasing idempotency key",
+				zap.String("request_id", app.contextGetRequestID(r)),
+				zap.Int32("idempotency_key_id", record.ID),
+				zap.String("error", err.Error()))
+		}
+	}()
+	var response bytes.Buffer
+	ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+	ww.Tee(&response)
+	next.ServeHTTP(ww, r)
+	status := ww.Status()
+	if status == 0 && ww.BytesWritten() > 0 {
+		status = http.StatusOK
+	}
+	if status == 0 || status >= http.StatusInternalServerError {
+		return
+	}
+	record.StatusCode = status
+	record.ContentType = w.Header().Get("Content-Type")
+	record.ResponseBody = response.Bytes()
+	if err := app.models.Idempotency.CompleteIdempotencyKey(ctx, record); err != nil {
+		app.logError(r, err)
+		return
+	}
+	completed = true
+}
+
+// replayIdempotentResponse() sends the stored response of an earlier request
+func replayIdempotentResponse(w http.ResponseWriter, record *data.IdempotencyRecord) {
+	if record.ContentType != "" {
+		w.Header().Set("Content-Type", record.ContentType)
+	}
+	w.Header().Set(idempotentReplayedHeader, "true")
+	w.WriteHeader(record.StatusCode)
+	w.Write(record.ResponseBody)
+}
+
+// hashRequest() returns the SHA-256 hash of the request's method, path and body, which
+// identifies the request a key was first used for
+func hashRequest(r *http.Request, body []byte) []byte {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s\n", r.Method, r.URL.Path)
+	hash.Write(body)
+	return hash.Sum(nil)
+}
+
+// purgeExpiredIdempotencyKeys() periodically deletes the expired idempotency keys until the
+// context is cancelled. Expired keys are already ignored, this only keeps the table small.
+func (app *application) purgeExpiredIdempotencyKeys(ctx context.Context) {
+	ticker := time.NewTicker(idempotencyPurgeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := app.models.Idempotency.DeleteExpiredIdempotencyKeys(ctx)
+			if err != nil {
+				app.logger.Error("error purging expired idempotency keys", zap.String("error", err.Error()))
+				continue
+			}
+			app.logger.Info("purged expired idempotency keys", zap.Int64("deleted", deleted))
+		}
+	}
+}
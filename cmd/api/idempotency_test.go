@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Blue-Davinci/SocialAid/internal/data"
+)
+
+func TestIdempotentHouseHoldCreation(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	program := seedProgram(t, app, "Inua Jamii")
+	geoLocation := seedGeoLocation(t, app, "Nairobi", "Highridge")
+	body := map[string]any{"program_id": program.ID, "geo_location_id": geoLocation.ID, "name": "Otieno"}
+	headers := authHeaders(idempotencyKeyHeader, "4b1a7c52-house-hold")
+
+	// the first request reserves the key and stores the response
+	first := ts.do(t, http.MethodPost, "/v1/house_holds", headers, body)
+	if first.status != http.StatusCreated {
+		t.Fatalf("status = %d, want %d\n%s", first.status, http.StatusCreated, first.body)
+	}
+	if first.header.Get(idempotentReplayedHeader) != "" {
+		t.Errorf("first response is marked as replayed")
+	}
+
+	// a retry is sent the stored response rather than creating a second house hold
+	retry := ts.do(t, http.MethodPost, "/v1/house_holds", headers, body)
+	if retry.status != http.StatusCreated || !bytes.Equal(retry.body, first.body) {
+		t.Errorf("retry = %d %s, want %d %s", retry.status, retry.body, first.status, first.body)
+	}
+	if retry.header.Get(idempotentReplayedHeader) != "true" {
+		t.Errorf("%s = %q, want true", idempotentReplayedHeader, retry.header.Get(idempotentReplayedHeader))
+	}
+	if _, err := app.models.HouseHold.GetHouseHoldInformation(context.Background(), 2, data.HouseHoldIncludes{}, app.config.Encryption.Key); !errors.Is(err, data.ErrHouseHoldDoesNotExist) {
+		t.Errorf("a second house hold was created, err = %v", err)
+	}
+
+	// the same key with another body is refused
+	body["name"] = "Wanjiru"
+	res := ts.do(t, http.MethodPost, "/v1/house_holds", headers, body)
+	checkProblem(t, res, http.StatusUnprocessableEntity, errCodeIdempotencyKeyReused)
+
+	res = ts.do(t, http.MethodPost, "/v1/house_holds", authHeaders(idempotencyKeyHeader, "not a valid key"), body)
+	checkProblem(t, res, http.StatusBadRequest, errCodeBadRequest)
+}
+
+func TestIdempotencyKeyRejectedWhereUnsupported(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	program := seedProgram(t, app, "Inua Jamii")
+	houseHold := seedHouseHold(t, app, program.ID, seedGeoLocation(t, app, "Nairobi", "Highridge").ID)
+	tests := []struct {
+		name    string
+		path    string
+		headers http.Header
+		body    any
+	}{
+		{name: "programs", path: "/v1/programs", headers: http.Header{}, body: map[string]any{"name": "Kazi Mtaani", "category": "cash transfer", "description": "stipend"}},
+		{name: "geo locations", path: "/v1/geo_locations", headers: http.Header{}, body: map[string]any{"county": "Kisumu"}},
+		{name: "register", path: "/v1/register", headers: http.Header{}, body: map[string]any{}},
+		// authenticated, but reveals are never replayed
+		{name: "reveal", path: fmt.Sprintf("/v1/house_holds/%d/reveal", houseHold.ID), headers: authHeaders(), body: map[string]any{"reason": "payment query"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.headers.Set(idempotencyKeyHeader, "unsupported-route-key")
+			res := ts.do(t, http.MethodPost, tt.path, tt.headers, tt.body)
+			checkProblem(t, res, http.StatusBadRequest, errCodeBadRequest)
+		})
+	}
+	// without the header the routes run as before
+	res := ts.do(t, http.MethodPost, "/v1/programs", nil, tests[0].body)
+	if res.status != http.StatusCreated {
+		t.Errorf("status = %d, want %d\n%s", res.status, http.StatusCreated, res.body)
+	}
+}
+
+// idempotentRequest() returns a POST with the idempotency key, sent by the test user or by
+// an anonymous one
+func idempotentRequest(t *testing.T, app *application, key string, anonymous bool) *http.Request {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "/v1/house_holds", bytes.NewBufferString(`{"name": "Otieno"}`))
+	r.Header.Set(idempotencyKeyHeader, key)
+	if anonymous {
+		return app.contextSetUser(r, data.AnonymousUser)
+	}
+	user, err := app.models.Auth.GetUserByEmail(context.Background(), "officer@socialaid.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return app.contextSetUser(r, user)
+}
+
+func TestIdempotentInProgress(t *testing.T) {
+	app := newTestApplication(t)
+	started, release := make(chan struct{}), make(chan struct{})
+	handler := app.idempotent(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	first := idempotentRequest(t, app, "slow-key", false)
+	done := make(chan int)
+	go func() {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, first)
+		done <- rr.Code
+	}()
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the first request never reached the handler")
+	}
+
+	// a retry while the first request is still running is told to come back later
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, idempotentRequest(t, app, "slow-key", false))
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("status = %d, want %d\n%s", rr.Code, http.StatusConflict, rr.Body)
+	}
+	if rr.Header().Get("Retry-After") == "" {
+		t.Error("no Retry-After header")
+	}
+
+	close(release)
+	if code := <-done; code != http.StatusCreated {
+		t.Errorf("first request status = %d, want %d", code, http.StatusCreated)
+	}
+}
+
+func TestIdempotentReleasesKeyOnServerError(t *testing.T) {
+	app := newTestApplication(t)
+	var calls atomic.Int32
+	handler := app.idempotent(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			app.serverErrorResponse(w, r, errors.New("the handler failed"))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, idempotentRequest(t, app, "retry-after-500", false))
+	if rr.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusInternalServerError)
+	}
+	// the key was released, so the retry runs the handler again rather than replaying the 500
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, idempotentRequest(t, app, "retry-after-500", false))
+	if rr.Code != http.StatusCreated || rr.Header().Get(idempotentReplayedHeader) != "" {
+		t.Errorf("retry = %d replayed %q, want a fresh %d", rr.Code, rr.Header().Get(idempotentReplayedHeader), http.StatusCreated)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("handler ran %d times, want 2", calls.Load())
+	}
+}
+
+func TestIdempotentRejectsAnonymousKeys(t *testing.T) {
+	app := newTestApplication(t)
+	handler := app.idempotent(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the handler ran for an anonymous key")
+	}))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, idempotentRequest(t, app, "anonymous-key", true))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", rr.Code, http.StatusBadRequest)
+	}
+}
+
+func TestIdempotentReclaimsAbandonedKey(t *testing.T) {
+	app := newTestApplication(t)
+	var calls atomic.Int32
+	handler := app.idempotent(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	// reserve the key the way a request that then crashed would have, leaving it in progress
+	reserve := func(key string, lockedUntil time.Time) *data.IdempotencyRecord {
+		t.Helper()
+		r := idempotentRequest(t, app, key, false)
+		body, _ := io.ReadAll(r.Body)
+		record := &data.IdempotencyRecord{
+			UserID:      app.contextGetUserID(r),
+			Key:         key,
+			RequestHash: hashRequest(r, body),
+			ExpiresAt:   time.Now().Add(app.config.Idempotency.TTL),
+			LockedUntil: &lockedUntil,
+		}
+		if err := app.models.Idempotency.ReserveIdempotencyKey(context.Background(), record); err != nil {
+			t.Fatal(err)
+		}
+		return record
+	}
+
+	// within its lease the key still belongs to the first request
+	reserve("leased-key", time.Now().Add(time.Minute))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, idempotentRequest(t, app, "leased-key", false))
+	if rr.Code != http.StatusConflict || calls.Load() != 0 {
+		t.Fatalf("status = %d after %d calls, want %d before the lease runs out", rr.Code, calls.Load(), http.StatusConflict)
+	}
+
+	// once the lease has run out a retry reclaims the key rather than waiting for the TTL
+	abandoned := reserve("abandoned-key", time.Now().Add(-time.Second))
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, idempotentRequest(t, app, "abandoned-key", false))
+	if rr.Code != http.StatusCreated || calls.Load() != 1 {
+		t.Fatalf("status = %d after %d calls, want the handler to run once", rr.Code, calls.Load())
+	}
+
+	// the abandoned request finishing late can't overwrite the response of the retry
+	abandoned.StatusCode = http.StatusInternalServerError
+	if err := app.models.Idempotency.CompleteIdempotencyKey(context.Background(), abandoned); err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, idempotentRequest(t, app, "abandoned-key", false))
+	if rr.Code != http.StatusCreated || rr.Header().Get(idempotentReplayedHeader) != "true" || calls.Load() != 1 {
+		t.Errorf("replay = %d replayed %q after %d calls, want the retry's %d", rr.Code, rr.Header().Get(idempotentReplayedHeader), calls.Load(), http.StatusCreated)
+	}
+}
@@ -1,108 +1,223 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/Blue-Davinci/SocialAid/internal/config"
 	"github.com/Blue-Davinci/SocialAid/internal/data"
-	"github.com/Blue-Davinci/SocialAid/internal/database"
-	"github.com/Blue-Davinci/SocialAid/internal/logger"
+	applogger "github.com/Blue-Davinci/SocialAid/internal/logger"
+	"github.com/Blue-Davinci/SocialAid/internal/validator"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
+	"github.com/pressly/goose/v3"
 	"go.uber.org/zap"
 )
 
-type config struct {
-	port int
-	env  string
-	api  struct {
-		name   string
-		author string
-	}
-	db struct {
-		dsn          string
-		maxOpenConns int
-		maxIdleConns int
-		maxIdleTime  string
-	}
-	encryption struct {
-		key string
-	}
-	cors struct {
-		trustedOrigins []string
-	}
-}
-
 type application struct {
-	config config
-	logger *zap.Logger
-	models data.Models
+	config          config.Config
+	logger          *zap.Logger
+	db              *sql.DB
+	models          data.Models
+	metrics         *appMetrics
+	phoneNormalizer *validator.PhoneNumberNormalizer
+	// migrations is the goose provider of the embedded schema, checked by the readiness probe
+	migrations *goose.Provider
+	// shuttingDown makes the readiness probe fail while we drain traffic
+	shuttingDown atomic.Bool
 }
 
 func main() {
 	// set up our logger
-	logger, err := logger.InitJSONLogger()
+	logger, err := applogger.InitJSONLogger()
 	if err != nil {
 		fmt.Println("Error initializing logger, exiting..")
 		return
 	}
 	// Load the environment variables from the .env file
 	getCurrentPath(logger)
-	// set up our config
-	var cfg config
-	// Port & env
-	flag.IntVar(&cfg.port, "port", 4000, "API server port")
-	flag.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
-	// API configuration
-	flag.StringVar(&cfg.api.name, "api-name", "SocialAid", "API name")
-	flag.StringVar(&cfg.api.author, "api-author", "Brian Karicha", "API author")
-	// Database configuration
-	flag.StringVar(&cfg.db.dsn, "db-dsn", os.Getenv("SOCIALAID_DB_DSN"), "PostgreSQL DSN")
-	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
-	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
-	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max connection idle time")
-	// Encryption key
-	flag.StringVar(&cfg.encryption.key, "encryption-key", os.Getenv("SOCIALAID_DATA_ENCRYPTION_KEY"), "Encryption key")
-	// CORS configuration
-	flag.Func("cors-trusted-origins", "Trusted CORS origins (space separated)", func(val string) error {
-		cfg.cors.trustedOrigins = strings.Fields(val)
-		return nil
+	// set up our config. The defaults are layered with the optional YAML config file and the
+	// SOCIALAID_* environment variables, which then serve as the defaults of the flags below
+	// so that flags take precedence over both.
+	// "api migrate <command> [flags]" manages the schema instead of starting the server
+	args := os.Args[1:]
+	migrateCommand := ""
+	if len(args) > 0 && args[0] == "migrate" {
+		if len(args) < 2 {
+			logger.Fatal("usage: api migrate up|down|status|version [flags]")
+		}
+		migrateCommand, args = args[1], args[2:]
+	}
+	cfg, err := config.Load(args)
+	if err != nil {
+		logger.Fatal("Error loading configuration.", zap.String("error", err.Error()))
+	}
+	configFlags(flag.CommandLine, &cfg)
+	// One-off jobs
+	normalizePhoneNumbers := flag.Bool("normalize-phone-numbers", false, "Normalize stored household head phone numbers to E.164 and exit")
+	printEffectiveConfig := flag.Bool("print-config", false, "Print the effective configuration with secrets masked and exit")
+	flag.CommandLine.Parse(args)
 
-	})
+	// print the effective config before validating it, so that an invalid one can be seen
+	// next to the errors reported below
+	if *printEffectiveConfig {
+		if err := config.Print(os.Stdout, cfg); err != nil {
+			logger.Fatal("Error printing configuration.", zap.String("error", err.Error()))
+		}
+	}
+	// validate the effective config, reporting every problem at once rather than failing
+	// on the first one we happen to use
+	v := validator.New()
+	if config.Validate(v, cfg); !v.Valid() {
+		logger.Fatal("Invalid configuration.", zap.String("error", config.ValidationError(v).Error()))
+	}
+	if *printEffectiveConfig {
+		return
+	}
 
-	// create our connection pull
+	// create our connection pull, only ever logging the DSN with its password masked
 	db, err := openDB(cfg)
 	if err != nil {
-		logger.Fatal(err.Error(), zap.String("dsn", cfg.db.dsn))
+		logger.Fatal(err.Error(), zap.String("dsn", applogger.RedactDSN(cfg.DB.DSN)))
+	}
+	logger.Info("database connection pool established", zap.String("dsn", applogger.RedactDSN(cfg.DB.DSN)))
+	// set up our embedded migrations
+	migrations, err := newMigrationProvider(db)
+	if err != nil {
+		logger.Fatal("Error loading migrations.", zap.String("error", err.Error()))
+	}
+	if migrateCommand != "" {
+		if err := runMigrateCommand(context.Background(), migrations, migrateCommand, os.Stdout); err != nil {
+			logger.Fatal("Error running migrations.", zap.String("command", migrateCommand), zap.String("error", err.Error()))
+		}
+		return
+	}
+	if cfg.DB.AutoMigrate {
+		results, err := migrations.Up(context.Background())
+		for _, result := range results {
+			logger.Info("applied migration", zap.String("result", result.String()))
+		}
+		if err != nil {
+			logger.Fatal("Error applying migrations.", zap.String("error", err.Error()))
+		}
+	}
+	// refuse to start against a schema that is behind this build
+	if err := checkSchemaVersion(context.Background(), migrations); err != nil {
+		logger.Fatal("Database schema is out of date.", zap.String("error", err.Error()))
 	}
-	logger.Info("database connection pool established", zap.String("dsn", cfg.db.dsn))
 	// create dependancies
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
+		config:          cfg,
+		logger:          logger,
+		db:              db,
+		models:          data.NewModels(db, cfg.DB.Timeouts),
+		metrics:         newAppMetrics(db),
+		phoneNormalizer: validator.NewPhoneNumberNormalizer(cfg.Phone.CountryCodes...),
+		migrations:      migrations,
+	}
+	// run the phone number migration job instead of the server if requested
+	if *normalizePhoneNumbers {
+		// stop cleanly between batches on Ctrl+C
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		result, err := app.models.HouseHold.NormalizeHouseholdHeadPhoneNumbers(ctx, cfg.Encryption.Key, app.phoneNormalizer)
+		if err != nil {
+			logger.Fatal("Error while normalizing phone numbers.", zap.String("error", err.Error()))
+		}
+		logger.Info("normalized phone numbers",
+			zap.Int("scanned", result.Scanned),
+			zap.Int("updated", result.Updated),
+			zap.Int("unchanged", result.Unchanged),
+			zap.Int32s("invalid_household_head_ids", result.Invalid))
+		return
 	}
 	// start the server
 	err = app.server()
 	if err != nil {
 		logger.Fatal("Error while starting server.", zap.String("error", err.Error()))
 	}
-	app.logger.Info("Starting the application", zap.String("env", app.config.env), zap.Int("port", app.config.port))
+	app.logger.Info("Starting the application", zap.String("env", app.config.Env), zap.Int("port", app.config.Port))
 }
 
-func openDB(cfg config) (*database.Queries, error) {
-	db, err := sql.Open("postgres", cfg.db.dsn)
+// configFlags() defines the configuration flags on fs. The values loaded so far, from the
+// defaults, the config file and the environment, serve as the flags' defaults, so that a
+// flag only overrides them when it is given.
+func configFlags(fs *flag.FlagSet, cfg *config.Config) {
+	fs.String("config", "", "Path to a YAML config file (defaults to $"+config.FileEnv+")")
+	// Port & env
+	fs.IntVar(&cfg.Port, "port", cfg.Port, "API server port")
+	fs.StringVar(&cfg.Env, "env", cfg.Env, "Environment (development|staging|production)")
+	fs.IntVar(&cfg.MetricsPort, "metrics-port", cfg.MetricsPort, "Admin port for /metrics (0 serves it on the API port)")
+	fs.DurationVar(&cfg.ShutdownDrainPeriod, "shutdown-drain-period", cfg.ShutdownDrainPeriod, "How long readiness reports 503 before shutting down")
+	// API configuration
+	fs.StringVar(&cfg.API.Name, "api-name", cfg.API.Name, "API name")
+	fs.StringVar(&cfg.API.Author, "api-author", cfg.API.Author, "API author")
+	// Database configuration
+	fs.StringVar(&cfg.DB.DSN, "db-dsn", cfg.DB.DSN, "PostgreSQL DSN")
+	fs.IntVar(&cfg.DB.MaxOpenConns, "db-max-open-conns", cfg.DB.MaxOpenConns, "PostgreSQL max open connections")
+	fs.IntVar(&cfg.DB.MaxIdleConns, "db-max-idle-conns", cfg.DB.MaxIdleConns, "PostgreSQL max idle connections")
+	fs.StringVar(&cfg.DB.MaxIdleTime, "db-max-idle-time", cfg.DB.MaxIdleTime, "PostgreSQL max connection idle time")
+	fs.DurationVar(&cfg.DB.Timeouts.Program, "db-timeout-programs", cfg.DB.Timeouts.Program, "Query timeout for programs")
+	fs.DurationVar(&cfg.DB.Timeouts.GeoLocation, "db-timeout-geolocations", cfg.DB.Timeouts.GeoLocation, "Query timeout for geo locations")
+	fs.DurationVar(&cfg.DB.Timeouts.HouseHold, "db-timeout-households", cfg.DB.Timeouts.HouseHold, "Query timeout for house holds")
+	fs.DurationVar(&cfg.DB.Timeouts.Auth, "db-timeout-auth", cfg.DB.Timeouts.Auth, "Query timeout for authentication")
+	fs.DurationVar(&cfg.DB.Timeouts.Idempotency, "db-timeout-idempotency", cfg.DB.Timeouts.Idempotency, "Query timeout for idempotency keys")
+	fs.DurationVar(&cfg.DB.Timeouts.Webhook, "db-timeout-webhooks", cfg.DB.Timeouts.Webhook, "Query timeout for webhooks")
+	fs.DurationVar(&cfg.DB.Timeouts.DataSubject, "db-timeout-data-subjects", cfg.DB.Timeouts.DataSubject, "Query timeout for data subject requests")
+	fs.DurationVar(&cfg.DB.Timeouts.PIIReveal, "db-timeout-pii-reveals", cfg.DB.Timeouts.PIIReveal, "Query timeout for the phone number reveal log")
+	fs.BoolVar(&cfg.DB.AutoMigrate, "auto-migrate", cfg.DB.AutoMigrate, "Apply pending migrations on startup, under an advisory lock")
+	// Encryption key
+	fs.StringVar(&cfg.Encryption.Key, "encryption-key", cfg.Encryption.Key, "Encryption key")
+	// CORS configuration
+	fs.Func("cors-trusted-origins", "Trusted CORS origins (space separated)", func(val string) error {
+		cfg.CORS.TrustedOrigins = strings.Fields(val)
+		return nil
+
+	})
+	// Phone number configuration, Kenya's calling code is always permitted
+	fs.Func("phone-country-codes", "Additional permitted phone calling codes besides 254 (space separated)", func(val string) error {
+		cfg.Phone.CountryCodes = strings.Fields(val)
+		return nil
+	})
+	// Idempotency keys
+	fs.DurationVar(&cfg.Idempotency.TTL, "idempotency-ttl", cfg.Idempotency.TTL, "How long responses to requests with an Idempotency-Key are replayed")
+	fs.DurationVar(&cfg.Idempotency.Lease, "idempotency-lease", cfg.Idempotency.Lease, "How long a request that never finishes holds its Idempotency-Key before a retry can reclaim it")
+	// Webhooks
+	fs.BoolVar(&cfg.Webhooks.Enabled, "webhooks-enabled", cfg.Webhooks.Enabled, "Send the outbox events to the webhook subscriptions")
+	fs.DurationVar(&cfg.Webhooks.PollInterval, "webhooks-poll-interval", cfg.Webhooks.PollInterval, "How often the outbox and the due deliveries are polled")
+	fs.IntVar(&cfg.Webhooks.BatchSize, "webhooks-batch-size", cfg.Webhooks.BatchSize, "Events fanned out and deliveries sent per poll")
+	fs.DurationVar(&cfg.Webhooks.Timeout, "webhooks-timeout", cfg.Webhooks.Timeout, "Timeout of each delivery request")
+	fs.IntVar(&cfg.Webhooks.MaxAttempts, "webhooks-max-attempts", cfg.Webhooks.MaxAttempts, "Attempts before a delivery is dead")
+	fs.DurationVar(&cfg.Webhooks.BackoffBase, "webhooks-backoff-base", cfg.Webhooks.BackoffBase, "Delay after the first failed attempt, doubling after each failure")
+	fs.DurationVar(&cfg.Webhooks.BackoffMax, "webhooks-backoff-max", cfg.Webhooks.BackoffMax, "Maximum delay between attempts")
+	// TLS configuration
+	fs.StringVar(&cfg.TLS.CertFile, "tls-cert-file", cfg.TLS.CertFile, "TLS certificate file, enables HTTPS together with -tls-key-file")
+	fs.StringVar(&cfg.TLS.KeyFile, "tls-key-file", cfg.TLS.KeyFile, "TLS private key file")
+	fs.StringVar(&cfg.TLS.MinVersion, "tls-min-version", cfg.TLS.MinVersion, "Minimum TLS version (1.2|1.3)")
+	fs.IntVar(&cfg.TLS.RedirectPort, "tls-redirect-port", cfg.TLS.RedirectPort, "Port redirecting HTTP to HTTPS (0 disables it)")
+	fs.DurationVar(&cfg.TLS.HSTSMaxAge, "tls-hsts-max-age", cfg.TLS.HSTSMaxAge, "Strict-Transport-Security max-age")
+}
+
+// openDB() opens and configures the connection pool. We return the pool itself rather than
+// the sqlc queries so that its stats remain reachable for our metrics.
+func openDB(cfg config.Config) (*sql.DB, error) {
+	db, err := sql.Open("postgres", cfg.DB.DSN)
 	if err != nil {
 		return nil, err
 	}
-	db.SetMaxOpenConns(cfg.db.maxOpenConns)
-	db.SetMaxIdleConns(cfg.db.maxIdleConns)
-	duration, err := time.ParseDuration(cfg.db.maxIdleTime)
+	db.SetMaxOpenConns(cfg.DB.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.DB.MaxIdleConns)
+	duration, err := time.ParseDuration(cfg.DB.MaxIdleTime)
 	if err != nil {
 		return nil, err
 	}
@@ -112,24 +227,23 @@ func openDB(cfg config) (*database.Queries, error) {
 	if err != nil {
 		return nil, err
 	}
-	queries := database.New(db)
-	return queries, nil
+	return db, nil
 }
 
 // getCurrentPath invokes getEnvPath to get the path to the .env file based on the current working directory.
-// After that it loads the .env file using godotenv.Load to be used by the initFlags() function
+// After that it loads the .env file using godotenv.Load to be used by the initFlags() function. The file is
+// optional, as with socialaidctl, so that deployments can be configured by the config file and the environment.
 func getCurrentPath(logger *zap.Logger) string {
 	currentpath := getEnvPath(logger)
-	if currentpath != "" {
-		err := godotenv.Load(currentpath)
-		if err != nil {
-			logger.Fatal(err.Error(), zap.String("path", currentpath))
-		}
-	} else {
-
-		logger.Error("Path Error", zap.String("path", currentpath), zap.String("error", "unable to load .env file"))
+	err := godotenv.Load(currentpath)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		logger.Info("No .env file, using the environment", zap.String("path", currentpath))
+	case err != nil:
+		logger.Fatal(err.Error(), zap.String("path", currentpath))
+	default:
+		logger.Info("Loading Environment Variables", zap.String("path", currentpath))
 	}
-	logger.Info("Loading Environment Variables", zap.String("path", currentpath))
 	return currentpath
 }
 
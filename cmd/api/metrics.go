@@ -0,0 +1,105 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsNamespace prefixes every metric we export, e.g socialaid_http_requests_total
+const metricsNamespace = "socialaid"
+
+// appMetrics holds the Prometheus collectors for the API. We use our own registry rather
+// than the global default so that only the metrics we register are exported.
+type appMetrics struct {
+	registry            *prometheus.Registry
+	httpRequests        *prometheus.CounterVec
+	httpRequestDuration *prometheus.HistogramVec
+	programsCreated     prometheus.Counter
+	houseHoldsCreated   prometheus.Counter
+	membersCreated      prometheus.Counter
+	authFailures        *prometheus.CounterVec
+}
+
+// newAppMetrics() creates and registers our collectors, including the sql.DBStats gauges
+// of the connection pool and the Go runtime and process collectors.
+func newAppMetrics(db *sql.DB) *appMetrics {
+	m := &appMetrics{
+		registry: prometheus.NewRegistry(),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_requests_total",
+			Help:      "Total HTTP requests by route pattern, method and status.",
+		}, []string{"route", "method", "status"}),
+		httpRequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency by route pattern, method and status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method", "status"}),
+		programsCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "programs_created_total",
+			Help:      "Total programs created.",
+		}),
+		houseHoldsCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "households_created_total",
+			Help:      "Total households created.",
+		}),
+		membersCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "household_members_created_total",
+			Help:      "Total household members created, including heads.",
+		}),
+		authFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "authentication_failures_total",
+			Help:      "Total failed authentication attempts by reason.",
+		}, []string{"reason"}),
+	}
+	m.registry.MustRegister(
+		m.httpRequests,
+		m.httpRequestDuration,
+		m.programsCreated,
+		m.houseHoldsCreated,
+		m.membersCreated,
+		m.authFailures,
+		collectors.NewDBStatsCollector(db, metricsNamespace),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// handler() returns the /metrics handler in the Prometheus text format
+func (m *appMetrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// recordMetrics() is a middleware that counts requests and observes their latency by the
+// chi route pattern rather than the raw path, so that IDs don't explode the label values.
+func (app *application) recordMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		route := routePattern(r)
+		if route == "" {
+			route = "unmatched"
+		}
+		labels := prometheus.Labels{"route": route, "method": r.Method, "status": strconv.Itoa(status)}
+		app.metrics.httpRequests.With(labels).Inc()
+		app.metrics.httpRequestDuration.With(labels).Observe(time.Since(start).Seconds())
+	})
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.uber.org/zap"
+)
+
+// requestIDHeader is the header we read the client's correlation ID from and echo back
+const requestIDHeader = "X-Request-ID"
+
+const (
+	requestIDContextKey       = contextKey("request_id")
+	requestMetadataContextKey = contextKey("request_metadata")
+)
+
+// requestIDRX restricts client supplied request IDs to a safe length and character set,
+// so that they can't be used to inject content into our logs or headers.
+var requestIDRX = regexp.MustCompile(`^[A-Za-z0-9\-_.:]{1,128}$`)
+
+// requestMetadata holds per-request details that are only known further down the chain,
+// such as the authenticated user. The access log middleware stores a pointer to it in the
+// context so that inner middleware can fill it in.
+type requestMetadata struct {
+	userID int32
+}
+
+// requestID() is a middleware that gives every request a correlation ID. We reuse the
+// client's X-Request-ID header if it is valid, otherwise we generate one. The ID is stored
+// in the request context and sent back in the X-Request-ID response header.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(requestIDHeader)
+		if !requestIDRX.MatchString(requestID) {
+			requestID = generateRequestID()
+		}
+		w.Header().Set(requestIDHeader, requestID)
+		ctx := context.WithValue(r.Context(), requestIDContextKey, requestID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// contextGetRequestID() returns the request's correlation ID, or an empty string if the
+// requestID() middleware has not run.
+func (app *application) contextGetRequestID(r *http.Request) string {
+	requestID, ok := r.Context().Value(requestIDContextKey).(string)
+	if !ok {
+		return ""
+	}
+	return requestID
+}
+
+// generateRequestID() returns a random 128-bit hex encoded ID
+func generateRequestID() string {
+	randomBytes := make([]byte, 16)
+	if _, err := rand.Read(randomBytes); err != nil {
+		// crypto/rand never fails on supported platforms, fall back to a time based ID
+		return time.Now().UTC().Format("20060102150405.000000000")
+	}
+	return hex.EncodeToString(randomBytes)
+}
+
+// logAccess() is a middleware that emits one structured access log entry per request with
+// the method, path, route pattern, status, latency, bytes written, request ID and the ID
+// of the authenticated user (0 for anonymous requests).
+func (app *application) logAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		// wrap the writer so that we can read the status and size of the response
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		metadata := &requestMetadata{}
+		r = r.WithContext(context.WithValue(r.Context(), requestMetadataContextKey, metadata))
+		next.ServeHTTP(ww, r)
+		// handlers that never call WriteHeader implicitly send a 200
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		app.logger.Info("access",
+			zap.String("request_id", app.contextGetRequestID(r)),
+			zap.String("request_method", r.Method),
+			zap.String("request_path", r.URL.Path),
+			zap.String("route", routePattern(r)),
+			zap.Int("status", status),
+			zap.Duration("latency", time.Since(start)),
+			zap.Int("bytes", ww.BytesWritten()),
+			zap.Int32("user_id", metadata.userID),
+			zap.String("remote_addr", r.RemoteAddr),
+			zap.String("user_agent", r.UserAgent()),
+		)
+	})
+}
+
+// contextSetRequestUserID() records the authenticated user's ID for the access log
+func (app *application) contextSetRequestUserID(r *http.Request, userID int32) {
+	if metadata, ok := r.Context().Value(requestMetadataContextKey).(*requestMetadata); ok {
+		metadata.userID = userID
+	}
+}
+
+// routePattern() returns the chi route pattern that matched the request, e.g
+// /v1/house_holds/{householdID}, which unlike the path has a bounded set of values.
+func routePattern(r *http.Request) string {
+	routeContext := chi.RouteContext(r.Context())
+	if routeContext == nil {
+		return ""
+	}
+	return routeContext.RoutePattern()
+}
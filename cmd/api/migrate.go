@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/Blue-Davinci/SocialAid/internal/sql/schema"
+	"github.com/pressly/goose/v3"
+	"github.com/pressly/goose/v3/lock"
+)
+
+// migrateCommands lists the subcommands of "api migrate"
+var migrateCommands = []string{"up", "down", "status", "version"}
+
+// newMigrationProvider() returns a goose provider for our embedded schema. Migrations run
+// under a Postgres advisory lock, so replicas started together with -auto-migrate don't race
+// each other: the first one applies the migrations while the others wait and find nothing
+// left to do. We keep goose's default goose_db_version table, so databases migrated with the
+// goose CLI carry on where they left off.
+func newMigrationProvider(db *sql.DB) (*goose.Provider, error) {
+	locker, err := lock.NewPostgresSessionLocker()
+	if err != nil {
+		return nil, err
+	}
+	return goose.NewProvider(goose.DialectPostgres, db, schema.FS, goose.WithSessionLocker(locker))
+}
+
+// latestSchemaVersion() returns the version of the newest embedded migration, i.e the
+// version the database must be at for this build to run.
+func latestSchemaVersion(provider *goose.Provider) int64 {
+	sources := provider.ListSources()
+	if len(sources) == 0 {
+		return 0
+	}
+	return sources[len(sources)-1].Version
+}
+
+// runMigrateCommand() runs one of the migrate subcommands, writing its report to w:
+//   - up applies every pending migration
+//   - down rolls back the most recent migration
+//   - status lists every migration with its state
+//   - version prints the current and latest versions
+func runMigrateCommand(ctx context.Context, provider *goose.Provider, command string, w io.Writer) error {
+	switch command {
+	case "up":
+		results, err := provider.Up(ctx)
+		for _, result := range results {
+			fmt.Fprintln(w, result)
+		}
+		if err != nil {
+			return err
+		}
+		if len(results) == 0 {
+			fmt.Fprintln(w, "no pending migrations")
+		}
+	case "down":
+		result, err := provider.Down(ctx)
+		if result != nil {
+			fmt.Fprintln(w, result)
+		}
+		if err != nil {
+			return err
+		}
+	case "status":
+		statuses, err := provider.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			appliedAt := "-"
+			if status.State == goose.StateApplied {
+				appliedAt = status.AppliedAt.UTC().Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(w, "%-8s %-20s %s\n", status.State, appliedAt, filepath.Base(status.Source.Path))
+		}
+	case "version":
+		current, err := provider.GetDBVersion(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "current version: %d\nlatest version: %d\n", current, latestSchemaVersion(provider))
+	default:
+		return fmt.Errorf("unknown migrate command %q, expected one of %v", command, migrateCommands)
+	}
+	return nil
+}
+
+// schemaVersions() returns the database's schema version, the latest embedded migration and
+// whether any migration is still pending, which also catches a missing older migration. It
+// is shared by the startup check and the readiness probe, and never takes the session lock,
+// so the probe isn't blocked while another replica migrates.
+func schemaVersions(ctx context.Context, provider *goose.Provider) (current, latest int64, pending bool, err error) {
+	current, latest, err = provider.GetVersions(ctx)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	pending, err = provider.HasPending(ctx)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	return current, latest, pending, nil
+}
+
+// checkSchemaVersion() fails if the database hasn't been migrated to the latest embedded
+// migration, so that we never serve requests against a schema the queries don't match.
+func checkSchemaVersion(ctx context.Context, provider *goose.Provider) error {
+	current, latest, pending, err := schemaVersions(ctx, provider)
+	if err != nil {
+		return err
+	}
+	if pending {
+		return fmt.Errorf("database schema is at version %d but this build needs %d, run \"api migrate up\" or start with -auto-migrate", current, latest)
+	}
+	return nil
+}
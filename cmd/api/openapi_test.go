@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// TestOpenAPIMatchesRoutes fails when a route is served but not documented, or documented
+// but not served, so that openapi.json can't drift from routes()
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	app := newTestApplication(t)
+	router, ok := app.routes().(chi.Routes)
+	if !ok {
+		t.Fatal("routes() is not a chi router")
+	}
+	served := map[string]bool{}
+	err := chi.Walk(router, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		served[operation(method, route)] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		t.Fatalf("openapi.json is not valid JSON: %v", err)
+	}
+	documented := map[string]bool{}
+	for path, item := range spec.Paths {
+		for method := range item {
+			// path items may also hold shared parameters, summaries and the like
+			if slices.Contains([]string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}, method) {
+				documented[operation(method, path)] = true
+			}
+		}
+	}
+
+	for op := range served {
+		if !documented[op] {
+			t.Errorf("%s is served but not in openapi.json", op)
+		}
+	}
+	for op := range documented {
+		if !served[op] {
+			t.Errorf("%s is in openapi.json but not served", op)
+		}
+	}
+}
+
+// operation() formats a method and route the same way for chi and the spec. chi reports the
+// index of a mounted router with a trailing slash, e.g /v1/programs/, which the spec writes
+// as /v1/programs.
+func operation(method, route string) string {
+	if route != "/" {
+		route = strings.TrimSuffix(route, "/")
+	}
+	return strings.ToUpper(method) + " " + route
+}
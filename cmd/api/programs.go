@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"net/http"
+	"time"
 
 	"github.com/Blue-Davinci/SocialAid/internal/data"
 	"github.com/Blue-Davinci/SocialAid/internal/validator"
@@ -11,9 +12,13 @@ import (
 func (app *application) createNewProgramdHandler(w http.ResponseWriter, r *http.Request) {
 	// make an input struct that will will hold the inputs we require
 	var input struct {
-		Name        string `json:"name"`
-		Category    string `json:"category"`
-		Description string `json:"description"`
+		Name               string           `json:"name"`
+		Category           string           `json:"category"`
+		Description        string           `json:"description"`
+		EnrollmentOpensAt  *time.Time       `json:"enrollment_opens_at"`
+		EnrollmentClosesAt *time.Time       `json:"enrollment_closes_at"`
+		HouseHoldCap       *int32           `json:"household_cap"`
+		CountyQuotas       map[string]int32 `json:"county_quotas"`
 	}
 	// read the request to the input struct
 	err := app.readJSON(w, r, &input)
@@ -24,29 +29,34 @@ func (app *application) createNewProgramdHandler(w http.ResponseWriter, r *http.
 	}
 	// create a new Program struct and read the input struct to it
 	program := &data.Program{
-		Name:        input.Name,
-		Category:    input.Category,
-		Description: input.Description,
+		Name:               input.Name,
+		Category:           input.Category,
+		Description:        input.Description,
+		EnrollmentOpensAt:  input.EnrollmentOpensAt,
+		EnrollmentClosesAt: input.EnrollmentClosesAt,
+		HouseHoldCap:       input.HouseHoldCap,
+		CountyQuotas:       input.CountyQuotas,
 	}
 	// validate the program struct
 	// validate
 	v := validator.New()
 	if data.ValidateProgram(v, program); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 	// we are good now, lets create the program
-	err = app.models.Program.CreateNewProgram(program)
+	err = app.models.Program.CreateNewProgram(r.Context(), program)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrDuplicateProgram):
-			v.AddError("name", "a program with this name already exists")
-			app.conflictResponse(w, r, v.Errors)
+			v.AddFieldError("name", validator.CodeAlreadyExists, "a program with this name already exists")
+			app.conflictResponse(w, r, errCodeDuplicateProgram, v)
 		default:
-			app.serverErrorResponse(w, r, err)
+			app.dataErrorResponse(w, r, err)
 		}
 		return
 	}
+	app.metrics.programsCreated.Inc()
 	// output to client
 	err = app.writeJSON(w, http.StatusCreated, envelope{"program": program}, nil)
 	if err != nil {
@@ -69,14 +79,19 @@ func (app *application) updateProgramByIdHandler(w http.ResponseWriter, r *http.
 	// validate the program ID
 	v := validator.New()
 	if data.ValidateURLID(v, id, "programID"); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 	// input struct to hold the user supplied fields
 	var input struct {
-		Name        *string `json:"name"`
-		Category    *string `json:"category"`
-		Description *string `json:"description"`
+		Name               *string    `json:"name"`
+		Category           *string    `json:"category"`
+		Description        *string    `json:"description"`
+		EnrollmentOpensAt  *time.Time `json:"enrollment_opens_at"`
+		EnrollmentClosesAt *time.Time `json:"enrollment_closes_at"`
+		HouseHoldCap       *int32     `json:"household_cap"`
+		// replaces every quota, an empty object removes them
+		CountyQuotas map[string]int32 `json:"county_quotas"`
 	}
 	// read the request to the input struct
 	err = app.readJSON(w, r, &input)
@@ -85,11 +100,11 @@ func (app *application) updateProgramByIdHandler(w http.ResponseWriter, r *http.
 		return
 	}
 	// get the program by its ID
-	program, err := app.models.Program.GetProgramById(int32(id))
+	program, err := app.models.Program.GetProgramById(r.Context(), int32(id))
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrProgramDoesNotExist):
-			app.notFoundResponse(w, r)
+			app.resourceNotFoundResponse(w, r, errCodeProgramNotFound, "program does not exist")
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
@@ -105,15 +120,33 @@ func (app *application) updateProgramByIdHandler(w http.ResponseWriter, r *http.
 	if input.Description != nil {
 		program.Description = *input.Description
 	}
+	if input.EnrollmentOpensAt != nil {
+		program.EnrollmentOpensAt = input.EnrollmentOpensAt
+	}
+	if input.EnrollmentClosesAt != nil {
+		program.EnrollmentClosesAt = input.EnrollmentClosesAt
+	}
+	if input.HouseHoldCap != nil {
+		program.HouseHoldCap = input.HouseHoldCap
+	}
+	if input.CountyQuotas != nil {
+		program.CountyQuotas = input.CountyQuotas
+	}
 	// validate the updated fields
 	if data.ValidateProgram(v, program); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 	// update the program
-	err = app.models.Program.UpdateProgramById(program)
+	err = app.models.Program.UpdateProgramById(r.Context(), program)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrDuplicateProgram):
+			v.AddFieldError("name", validator.CodeAlreadyExists, "a program with this name already exists")
+			app.conflictResponse(w, r, errCodeDuplicateProgram, v)
+		default:
+			app.dataErrorResponse(w, r, err)
+		}
 		return
 	}
 	// output to client
@@ -123,3 +156,36 @@ func (app *application) updateProgramByIdHandler(w http.ResponseWriter, r *http.
 	}
 
 }
+
+// getProgramCapacityHandler() is a handler that shows how many more house holds a program
+// can take, overall and in each county with a quota or with house holds enrolled, along
+// with whether its enrollment window is open.
+func (app *application) getProgramCapacityHandler(w http.ResponseWriter, r *http.Request) {
+	// get the program ID from the URL
+	id, err := app.readIDParam(r, "programID")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	// validate the program ID
+	v := validator.New()
+	if data.ValidateURLID(v, id, "programID"); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+	capacity, err := app.models.Program.GetProgramCapacity(r.Context(), int32(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrProgramDoesNotExist):
+			app.resourceNotFoundResponse(w, r, errCodeProgramNotFound, "program does not exist")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// output to client
+	err = app.writeJSON(w, http.StatusOK, envelope{"capacity": capacity}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/Blue-Davinci/SocialAid/internal/data"
+	"github.com/Blue-Davinci/SocialAid/internal/validator"
+)
+
+func TestCreateNewProgram(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	res := ts.do(t, http.MethodPost, "/v1/programs", nil, map[string]any{
+		"name":        "Inua Jamii",
+		"category":    "cash transfer",
+		"description": "monthly stipend for the elderly",
+	})
+	if res.status != http.StatusCreated {
+		t.Fatalf("status = %d, want %d\n%s", res.status, http.StatusCreated, res.body)
+	}
+	var created struct {
+		Program data.Program `json:"program"`
+	}
+	res.decode(t, &created)
+	if created.Program.ID == 0 || created.Program.Name != "Inua Jamii" {
+		t.Errorf("program = %+v", created.Program)
+	}
+
+	tests := []struct {
+		name   string
+		body   any
+		status int
+		code   string
+		field  string
+	}{
+		{
+			name:   "duplicate name",
+			body:   map[string]any{"name": "Inua Jamii", "category": "cash transfer", "description": "again"},
+			status: http.StatusConflict,
+			code:   errCodeDuplicateProgram,
+			field:  "name",
+		},
+		{
+			name:   "missing name",
+			body:   map[string]any{"category": "cash transfer", "description": "no name"},
+			status: http.StatusUnprocessableEntity,
+			code:   errCodeValidationFailed,
+			field:  "name",
+		},
+		{
+			name: "quota larger than cap",
+			body: map[string]any{
+				"name": "Capped", "category": "cash transfer", "description": "capped",
+				"household_cap": 1, "county_quotas": map[string]int{"Nairobi": 2},
+			},
+			status: http.StatusUnprocessableEntity,
+			code:   errCodeValidationFailed,
+			field:  "county_quotas",
+		},
+		{
+			name:   "unknown field",
+			body:   map[string]any{"name": "Unknown", "category": "c", "description": "d", "budget": 10},
+			status: http.StatusBadRequest,
+			code:   errCodeBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ts.do(t, http.MethodPost, "/v1/programs", nil, tt.body)
+			p := checkProblem(t, res, tt.status, tt.code)
+			if _, ok := p.Errors[tt.field]; tt.field != "" && !ok {
+				t.Errorf("no error for %s: %s", tt.field, res.body)
+			}
+		})
+	}
+}
+
+func TestUpdateProgramById(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	program := seedProgram(t, app, "Inua Jamii")
+	seedProgram(t, app, "Kazi Mtaani")
+
+	res := ts.do(t, http.MethodPatch, fmt.Sprintf("/v1/programs/%d", program.ID), nil, map[string]any{"description": "updated"})
+	if res.status != http.StatusOK {
+		t.Fatalf("status = %d, want %d\n%s", res.status, http.StatusOK, res.body)
+	}
+	var updated struct {
+		Program data.Program `json:"program"`
+	}
+	res.decode(t, &updated)
+	if updated.Program.Description != "updated" || updated.Program.Name != "Inua Jamii" {
+		t.Errorf("program = %+v", updated.Program)
+	}
+
+	res = ts.do(t, http.MethodPatch, fmt.Sprintf("/v1/programs/%d", program.ID), nil, map[string]any{"name": "Kazi Mtaani"})
+	checkProblem(t, res, http.StatusConflict, errCodeDuplicateProgram)
+
+	res = ts.do(t, http.MethodPatch, "/v1/programs/999", nil, map[string]any{"description": "missing"})
+	checkProblem(t, res, http.StatusNotFound, errCodeProgramNotFound)
+}
+
+func TestGetProgramCapacity(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	houseHoldCap := int32(2)
+	program := &data.Program{Name: "Capped", Category: "c", Description: "d", HouseHoldCap: &houseHoldCap}
+	if err := app.models.Program.CreateNewProgram(context.Background(), program); err != nil {
+		t.Fatal(err)
+	}
+	geoLocation := seedGeoLocation(t, app, "Nairobi", "Highridge")
+	seedHouseHold(t, app, program.ID, geoLocation.ID)
+
+	res := ts.do(t, http.MethodGet, fmt.Sprintf("/v1/programs/%d/capacity", program.ID), nil, nil)
+	if res.status != http.StatusOK {
+		t.Fatalf("status = %d, want %d\n%s", res.status, http.StatusOK, res.body)
+	}
+	var got struct {
+		Capacity data.ProgramCapacity `json:"capacity"`
+	}
+	res.decode(t, &got)
+	if got.Capacity.Enrolled != 1 || got.Capacity.Remaining == nil || *got.Capacity.Remaining != 1 {
+		t.Errorf("capacity = %+v", got.Capacity)
+	}
+
+	// the program is full after one more house hold
+	seedHouseHold(t, app, program.ID, geoLocation.ID)
+	res = ts.do(t, http.MethodPost, "/v1/house_holds", authHeaders(), map[string]any{
+		"program_id": program.ID, "geo_location_id": geoLocation.ID, "name": "Wanjiru",
+	})
+	p := checkProblem(t, res, http.StatusUnprocessableEntity, errCodeValidationFailed)
+	if p.Errors["program_id"].Code != validator.CodeCapacityReached {
+		t.Errorf("errors = %+v", p.Errors)
+	}
+
+	res = ts.do(t, http.MethodGet, "/v1/programs/999/capacity", nil, nil)
+	checkProblem(t, res, http.StatusNotFound, errCodeProgramNotFound)
+}
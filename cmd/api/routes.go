@@ -10,11 +10,23 @@ import (
 
 func (app *application) routes() http.Handler {
 	router := chi.NewRouter()
+	// our own JSON responses for unknown routes and methods
+	router.NotFound(app.notFoundResponse)
+	router.MethodNotAllowed(app.methodNotAllowedResponse)
+	// give every request a correlation ID, reusing the client's X-Request-ID if sent,
+	// and log one access entry per request
+	router.Use(app.requestID)
+	router.Use(app.logAccess)
+	router.Use(app.recordMetrics)
+	// only send HSTS when we terminate TLS ourselves
+	if app.config.TLSEnabled() {
+		router.Use(app.strictTransportSecurity)
+	}
 	router.Use(cors.Handler(cors.Options{
-		AllowedOrigins:   app.config.cors.trustedOrigins,
+		AllowedOrigins:   app.config.CORS.TrustedOrigins,
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "PATCH"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"link"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "X-Request-ID", "Idempotency-Key", "If-None-Match", "If-Modified-Since"},
+		ExposedHeaders:   []string{"link", "X-Request-ID", "Idempotent-Replayed", "Retry-After", "ETag"},
 		AllowCredentials: false,
 		MaxAge:           300, // Maximum value not ignored by any of major browsers
 	}))
@@ -22,28 +34,54 @@ func (app *application) routes() http.Handler {
 	authMiddleware := alice.New(app.authenticate, app.requireAuthenticatedUser)
 	// create main router
 	v1Router := chi.NewRouter()
+	// answer conditional GETs with a 304 when the client's copy is still current
+	v1Router.Use(app.conditionalGET)
+	// liveness and readiness probes
+	v1Router.With(app.cacheControl(cacheControlNoStore)).Get("/healthcheck", app.healthcheckHandler)
+	v1Router.With(app.cacheControl(cacheControlNoStore)).Get("/readiness", app.readinessHandler)
+	// the OpenAPI specification and its docs page
+	v1Router.With(app.cacheControl(cacheControlPublic)).Get("/openapi.json", app.openAPIHandler)
+	v1Router.With(app.cacheControl(cacheControlPublic)).Get("/docs", app.docsHandler)
 	v1Router.Mount("/programs", app.programRoutes())
 	v1Router.Mount("/geo_locations", app.geoLocationRoutes())
 	// we assume that households routes will require authentication
 	v1Router.With(authMiddleware.Then).Mount("/house_holds", app.houseHoldRoutes())
+	v1Router.With(authMiddleware.Then, app.cacheControl(cacheControlPrivateNoStore)).Get("/house_holds.geojson", app.getHouseHoldsGeoJSONHandler)
 	v1Router.Mount("/register", app.regRoutes())
 
 	// Mount to our Versioning router
 	router.Mount("/v1", v1Router)
+	// serve the metrics here unless they have their own admin port
+	if app.config.MetricsPort == 0 {
+		router.Method(http.MethodGet, "/metrics", app.metrics.handler())
+	}
 	return router
 }
 
-// programRoutes() is a route handler responsible for all program routes
+// metricsRoutes() is the route handler of the admin metrics server
+func (app *application) metricsRoutes() http.Handler {
+	router := chi.NewRouter()
+	router.Method(http.MethodGet, "/metrics", app.metrics.handler())
+	return router
+}
+
+// programRoutes() is a route handler responsible for all program routes. Like the geo
+// location and registration routes they are public, so they refuse idempotency keys, which
+// are scoped to the authenticated user.
 func (app *application) programRoutes() http.Handler {
 	router := chi.NewRouter()
+	router.Use(app.rejectIdempotencyKey)
 	router.Post("/", app.createNewProgramdHandler)
 	router.Patch("/{programID}", app.updateProgramByIdHandler)
+	// capacity changes with every enrollment, so clients revalidate it each time
+	router.With(app.cacheControl(cacheControlRevalidate)).Get("/{programID}/capacity", app.getProgramCapacityHandler)
 	return router
 }
 
 // geoLocationRoutes() is a route handler responsible for all geo location routes
 func (app *application) geoLocationRoutes() http.Handler {
 	router := chi.NewRouter()
+	router.Use(app.rejectIdempotencyKey)
 	router.Post("/", app.createNewGeoLocationHandler)
 	return router
 }
@@ -51,18 +89,32 @@ func (app *application) geoLocationRoutes() http.Handler {
 // houseHoldRoutes() is a route handler responsible for all house hold routes
 func (app *application) houseHoldRoutes() http.Handler {
 	router := chi.NewRouter()
+	// house holds hold personal data, which must not be kept in any cache
+	router.Use(app.cacheControl(cacheControlPrivateNoStore))
 	router.Get("/{householdID}", app.getHouseHoldInformationHandler) // GET request with a parameter
-	router.Post("/", app.createNewHouseHoldHandler)
+	// reveals are logged every time and their responses hold the phone number in full, so
+	// they are never stored for idempotent replay
+	router.With(app.rejectIdempotencyKey).Post("/{householdID}/reveal", app.revealHouseHoldPhoneNumberHandler)
+	router.Group(func(router chi.Router) {
+		// runs after the authentication applied where these routes are mounted, so that the
+		// idempotency keys are scoped to the user
+		router.Use(app.idempotent)
+		router.Post("/", app.createNewHouseHoldHandler)
+		// enrollments in further programs
+		router.Post("/{householdID}/enrollments", app.enrollHouseHoldHandler)
+		router.Patch("/{householdID}/enrollments/{enrollmentID}", app.updateEnrollmentStatusHandler)
 
-	// household head
-	router.Post("/head", app.createNewHouseholdHeadHandler)
-	// household member
-	router.Post("/member", app.createNewHouseholdMemberHandler)
+		// household head
+		router.Post("/head", app.createNewHouseholdHeadHandler)
+		// household member
+		router.Post("/member", app.createNewHouseholdMemberHandler)
+	})
 	return router
 }
 
 func (app *application) regRoutes() http.Handler {
 	router := chi.NewRouter()
+	router.Use(app.rejectIdempotencyKey)
 	router.Post("/", app.testHandler)
 	return router
 }
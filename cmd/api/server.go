@@ -2,8 +2,8 @@ package main
 
 import (
 	"context"
-	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -14,13 +14,67 @@ import (
 )
 
 func (app *application) server() error {
+	// every request context derives from baseCtx, which we cancel if the graceful shutdown
+	// runs out of time so that the queries still in flight are cancelled too
+	baseCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
 	// declare our http server
 	srv := &http.Server{
-		Addr:         fmt.Sprintf(":%d", app.config.port),
+		Addr:         fmt.Sprintf(":%d", app.config.Port),
 		Handler:      app.routes(),
 		IdleTimeout:  time.Minute,
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 60 * time.Second,
+		BaseContext:  func(net.Listener) context.Context { return baseCtx },
+	}
+	// serve HTTPS ourselves if a certificate has been configured, reloading it on change or
+	// SIGHUP, and redirect plain HTTP to it if a redirect port has been configured
+	var redirectSrv *http.Server
+	if app.config.TLSEnabled() {
+		reloader, err := newCertReloader(app.config.TLS.CertFile, app.config.TLS.KeyFile, app.logger)
+		if err != nil {
+			return err
+		}
+		watchCtx, stopWatching := context.WithCancel(context.Background())
+		defer stopWatching()
+		go reloader.watch(watchCtx)
+		srv.TLSConfig = app.tlsConfig(reloader)
+		if app.config.TLS.RedirectPort != 0 {
+			redirectSrv = app.redirectServer()
+			go func() {
+				app.logger.Info("starting HTTP to HTTPS redirect server", zap.String("addr", redirectSrv.Addr))
+				if err := listenAndServe(redirectSrv); err != nil {
+					app.logger.Error("redirect server stopped", zap.String("error", err.Error()))
+				}
+			}()
+		}
+	}
+	// purge the expired idempotency keys in the background until we shut down
+	purgeCtx, stopPurging := context.WithCancel(context.Background())
+	defer stopPurging()
+	go app.purgeExpiredIdempotencyKeys(purgeCtx)
+	// send the outbox events to the webhook subscriptions until we shut down
+	if app.config.Webhooks.Enabled {
+		dispatchCtx, stopDispatching := context.WithCancel(context.Background())
+		defer stopDispatching()
+		go app.webhookDispatcher().Run(dispatchCtx)
+	}
+	// serve /metrics on its own admin port if one has been configured
+	var metricsSrv *http.Server
+	if app.config.MetricsPort != 0 {
+		metricsSrv = &http.Server{
+			Addr:         fmt.Sprintf(":%d", app.config.MetricsPort),
+			Handler:      app.metricsRoutes(),
+			IdleTimeout:  time.Minute,
+			ReadTimeout:  10 * time.Second,
+			WriteTimeout: 30 * time.Second,
+		}
+		go func() {
+			app.logger.Info("starting metrics server", zap.String("addr", metricsSrv.Addr))
+			if err := listenAndServe(metricsSrv); err != nil {
+				app.logger.Error("metrics server stopped", zap.String("error", err.Error()))
+			}
+		}()
 	}
 	// make a channel to listen for shutdown signals
 	shutdownChan := make(chan error)
@@ -34,11 +88,29 @@ func (app *application) server() error {
 		s := <-quit
 		// printout the signal details
 		app.logger.Info("shutting down server", zap.String("signal", s.String()))
+		// fail the readiness probe and give the load balancer time to stop sending traffic
+		// before we stop accepting connections
+		app.shuttingDown.Store(true)
+		app.logger.Info("draining traffic", zap.Duration("period", app.config.ShutdownDrainPeriod))
+		time.Sleep(app.config.ShutdownDrainPeriod)
 		// make a 20sec context
 		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
 		defer cancel()
+		// stop the metrics server alongside the API
+		if metricsSrv != nil {
+			if err := metricsSrv.Shutdown(ctx); err != nil {
+				app.logger.Error("error stopping metrics server", zap.String("error", err.Error()))
+			}
+		}
+		// and the redirect server
+		if redirectSrv != nil {
+			if err := redirectSrv.Shutdown(ctx); err != nil {
+				app.logger.Error("error stopping redirect server", zap.String("error", err.Error()))
+			}
+		}
 		err := srv.Shutdown(ctx)
 		if err != nil {
+			cancelRequests()
 			shutdownChan <- err
 		}
 		// Log a message to say that we're waiting for any background goroutines to
@@ -49,11 +121,9 @@ func (app *application) server() error {
 	}()
 
 	// start the server printing out our main settings
-	app.logger.Info("starting server", zap.String("addr", srv.Addr), zap.String("env", app.config.env))
-	if err := srv.ListenAndServe(); err != nil {
-		if !errors.Is(err, http.ErrServerClosed) {
-			return err
-		}
+	app.logger.Info("starting server", zap.String("addr", srv.Addr), zap.String("env", app.config.Env), zap.Bool("tls", srv.TLSConfig != nil))
+	if err := listenAndServe(srv); err != nil {
+		return err
 	}
 	// Otherwise, we wait to receive the return value from Shutdown() on the
 	// shutdownError channel. If return value is an error, we know that there was a
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Blue-Davinci/SocialAid/internal/config"
+	"github.com/Blue-Davinci/SocialAid/internal/data"
+	"github.com/Blue-Davinci/SocialAid/internal/validator"
+	"go.uber.org/zap"
+)
+
+// testApiKey is the API key of the user newTestApplication() registers
+const testApiKey = "TESTAPIKEYTESTAPIKEYTESTAP"
+
+// newTestApplication() returns an application backed by the in-memory stores, with one
+// user who authenticates with testApiKey
+func newTestApplication(t *testing.T) *application {
+	t.Helper()
+	cfg := config.Default()
+	cfg.Encryption.Key = hex.EncodeToString(make([]byte, 32))
+	models := data.NewMemoryModels()
+	models.Auth.(*data.MemoryAuthModel).AddUser(&data.User{Email: "officer@socialaid.org", Name: "Officer"}, testApiKey)
+	return &application{
+		config:          cfg,
+		logger:          zap.NewNop(),
+		models:          models,
+		metrics:         newAppMetrics(nil),
+		phoneNormalizer: validator.NewPhoneNumberNormalizer(),
+	}
+}
+
+// testServer wraps an httptest.Server running the application's routes
+type testServer struct {
+	*httptest.Server
+}
+
+func newTestServer(t *testing.T, h http.Handler) *testServer {
+	t.Helper()
+	ts := httptest.NewServer(h)
+	t.Cleanup(ts.Close)
+	return &testServer{ts}
+}
+
+// testResponse is a response read in full by testServer.do()
+type testResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// decode() decodes the response body into dst, failing the test when it isn't valid JSON
+func (r testResponse) decode(t *testing.T, dst any) {
+	t.Helper()
+	if err := json.Unmarshal(r.body, dst); err != nil {
+		t.Fatalf("decoding response body: %v\n%s", err, r.body)
+	}
+}
+
+// do() sends the request, JSON encoding body unless it is nil or already a string, and
+// reads the whole response. headers may be nil.
+func (ts *testServer) do(t *testing.T, method, path string, headers http.Header, body any) testResponse {
+	t.Helper()
+	var reader io.Reader
+	switch body := body.(type) {
+	case nil:
+	case string:
+		reader = bytes.NewBufferString(body)
+	default:
+		js, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(js)
+	}
+	req, err := http.NewRequest(method, ts.URL+path, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, values := range headers {
+		req.Header[key] = values
+	}
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	resBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testResponse{status: res.StatusCode, header: res.Header, body: resBody}
+}
+
+// authHeaders() returns the headers of a request authenticated as the test user, with any
+// extra headers given as key/value pairs
+func authHeaders(pairs ...string) http.Header {
+	headers := http.Header{}
+	headers.Set("ApiKey", testApiKey)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		headers.Set(pairs[i], pairs[i+1])
+	}
+	return headers
+}
+
+// problem is the part of an RFC 7807 error response the tests check
+type problem struct {
+	Status int                             `json:"status"`
+	Code   string                          `json:"code"`
+	Errors map[string]validator.FieldError `json:"errors"`
+}
+
+// checkProblem() fails the test unless the response is an error with the status and code
+func checkProblem(t *testing.T, res testResponse, status int, code string) problem {
+	t.Helper()
+	if res.status != status {
+		t.Fatalf("status = %d, want %d\n%s", res.status, status, res.body)
+	}
+	var p problem
+	res.decode(t, &p)
+	if p.Code != code {
+		t.Fatalf("code = %q, want %q\n%s", p.Code, code, res.body)
+	}
+	return p
+}
+
+// seedGeoLocation() stores a geo location in the county directly through the models
+func seedGeoLocation(t *testing.T, app *application, county, subLocation string) *data.GeoLocation {
+	t.Helper()
+	geoLocation := &data.GeoLocation{County: county, SubCounty: "Westlands", Location: "Parklands", SubLocation: subLocation}
+	if err := app.models.GeoLocation.CreateNewGeoLocation(context.Background(), geoLocation); err != nil {
+		t.Fatal(err)
+	}
+	return geoLocation
+}
+
+// seedProgram() stores an open program without caps directly through the models
+func seedProgram(t *testing.T, app *application, name string) *data.Program {
+	t.Helper()
+	program := &data.Program{Name: name, Category: "cash transfer", Description: "a test program"}
+	if err := app.models.Program.CreateNewProgram(context.Background(), program); err != nil {
+		t.Fatal(err)
+	}
+	return program
+}
+
+// seedHouseHold() stores a house hold in the program and geo location directly through the
+// models
+func seedHouseHold(t *testing.T, app *application, programID, geoLocationID int32) *data.HouseHold {
+	t.Helper()
+	houseHold := &data.HouseHold{ProgramID: programID, GeoLocationID: geoLocationID, Name: "Otieno"}
+	if err := app.models.HouseHold.CreateNewHouseHold(context.Background(), houseHold); err != nil {
+		t.Fatal(err)
+	}
+	return houseHold
+}
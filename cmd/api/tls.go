@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Blue-Davinci/SocialAid/internal/config"
+	"go.uber.org/zap"
+)
+
+// certPollInterval is how often we check the certificate files for changes. We poll the
+// modification times rather than watch the files, as the atomic symlink swaps used by
+// cert-manager and Kubernetes secrets don't reliably raise file events.
+const certPollInterval = 30 * time.Second
+
+// certReloader holds the current certificate and swaps it for a freshly loaded one when the
+// files change or on SIGHUP, so that renewed certificates are picked up without a restart.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	logger   *zap.Logger
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	modTime  time.Time
+}
+
+// newCertReloader() loads the initial certificate, failing if it can't be read
+func newCertReloader(certFile, keyFile string, logger *zap.Logger) (*certReloader, error) {
+	reloader := &certReloader{certFile: certFile, keyFile: keyFile, logger: logger}
+	if err := reloader.reload(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// reload() reads the certificate and key files and replaces the current certificate. On
+// error the current certificate is kept.
+func (c *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading TLS certificate: %w", err)
+	}
+	modTime, err := c.latestModTime()
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cert = &cert
+	c.modTime = modTime
+	return nil
+}
+
+// latestModTime() returns the most recent modification time of the certificate and key files
+func (c *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// changed() reports whether either file has been modified since the last reload
+func (c *certReloader) changed() bool {
+	modTime, err := c.latestModTime()
+	if err != nil {
+		// the files may be mid-swap, we'll try again on the next tick
+		return false
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return !modTime.Equal(c.modTime)
+}
+
+// getCertificate() is used as the tls.Config's GetCertificate so that every handshake sees
+// the current certificate.
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// watch() reloads the certificate on SIGHUP and whenever the files change, until the
+// context is cancelled.
+func (c *certReloader) watch(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	ticker := time.NewTicker(certPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			c.reloadAndLog("SIGHUP")
+		case <-ticker.C:
+			if c.changed() {
+				c.reloadAndLog("file change")
+			}
+		}
+	}
+}
+
+func (c *certReloader) reloadAndLog(trigger string) {
+	if err := c.reload(); err != nil {
+		c.logger.Error("error reloading TLS certificate, keeping the current one",
+			zap.String("trigger", trigger), zap.String("error", err.Error()))
+		return
+	}
+	c.logger.Info("reloaded TLS certificate", zap.String("trigger", trigger), zap.String("cert_file", c.certFile))
+}
+
+// tlsConfig() returns our TLS settings: the configured minimum version, ECDHE key exchange
+// with AEAD ciphers only for TLS 1.2 (TLS 1.3 suites are not configurable), and the
+// certificate served by the reloader.
+func (app *application) tlsConfig(reloader *certReloader) *tls.Config {
+	return &tls.Config{
+		MinVersion:       config.TLSVersions[app.config.TLS.MinVersion],
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+		GetCertificate: reloader.getCertificate,
+	}
+}
+
+// redirectServer() returns a plain HTTP server that permanently redirects every request to
+// the same host and path on our HTTPS port.
+func (app *application) redirectServer() *http.Server {
+	return &http.Server{
+		Addr:         fmt.Sprintf(":%d", app.config.TLS.RedirectPort),
+		Handler:      http.HandlerFunc(app.redirectToHTTPS),
+		IdleTimeout:  time.Minute,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 5 * time.Second,
+	}
+}
+
+func (app *application) redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(r.Host); err == nil {
+		host = h
+	}
+	if app.config.Port != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(app.config.Port))
+	}
+	target := "https://" + host + r.URL.RequestURI()
+	http.Redirect(w, r, target, http.StatusPermanentRedirect)
+}
+
+// strictTransportSecurity() is a middleware that sets the HSTS header, telling browsers to
+// only use HTTPS for our host. It is only used when we serve TLS ourselves.
+func (app *application) strictTransportSecurity(next http.Handler) http.Handler {
+	value := fmt.Sprintf("max-age=%d; includeSubDomains", int64(app.config.TLS.HSTSMaxAge.Seconds()))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Strict-Transport-Security", value)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// listenAndServe() starts the server over TLS when a certificate is configured, and over
+// plain HTTP otherwise.
+func listenAndServe(srv *http.Server) error {
+	var err error
+	if srv.TLSConfig != nil {
+		// the certificate comes from TLSConfig.GetCertificate
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
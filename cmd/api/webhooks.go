@@ -0,0 +1,19 @@
+package main
+
+import (
+	"github.com/Blue-Davinci/SocialAid/internal/webhook"
+)
+
+// webhookDispatcher() returns the dispatcher sending the outbox events to the webhook
+// subscriptions, configured from the webhooks settings
+func (app *application) webhookDispatcher() *webhook.Dispatcher {
+	return webhook.NewDispatcher(app.models.Webhook, app.logger, webhook.Config{
+		EncryptionKey: app.config.Encryption.Key,
+		PollInterval:  app.config.Webhooks.PollInterval,
+		BatchSize:     int32(app.config.Webhooks.BatchSize),
+		Timeout:       app.config.Webhooks.Timeout,
+		MaxAttempts:   app.config.Webhooks.MaxAttempts,
+		BackoffBase:   app.config.Webhooks.BackoffBase,
+		BackoffMax:    app.config.Webhooks.BackoffMax,
+	})
+}
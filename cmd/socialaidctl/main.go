@@ -0,0 +1,802 @@
+// Command socialaidctl is the SocialAid admin tool. It manages users, their API keys and
+// permissions and the webhook subscriptions, handles data subject requests, shows the log of
+// revealed phone numbers, lists reference data and runs integrity checks, reading the same
+// configuration (config file, SOCIALAID_* environment variables and .env file) as the API.
+//
+// Usage:
+//
+//	socialaidctl [global flags] <command> [command flags]
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Blue-Davinci/SocialAid/internal/config"
+	"github.com/Blue-Davinci/SocialAid/internal/data"
+	"github.com/Blue-Davinci/SocialAid/internal/validator"
+	"github.com/joho/godotenv"
+	_ "github.com/lib/pq"
+)
+
+const usage = `Usage: socialaidctl [global flags] <command> [command flags]
+
+Commands:
+  gen-key [-bytes 32]                      Generate a hex encoded data encryption key
+  users create -email EMAIL -name NAME     Create a user
+  users disable -email EMAIL               Disable a user, none of their API keys work afterwards
+  users grant -email EMAIL -permission P   Grant a permission, e.g pii:read
+  users revoke -email EMAIL -permission P  Take a permission away
+  users permissions -email EMAIL           List a user's permissions
+  keys issue -email EMAIL                  Issue an API key, the plaintext is only shown once
+  keys list -email EMAIL                   List a user's API keys
+  keys revoke -id ID                       Revoke an API key
+  programs list                            List the programs
+  geolocations list                        List the geo locations
+  webhooks create -url URL [-events a,b]   Subscribe a URL to events (all if none), the secret is only shown once
+  webhooks list                            List the webhook subscriptions
+  webhooks disable -id ID                  Disable a subscription, its pending deliveries are dropped
+  webhooks deliveries -id ID [-status S]   List a subscription's recent deliveries
+  webhooks attempts -delivery ID           Show a delivery's log of attempts
+  webhooks retry -delivery ID              Requeue a dead delivery
+  dsar request -kind export|erasure -national-id ID -reason TEXT -by EMAIL
+                                           Record a data subject request for approval
+  dsar list [-status S]                    List the data subject requests
+  dsar approve -id ID -by EMAIL [-out FILE]
+                                           Approve and run a request, exports are written to FILE or stdout
+  dsar reject -id ID -by EMAIL             Reject a request
+  reveals list [-household ID] [-email EMAIL]
+                                           List the phone numbers revealed in full, newest first
+  check phone-numbers                      Decrypt every household head phone number
+
+Global flags:
+  -config FILE            YAML config file (defaults to $SOCIALAID_CONFIG_FILE)
+  -db-dsn DSN             PostgreSQL DSN
+  -encryption-key KEY     Encryption key
+`
+
+// ctl holds what the commands need. The models are only set up for the commands that
+// use the database.
+type ctl struct {
+	cfg    config.Config
+	models data.Models
+	out    io.Writer
+}
+
+// commands are the database backed commands, each run by dispatch()
+var commands = map[string]bool{
+	"users create":        true,
+	"users disable":       true,
+	"users grant":         true,
+	"users revoke":        true,
+	"users permissions":   true,
+	"keys issue":          true,
+	"keys list":           true,
+	"keys revoke":         true,
+	"programs list":       true,
+	"geolocations list":   true,
+	"webhooks create":     true,
+	"webhooks list":       true,
+	"webhooks disable":    true,
+	"webhooks deliveries": true,
+	"webhooks attempts":   true,
+	"webhooks retry":      true,
+	"dsar request":        true,
+	"dsar list":           true,
+	"dsar approve":        true,
+	"dsar reject":         true,
+	"reveals list":        true,
+	"check phone-numbers": true,
+}
+
+// errUsage is returned for invalid invocations, after which we print the usage
+var errUsage = errors.New("invalid usage")
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		if errors.Is(err, errUsage) {
+			fmt.Fprintf(os.Stderr, "socialaidctl: %v\n\n", err)
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(2)
+		}
+		fmt.Fprintf(os.Stderr, "socialaidctl: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, out io.Writer) error {
+	// the API's .env file is optional, the variables may come from the environment
+	if err := godotenv.Load(filepath.Join("cmd", "api", ".env")); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	// same precedence as the API: defaults < config file < environment < flags
+	cfg, err := config.Load(args)
+	if err != nil {
+		return fmt.Errorf("loading configuration: %w", err)
+	}
+	flags := flag.NewFlagSet("socialaidctl", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	flags.String("config", "", "Path to a YAML config file (defaults to $"+config.FileEnv+")")
+	flags.StringVar(&cfg.DB.DSN, "db-dsn", cfg.DB.DSN, "PostgreSQL DSN")
+	flags.StringVar(&cfg.Encryption.Key, "encryption-key", cfg.Encryption.Key, "Encryption key")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			fmt.Fprint(out, usage)
+			return nil
+		}
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+	if flags.NArg() == 0 {
+		return fmt.Errorf("%w: no command given", errUsage)
+	}
+	command, args := flags.Arg(0), flags.Args()[1:]
+	app := &ctl{cfg: cfg, out: out}
+	// gen-key is how the encryption key is created in the first place, so it needs neither a
+	// valid configuration nor the database
+	if command == "gen-key" {
+		return app.genKey(args)
+	}
+	// the other commands are a noun and a verb
+	if len(args) == 0 || !commands[command+" "+args[0]] {
+		return fmt.Errorf("%w: unknown command %q", errUsage, strings.Join(flags.Args(), " "))
+	}
+	command, args = command+" "+args[0], args[1:]
+	v := validator.New()
+	if config.Validate(v, cfg); !v.Valid() {
+		return fmt.Errorf("invalid configuration: %w", config.ValidationError(v))
+	}
+	db, err := openDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	app.models = data.NewModels(db, cfg.DB.Timeouts)
+	// stop cleanly on Ctrl+C, e.g during a long integrity check
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return app.dispatch(ctx, command, args)
+}
+
+// dispatch() runs one of the database backed commands
+func (app *ctl) dispatch(ctx context.Context, command string, args []string) error {
+	switch command {
+	case "users create":
+		return app.createUser(ctx, args)
+	case "users disable":
+		return app.disableUser(ctx, args)
+	case "users grant":
+		return app.grantPermission(ctx, args)
+	case "users revoke":
+		return app.revokePermission(ctx, args)
+	case "users permissions":
+		return app.listPermissions(ctx, args)
+	case "keys issue":
+		return app.issueApiKey(ctx, args)
+	case "keys list":
+		return app.listApiKeys(ctx, args)
+	case "keys revoke":
+		return app.revokeApiKey(ctx, args)
+	case "programs list":
+		return app.listPrograms(ctx)
+	case "geolocations list":
+		return app.listGeoLocations(ctx)
+	case "webhooks create":
+		return app.createWebhook(ctx, args)
+	case "webhooks list":
+		return app.listWebhooks(ctx)
+	case "webhooks disable":
+		return app.disableWebhook(ctx, args)
+	case "webhooks deliveries":
+		return app.listWebhookDeliveries(ctx, args)
+	case "webhooks attempts":
+		return app.listWebhookDeliveryAttempts(ctx, args)
+	case "webhooks retry":
+		return app.retryWebhookDelivery(ctx, args)
+	case "dsar request":
+		return app.requestDataSubject(ctx, args)
+	case "dsar list":
+		return app.listDataSubjectRequests(ctx, args)
+	case "dsar approve":
+		return app.approveDataSubjectRequest(ctx, args)
+	case "dsar reject":
+		return app.rejectDataSubjectRequest(ctx, args)
+	case "reveals list":
+		return app.listPIIReveals(ctx, args)
+	case "check phone-numbers":
+		return app.checkPhoneNumbers(ctx)
+	default:
+		return fmt.Errorf("%w: unknown command %q", errUsage, command)
+	}
+}
+
+// openDB() opens a small connection pool, the commands run one query at a time
+func openDB(cfg config.Config) (*sql.DB, error) {
+	db, err := sql.Open("postgres", cfg.DB.DSN)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(2)
+	db.SetMaxIdleConns(2)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("connecting to the database: %w", err)
+	}
+	return db, nil
+}
+
+// parseFlags() parses the command's flags, turning flag errors into usage errors
+func parseFlags(flags *flag.FlagSet, args []string) error {
+	flags.SetOutput(io.Discard)
+	if err := flags.Parse(args); err != nil {
+		return fmt.Errorf("%w: %s: %v", errUsage, flags.Name(), err)
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("%w: %s: unexpected arguments %v", errUsage, flags.Name(), flags.Args())
+	}
+	return nil
+}
+
+// genKey() prints a new random encryption key for SOCIALAID_DATA_ENCRYPTION_KEY
+func (app *ctl) genKey(args []string) error {
+	flags := flag.NewFlagSet("gen-key", flag.ContinueOnError)
+	keyLength := flags.Int("bytes", data.KeyLength32, "Key length in bytes (16, 24 or 32)")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	key, err := data.GenerateSecurityKey(*keyLength)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(app.out, key)
+	return nil
+}
+
+// createUser() creates a user. They have no access until they are issued an API key.
+func (app *ctl) createUser(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("users create", flag.ContinueOnError)
+	user := &data.User{}
+	flags.StringVar(&user.Email, "email", "", "Email address")
+	flags.StringVar(&user.Name, "name", "", "Name")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	v := validator.New()
+	if data.ValidateUser(v, user); !v.Valid() {
+		return config.ValidationError(v)
+	}
+	if err := app.models.Auth.CreateUser(ctx, user); err != nil {
+		return err
+	}
+	fmt.Fprintf(app.out, "created user %d <%s>\n", user.ID, user.Email)
+	return nil
+}
+
+// disableUser() disables a user, which immediately stops all of their API keys working
+func (app *ctl) disableUser(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("users disable", flag.ContinueOnError)
+	email := flags.String("email", "", "Email address")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if err := app.models.Auth.DisableUser(ctx, *email); err != nil {
+		return err
+	}
+	fmt.Fprintf(app.out, "disabled user <%s>\n", *email)
+	return nil
+}
+
+// grantPermission() grants a permission to the user, which takes effect on their next request
+func (app *ctl) grantPermission(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("users grant", flag.ContinueOnError)
+	email := flags.String("email", "", "Email address of the user")
+	permission := flags.String("permission", "", "Permission code, e.g "+data.PermissionPIIRead)
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	v := validator.New()
+	if validator.OneOf(v, "permission", *permission, data.KnownPermissions...); !v.Valid() {
+		return config.ValidationError(v)
+	}
+	user, err := app.models.Auth.GetUserByEmail(ctx, *email)
+	if err != nil {
+		return err
+	}
+	if err := app.models.Auth.AddPermissionForUser(ctx, user.ID, *permission); err != nil {
+		return err
+	}
+	fmt.Fprintf(app.out, "granted %s to <%s>\n", *permission, user.Email)
+	return nil
+}
+
+// revokePermission() takes a permission away from the user
+func (app *ctl) revokePermission(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("users revoke", flag.ContinueOnError)
+	email := flags.String("email", "", "Email address of the user")
+	permission := flags.String("permission", "", "Permission code, e.g "+data.PermissionPIIRead)
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	user, err := app.models.Auth.GetUserByEmail(ctx, *email)
+	if err != nil {
+		return err
+	}
+	if err := app.models.Auth.RemovePermissionForUser(ctx, user.ID, *permission); err != nil {
+		return err
+	}
+	fmt.Fprintf(app.out, "revoked %s from <%s>\n", *permission, user.Email)
+	return nil
+}
+
+// listPermissions() lists the permissions granted to the user
+func (app *ctl) listPermissions(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("users permissions", flag.ContinueOnError)
+	email := flags.String("email", "", "Email address of the user")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	user, err := app.models.Auth.GetUserByEmail(ctx, *email)
+	if err != nil {
+		return err
+	}
+	permissions, err := app.models.Auth.GetAllPermissionsForUser(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	for _, permission := range permissions {
+		fmt.Fprintln(app.out, permission)
+	}
+	return nil
+}
+
+// issueApiKey() issues a new API key to the user and prints its plaintext, which we don't
+// store and can't show again
+func (app *ctl) issueApiKey(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("keys issue", flag.ContinueOnError)
+	email := flags.String("email", "", "Email address of the user")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	user, err := app.models.Auth.GetUserByEmail(ctx, *email)
+	if err != nil {
+		return err
+	}
+	if user.DisabledAt != nil {
+		return fmt.Errorf("user <%s> is disabled", user.Email)
+	}
+	apiKey, err := app.models.Auth.IssueApiKey(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(app.out, "issued API key %d to <%s>, it will not be shown again:\n%s\n", apiKey.ID, user.Email, apiKey.Plaintext)
+	return nil
+}
+
+// listApiKeys() lists the user's API keys
+func (app *ctl) listApiKeys(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("keys list", flag.ContinueOnError)
+	email := flags.String("email", "", "Email address of the user")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	user, err := app.models.Auth.GetUserByEmail(ctx, *email)
+	if err != nil {
+		return err
+	}
+	apiKeys, err := app.models.Auth.GetApiKeysForUser(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(app.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tCREATED\tREVOKED")
+	for _, apiKey := range apiKeys {
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", apiKey.ID, formatTime(&apiKey.CreatedAt), formatTime(apiKey.RevokedAt))
+	}
+	return tw.Flush()
+}
+
+// revokeApiKey() revokes an API key by its ID, as shown by keys list
+func (app *ctl) revokeApiKey(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("keys revoke", flag.ContinueOnError)
+	id := flags.Int("id", 0, "ID of the API key")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if err := app.models.Auth.RevokeApiKey(ctx, int32(*id)); err != nil {
+		return err
+	}
+	fmt.Fprintf(app.out, "revoked API key %d\n", *id)
+	return nil
+}
+
+// listPrograms() lists every program
+func (app *ctl) listPrograms(ctx context.Context) error {
+	programs, err := app.models.Program.GetAllPrograms(ctx)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(app.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tCATEGORY\tENROLLMENT OPENS\tENROLLMENT CLOSES\tCAP\tCREATED")
+	for _, program := range programs {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", program.ID, program.Name, program.Category,
+			formatTime(program.EnrollmentOpensAt), formatTime(program.EnrollmentClosesAt), formatInt32(program.HouseHoldCap), formatTime(&program.CreatedAt))
+	}
+	return tw.Flush()
+}
+
+// listGeoLocations() lists every geo location
+func (app *ctl) listGeoLocations(ctx context.Context) error {
+	geoLocations, err := app.models.GeoLocation.GetAllGeoLocations(ctx)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(app.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tCOUNTY\tSUB COUNTY\tLOCATION\tSUB LOCATION\tLATITUDE\tLONGITUDE")
+	for _, g := range geoLocations {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", g.ID, g.County, g.SubCounty, g.Location, g.SubLocation, formatFloat(g.Latitude), formatFloat(g.Longitude))
+	}
+	return tw.Flush()
+}
+
+// createWebhook() subscribes a URL to events and prints the generated secret the payloads are
+// signed with, which is only shown here
+func (app *ctl) createWebhook(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("webhooks create", flag.ContinueOnError)
+	subscription := &data.WebhookSubscription{}
+	flags.StringVar(&subscription.URL, "url", "", "URL the events are POSTed to")
+	events := flags.String("events", "", "Comma separated event types, every event if empty")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	subscription.EventTypes = []string{}
+	for _, eventType := range strings.Split(*events, ",") {
+		if eventType = strings.TrimSpace(eventType); eventType != "" {
+			subscription.EventTypes = append(subscription.EventTypes, eventType)
+		}
+	}
+	v := validator.New()
+	if data.ValidateWebhookSubscription(v, subscription); !v.Valid() {
+		return config.ValidationError(v)
+	}
+	secret, err := data.GenerateSecurityKey(data.KeyLength32)
+	if err != nil {
+		return err
+	}
+	subscription.Secret = secret
+	if err := app.models.Webhook.CreateWebhookSubscription(ctx, subscription, app.cfg.Encryption.Key); err != nil {
+		return err
+	}
+	fmt.Fprintf(app.out, "created webhook subscription %d for %s, its signing secret will not be shown again:\n%s\n", subscription.ID, subscription.URL, subscription.Secret)
+	return nil
+}
+
+// listWebhooks() lists every webhook subscription
+func (app *ctl) listWebhooks(ctx context.Context) error {
+	subscriptions, err := app.models.Webhook.GetAllWebhookSubscriptions(ctx)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(app.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tURL\tEVENTS\tACTIVE\tCREATED")
+	for _, s := range subscriptions {
+		events := "*"
+		if len(s.EventTypes) > 0 {
+			events = strings.Join(s.EventTypes, ",")
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%t\t%s\n", s.ID, s.URL, events, s.Active, formatTime(&s.CreatedAt))
+	}
+	return tw.Flush()
+}
+
+// disableWebhook() disables a webhook subscription, it gets no new events and its pending
+// deliveries are marked dead
+func (app *ctl) disableWebhook(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("webhooks disable", flag.ContinueOnError)
+	id := flags.Int("id", 0, "ID of the subscription")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if err := app.models.Webhook.DisableWebhookSubscription(ctx, int32(*id)); err != nil {
+		return err
+	}
+	fmt.Fprintf(app.out, "disabled webhook subscription %d\n", *id)
+	return nil
+}
+
+// listWebhookDeliveries() lists a subscription's most recent deliveries
+func (app *ctl) listWebhookDeliveries(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("webhooks deliveries", flag.ContinueOnError)
+	id := flags.Int("id", 0, "ID of the subscription")
+	status := flags.String("status", "", "Only list deliveries in this status (pending|delivered|dead)")
+	limit := flags.Int("limit", 50, "Maximum number of deliveries listed")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	v := validator.New()
+	if *status != "" {
+		validator.OneOf(v, "status", *status, data.WebhookDeliveryPending, data.WebhookDeliveryDelivered, data.WebhookDeliveryDead)
+	}
+	validator.InRange(v, "limit", *limit, 1, 1000)
+	if !v.Valid() {
+		return config.ValidationError(v)
+	}
+	deliveries, err := app.models.Webhook.GetWebhookDeliveries(ctx, int32(*id), *status, int32(*limit))
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(app.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tEVENT\tTYPE\tSTATUS\tATTEMPTS\tNEXT ATTEMPT\tLAST STATUS\tLAST ERROR")
+	for _, d := range deliveries {
+		nextAttemptAt := &d.NextAttemptAt
+		if d.Status != data.WebhookDeliveryPending {
+			nextAttemptAt = nil
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t%d\t%s\t%s\t%s\n", d.ID, d.EventID, d.EventType, d.Status, d.Attempts, formatTime(nextAttemptAt), formatStatusCode(d.LastStatusCode), formatText(d.LastError))
+	}
+	return tw.Flush()
+}
+
+// listWebhookDeliveryAttempts() shows the log of a delivery's attempts
+func (app *ctl) listWebhookDeliveryAttempts(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("webhooks attempts", flag.ContinueOnError)
+	id := flags.Int64("delivery", 0, "ID of the delivery")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	attempts, err := app.models.Webhook.GetWebhookDeliveryAttempts(ctx, *id)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(app.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ATTEMPT\tAT\tSTATUS\tDURATION\tERROR")
+	for _, a := range attempts {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", a.Attempt, formatTime(&a.AttemptedAt), formatStatusCode(a.StatusCode), a.Duration, formatText(a.Error))
+	}
+	return tw.Flush()
+}
+
+// retryWebhookDelivery() requeues a dead delivery with a fresh set of attempts
+func (app *ctl) retryWebhookDelivery(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("webhooks retry", flag.ContinueOnError)
+	id := flags.Int64("delivery", 0, "ID of the delivery")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if err := app.models.Webhook.RetryWebhookDelivery(ctx, *id); err != nil {
+		return err
+	}
+	fmt.Fprintf(app.out, "requeued webhook delivery %d\n", *id)
+	return nil
+}
+
+// requestDataSubject() records a data subject's request to export or erase their data. It
+// only runs once someone else approves it.
+func (app *ctl) requestDataSubject(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("dsar request", flag.ContinueOnError)
+	request := &data.DataSubjectRequest{}
+	flags.StringVar(&request.Kind, "kind", "", "Kind of request (export|erasure)")
+	flags.StringVar(&request.NationalID, "national-id", "", "National ID of the data subject")
+	flags.StringVar(&request.Reason, "reason", "", "Reason for the request, e.g the reference of the data subject's letter")
+	by := flags.String("by", "", "Email address of the user recording the request")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	v := validator.New()
+	if data.ValidateDataSubjectRequest(v, request); !v.Valid() {
+		return config.ValidationError(v)
+	}
+	user, err := app.activeUser(ctx, *by)
+	if err != nil {
+		return err
+	}
+	request.RequestedBy = user.ID
+	if err := app.models.DataSubject.CreateDataSubjectRequest(ctx, request, app.cfg.Encryption.Key); err != nil {
+		return err
+	}
+	fmt.Fprintf(app.out, "recorded %s request %d matching %d household heads and %d member records, it must be approved by another user\n", request.Kind, request.ID, request.HeadsFound, request.MembersFound)
+	return nil
+}
+
+// listDataSubjectRequests() lists the data subject requests, newest first
+func (app *ctl) listDataSubjectRequests(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("dsar list", flag.ContinueOnError)
+	status := flags.String("status", "", "Only list requests in this status (pending|completed|rejected)")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if *status != "" {
+		v := validator.New()
+		if validator.OneOf(v, "status", *status, data.DataSubjectRequestPending, data.DataSubjectRequestCompleted, data.DataSubjectRequestRejected); !v.Valid() {
+			return config.ValidationError(v)
+		}
+	}
+	requests, err := app.models.DataSubject.GetAllDataSubjectRequests(ctx, *status)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(app.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tKIND\tSTATUS\tHEADS\tMEMBERS\tREQUESTED BY\tCREATED\tDECIDED BY\tDECIDED\tREASON")
+	for _, r := range requests {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%d\t%s\t%s\t%s\t%s\t%s\n", r.ID, r.Kind, r.Status, r.HeadsFound, r.MembersFound, r.RequestedByEmail, formatTime(&r.CreatedAt), formatText(r.DecidedByEmail), formatTime(r.DecidedAt), r.Reason)
+	}
+	return tw.Flush()
+}
+
+// approveDataSubjectRequest() approves a pending request and runs it. Exports contain the
+// decrypted records, so they are written to a file only the current user can read.
+func (app *ctl) approveDataSubjectRequest(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("dsar approve", flag.ContinueOnError)
+	id := flags.Int("id", 0, "ID of the request")
+	by := flags.String("by", "", "Email address of the approving user")
+	out := flags.String("out", "", "File exports are written to, stdout if empty")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	user, err := app.activeUser(ctx, *by)
+	if err != nil {
+		return err
+	}
+	// create the file first, an export that can't be written would otherwise be lost
+	exportOut := app.out
+	if *out != "" {
+		f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		exportOut = f
+	}
+	request, export, err := app.models.DataSubject.ApproveDataSubjectRequest(ctx, int32(*id), user.ID, app.cfg.Encryption.Key)
+	if err != nil {
+		if *out != "" {
+			os.Remove(*out)
+		}
+		return err
+	}
+	if export != nil {
+		encoder := json.NewEncoder(exportOut)
+		encoder.SetIndent("", "\t")
+		if err := encoder.Encode(export); err != nil {
+			return err
+		}
+	} else if *out != "" {
+		os.Remove(*out)
+	}
+	summary := app.out
+	if *out == "" && export != nil {
+		// keep stdout a valid JSON document
+		summary = os.Stderr
+	}
+	fmt.Fprintf(summary, "completed %s request %d covering %d household heads and %d member records\n", request.Kind, request.ID, request.HeadsFound, request.MembersFound)
+	return nil
+}
+
+// rejectDataSubjectRequest() rejects a pending request, nothing is exported or erased
+func (app *ctl) rejectDataSubjectRequest(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("dsar reject", flag.ContinueOnError)
+	id := flags.Int("id", 0, "ID of the request")
+	by := flags.String("by", "", "Email address of the rejecting user")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	user, err := app.activeUser(ctx, *by)
+	if err != nil {
+		return err
+	}
+	if err := app.models.DataSubject.RejectDataSubjectRequest(ctx, int32(*id), user.ID); err != nil {
+		return err
+	}
+	fmt.Fprintf(app.out, "rejected data subject request %d\n", *id)
+	return nil
+}
+
+// activeUser() looks up the user recording or deciding a data subject request, who must not
+// be disabled
+func (app *ctl) activeUser(ctx context.Context, email string) (*data.User, error) {
+	user, err := app.models.Auth.GetUserByEmail(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	if user.DisabledAt != nil {
+		return nil, fmt.Errorf("user <%s> is disabled", user.Email)
+	}
+	return user, nil
+}
+
+// listPIIReveals() lists the most recent phone numbers returned in full, optionally only
+// those of a house hold or revealed to a user
+func (app *ctl) listPIIReveals(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("reveals list", flag.ContinueOnError)
+	filter := &data.PIIRevealFilter{}
+	houseHoldID := flags.Int("household", 0, "Only list reveals of this house hold")
+	email := flags.String("email", "", "Only list reveals to this user")
+	limit := flags.Int("limit", 50, "Maximum number of reveals listed")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	v := validator.New()
+	validator.Min(v, "household", *houseHoldID, 0)
+	if validator.InRange(v, "limit", *limit, 1, 1000); !v.Valid() {
+		return config.ValidationError(v)
+	}
+	filter.HouseHoldID = int32(*houseHoldID)
+	filter.Limit = int32(*limit)
+	if *email != "" {
+		user, err := app.models.Auth.GetUserByEmail(ctx, *email)
+		if err != nil {
+			return err
+		}
+		filter.UserID = user.ID
+	}
+	reveals, err := app.models.PIIReveal.GetPIIReveals(ctx, filter)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(app.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tAT\tUSER\tHOUSEHOLD\tSOURCE\tREQUEST ID\tREASON")
+	for _, reveal := range reveals {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%s\t%s\t%s\n", reveal.ID, formatTime(&reveal.CreatedAt), reveal.UserEmail, reveal.HouseHoldID, reveal.Source, formatText(reveal.RequestID), formatText(reveal.Reason))
+	}
+	return tw.Flush()
+}
+
+// checkPhoneNumbers() decrypts every household head phone number with the configured key,
+// failing if any can't be decrypted
+func (app *ctl) checkPhoneNumbers(ctx context.Context) error {
+	result, err := app.models.HouseHold.CheckHouseholdHeadPhoneNumbers(ctx, app.cfg.Encryption.Key)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(app.out, "checked %d household head phone numbers\n", result.Scanned)
+	if len(result.Undecryptable) > 0 {
+		return fmt.Errorf("%d phone numbers could not be decrypted, household head IDs: %v", len(result.Undecryptable), result.Undecryptable)
+	}
+	return nil
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Local().Format(time.DateTime)
+}
+
+func formatStatusCode(code int) string {
+	if code == 0 {
+		return "-"
+	}
+	return strconv.Itoa(code)
+}
+
+func formatText(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func formatInt32(i *int32) string {
+	if i == nil {
+		return "-"
+	}
+	return strconv.FormatInt(int64(*i), 10)
+}
+
+func formatFloat(f *float64) string {
+	if f == nil {
+		return "-"
+	}
+	return strconv.FormatFloat(*f, 'f', -1, 64)
+}
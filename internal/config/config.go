@@ -0,0 +1,520 @@
+// Package config loads the configuration shared by the API and socialaidctl from the
+// defaults, an optional YAML file, SOCIALAID_* environment variables and flags.
+package config
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Blue-Davinci/SocialAid/internal/data"
+	"github.com/Blue-Davinci/SocialAid/internal/logger"
+	"github.com/Blue-Davinci/SocialAid/internal/validator"
+	"gopkg.in/yaml.v3"
+)
+
+// FileEnv names the config file when the -config flag is not given
+const FileEnv = "SOCIALAID_CONFIG_FILE"
+
+// TLSVersions maps the accepted -tls-min-version values to their crypto/tls constants
+var TLSVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// countryCodeRX matches an international calling code, e.g 254
+var countryCodeRX = regexp.MustCompile(`^[1-9]\d{0,2}$`)
+
+// fileConfig mirrors Config for the optional YAML config file. Every field is a pointer so
+// that we can tell a value that was left out of the file from one set to its zero value.
+type fileConfig struct {
+	Port                *int           `yaml:"port"`
+	Env                 *string        `yaml:"env"`
+	MetricsPort         *int           `yaml:"metrics_port"`
+	ShutdownDrainPeriod *time.Duration `yaml:"shutdown_drain_period"`
+	API                 struct {
+		Name   *string `yaml:"name"`
+		Author *string `yaml:"author"`
+	} `yaml:"api"`
+	DB struct {
+		DSN          *string `yaml:"dsn"`
+		MaxOpenConns *int    `yaml:"max_open_conns"`
+		MaxIdleConns *int    `yaml:"max_idle_conns"`
+		MaxIdleTime  *string `yaml:"max_idle_time"`
+		AutoMigrate  *bool   `yaml:"auto_migrate"`
+		Timeouts     struct {
+			Programs     *time.Duration `yaml:"programs"`
+			GeoLocations *time.Duration `yaml:"geolocations"`
+			HouseHolds   *time.Duration `yaml:"households"`
+			Auth         *time.Duration `yaml:"auth"`
+			Idempotency  *time.Duration `yaml:"idempotency"`
+			Webhooks     *time.Duration `yaml:"webhooks"`
+			DataSubjects *time.Duration `yaml:"data_subjects"`
+			PIIReveals   *time.Duration `yaml:"pii_reveals"`
+		} `yaml:"timeouts"`
+	} `yaml:"db"`
+	Encryption struct {
+		Key *string `yaml:"key"`
+	} `yaml:"encryption"`
+	CORS struct {
+		TrustedOrigins []string `yaml:"trusted_origins"`
+	} `yaml:"cors"`
+	Phone struct {
+		CountryCodes []string `yaml:"country_codes"`
+	} `yaml:"phone"`
+	Idempotency struct {
+		TTL   *time.Duration `yaml:"ttl"`
+		Lease *time.Duration `yaml:"lease"`
+	} `yaml:"idempotency"`
+	Webhooks struct {
+		Enabled      *bool          `yaml:"enabled"`
+		PollInterval *time.Duration `yaml:"poll_interval"`
+		BatchSize    *int           `yaml:"batch_size"`
+		Timeout      *time.Duration `yaml:"timeout"`
+		MaxAttempts  *int           `yaml:"max_attempts"`
+		BackoffBase  *time.Duration `yaml:"backoff_base"`
+		BackoffMax   *time.Duration `yaml:"backoff_max"`
+	} `yaml:"webhooks"`
+	TLS struct {
+		CertFile     *string        `yaml:"cert_file"`
+		KeyFile      *string        `yaml:"key_file"`
+		MinVersion   *string        `yaml:"min_version"`
+		RedirectPort *int           `yaml:"redirect_port"`
+		HSTSMaxAge   *time.Duration `yaml:"hsts_max_age"`
+	} `yaml:"tls"`
+}
+
+// Config holds the settings of the API and of socialaidctl. It is loaded by Load() and
+// checked by Validate().
+type Config struct {
+	Port int
+	Env  string
+	// metrics port, 0 serves /metrics on the main port
+	MetricsPort int
+	// how long readiness reports 503 before the server stops accepting connections
+	ShutdownDrainPeriod time.Duration
+
+	API struct {
+		Name   string
+		Author string
+	}
+	DB struct {
+		DSN          string
+		MaxOpenConns int
+		MaxIdleConns int
+		MaxIdleTime  string
+		// per-model query timeouts
+		Timeouts data.ModelTimeouts
+		// apply pending migrations on startup
+		AutoMigrate bool
+	}
+	Encryption struct {
+		Key string
+	}
+	CORS struct {
+		TrustedOrigins []string
+	}
+	Phone struct {
+		CountryCodes []string
+	}
+	// how long the responses to requests with an Idempotency-Key are kept for replay, and
+	// how long a key stays reserved by a request that never finishes
+	Idempotency struct {
+		TTL   time.Duration
+		Lease time.Duration
+	}
+	// the dispatcher sending the outbox events to the webhook subscriptions
+	Webhooks struct {
+		Enabled      bool
+		PollInterval time.Duration
+		BatchSize    int
+		Timeout      time.Duration
+		MaxAttempts  int
+		BackoffBase  time.Duration
+		BackoffMax   time.Duration
+	}
+	// TLS is enabled when both the cert and key files are set
+	TLS struct {
+		CertFile     string
+		KeyFile      string
+		MinVersion   string
+		RedirectPort int
+		HSTSMaxAge   time.Duration
+	}
+}
+
+// TLSEnabled() reports whether we should serve HTTPS
+func (cfg Config) TLSEnabled() bool {
+	return cfg.TLS.CertFile != "" && cfg.TLS.KeyFile != ""
+}
+
+// Default() returns the configuration used when nothing else is set
+func Default() Config {
+	var cfg Config
+	cfg.Port = 4000
+	cfg.Env = "development"
+	cfg.ShutdownDrainPeriod = 5 * time.Second
+	cfg.API.Name = "SocialAid"
+	cfg.API.Author = "Brian Karicha"
+	cfg.DB.MaxOpenConns = 25
+	cfg.DB.MaxIdleConns = 25
+	cfg.DB.MaxIdleTime = "15m"
+	cfg.DB.Timeouts = data.DefaultModelTimeouts()
+	cfg.Idempotency.TTL = 24 * time.Hour
+	cfg.Idempotency.Lease = 2 * time.Minute
+	cfg.Webhooks.Enabled = true
+	cfg.Webhooks.PollInterval = 5 * time.Second
+	cfg.Webhooks.BatchSize = 20
+	cfg.Webhooks.Timeout = 10 * time.Second
+	cfg.Webhooks.MaxAttempts = 8
+	cfg.Webhooks.BackoffBase = 30 * time.Second
+	cfg.Webhooks.BackoffMax = time.Hour
+	cfg.TLS.MinVersion = "1.2"
+	cfg.TLS.HSTSMaxAge = 180 * 24 * time.Hour
+	return cfg
+}
+
+// Load() builds the configuration from the defaults, the optional YAML config file and
+// the SOCIALAID_* environment variables, in that order. The flags are parsed afterwards with
+// these values as their defaults, giving the precedence file < env < flags. All the errors
+// found are returned together.
+func Load(args []string) (Config, error) {
+	cfg := Default()
+	if path := filePath(args); path != "" {
+		if err := applyFile(&cfg, path); err != nil {
+			return cfg, err
+		}
+	}
+	return cfg, applyEnv(&cfg)
+}
+
+// filePath() finds the config file path in the -config/--config flag before the flags
+// are parsed, falling back to the SOCIALAID_CONFIG_FILE environment variable.
+func filePath(args []string) string {
+	for i, arg := range args {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || name != "config" {
+			continue
+		}
+		if hasValue {
+			return value
+		}
+		if i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return os.Getenv(FileEnv)
+}
+
+// applyFile() reads the YAML config file and overlays the values it sets. Unknown
+// keys are rejected so that typos don't silently fall back to the defaults.
+func applyFile(cfg *Config, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+	defer file.Close()
+	var fc fileConfig
+	dec := yaml.NewDecoder(file)
+	dec.KnownFields(true)
+	if err := dec.Decode(&fc); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	overlay(&cfg.Port, fc.Port)
+	overlay(&cfg.Env, fc.Env)
+	overlay(&cfg.MetricsPort, fc.MetricsPort)
+	overlay(&cfg.ShutdownDrainPeriod, fc.ShutdownDrainPeriod)
+	overlay(&cfg.API.Name, fc.API.Name)
+	overlay(&cfg.API.Author, fc.API.Author)
+	overlay(&cfg.DB.DSN, fc.DB.DSN)
+	overlay(&cfg.DB.MaxOpenConns, fc.DB.MaxOpenConns)
+	overlay(&cfg.DB.MaxIdleConns, fc.DB.MaxIdleConns)
+	overlay(&cfg.DB.MaxIdleTime, fc.DB.MaxIdleTime)
+	overlay(&cfg.DB.AutoMigrate, fc.DB.AutoMigrate)
+	overlay(&cfg.DB.Timeouts.Program, fc.DB.Timeouts.Programs)
+	overlay(&cfg.DB.Timeouts.GeoLocation, fc.DB.Timeouts.GeoLocations)
+	overlay(&cfg.DB.Timeouts.HouseHold, fc.DB.Timeouts.HouseHolds)
+	overlay(&cfg.DB.Timeouts.Auth, fc.DB.Timeouts.Auth)
+	overlay(&cfg.DB.Timeouts.Idempotency, fc.DB.Timeouts.Idempotency)
+	overlay(&cfg.DB.Timeouts.Webhook, fc.DB.Timeouts.Webhooks)
+	overlay(&cfg.DB.Timeouts.DataSubject, fc.DB.Timeouts.DataSubjects)
+	overlay(&cfg.DB.Timeouts.PIIReveal, fc.DB.Timeouts.PIIReveals)
+	overlay(&cfg.Encryption.Key, fc.Encryption.Key)
+	overlay(&cfg.Idempotency.TTL, fc.Idempotency.TTL)
+	overlay(&cfg.Idempotency.Lease, fc.Idempotency.Lease)
+	overlay(&cfg.Webhooks.Enabled, fc.Webhooks.Enabled)
+	overlay(&cfg.Webhooks.PollInterval, fc.Webhooks.PollInterval)
+	overlay(&cfg.Webhooks.BatchSize, fc.Webhooks.BatchSize)
+	overlay(&cfg.Webhooks.Timeout, fc.Webhooks.Timeout)
+	overlay(&cfg.Webhooks.MaxAttempts, fc.Webhooks.MaxAttempts)
+	overlay(&cfg.Webhooks.BackoffBase, fc.Webhooks.BackoffBase)
+	overlay(&cfg.Webhooks.BackoffMax, fc.Webhooks.BackoffMax)
+	overlay(&cfg.TLS.CertFile, fc.TLS.CertFile)
+	overlay(&cfg.TLS.KeyFile, fc.TLS.KeyFile)
+	overlay(&cfg.TLS.MinVersion, fc.TLS.MinVersion)
+	overlay(&cfg.TLS.RedirectPort, fc.TLS.RedirectPort)
+	overlay(&cfg.TLS.HSTSMaxAge, fc.TLS.HSTSMaxAge)
+	if fc.CORS.TrustedOrigins != nil {
+		cfg.CORS.TrustedOrigins = fc.CORS.TrustedOrigins
+	}
+	if fc.Phone.CountryCodes != nil {
+		cfg.Phone.CountryCodes = fc.Phone.CountryCodes
+	}
+	return nil
+}
+
+// overlay() sets dst to the value of src if src was set
+func overlay[T any](dst *T, src *T) {
+	if src != nil {
+		*dst = *src
+	}
+}
+
+// applyEnv() overlays the values set in SOCIALAID_* environment variables, collecting
+// every value that can't be parsed.
+func applyEnv(cfg *Config) error {
+	var errs []error
+	envInt := func(key string, dst *int) {
+		if value, ok := os.LookupEnv(key); ok {
+			i, err := strconv.Atoi(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: must be an integer", key))
+				return
+			}
+			*dst = i
+		}
+	}
+	envString := func(key string, dst *string) {
+		if value, ok := os.LookupEnv(key); ok {
+			*dst = value
+		}
+	}
+	envDuration := func(key string, dst *time.Duration) {
+		if value, ok := os.LookupEnv(key); ok {
+			duration, err := time.ParseDuration(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: must be a duration such as 5s", key))
+				return
+			}
+			*dst = duration
+		}
+	}
+	envBool := func(key string, dst *bool) {
+		if value, ok := os.LookupEnv(key); ok {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: must be true or false", key))
+				return
+			}
+			*dst = b
+		}
+	}
+	envFields := func(key string, dst *[]string) {
+		if value, ok := os.LookupEnv(key); ok {
+			*dst = strings.Fields(value)
+		}
+	}
+	envInt("SOCIALAID_PORT", &cfg.Port)
+	envString("SOCIALAID_ENV", &cfg.Env)
+	envInt("SOCIALAID_METRICS_PORT", &cfg.MetricsPort)
+	envDuration("SOCIALAID_SHUTDOWN_DRAIN_PERIOD", &cfg.ShutdownDrainPeriod)
+	envString("SOCIALAID_API_NAME", &cfg.API.Name)
+	envString("SOCIALAID_API_AUTHOR", &cfg.API.Author)
+	envString("SOCIALAID_DB_DSN", &cfg.DB.DSN)
+	envInt("SOCIALAID_DB_MAX_OPEN_CONNS", &cfg.DB.MaxOpenConns)
+	envInt("SOCIALAID_DB_MAX_IDLE_CONNS", &cfg.DB.MaxIdleConns)
+	envString("SOCIALAID_DB_MAX_IDLE_TIME", &cfg.DB.MaxIdleTime)
+	envBool("SOCIALAID_DB_AUTO_MIGRATE", &cfg.DB.AutoMigrate)
+	envDuration("SOCIALAID_DB_TIMEOUT_PROGRAMS", &cfg.DB.Timeouts.Program)
+	envDuration("SOCIALAID_DB_TIMEOUT_GEOLOCATIONS", &cfg.DB.Timeouts.GeoLocation)
+	envDuration("SOCIALAID_DB_TIMEOUT_HOUSEHOLDS", &cfg.DB.Timeouts.HouseHold)
+	envDuration("SOCIALAID_DB_TIMEOUT_AUTH", &cfg.DB.Timeouts.Auth)
+	envDuration("SOCIALAID_DB_TIMEOUT_IDEMPOTENCY", &cfg.DB.Timeouts.Idempotency)
+	envDuration("SOCIALAID_DB_TIMEOUT_WEBHOOKS", &cfg.DB.Timeouts.Webhook)
+	envDuration("SOCIALAID_DB_TIMEOUT_DATA_SUBJECTS", &cfg.DB.Timeouts.DataSubject)
+	envDuration("SOCIALAID_DB_TIMEOUT_PII_REVEALS", &cfg.DB.Timeouts.PIIReveal)
+	envString("SOCIALAID_DATA_ENCRYPTION_KEY", &cfg.Encryption.Key)
+	envFields("SOCIALAID_CORS_TRUSTED_ORIGINS", &cfg.CORS.TrustedOrigins)
+	envFields("SOCIALAID_PHONE_COUNTRY_CODES", &cfg.Phone.CountryCodes)
+	envDuration("SOCIALAID_IDEMPOTENCY_TTL", &cfg.Idempotency.TTL)
+	envDuration("SOCIALAID_IDEMPOTENCY_LEASE", &cfg.Idempotency.Lease)
+	envBool("SOCIALAID_WEBHOOKS_ENABLED", &cfg.Webhooks.Enabled)
+	envDuration("SOCIALAID_WEBHOOKS_POLL_INTERVAL", &cfg.Webhooks.PollInterval)
+	envInt("SOCIALAID_WEBHOOKS_BATCH_SIZE", &cfg.Webhooks.BatchSize)
+	envDuration("SOCIALAID_WEBHOOKS_TIMEOUT", &cfg.Webhooks.Timeout)
+	envInt("SOCIALAID_WEBHOOKS_MAX_ATTEMPTS", &cfg.Webhooks.MaxAttempts)
+	envDuration("SOCIALAID_WEBHOOKS_BACKOFF_BASE", &cfg.Webhooks.BackoffBase)
+	envDuration("SOCIALAID_WEBHOOKS_BACKOFF_MAX", &cfg.Webhooks.BackoffMax)
+	envString("SOCIALAID_TLS_CERT_FILE", &cfg.TLS.CertFile)
+	envString("SOCIALAID_TLS_KEY_FILE", &cfg.TLS.KeyFile)
+	envString("SOCIALAID_TLS_MIN_VERSION", &cfg.TLS.MinVersion)
+	envInt("SOCIALAID_TLS_REDIRECT_PORT", &cfg.TLS.RedirectPort)
+	envDuration("SOCIALAID_TLS_HSTS_MAX_AGE", &cfg.TLS.HSTSMaxAge)
+	return errors.Join(errs...)
+}
+
+// Validate() checks the effective configuration, recording every problem in the
+// validator so that they can all be reported at once on startup.
+func Validate(v *validator.Validator, cfg Config) {
+	validator.InRange(v, "port", cfg.Port, 1, 65535)
+	validator.OneOf(v, "env", cfg.Env, "development", "staging", "production")
+	if cfg.MetricsPort != 0 {
+		validator.InRange(v, "metrics-port", cfg.MetricsPort, 1, 65535)
+		v.CrossField(cfg.MetricsPort != cfg.Port, "metrics-port", "must differ from port")
+	}
+	validator.Min(v, "shutdown-drain-period", cfg.ShutdownDrainPeriod, 0)
+	// database
+	v.Required("db-dsn", cfg.DB.DSN)
+	if cfg.DB.DSN != "" {
+		v.CheckCode(validDSN(cfg.DB.DSN), "db-dsn", validator.CodeInvalidFormat, "must be a postgres:// URL or a key=value connection string")
+	}
+	validator.Min(v, "db-max-open-conns", cfg.DB.MaxOpenConns, 1)
+	validator.InRange(v, "db-max-idle-conns", cfg.DB.MaxIdleConns, 0, cfg.DB.MaxOpenConns)
+	if maxIdleTime, err := time.ParseDuration(cfg.DB.MaxIdleTime); err != nil {
+		v.AddFieldError("db-max-idle-time", validator.CodeInvalidFormat, "must be a duration such as 15m")
+	} else {
+		validator.Min(v, "db-max-idle-time", maxIdleTime, 0)
+	}
+	validator.Min(v, "db-timeout-programs", cfg.DB.Timeouts.Program, time.Millisecond)
+	validator.Min(v, "db-timeout-geolocations", cfg.DB.Timeouts.GeoLocation, time.Millisecond)
+	validator.Min(v, "db-timeout-households", cfg.DB.Timeouts.HouseHold, time.Millisecond)
+	validator.Min(v, "db-timeout-auth", cfg.DB.Timeouts.Auth, time.Millisecond)
+	validator.Min(v, "db-timeout-idempotency", cfg.DB.Timeouts.Idempotency, time.Millisecond)
+	validator.Min(v, "db-timeout-webhooks", cfg.DB.Timeouts.Webhook, time.Millisecond)
+	validator.Min(v, "db-timeout-data-subjects", cfg.DB.Timeouts.DataSubject, time.Millisecond)
+	validator.Min(v, "db-timeout-pii-reveals", cfg.DB.Timeouts.PIIReveal, time.Millisecond)
+	// encryption
+	if err := data.CheckEncryptionKey(cfg.Encryption.Key); err != nil {
+		v.AddFieldError("encryption-key", validator.CodeInvalid, "must be a hex encoded 16, 24 or 32 byte key")
+	}
+	// cors
+	for _, origin := range cfg.CORS.TrustedOrigins {
+		if !validOrigin(origin) {
+			v.AddFieldError("cors-trusted-origins", validator.CodeInvalidFormat, fmt.Sprintf("%q must be an origin such as https://example.com", origin))
+		}
+	}
+	// phone
+	for _, code := range cfg.Phone.CountryCodes {
+		if !validator.Matches(strings.TrimPrefix(code, "+"), countryCodeRX) {
+			v.AddFieldError("phone-country-codes", validator.CodeInvalidFormat, fmt.Sprintf("%q must be a calling code such as 256", code))
+		}
+	}
+	// idempotency, keys must outlive the retries of a flaky connection
+	validator.Min(v, "idempotency-ttl", cfg.Idempotency.TTL, time.Minute)
+	// and the lease must outlast the slowest request, or a retry would run it a second time
+	validator.InRange(v, "idempotency-lease", cfg.Idempotency.Lease, time.Second, cfg.Idempotency.TTL)
+	// webhooks
+	validator.Min(v, "webhooks-poll-interval", cfg.Webhooks.PollInterval, 100*time.Millisecond)
+	validator.InRange(v, "webhooks-batch-size", cfg.Webhooks.BatchSize, 1, 500)
+	validator.Min(v, "webhooks-timeout", cfg.Webhooks.Timeout, time.Second)
+	validator.InRange(v, "webhooks-max-attempts", cfg.Webhooks.MaxAttempts, 1, 50)
+	validator.Min(v, "webhooks-backoff-base", cfg.Webhooks.BackoffBase, time.Second)
+	validator.Min(v, "webhooks-backoff-max", cfg.Webhooks.BackoffMax, cfg.Webhooks.BackoffBase)
+	// tls
+	v.CrossField((cfg.TLS.CertFile == "") == (cfg.TLS.KeyFile == ""), "tls-cert-file", "must be set together with tls-key-file")
+	for key, file := range map[string]string{"tls-cert-file": cfg.TLS.CertFile, "tls-key-file": cfg.TLS.KeyFile} {
+		if file != "" {
+			_, err := os.Stat(file)
+			v.CheckCode(err == nil, key, validator.CodeNotFound, "must be a readable file")
+		}
+	}
+	_, ok := TLSVersions[cfg.TLS.MinVersion]
+	v.CheckCode(ok, "tls-min-version", validator.CodeOutOfRange, "must be 1.2 or 1.3")
+	if cfg.TLS.RedirectPort != 0 {
+		validator.InRange(v, "tls-redirect-port", cfg.TLS.RedirectPort, 1, 65535)
+		v.CrossField(cfg.TLSEnabled(), "tls-redirect-port", "requires tls-cert-file and tls-key-file")
+		v.CrossField(cfg.TLS.RedirectPort != cfg.Port && cfg.TLS.RedirectPort != cfg.MetricsPort, "tls-redirect-port", "must differ from port and metrics-port")
+	}
+	validator.Min(v, "tls-hsts-max-age", cfg.TLS.HSTSMaxAge, 0)
+}
+
+// ValidationError() flattens the validator's errors into a single error listing all of them
+func ValidationError(v *validator.Validator) error {
+	keys := make([]string, 0, len(v.Errors))
+	for key := range v.Errors {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	errs := make([]error, 0, len(keys))
+	for _, key := range keys {
+		errs = append(errs, fmt.Errorf("%s: %s", key, v.Errors[key]))
+	}
+	return errors.Join(errs...)
+}
+
+// validDSN() accepts postgres:// URLs with a host and lib/pq key=value connection strings
+func validDSN(dsn string) bool {
+	if strings.Contains(dsn, "://") {
+		u, err := url.Parse(dsn)
+		return err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql") && u.Host != ""
+	}
+	return strings.Contains(dsn, "=")
+}
+
+// validOrigin() accepts "*" and scheme://host[:port] origins without a path
+func validOrigin(origin string) bool {
+	if origin == "*" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" && (u.Path == "" || u.Path == "/")
+}
+
+// Print() writes the effective configuration as YAML with the secrets masked, in the
+// same shape as the config file so that it can be used as a starting point for one.
+func Print(w io.Writer, cfg Config) error {
+	var fc fileConfig
+	fc.Port = &cfg.Port
+	fc.Env = &cfg.Env
+	fc.MetricsPort = &cfg.MetricsPort
+	fc.ShutdownDrainPeriod = &cfg.ShutdownDrainPeriod
+	fc.API.Name = &cfg.API.Name
+	fc.API.Author = &cfg.API.Author
+	dsn := logger.RedactDSN(cfg.DB.DSN)
+	fc.DB.DSN = &dsn
+	fc.DB.MaxOpenConns = &cfg.DB.MaxOpenConns
+	fc.DB.MaxIdleConns = &cfg.DB.MaxIdleConns
+	fc.DB.MaxIdleTime = &cfg.DB.MaxIdleTime
+	fc.DB.AutoMigrate = &cfg.DB.AutoMigrate
+	fc.DB.Timeouts.Programs = &cfg.DB.Timeouts.Program
+	fc.DB.Timeouts.GeoLocations = &cfg.DB.Timeouts.GeoLocation
+	fc.DB.Timeouts.HouseHolds = &cfg.DB.Timeouts.HouseHold
+	fc.DB.Timeouts.Auth = &cfg.DB.Timeouts.Auth
+	fc.DB.Timeouts.Idempotency = &cfg.DB.Timeouts.Idempotency
+	fc.DB.Timeouts.Webhooks = &cfg.DB.Timeouts.Webhook
+	fc.DB.Timeouts.DataSubjects = &cfg.DB.Timeouts.DataSubject
+	fc.DB.Timeouts.PIIReveals = &cfg.DB.Timeouts.PIIReveal
+	encryptionKey := ""
+	if cfg.Encryption.Key != "" {
+		encryptionKey = logger.RedactedValue
+	}
+	fc.Encryption.Key = &encryptionKey
+	fc.CORS.TrustedOrigins = cfg.CORS.TrustedOrigins
+	fc.Phone.CountryCodes = cfg.Phone.CountryCodes
+	fc.Idempotency.TTL = &cfg.Idempotency.TTL
+	fc.Idempotency.Lease = &cfg.Idempotency.Lease
+	fc.Webhooks.Enabled = &cfg.Webhooks.Enabled
+	fc.Webhooks.PollInterval = &cfg.Webhooks.PollInterval
+	fc.Webhooks.BatchSize = &cfg.Webhooks.BatchSize
+	fc.Webhooks.Timeout = &cfg.Webhooks.Timeout
+	fc.Webhooks.MaxAttempts = &cfg.Webhooks.MaxAttempts
+	fc.Webhooks.BackoffBase = &cfg.Webhooks.BackoffBase
+	fc.Webhooks.BackoffMax = &cfg.Webhooks.BackoffMax
+	fc.TLS.CertFile = &cfg.TLS.CertFile
+	fc.TLS.KeyFile = &cfg.TLS.KeyFile
+	fc.TLS.MinVersion = &cfg.TLS.MinVersion
+	fc.TLS.RedirectPort = &cfg.TLS.RedirectPort
+	fc.TLS.HSTSMaxAge = &cfg.TLS.HSTSMaxAge
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	defer enc.Close()
+	return enc.Encode(fc)
+}
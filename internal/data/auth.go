@@ -7,6 +7,7 @@ import (
 	"database/sql"
 	"encoding/base32"
 	"errors"
+	"slices"
 	"time"
 
 	"github.com/Blue-Davinci/SocialAid/internal/database"
@@ -15,19 +16,38 @@ import (
 
 type AuthManagerModel struct {
 	DB *database.Queries
+	// Timeout bounds every query, on top of the caller's context
+	Timeout time.Duration
 }
 
 type Apikey struct {
+	ID        int32 `json:",omitempty"`
 	Plaintext string
 	Hash      []byte
 }
 
+// ApiKeyRecord describes a stored API key, of which we only keep the hash
+type ApiKeyRecord struct {
+	ID        int32      `json:"id"`
+	UserID    int32      `json:"user_id"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
 const (
 	DefaultAuthManDBContextTimeout = 5 * time.Second
 )
 
+// PermissionPIIRead lets a user read the household heads' phone numbers in full
+const PermissionPIIRead = "pii:read"
+
+// KnownPermissions lists every permission that can be granted
+var KnownPermissions = []string{PermissionPIIRead}
+
 var (
-	ErrUserNotFound = errors.New("user not found")
+	ErrUserNotFound   = errors.New("user not found")
+	ErrDuplicateEmail = errors.New("a user with this email address already exists")
+	ErrApiKeyNotFound = errors.New("api key not found or already revoked")
 )
 
 type User struct {
@@ -37,6 +57,16 @@ type User struct {
 	ApiKey    Apikey    `json:"-"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+	// DisabledAt is set once the user is disabled, after which none of their keys work
+	DisabledAt *time.Time `json:"disabled_at,omitempty"`
+}
+
+// Permissions holds the codes of the permissions granted to a user, e.g "pii:read"
+type Permissions []string
+
+// Include() reports whether the permission code is one of the permissions
+func (p Permissions) Include(code string) bool {
+	return slices.Contains(p, code)
 }
 
 // Declare a new AnonymousUser variable.
@@ -49,15 +79,24 @@ func (u *User) IsAnonymous() bool {
 
 // Check that the plaintext token has been provided and is exactly 26 bytes long.
 func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
-	v.Check(tokenPlaintext != "", "token", "must be provided")
+	v.Required("token", tokenPlaintext)
 	//v.Check(len(tokenPlaintext) == 36, "token", "must be valid")
 }
 
+// ValidateUser() checks the details we need to create a user
+func ValidateUser(v *validator.Validator, u *User) {
+	v.Required("email", u.Email)
+	v.Required("name", u.Name)
+	v.MaxLength("name", u.Name, 255)
+	v.CheckCode(validator.Matches(u.Email, validator.EmailRX), "email", validator.CodeInvalidFormat, "must be a valid email address")
+}
+
 // GetForApiKey() is a method that returns a user for a given api key
-// We recieve a plaintext API key as a string and return a pointer to a User struct and an error
-func (m AuthManagerModel) GetForApiKey(apiKey string) (*User, error) {
+// We recieve a plaintext API key as a string and return a pointer to a User struct and an error.
+// Revoked keys and keys of disabled users are treated as unknown.
+func (m AuthManagerModel) GetForApiKey(ctx context.Context, apiKey string) (*User, error) {
 	// create context
-	ctx, cancel := contextGenerator(context.Background(), DefaultAuthManDBContextTimeout)
+	ctx, cancel := contextGenerator(ctx, m.Timeout)
 	defer cancel()
 	// calculate sha256 hash of the api key
 	hash := sha256.Sum256([]byte(apiKey))
@@ -69,7 +108,7 @@ func (m AuthManagerModel) GetForApiKey(apiKey string) (*User, error) {
 		case errors.Is(err, sql.ErrNoRows):
 			return nil, ErrUserNotFound
 		default:
-			return nil, err
+			return nil, translateDBError(ctx, err)
 		}
 	}
 	// make a user
@@ -84,7 +123,177 @@ func (m AuthManagerModel) GetForApiKey(apiKey string) (*User, error) {
 	return authenticatedUser, nil
 }
 
+// CreateUser() creates a new user. Users have no access until they are issued an API key.
+func (m AuthManagerModel) CreateUser(ctx context.Context, user *User) error {
+	// create context
+	ctx, cancel := contextGenerator(ctx, m.Timeout)
+	defer cancel()
+	userInfo, err := m.DB.CreateUser(ctx, database.CreateUserParams{
+		Email: user.Email,
+		Name:  user.Name,
+	})
+	if err != nil {
+		// translate constraint violations to our sentinel errors
+		return translateDBError(ctx, err)
+	}
+	user.ID = userInfo.ID
+	user.CreatedAt = userInfo.CreatedAt
+	user.UpdatedAt = userInfo.UpdatedAt
+	return nil
+}
+
+// GetUserByEmail() gets a user, including disabled ones, by their email address
+func (m AuthManagerModel) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	// create context
+	ctx, cancel := contextGenerator(ctx, m.Timeout)
+	defer cancel()
+	user, err := m.DB.GetUserByEmail(ctx, email)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrUserNotFound
+		default:
+			return nil, translateDBError(ctx, err)
+		}
+	}
+	return &User{
+		ID:         user.ID,
+		Email:      user.Email,
+		Name:       user.Name,
+		CreatedAt:  user.CreatedAt,
+		UpdatedAt:  user.UpdatedAt,
+		DisabledAt: fromNullTime(user.DisabledAt),
+	}, nil
+}
+
+// DisableUser() disables the user with the email address, so that none of their API keys
+// authenticate any more. Disabling a user that is already disabled is not an error.
+func (m AuthManagerModel) DisableUser(ctx context.Context, email string) error {
+	// create context
+	ctx, cancel := contextGenerator(ctx, m.Timeout)
+	defer cancel()
+	_, err := m.DB.DisableUserByEmail(ctx, email)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrUserNotFound
+		default:
+			return translateDBError(ctx, err)
+		}
+	}
+	return nil
+}
+
+// IssueApiKey() generates a new API key for the user and stores its hash. The plaintext is
+// only available in the returned Apikey and can't be recovered later.
+func (m AuthManagerModel) IssueApiKey(ctx context.Context, userID int32) (*Apikey, error) {
+	apiKey, err := newApikey()
+	if err != nil {
+		return nil, err
+	}
+	// create context
+	ctx, cancel := contextGenerator(ctx, m.Timeout)
+	defer cancel()
+	apiKeyInfo, err := m.DB.CreateApiKey(ctx, database.CreateApiKeyParams{
+		UserID: userID,
+		Hash:   apiKey.Hash,
+	})
+	if err != nil {
+		// translate constraint violations to our sentinel errors
+		return nil, translateDBError(ctx, err)
+	}
+	apiKey.ID = apiKeyInfo.ID
+	return apiKey, nil
+}
+
+// GetApiKeysForUser() lists the user's API keys, including revoked ones
+func (m AuthManagerModel) GetApiKeysForUser(ctx context.Context, userID int32) ([]*ApiKeyRecord, error) {
+	// create context
+	ctx, cancel := contextGenerator(ctx, m.Timeout)
+	defer cancel()
+	apiKeys, err := m.DB.GetApiKeysByUserId(ctx, userID)
+	if err != nil {
+		return nil, translateDBError(ctx, err)
+	}
+	records := make([]*ApiKeyRecord, 0, len(apiKeys))
+	for _, apiKey := range apiKeys {
+		records = append(records, &ApiKeyRecord{
+			ID:        apiKey.ID,
+			UserID:    apiKey.UserID,
+			CreatedAt: apiKey.CreatedAt,
+			RevokedAt: fromNullTime(apiKey.RevokedAt),
+		})
+	}
+	return records, nil
+}
+
+// RevokeApiKey() revokes an API key by its ID. Revoked keys stop authenticating immediately.
+func (m AuthManagerModel) RevokeApiKey(ctx context.Context, id int32) error {
+	// create context
+	ctx, cancel := contextGenerator(ctx, m.Timeout)
+	defer cancel()
+	rowsAffected, err := m.DB.RevokeApiKey(ctx, id)
+	if err != nil {
+		return translateDBError(ctx, err)
+	}
+	if rowsAffected == 0 {
+		return ErrApiKeyNotFound
+	}
+	return nil
+}
+
+// GetAllPermissionsForUser() returns the permissions granted to the user
+func (m AuthManagerModel) GetAllPermissionsForUser(ctx context.Context, userID int32) (Permissions, error) {
+	// create context
+	ctx, cancel := contextGenerator(ctx, m.Timeout)
+	defer cancel()
+	codes, err := m.DB.GetAllPermissionsForUser(ctx, userID)
+	if err != nil {
+		return nil, translateDBError(ctx, err)
+	}
+	return Permissions(codes), nil
+}
+
+// AddPermissionForUser() grants the permission to the user, granting one they already
+// hold is not an error. The code must be one of KnownPermissions.
+func (m AuthManagerModel) AddPermissionForUser(ctx context.Context, userID int32, code string) error {
+	// create context
+	ctx, cancel := contextGenerator(ctx, m.Timeout)
+	defer cancel()
+	_, err := m.DB.AddPermissionForUser(ctx, database.AddPermissionForUserParams{
+		UserID: userID,
+		Code:   code,
+	})
+	if err != nil {
+		// translate constraint violations to our sentinel errors
+		return translateDBError(ctx, err)
+	}
+	return nil
+}
+
+// RemovePermissionForUser() takes the permission away from the user, removing one they
+// don't hold is not an error
+func (m AuthManagerModel) RemovePermissionForUser(ctx context.Context, userID int32, code string) error {
+	// create context
+	ctx, cancel := contextGenerator(ctx, m.Timeout)
+	defer cancel()
+	_, err := m.DB.RemovePermissionForUser(ctx, database.RemovePermissionForUserParams{
+		UserID: userID,
+		Code:   code,
+	})
+	if err != nil {
+		return translateDBError(ctx, err)
+	}
+	return nil
+}
+
+// GenerateToken() generates a new random API key along with its hash
 func (m AuthManagerModel) GenerateToken() (*Apikey, error) {
+	return newApikey()
+}
+
+// newApikey() generates a random plaintext API key and the SHA-256 hash we store for it
+func newApikey() (*Apikey, error) {
 	apiKey := &Apikey{}
 	// Initialize a zero-valued byte slice with a length of 16 bytes.
 	randomBytes := make([]byte, 16)
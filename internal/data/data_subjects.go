@@ -0,0 +1,360 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Blue-Davinci/SocialAid/internal/database"
+	"github.com/Blue-Davinci/SocialAid/internal/validator"
+)
+
+type DataSubjectsManagerModel struct {
+	DB *database.Queries
+	// Conn begins the transactions that run a request and record it as completed
+	Conn *sql.DB
+	// Timeout bounds every query, on top of the caller's context
+	Timeout time.Duration
+}
+
+const (
+	// DefaultDataSubjectManDBContextTimeout is longer than the other models' as erasures
+	// scrub the outbox, which has no index on the records its events describe
+	DefaultDataSubjectManDBContextTimeout = 30 * time.Second
+	// MaxDataSubjectReasonLength is the longest reason we accept for a request
+	MaxDataSubjectReasonLength = 1000
+)
+
+// The kinds of data subject requests. Exports hand the data subject everything we hold on
+// them, erasures scrub their names, national IDs and phone numbers but keep the records so
+// that the aggregate counts and the history of their house holds are unaffected.
+const (
+	DataSubjectExportRequest  = "export"
+	DataSubjectErasureRequest = "erasure"
+)
+
+// The states of a data subject request
+const (
+	DataSubjectRequestPending   = "pending"
+	DataSubjectRequestCompleted = "completed"
+	DataSubjectRequestRejected  = "rejected"
+)
+
+var (
+	ErrDataSubjectRequestNotFound     = errors.New("data subject request not found or already decided")
+	ErrDataSubjectRequestSelfDecision = errors.New("a data subject request must be decided by someone other than its requester")
+)
+
+// DataSubjectRequest is a request to export or erase everything we hold on the data subject
+// with a national ID. The national ID is only held until the request is decided.
+type DataSubjectRequest struct {
+	ID          int32  `json:"id"`
+	Kind        string `json:"kind"`
+	NationalID  string `json:"-"`
+	Reason      string `json:"reason"`
+	Status      string `json:"status"`
+	RequestedBy int32  `json:"requested_by"`
+	// RequestedByEmail and DecidedByEmail are only set when listing the requests
+	RequestedByEmail string `json:"requested_by_email,omitempty"`
+	// DecidedBy is 0 until the request has been approved or rejected
+	DecidedBy      int32      `json:"decided_by,omitempty"`
+	DecidedByEmail string     `json:"decided_by_email,omitempty"`
+	DecidedAt      *time.Time `json:"decided_at,omitempty"`
+	// HeadsFound and MembersFound count the records that matched when the request was made,
+	// and once completed the records that were exported or erased
+	HeadsFound   int32     `json:"heads_found"`
+	MembersFound int32     `json:"members_found"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// DataSubjectExport is the bundle handed to the data subject, with their phone numbers
+// decrypted
+type DataSubjectExport struct {
+	RequestID   int32                   `json:"request_id"`
+	NationalID  string                  `json:"national_id"`
+	GeneratedAt time.Time               `json:"generated_at"`
+	HouseHolds  []*DataSubjectHouseHold `json:"house_holds"`
+}
+
+// DataSubjectHouseHold is a house hold the data subject heads, with the records we hold on
+// them in it
+type DataSubjectHouseHold struct {
+	HouseHold   HouseHold     `json:"house_hold"`
+	ProgramName string        `json:"program_name"`
+	County      string        `json:"county"`
+	SubCounty   string        `json:"sub_county"`
+	Location    string        `json:"location"`
+	SubLocation string        `json:"sub_location"`
+	Head        HouseHoldHead `json:"head"`
+	// MemberRecords holds the member records added for the head
+	MemberRecords []*HouseHoldMember `json:"member_records"`
+}
+
+// ValidateDataSubjectRequest() validates a new data subject request
+func ValidateDataSubjectRequest(v *validator.Validator, r *DataSubjectRequest) {
+	validator.OneOf(v, "kind", r.Kind, DataSubjectExportRequest, DataSubjectErasureRequest)
+	v.Required("national_id", r.NationalID)
+	v.MaxLength("national_id", r.NationalID, 50)
+	v.Required("reason", r.Reason)
+	v.MaxLength("reason", r.Reason, MaxDataSubjectReasonLength)
+}
+
+// CreateDataSubjectRequest() records a pending request along with how many records match
+// the national ID, which is encrypted like the phone numbers. Nothing is exported or erased
+// until another user approves the request.
+func (m DataSubjectsManagerModel) CreateDataSubjectRequest(ctx context.Context, request *DataSubjectRequest, encryption_key string) error {
+	// create context
+	ctx, cancel := contextGenerator(ctx, m.Timeout)
+	defer cancel()
+	decodedKey, err := DecodeEncryptionKey(encryption_key)
+	if err != nil {
+		return err
+	}
+	encryptedNationalID, err := EncryptData(request.NationalID, decodedKey)
+	if err != nil {
+		return err
+	}
+	found, err := m.DB.CountDataSubjectRecords(ctx, request.NationalID)
+	if err != nil {
+		return translateDBError(ctx, err)
+	}
+	request.HeadsFound = int32(found.HeadsFound)
+	request.MembersFound = int32(found.MembersFound)
+	requestInfo, err := m.DB.CreateDataSubjectRequest(ctx, database.CreateDataSubjectRequestParams{
+		Kind:         request.Kind,
+		NationalID:   sql.NullString{String: encryptedNationalID, Valid: true},
+		Reason:       request.Reason,
+		RequestedBy:  request.RequestedBy,
+		HeadsFound:   request.HeadsFound,
+		MembersFound: request.MembersFound,
+	})
+	if err != nil {
+		return translateDBError(ctx, err)
+	}
+	request.ID = requestInfo.ID
+	request.Status = requestInfo.Status
+	request.CreatedAt = requestInfo.CreatedAt
+	request.UpdatedAt = requestInfo.UpdatedAt
+	return nil
+}
+
+// GetAllDataSubjectRequests() lists the requests, newest first, only those with the status
+// unless it is empty. The national IDs are left out.
+func (m DataSubjectsManagerModel) GetAllDataSubjectRequests(ctx context.Context, status string) ([]*DataSubjectRequest, error) {
+	// create context
+	ctx, cancel := contextGenerator(ctx, m.Timeout)
+	defer cancel()
+	requestRows, err := m.DB.GetAllDataSubjectRequests(ctx, status)
+	if err != nil {
+		return nil, translateDBError(ctx, err)
+	}
+	requests := make([]*DataSubjectRequest, 0, len(requestRows))
+	for _, requestInfo := range requestRows {
+		requests = append(requests, &DataSubjectRequest{
+			ID:               requestInfo.ID,
+			Kind:             requestInfo.Kind,
+			Reason:           requestInfo.Reason,
+			Status:           requestInfo.Status,
+			RequestedBy:      requestInfo.RequestedBy,
+			RequestedByEmail: requestInfo.RequestedByEmail,
+			DecidedBy:        requestInfo.DecidedBy.Int32,
+			DecidedByEmail:   requestInfo.DecidedByEmail.String,
+			DecidedAt:        fromNullTime(requestInfo.DecidedAt),
+			HeadsFound:       requestInfo.HeadsFound,
+			MembersFound:     requestInfo.MembersFound,
+			CreatedAt:        requestInfo.CreatedAt,
+			UpdatedAt:        requestInfo.UpdatedAt,
+		})
+	}
+	return requests, nil
+}
+
+// ApproveDataSubjectRequest() approves a pending request on behalf of a user other than its
+// requester and runs it in the same transaction, so that a request is completed exactly once.
+// Exports return the bundle for the data subject, erasures scrub the records and return nil.
+// Either way the national ID is cleared from the request, which is kept as the record.
+func (m DataSubjectsManagerModel) ApproveDataSubjectRequest(ctx context.Context, id int32, approverID int32, encryption_key string) (*DataSubjectRequest, *DataSubjectExport, error) {
+	// create context
+	ctx, cancel := contextGenerator(ctx, m.Timeout)
+	defer cancel()
+	decodedKey, err := DecodeEncryptionKey(encryption_key)
+	if err != nil {
+		return nil, nil, err
+	}
+	var request *DataSubjectRequest
+	var export *DataSubjectExport
+	err = withTransaction(ctx, m.Conn, m.DB, func(q *database.Queries) error {
+		requestInfo, err := q.GetPendingDataSubjectRequestForUpdate(ctx, id)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrDataSubjectRequestNotFound
+			}
+			return err
+		}
+		if requestInfo.RequestedBy == approverID {
+			return ErrDataSubjectRequestSelfDecision
+		}
+		nationalID, err := DecryptData(requestInfo.NationalID.String, decodedKey)
+		if err != nil {
+			return err
+		}
+		var headsFound, membersFound int64
+		switch requestInfo.Kind {
+		case DataSubjectExportRequest:
+			export, err = exportDataSubject(ctx, q, nationalID, decodedKey)
+			if err != nil {
+				return err
+			}
+			export.RequestID = requestInfo.ID
+			headsFound = int64(len(export.HouseHolds))
+			for _, houseHold := range export.HouseHolds {
+				membersFound += int64(len(houseHold.MemberRecords))
+			}
+		case DataSubjectErasureRequest:
+			headsFound, membersFound, err = eraseDataSubject(ctx, q, nationalID)
+			if err != nil {
+				return err
+			}
+		}
+		err = q.CompleteDataSubjectRequest(ctx, database.CompleteDataSubjectRequestParams{
+			ID:           requestInfo.ID,
+			DecidedBy:    sql.NullInt32{Int32: approverID, Valid: true},
+			HeadsFound:   int32(headsFound),
+			MembersFound: int32(membersFound),
+		})
+		if err != nil {
+			return err
+		}
+		decidedAt := time.Now()
+		request = &DataSubjectRequest{
+			ID:           requestInfo.ID,
+			Kind:         requestInfo.Kind,
+			Reason:       requestInfo.Reason,
+			Status:       DataSubjectRequestCompleted,
+			RequestedBy:  requestInfo.RequestedBy,
+			DecidedBy:    approverID,
+			DecidedAt:    &decidedAt,
+			HeadsFound:   int32(headsFound),
+			MembersFound: int32(membersFound),
+			CreatedAt:    requestInfo.CreatedAt,
+			UpdatedAt:    decidedAt,
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, translateDBError(ctx, err)
+	}
+	return request, export, nil
+}
+
+// RejectDataSubjectRequest() rejects a pending request on behalf of a user other than its
+// requester, clearing its national ID
+func (m DataSubjectsManagerModel) RejectDataSubjectRequest(ctx context.Context, id int32, deciderID int32) error {
+	// create context
+	ctx, cancel := contextGenerator(ctx, m.Timeout)
+	defer cancel()
+	rejected, err := m.DB.RejectDataSubjectRequest(ctx, database.RejectDataSubjectRequestParams{
+		ID:        id,
+		DecidedBy: sql.NullInt32{Int32: deciderID, Valid: true},
+	})
+	if err != nil {
+		// the data_subject_requests_decided_by_check constraint stops requesters deciding
+		return translateDBError(ctx, err)
+	}
+	if rejected == 0 {
+		return ErrDataSubjectRequestNotFound
+	}
+	return nil
+}
+
+// exportDataSubject() gathers the house holds headed by the national ID, along with the
+// head's member records, decrypting the phone numbers
+func exportDataSubject(ctx context.Context, q *database.Queries, nationalID string, decodedKey []byte) (*DataSubjectExport, error) {
+	headRows, err := q.GetDataSubjectHouseholdHeads(ctx, nationalID)
+	if err != nil {
+		return nil, err
+	}
+	memberRows, err := q.GetDataSubjectHouseholdMembers(ctx, nationalID)
+	if err != nil {
+		return nil, err
+	}
+	export := &DataSubjectExport{
+		NationalID:  nationalID,
+		GeneratedAt: time.Now(),
+		HouseHolds:  make([]*DataSubjectHouseHold, 0, len(headRows)),
+	}
+	for _, headInfo := range headRows {
+		phoneNumber, err := decryptPhoneNumber(headInfo.PhoneNumber, decodedKey)
+		if err != nil {
+			return nil, err
+		}
+		houseHold := &DataSubjectHouseHold{
+			HouseHold: HouseHold{
+				ID:            headInfo.HouseholdID,
+				ProgramID:     headInfo.ProgramID,
+				GeoLocationID: headInfo.GeolocationID,
+				Name:          headInfo.HouseholdName,
+				Latitude:      fromNullFloat64(headInfo.Latitude),
+				Longitude:     fromNullFloat64(headInfo.Longitude),
+				CreatedAt:     headInfo.HouseholdCreatedAt,
+			},
+			ProgramName: headInfo.ProgramName,
+			County:      headInfo.County,
+			SubCounty:   headInfo.SubCounty,
+			Location:    headInfo.Location,
+			SubLocation: headInfo.SubLocation,
+			Head: HouseHoldHead{
+				ID:             headInfo.ID,
+				HouseHoldID:    headInfo.HouseholdID,
+				Name:           headInfo.Name,
+				NationalID:     headInfo.NationalID,
+				NationalIDType: headInfo.NationalIDType,
+				PhoneNumber:    phoneNumber,
+				Age:            headInfo.Age,
+				CreatedAt:      headInfo.CreatedAt,
+				UpdatedAt:      headInfo.UpdatedAt,
+			},
+			MemberRecords: []*HouseHoldMember{},
+		}
+		for _, memberInfo := range memberRows {
+			if memberInfo.HouseholdID != headInfo.HouseholdID {
+				continue
+			}
+			houseHold.MemberRecords = append(houseHold.MemberRecords, &HouseHoldMember{
+				ID:          memberInfo.ID,
+				HouseHoldID: memberInfo.HouseholdID,
+				Name:        memberInfo.Name,
+				Age:         memberInfo.Age,
+				Relation:    memberInfo.Relation,
+				CreatedAt:   memberInfo.CreatedAt,
+				UpdatedAt:   memberInfo.UpdatedAt,
+			})
+		}
+		export.HouseHolds = append(export.HouseHolds, houseHold)
+	}
+	return export, nil
+}
+
+// eraseDataSubject() scrubs the names, national IDs and phone numbers of the heads with the
+// national ID, their member records, the names of their house holds and the events
+// published about them. It returns how many heads and member records were erased.
+func eraseDataSubject(ctx context.Context, q *database.Queries, nationalID string) (int64, int64, error) {
+	// the outbox, house holds and members are matched through the heads, so these go first
+	if err := q.EraseDataSubjectOutboxEvents(ctx, nationalID); err != nil {
+		return 0, 0, err
+	}
+	if err := q.EraseDataSubjectHouseholds(ctx, nationalID); err != nil {
+		return 0, 0, err
+	}
+	membersErased, err := q.EraseDataSubjectMembers(ctx, nationalID)
+	if err != nil {
+		return 0, 0, err
+	}
+	headsErased, err := q.EraseDataSubjectHeads(ctx, nationalID)
+	if err != nil {
+		return 0, 0, err
+	}
+	return headsErased, membersErased, nil
+}
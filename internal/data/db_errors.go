@@ -0,0 +1,113 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// Postgres SQLSTATE codes for the integrity constraint violations we translate.
+// See https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	pgUniqueViolation     = pq.ErrorCode("23505")
+	pgForeignKeyViolation = pq.ErrorCode("23503")
+	pgCheckViolation      = pq.ErrorCode("23514")
+	// pgQueryCanceled is sent when lib/pq cancels a running query because its context ended
+	pgQueryCanceled = pq.ErrorCode("57014")
+)
+
+var (
+	// ErrUniqueViolation is returned for unique violations we have no specific sentinel for
+	ErrUniqueViolation = errors.New("a record with these details already exists")
+	// ErrForeignKeyViolation is returned for foreign key violations we have no specific sentinel for
+	ErrForeignKeyViolation = errors.New("a referenced record does not exist")
+	// ErrCheckViolation is returned when a row fails one of the table's CHECK constraints
+	ErrCheckViolation = errors.New("a value is not permitted")
+	// ErrQueryCanceled is returned when the caller's context was cancelled mid query, e.g the
+	// client disconnected or the server is shutting down
+	ErrQueryCanceled = errors.New("the query was cancelled")
+	// ErrQueryTimeout is returned when a query outlived its context's deadline
+	ErrQueryTimeout = errors.New("the query timed out")
+)
+
+// constraintKey identifies a constraint violation by its SQLSTATE code and constraint name
+type constraintKey struct {
+	code       pq.ErrorCode
+	constraint string
+}
+
+// constraintErrors maps the constraint violations our models expect to their sentinel errors.
+// When a new constraint is added to the schema, add its entry here rather than matching on
+// the driver's error message, which may change between driver and Postgres versions.
+var constraintErrors = map[constraintKey]error{
+	{pgUniqueViolation, "programs_name_key"}:                                 ErrDuplicateProgram,
+	{pgUniqueViolation, "geolocations_sub_location_key"}:                     ErrDuplicateGeoLocation,
+	{pgForeignKeyViolation, "households_geolocation_id_fkey"}:                ErrGeoLocationDoesNotExist,
+	{pgForeignKeyViolation, "households_program_id_fkey"}:                    ErrProgramDoesNotExist,
+	{pgForeignKeyViolation, "household_heads_household_id_fkey"}:             ErrHouseHoldDoesNotExist,
+	{pgUniqueViolation, "household_heads_household_id_key"}:                  ErrHouseHoldAlreadyExists,
+	{pgForeignKeyViolation, "household_members_household_id_fkey"}:           ErrHouseHoldDoesNotExist,
+	{pgUniqueViolation, "unique_household_member"}:                           ErrHouseHoldMemberExists,
+	{pgUniqueViolation, "users_email_key"}:                                   ErrDuplicateEmail,
+	{pgForeignKeyViolation, "api_keys_user_id_fkey"}:                         ErrUserNotFound,
+	{pgForeignKeyViolation, "data_subject_requests_requested_by_fkey"}:       ErrUserNotFound,
+	{pgForeignKeyViolation, "data_subject_requests_decided_by_fkey"}:         ErrUserNotFound,
+	{pgCheckViolation, "data_subject_requests_decided_by_check"}:             ErrDataSubjectRequestSelfDecision,
+	{pgForeignKeyViolation, "users_permissions_user_id_fkey"}:                ErrUserNotFound,
+	{pgForeignKeyViolation, "pii_reveals_user_id_fkey"}:                      ErrUserNotFound,
+	{pgForeignKeyViolation, "pii_reveals_household_id_fkey"}:                 ErrHouseHoldDoesNotExist,
+	{pgForeignKeyViolation, "household_enrollments_household_id_fkey"}:       ErrHouseHoldDoesNotExist,
+	{pgForeignKeyViolation, "household_enrollments_program_id_fkey"}:         ErrProgramDoesNotExist,
+	{pgUniqueViolation, "household_enrollments_household_id_program_id_key"}: ErrHouseHoldAlreadyEnrolled,
+}
+
+// translateDBError() converts a Postgres constraint violation to one of our sentinel errors
+// using its SQLSTATE code and constraint name. Violations of constraints missing from
+// constraintErrors are mapped to the generic ErrUniqueViolation, ErrForeignKeyViolation or
+// ErrCheckViolation, wrapped with the constraint's name. Queries stopped by their context
+// are reported as ErrQueryCanceled or ErrQueryTimeout. Any other error is returned as is.
+func translateDBError(ctx context.Context, err error) error {
+	if ctxErr := translateContextError(ctx, err); ctxErr != nil {
+		return ctxErr
+	}
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return err
+	}
+	if sentinel, ok := constraintErrors[constraintKey{pqErr.Code, pqErr.Constraint}]; ok {
+		return sentinel
+	}
+	switch pqErr.Code {
+	case pgUniqueViolation:
+		return fmt.Errorf("%w: %s", ErrUniqueViolation, pqErr.Constraint)
+	case pgForeignKeyViolation:
+		return fmt.Errorf("%w: %s", ErrForeignKeyViolation, pqErr.Constraint)
+	case pgCheckViolation:
+		return fmt.Errorf("%w: %s", ErrCheckViolation, pqErr.Constraint)
+	default:
+		return err
+	}
+}
+
+// translateContextError() returns ErrQueryCanceled or ErrQueryTimeout, wrapping err, if the
+// query failed because ctx ended, and nil otherwise. Depending on when the context ends we
+// get either the context's own error or Postgres' query_canceled, so we ask the context
+// which of the two it was.
+func translateContextError(ctx context.Context, err error) error {
+	var pqErr *pq.Error
+	stoppedByContext := errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) ||
+		(errors.As(err, &pqErr) && pqErr.Code == pgQueryCanceled)
+	if !stoppedByContext {
+		return nil
+	}
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return fmt.Errorf("%w: %w", ErrQueryTimeout, err)
+	case errors.Is(ctx.Err(), context.Canceled):
+		return fmt.Errorf("%w: %w", ErrQueryCanceled, err)
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,187 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Blue-Davinci/SocialAid/internal/database"
+	"github.com/Blue-Davinci/SocialAid/internal/validator"
+)
+
+const (
+	// MaxEnrollmentExitReasonLength is the longest exit reason we accept
+	MaxEnrollmentExitReasonLength = 1000
+)
+
+// The states of an enrollment. Active and suspended house holds take up a place in the
+// program, exited ones have left it for good and free their place.
+const (
+	EnrollmentStatusActive    = "active"
+	EnrollmentStatusSuspended = "suspended"
+	EnrollmentStatusExited    = "exited"
+)
+
+// EnrollmentStatuses lists every status an enrollment may have
+var EnrollmentStatuses = []string{
+	EnrollmentStatusActive,
+	EnrollmentStatusSuspended,
+	EnrollmentStatusExited,
+}
+
+var (
+	ErrEnrollmentDoesNotExist   = errors.New("enrollment does not exist")
+	ErrHouseHoldAlreadyEnrolled = errors.New("house hold is already enrolled in the program")
+	ErrEnrollmentExited         = errors.New("the house hold has exited the program, its enrollment can no longer change")
+)
+
+// Enrollment is a house hold's enrollment in a program. A house hold is enrolled in the
+// program it is registered under when it is created, and may be enrolled in others after.
+type Enrollment struct {
+	ID          int32 `json:"id"`
+	HouseHoldID int32 `json:"house_hold_id"`
+	ProgramID   int32 `json:"program_id"`
+	// ProgramName is only set when the enrollments are read with their house hold
+	ProgramName string    `json:"program_name,omitempty"`
+	Status      string    `json:"status"`
+	EnrolledAt  time.Time `json:"enrolled_at"`
+	// ExitReason and ExitedAt are only set once the house hold has exited the program
+	ExitReason string     `json:"exit_reason,omitempty"`
+	ExitedAt   *time.Time `json:"exited_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// ValidateEnrollment() validates a new enrollment, its house hold is taken from the URL
+func ValidateEnrollment(v *validator.Validator, e *Enrollment) {
+	v.RequiredID("program_id", e.ProgramID)
+}
+
+// ValidateEnrollmentStatus() validates a change to an enrollment's status. A reason must be
+// given when, and only when, the house hold exits the program.
+func ValidateEnrollmentStatus(v *validator.Validator, e *Enrollment) {
+	v.Required("status", e.Status)
+	if e.Status != "" {
+		validator.OneOf(v, "status", e.Status, EnrollmentStatuses...)
+	}
+	if e.Status == EnrollmentStatusExited {
+		v.Required("exit_reason", strings.TrimSpace(e.ExitReason))
+		v.MaxLength("exit_reason", e.ExitReason, MaxEnrollmentExitReasonLength)
+		return
+	}
+	v.CrossField(e.ExitReason == "", "exit_reason", "must only be provided when status is exited")
+}
+
+// EnrollHouseHold() enrolls an existing house hold in another program, publishing a
+// household.enrolled event with it. Like CreateNewHouseHold(), the program must be open and
+// have room for the house hold in its county, otherwise one of ErrEnrollmentNotOpen,
+// ErrEnrollmentClosed, ErrProgramFull or ErrCountyQuotaFull is returned. A house hold is
+// only enrolled once in each program, even after exiting it.
+func (m HouseHoldsManagerModel) EnrollHouseHold(ctx context.Context, enrollment *Enrollment) error {
+	// create context
+	ctx, cancel := contextGenerator(ctx, m.Timeout)
+	defer cancel()
+	err := withTransaction(ctx, m.Conn, m.DB, func(q *database.Queries) error {
+		county, err := q.GetHouseholdCountyById(ctx, enrollment.HouseHoldID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrHouseHoldDoesNotExist
+			}
+			return err
+		}
+		// the program must be open and have room for it, which locks the program until we commit
+		if err := checkEnrollment(ctx, q, enrollment.ProgramID, county); err != nil {
+			return err
+		}
+		enrollmentInfo, err := q.CreateHouseholdEnrollment(ctx, database.CreateHouseholdEnrollmentParams{
+			HouseholdID: enrollment.HouseHoldID,
+			ProgramID:   enrollment.ProgramID,
+		})
+		if err != nil {
+			return err
+		}
+		// set the new enrollment info
+		enrollment.ID = enrollmentInfo.ID
+		enrollment.Status = enrollmentInfo.Status
+		enrollment.EnrolledAt = enrollmentInfo.EnrolledAt
+		enrollment.CreatedAt = enrollmentInfo.CreatedAt
+		enrollment.UpdatedAt = enrollmentInfo.UpdatedAt
+		return createOutboxEvent(ctx, q, EventHouseHoldEnrolled, enrollment)
+	})
+	if err != nil {
+		// translate constraint violations to our sentinel errors
+		return translateDBError(ctx, err)
+	}
+	return nil
+}
+
+// UpdateEnrollmentStatus() changes the status of the house hold's enrollment, publishing a
+// household.enrollment_updated event with it. We recieve the enrollment's ID, house hold ID,
+// new status and exit reason and fill in the rest of the enrollment. Exiting is final,
+// changing an exited enrollment returns ErrEnrollmentExited.
+func (m HouseHoldsManagerModel) UpdateEnrollmentStatus(ctx context.Context, enrollment *Enrollment) error {
+	// create context
+	ctx, cancel := contextGenerator(ctx, m.Timeout)
+	defer cancel()
+	err := withTransaction(ctx, m.Conn, m.DB, func(q *database.Queries) error {
+		stored, err := q.GetHouseholdEnrollmentForUpdate(ctx, database.GetHouseholdEnrollmentForUpdateParams{
+			ID:          enrollment.ID,
+			HouseholdID: enrollment.HouseHoldID,
+		})
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrEnrollmentDoesNotExist
+			}
+			return err
+		}
+		if stored.Status == EnrollmentStatusExited {
+			return ErrEnrollmentExited
+		}
+		enrollmentInfo, err := q.UpdateHouseholdEnrollmentStatus(ctx, database.UpdateHouseholdEnrollmentStatusParams{
+			ID:         enrollment.ID,
+			Status:     enrollment.Status,
+			ExitReason: toNullString(enrollment.ExitReason),
+		})
+		if err != nil {
+			return err
+		}
+		// set the updated enrollment info
+		enrollment.ProgramID = stored.ProgramID
+		enrollment.EnrolledAt = stored.EnrolledAt
+		enrollment.ExitedAt = fromNullTime(enrollmentInfo.ExitedAt)
+		enrollment.CreatedAt = stored.CreatedAt
+		enrollment.UpdatedAt = enrollmentInfo.UpdatedAt
+		return createOutboxEvent(ctx, q, EventHouseHoldEnrollmentUpdated, enrollment)
+	})
+	if err != nil {
+		// translate constraint violations to our sentinel errors
+		return translateDBError(ctx, err)
+	}
+	return nil
+}
+
+// getHouseHoldEnrollments() gets every enrollment of the house hold, with its program's name
+func (m HouseHoldsManagerModel) getHouseHoldEnrollments(ctx context.Context, houseHoldID int32) ([]*Enrollment, error) {
+	enrollmentRows, err := m.DB.GetHouseholdEnrollmentsByHouseholdId(ctx, houseHoldID)
+	if err != nil {
+		return nil, translateDBError(ctx, err)
+	}
+	enrollments := make([]*Enrollment, 0, len(enrollmentRows))
+	for _, row := range enrollmentRows {
+		enrollments = append(enrollments, &Enrollment{
+			ID:          row.ID,
+			HouseHoldID: row.HouseholdID,
+			ProgramID:   row.ProgramID,
+			ProgramName: row.ProgramName,
+			Status:      row.Status,
+			EnrolledAt:  row.EnrolledAt,
+			ExitReason:  row.ExitReason.String,
+			ExitedAt:    fromNullTime(row.ExitedAt),
+			CreatedAt:   row.CreatedAt,
+			UpdatedAt:   row.UpdatedAt,
+		})
+	}
+	return enrollments, nil
+}
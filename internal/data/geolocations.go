@@ -11,6 +11,8 @@ import (
 
 type GeoLocationsManagerModel struct {
 	DB *database.Queries
+	// Timeout bounds every query, on top of the caller's context
+	Timeout time.Duration
 }
 
 const (
@@ -27,26 +29,29 @@ type GeoLocation struct {
 	SubCounty   string    `json:"sub_county"`
 	Location    string    `json:"location"`
 	SubLocation string    `json:"sub_location"`
+	Latitude    *float64  `json:"latitude,omitempty"`
+	Longitude   *float64  `json:"longitude,omitempty"`
 	CreatedAt   time.Time `json:"created_at"`
 }
 
 func ValidateGeoLocation(v *validator.Validator, g *GeoLocation) {
-	v.Check(g.County != "", "county", "must be provided")
-	v.Check(g.SubCounty != "", "sub_county", "must be provided")
-	v.Check(g.Location != "", "location", "must be provided")
-	v.Check(g.SubLocation != "", "sub_location", "must be provided")
-	v.Check(len(g.County) <= 255, "county", "must not be more than 255 bytes long")
-	v.Check(len(g.SubCounty) <= 255, "sub_county", "must not be more than 255 bytes long")
-	v.Check(len(g.Location) <= 255, "location", "must not be more than 255 bytes long")
-	v.Check(len(g.SubLocation) <= 255, "sub_location", "must not be more than 255 bytes long")
+	v.Required("county", g.County)
+	v.Required("sub_county", g.SubCounty)
+	v.Required("location", g.Location)
+	v.Required("sub_location", g.SubLocation)
+	v.MaxLength("county", g.County, 255)
+	v.MaxLength("sub_county", g.SubCounty, 255)
+	v.MaxLength("location", g.Location, 255)
+	v.MaxLength("sub_location", g.SubLocation, 255)
+	ValidateCoordinates(v, g.Latitude, g.Longitude)
 }
 
 // CreateNewGeoLocation() creates a new geo location in the database
 // We recieve a pointer to a GeoLocation struct and return an error if the geo location already exists or
 // if there was an error creating the geo location
-func (m GeoLocationsManagerModel) CreateNewGeoLocation(geoLocation *GeoLocation) error {
+func (m GeoLocationsManagerModel) CreateNewGeoLocation(ctx context.Context, geoLocation *GeoLocation) error {
 	// create context
-	ctx, cancel := contextGenerator(context.Background(), DefaultGeoLocManDBContextTimeout)
+	ctx, cancel := contextGenerator(ctx, m.Timeout)
 	defer cancel()
 	// create new geo location
 	geoLocationInfo, err := m.DB.CreateNewGeoLocation(ctx, database.CreateNewGeoLocationParams{
@@ -54,14 +59,12 @@ func (m GeoLocationsManagerModel) CreateNewGeoLocation(geoLocation *GeoLocation)
 		SubCounty:   geoLocation.SubCounty,
 		Location:    geoLocation.Location,
 		SubLocation: geoLocation.SubLocation,
+		Latitude:    toNullFloat64(geoLocation.Latitude),
+		Longitude:   toNullFloat64(geoLocation.Longitude),
 	})
 	if err != nil {
-		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "geolocations_sub_location_key"`:
-			return ErrDuplicateGeoLocation
-		default:
-			return err
-		}
+		// translate constraint violations to our sentinel errors
+		return translateDBError(ctx, err)
 	}
 	// set the new geo location info
 	geoLocation.ID = geoLocationInfo.ID
@@ -69,3 +72,28 @@ func (m GeoLocationsManagerModel) CreateNewGeoLocation(geoLocation *GeoLocation)
 
 	return nil
 }
+
+// GetAllGeoLocations() gets every geo location ordered by ID, for admin tooling
+func (m GeoLocationsManagerModel) GetAllGeoLocations(ctx context.Context) ([]*GeoLocation, error) {
+	// create context
+	ctx, cancel := contextGenerator(ctx, m.Timeout)
+	defer cancel()
+	geoLocationRows, err := m.DB.GetAllGeoLocations(ctx)
+	if err != nil {
+		return nil, translateDBError(ctx, err)
+	}
+	geoLocations := make([]*GeoLocation, 0, len(geoLocationRows))
+	for _, geoLocationInfo := range geoLocationRows {
+		geoLocations = append(geoLocations, &GeoLocation{
+			ID:          geoLocationInfo.ID,
+			County:      geoLocationInfo.County,
+			SubCounty:   geoLocationInfo.SubCounty,
+			Location:    geoLocationInfo.Location,
+			SubLocation: geoLocationInfo.SubLocation,
+			Latitude:    fromNullFloat64(geoLocationInfo.Latitude),
+			Longitude:   fromNullFloat64(geoLocationInfo.Longitude),
+			CreatedAt:   geoLocationInfo.CreatedAt,
+		})
+	}
+	return geoLocations, nil
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"math"
 	"time"
 
 	"github.com/Blue-Davinci/SocialAid/internal/database"
@@ -12,10 +14,24 @@ import (
 
 type HouseHoldsManagerModel struct {
 	DB *database.Queries
+	// Conn begins the transactions that write the outbox along with the change
+	Conn *sql.DB
+	// Timeout bounds every query, on top of the caller's context
+	Timeout time.Duration
 }
 
 const (
 	DefaultHouseHoldManDBContextTimeout = 5 * time.Second
+	// MaxRadiusKm is the largest radius we allow for a radius search
+	MaxRadiusKm = 500
+	// MaxPersonAge is the oldest age we accept for heads and members
+	MaxPersonAge = 130
+	// DefaultPhoneNumberMigrationBatchSize is how many household heads we re-encrypt per batch
+	DefaultPhoneNumberMigrationBatchSize = 500
+	// maxGeoJSONScanBatches bounds the batches of house holds a radius search reads for one
+	// page. Most of a dense bounding box may lie outside the radius, so rather than reading
+	// all of it we return a short page with a cursor after the last house hold read.
+	maxGeoJSONScanBatches = 10
 )
 
 var (
@@ -28,7 +44,9 @@ var (
 )
 
 type EnrichedHouseHold struct {
-	HouseHoldID          int32  `json:"house_hold_id"`
+	HouseHoldID int32 `json:"house_hold_id"`
+	// ProgramID and ProgramName are the program the house hold was registered under, every
+	// program it is enrolled in is listed in Enrollments
 	ProgramID            int32  `json:"program_id"`
 	ProgramName          string `json:"program_name"`
 	GeoLocationID        int32  `json:"geolocation_id"`
@@ -38,24 +56,153 @@ type EnrichedHouseHold struct {
 	HouseHoldHeadName    string `json:"household_head_name"`
 	PhoneNumber          string `json:"phone_number"`
 	HouseHoldMemberCount int64  `json:"household_member_count"`
+	// Enrollments lists the house hold's enrollments in programs, in the order they were made
+	Enrollments []*Enrollment `json:"enrollments"`
+	// UpdatedAt is the last change to the house hold, its program, head, members or
+	// enrollments, sent as the Last-Modified header rather than in the body
+	UpdatedAt time.Time `json:"-"`
+	// the related records, only set when asked for with HouseHoldIncludes. The head's phone
+	// number is decrypted, like PhoneNumber.
+	Members     []*HouseHoldMember `json:"members,omitempty"`
+	Head        *HouseHoldHead     `json:"head,omitempty"`
+	Program     *Program           `json:"program,omitempty"`
+	GeoLocation *GeoLocation       `json:"geolocation,omitempty"`
 }
+
+// MaskPhoneNumbers() masks the head's phone number, wherever the house hold holds it, for
+// users who may not read it in full
+func (h *EnrichedHouseHold) MaskPhoneNumbers() {
+	h.PhoneNumber = MaskPhoneNumber(h.PhoneNumber)
+	if h.Head != nil {
+		h.Head.PhoneNumber = MaskPhoneNumber(h.Head.PhoneNumber)
+	}
+}
+
+// EnrichedHouseHoldFields lists the fields of an EnrichedHouseHold, as named in its JSON,
+// that a sparse fieldset may select
+var EnrichedHouseHoldFields = []string{
+	"house_hold_id", "program_id", "program_name", "geolocation_id", "county", "sub_county",
+	"household_head_id", "household_head_name", "phone_number", "household_member_count",
+	"enrollments", "members", "head", "program", "geolocation",
+}
+
+// HouseHoldIncludes selects the related records GetHouseHoldInformation() loads along with
+// the house hold, each with a single query
+type HouseHoldIncludes struct {
+	Members     bool
+	Head        bool
+	Program     bool
+	GeoLocation bool
+}
+
+// The values accepted by ParseHouseHoldIncludes()
+const (
+	HouseHoldIncludeMembers     = "members"
+	HouseHoldIncludeHead        = "head"
+	HouseHoldIncludeProgram     = "program"
+	HouseHoldIncludeGeoLocation = "geolocation"
+)
+
+// ParseHouseHoldIncludes() turns the list of related records asked for, e.g from
+// ?include=members,head, into HouseHoldIncludes, recording unknown values in the validator
+func ParseHouseHoldIncludes(v *validator.Validator, values []string) HouseHoldIncludes {
+	var includes HouseHoldIncludes
+	for _, value := range values {
+		switch value {
+		case HouseHoldIncludeMembers:
+			includes.Members = true
+		case HouseHoldIncludeHead:
+			includes.Head = true
+		case HouseHoldIncludeProgram:
+			includes.Program = true
+		case HouseHoldIncludeGeoLocation:
+			includes.GeoLocation = true
+		default:
+			validator.OneOf(v, "include", value, HouseHoldIncludeMembers, HouseHoldIncludeHead, HouseHoldIncludeProgram, HouseHoldIncludeGeoLocation)
+		}
+	}
+	return includes
+}
+
 type HouseHold struct {
 	ID            int32     `json:"id"`
 	ProgramID     int32     `json:"program_id"`
 	GeoLocationID int32     `json:"geo_location_id"`
 	Name          string    `json:"name"`
+	Latitude      *float64  `json:"latitude,omitempty"`
+	Longitude     *float64  `json:"longitude,omitempty"`
 	CreatedAt     time.Time `json:"created_at"`
 }
 
+// HouseHoldGeoFilter holds the filters used when mapping house holds. Zero values mean
+// "no filter". ProgramID matches the house holds enrolled in the program and not exited,
+// whichever program they were registered under. When Latitude, Longitude and RadiusKm are
+// set, only house holds within RadiusKm of that point are returned.
+type HouseHoldGeoFilter struct {
+	ProgramID     int32
+	GeoLocationID int32
+	County        string
+	SubCounty     string
+	Latitude      *float64
+	Longitude     *float64
+	RadiusKm      *float64
+}
+
+// GeoJSONFeatureCollection is a GeoJSON (RFC 7946) FeatureCollection of house holds
+type GeoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []GeoJSONFeature `json:"features"`
+}
+
+type GeoJSONFeature struct {
+	Type       string                   `json:"type"`
+	Geometry   GeoJSONPoint             `json:"geometry"`
+	Properties HouseHoldGeoJSONProperty `json:"properties"`
+}
+
+// GeoJSONPoint holds the coordinates in GeoJSON's [longitude, latitude] order
+type GeoJSONPoint struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+type HouseHoldGeoJSONProperty struct {
+	HouseHoldID   int32    `json:"house_hold_id"`
+	Name          string   `json:"name"`
+	ProgramID     int32    `json:"program_id"`
+	ProgramName   string   `json:"program_name"`
+	GeoLocationID int32    `json:"geo_location_id"`
+	County        string   `json:"county"`
+	SubCounty     string   `json:"sub_county"`
+	DistanceKm    *float64 `json:"distance_km,omitempty"`
+}
+
 type HouseHoldHead struct {
-	ID          int32     `json:"id"`
-	HouseHoldID int32     `json:"house_hold_id"`
-	Name        string    `json:"name"`
-	NationalID  string    `json:"national_id"`
-	PhoneNumber string    `json:"phone_number"`
-	Age         int32     `json:"age"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID             int32     `json:"id"`
+	HouseHoldID    int32     `json:"house_hold_id"`
+	Name           string    `json:"name"`
+	NationalID     string    `json:"national_id"`
+	NationalIDType string    `json:"national_id_type"`
+	PhoneNumber    string    `json:"phone_number"`
+	Age            int32     `json:"age"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+	// ErasedAt is set once the head has been erased on request, see DataSubjectStore
+	ErasedAt *time.Time `json:"erased_at,omitempty"`
+}
+
+// PhoneNumberMigrationResult summarizes a run of NormalizeHouseholdHeadPhoneNumbers()
+type PhoneNumberMigrationResult struct {
+	Scanned   int     `json:"scanned"`
+	Updated   int     `json:"updated"`
+	Unchanged int     `json:"unchanged"`
+	Invalid   []int32 `json:"invalid_household_head_ids"`
+}
+
+// PhoneNumberCheckResult summarizes a run of CheckHouseholdHeadPhoneNumbers()
+type PhoneNumberCheckResult struct {
+	Scanned       int     `json:"scanned"`
+	Undecryptable []int32 `json:"undecryptable_household_head_ids"`
 }
 
 type HouseHoldMember struct {
@@ -66,41 +213,87 @@ type HouseHoldMember struct {
 	Relation    string    `json:"relation"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
+	// ErasedAt is set once the member has been erased on request, see DataSubjectStore
+	ErasedAt *time.Time `json:"erased_at,omitempty"`
 }
 
 // ValidateHouseHold() validates the house hold struct
 func ValidateHouseHold(v *validator.Validator, h *HouseHold) {
-	v.Check(h.ProgramID != 0, "program_id", "must be provided")
-	v.Check(h.GeoLocationID != 0, "geo_location_id", "must be provided")
-	v.Check(h.Name != "", "name", "must be provided")
-	v.Check(len(h.Name) <= 255, "name", "must not be more than 255 bytes long")
+	v.RequiredID("program_id", h.ProgramID)
+	v.RequiredID("geo_location_id", h.GeoLocationID)
+	v.Required("name", h.Name)
+	v.MaxLength("name", h.Name, 255)
+	ValidateCoordinates(v, h.Latitude, h.Longitude)
+}
+
+// ValidateHouseHoldGeoFilterID() validates an ID filter as parsed, before it is narrowed to
+// int32, which would wrap e.g 4294967297 round to 1 and so to another program's house holds.
+func ValidateHouseHoldGeoFilterID(v *validator.Validator, key string, id int) {
+	validator.InRange(v, key, id, 0, math.MaxInt32)
+}
+
+// ValidateHouseHoldGeoFilter() validates the filters used for the GeoJSON and radius search.
+// A radius search needs the latitude, longitude and radius together.
+func ValidateHouseHoldGeoFilter(v *validator.Validator, f *HouseHoldGeoFilter) {
+	validator.Min(v, "program_id", f.ProgramID, 0)
+	validator.Min(v, "geo_location_id", f.GeoLocationID, 0)
+	if f.Latitude == nil && f.Longitude == nil && f.RadiusKm == nil {
+		return
+	}
+	v.CrossField(f.RadiusKm != nil, "radius_km", "must be provided together with latitude and longitude")
+	if f.RadiusKm != nil {
+		v.CheckCode(*f.RadiusKm > 0, "radius_km", validator.CodeOutOfRange, "must be greater than zero")
+		validator.InRange(v, "radius_km", *f.RadiusKm, 0, MaxRadiusKm)
+	}
+	if f.Latitude == nil && f.Longitude == nil {
+		v.CrossField(false, "latitude", "must be provided together with radius_km")
+		return
+	}
+	ValidateCoordinates(v, f.Latitude, f.Longitude)
 }
 
 func ValidateHouseHoldHead(v *validator.Validator, h *HouseHoldHead) {
-	v.Check(h.HouseHoldID != 0, "house_hold_id", "must be provided")
-	v.Check(h.Name != "", "name", "must be provided")
-	v.Check(len(h.Name) <= 255, "name", "must not be more than 255 bytes long")
-	v.Check(h.NationalID != "", "national_id", "must be provided")
-	v.Check(len(h.NationalID) <= 15, "national_id", "must not be more than 255 bytes long")
-	v.Check(h.PhoneNumber != "", "phone_number", "must be provided")
-	v.Check(h.Age != 0, "age", "must be provided")
+	v.RequiredID("house_hold_id", h.HouseHoldID)
+	v.Required("name", h.Name)
+	v.MaxLength("name", h.Name, 255)
+	v.Required("national_id", h.NationalID)
+	v.MaxLength("national_id", h.NationalID, 50)
+	v.NationalID("national_id", "national_id_type", h.NationalIDType, h.NationalID)
+	v.Required("phone_number", h.PhoneNumber)
+	validator.NotZero(v, "age", h.Age)
+	validator.InRange(v, "age", h.Age, 1, MaxPersonAge)
+	// national IDs are only issued to adults, so a younger head must use another document
+	if h.NationalIDType == validator.IDTypeNationalID && h.Age != 0 {
+		v.CrossField(h.Age >= validator.MinimumNationalIDAge, "age", "must be at least 18 for a national ID holder")
+	}
+}
+
+// NormalizeHouseHoldHeadPhoneNumber() rewrites the head's phone number to canonical E.164
+// so that every number we encrypt and store is in the same format. We add a validation
+// error if the number cannot be normalized.
+func NormalizeHouseHoldHeadPhoneNumber(v *validator.Validator, normalizer *validator.PhoneNumberNormalizer, h *HouseHoldHead) {
+	if h.PhoneNumber == "" {
+		return
+	}
+	h.PhoneNumber = v.PhoneNumber("phone_number", normalizer, h.PhoneNumber)
 }
 
 func ValidateHouseHoldMember(v *validator.Validator, h *HouseHoldMember) {
-	v.Check(h.HouseHoldID != 0, "house_hold_id", "must be provided")
-	v.Check(h.Name != "", "name", "must be provided")
-	v.Check(len(h.Name) <= 255, "name", "must not be more than 255 bytes long")
-	v.Check(h.Age != 0, "age", "must be provided")
-	v.Check(h.Relation != "", "relation", "must be provided")
-	v.Check(len(h.Relation) <= 255, "relation", "must not be more than 255 bytes long")
+	v.RequiredID("house_hold_id", h.HouseHoldID)
+	v.Required("name", h.Name)
+	v.MaxLength("name", h.Name, 255)
+	validator.NotZero(v, "age", h.Age)
+	validator.InRange(v, "age", h.Age, 1, MaxPersonAge)
+	v.Required("relation", h.Relation)
+	v.MaxLength("relation", h.Relation, 50)
 }
 
 // GetHouseholdHeadByHouseholdId() retrieves a house hold head by the house hold id
 // We recieve the house hold id and return the house hold head and an error if there was an error
 // retrieving the house hold head
-func (m HouseHoldsManagerModel) GetHouseholdHeadByHouseholdId(houseHoldID int32) (*HouseHoldHead, error) {
+func (m HouseHoldsManagerModel) GetHouseholdHeadByHouseholdId(ctx context.Context, houseHoldID int32) (*HouseHoldHead, error) {
 	// create context
-	ctx, cancel := contextGenerator(context.Background(), DefaultHouseHoldManDBContextTimeout)
+	ctx, cancel := contextGenerator(ctx, m.Timeout)
 	defer cancel()
 	// get the house hold head
 	houseHoldHead, err := m.DB.GetHouseholdHeadByHouseholdId(ctx, houseHoldID)
@@ -109,28 +302,31 @@ func (m HouseHoldsManagerModel) GetHouseholdHeadByHouseholdId(houseHoldID int32)
 		case errors.Is(err, sql.ErrNoRows):
 			return nil, ErrHouseHoldHeadDoesNotExist
 		default:
-			return nil, err
+			return nil, translateDBError(ctx, err)
 		}
 	}
 	// return the house hold head
 	return &HouseHoldHead{
-		ID:          houseHoldHead.ID,
-		HouseHoldID: houseHoldHead.HouseholdID,
-		Name:        houseHoldHead.Name,
-		NationalID:  houseHoldHead.NationalID,
-		PhoneNumber: houseHoldHead.PhoneNumber,
-		Age:         houseHoldHead.Age,
-		CreatedAt:   houseHoldHead.CreatedAt,
-		UpdatedAt:   houseHoldHead.UpdatedAt,
+		ID:             houseHoldHead.ID,
+		HouseHoldID:    houseHoldHead.HouseholdID,
+		Name:           houseHoldHead.Name,
+		NationalID:     houseHoldHead.NationalID,
+		NationalIDType: houseHoldHead.NationalIDType,
+		PhoneNumber:    houseHoldHead.PhoneNumber,
+		Age:            houseHoldHead.Age,
+		CreatedAt:      houseHoldHead.CreatedAt,
+		UpdatedAt:      houseHoldHead.UpdatedAt,
+		ErasedAt:       fromNullTime(houseHoldHead.ErasedAt),
 	}, nil
 }
 
 // GetHouseHoldInformation() retrieves a house hold by the house hold id
 // We recieve the house hold id and return the house hold and an error if there was an error
-// retrieving the house hold
-func (m HouseHoldsManagerModel) GetHouseHoldInformation(houseHoldID int32, encryption_key string) (*EnrichedHouseHold, error) {
+// retrieving the house hold. Its enrollments, and the related records selected by includes,
+// are loaded with one query each.
+func (m HouseHoldsManagerModel) GetHouseHoldInformation(ctx context.Context, houseHoldID int32, includes HouseHoldIncludes, encryption_key string) (*EnrichedHouseHold, error) {
 	// create context
-	ctx, cancel := contextGenerator(context.Background(), DefaultHouseHoldManDBContextTimeout)
+	ctx, cancel := contextGenerator(ctx, m.Timeout)
 	defer cancel()
 	// get the house hold
 	houseHolds, err := m.DB.GetHouseHoldInformation(ctx, houseHoldID)
@@ -139,7 +335,7 @@ func (m HouseHoldsManagerModel) GetHouseHoldInformation(houseHoldID int32, encry
 		case errors.Is(err, sql.ErrNoRows):
 			return nil, ErrHouseHoldDoesNotExist
 		default:
-			return nil, err
+			return nil, translateDBError(ctx, err)
 		}
 	}
 	// prepare decrypted phone number
@@ -148,7 +344,7 @@ func (m HouseHoldsManagerModel) GetHouseHoldInformation(houseHoldID int32, encry
 	if err != nil {
 		return nil, err
 	}
-	decryptedPhoneNumber, err := DecryptData(houseHolds.PhoneNumber, decodedKey)
+	decryptedPhoneNumber, err := decryptPhoneNumber(houseHolds.PhoneNumber, decodedKey)
 	if err != nil {
 		return nil, err
 	}
@@ -166,37 +362,227 @@ func (m HouseHoldsManagerModel) GetHouseHoldInformation(houseHoldID int32, encry
 		// we need to decrypt the phone number before returning it
 		PhoneNumber:          decryptedPhoneNumber,
 		HouseHoldMemberCount: houseHolds.HouseholdMemberCount,
+		UpdatedAt:            houseHolds.UpdatedAt,
+	}
+	enrichedHouseHolds.Enrollments, err = m.getHouseHoldEnrollments(ctx, houseHoldID)
+	if err != nil {
+		return nil, err
+	}
+	for _, enrollment := range enrichedHouseHolds.Enrollments {
+		if enrollment.UpdatedAt.After(enrichedHouseHolds.UpdatedAt) {
+			enrichedHouseHolds.UpdatedAt = enrollment.UpdatedAt
+		}
+	}
+	if err := m.loadHouseHoldIncludes(ctx, enrichedHouseHolds, includes); err != nil {
+		return nil, err
 	}
 	// return the house hold
 	return enrichedHouseHolds, nil
 }
 
+// loadHouseHoldIncludes() loads the related records selected by includes into the house
+// hold, each with a single query on an indexed key
+func (m HouseHoldsManagerModel) loadHouseHoldIncludes(ctx context.Context, houseHold *EnrichedHouseHold, includes HouseHoldIncludes) error {
+	if includes.Members {
+		memberRows, err := m.DB.GetHouseholdMembersByHouseholdId(ctx, houseHold.HouseHoldID)
+		if err != nil {
+			return translateDBError(ctx, err)
+		}
+		houseHold.Members = make([]*HouseHoldMember, 0, len(memberRows))
+		for _, member := range memberRows {
+			houseHold.Members = append(houseHold.Members, &HouseHoldMember{
+				ID:          member.ID,
+				HouseHoldID: member.HouseholdID,
+				Name:        member.Name,
+				Age:         member.Age,
+				Relation:    member.Relation,
+				CreatedAt:   member.CreatedAt,
+				UpdatedAt:   member.UpdatedAt,
+				ErasedAt:    fromNullTime(member.ErasedAt),
+			})
+		}
+	}
+	if includes.Head {
+		head, err := m.DB.GetHouseholdHeadByHouseholdId(ctx, houseHold.HouseHoldID)
+		if err != nil {
+			return translateDBError(ctx, err)
+		}
+		houseHold.Head = &HouseHoldHead{
+			ID:             head.ID,
+			HouseHoldID:    head.HouseholdID,
+			Name:           head.Name,
+			NationalID:     head.NationalID,
+			NationalIDType: head.NationalIDType,
+			// the phone number we have already decrypted
+			PhoneNumber: houseHold.PhoneNumber,
+			Age:         head.Age,
+			CreatedAt:   head.CreatedAt,
+			UpdatedAt:   head.UpdatedAt,
+			ErasedAt:    fromNullTime(head.ErasedAt),
+		}
+	}
+	if includes.Program {
+		program, err := m.DB.GetProgramById(ctx, houseHold.ProgramID)
+		if err != nil {
+			return translateDBError(ctx, err)
+		}
+		houseHold.Program = programFromRow(program)
+	}
+	if includes.GeoLocation {
+		geoLocation, err := m.DB.GetGeoLocationById(ctx, houseHold.GeoLocationID)
+		if err != nil {
+			return translateDBError(ctx, err)
+		}
+		houseHold.GeoLocation = &GeoLocation{
+			ID:          geoLocation.ID,
+			County:      geoLocation.County,
+			SubCounty:   geoLocation.SubCounty,
+			Location:    geoLocation.Location,
+			SubLocation: geoLocation.SubLocation,
+			Latitude:    fromNullFloat64(geoLocation.Latitude),
+			Longitude:   fromNullFloat64(geoLocation.Longitude),
+			CreatedAt:   geoLocation.CreatedAt,
+		}
+	}
+	return nil
+}
+
+// GetHouseHoldsGeoJSON() retrieves the house holds matching the filter as a GeoJSON FeatureCollection
+// House holds without their own coordinates fall back to the coordinates of their geo location.
+// For radius searches we pre-filter with a bounding box in SQL and then apply the exact
+// haversine distance here, so that we do not depend on PostGIS. The house holds are keyset
+// paginated on their ID, along with the collection we return the cursor of the next page.
+// A radius search reads at most maxGeoJSONScanBatches batches per page, so its pages may be
+// short, or even empty, and still have a next page.
+func (m HouseHoldsManagerModel) GetHouseHoldsGeoJSON(ctx context.Context, filter *HouseHoldGeoFilter, page Page) (*GeoJSONFeatureCollection, *Cursor, error) {
+	// create context
+	ctx, cancel := contextGenerator(ctx, m.Timeout)
+	defer cancel()
+	// by default we search the whole country
+	params := database.GetHouseHoldsForGeoJSONParams{
+		ProgramID:     filter.ProgramID,
+		GeolocationID: filter.GeoLocationID,
+		County:        filter.County,
+		SubCounty:     filter.SubCounty,
+		MinLatitude:   KenyaMinLatitude,
+		MaxLatitude:   KenyaMaxLatitude,
+		MinLongitude:  KenyaMinLongitude,
+		MaxLongitude:  KenyaMaxLongitude,
+		AfterID:       page.AfterID(),
+		// one more than asked for, to tell whether there is a next page
+		Limit: page.Limit + 1,
+	}
+	isRadiusSearch := filter.Latitude != nil && filter.Longitude != nil && filter.RadiusKm != nil
+	if isRadiusSearch {
+		params.MinLatitude, params.MaxLatitude, params.MinLongitude, params.MaxLongitude = boundingBox(*filter.Latitude, *filter.Longitude, *filter.RadiusKm)
+	}
+	// build the feature collection
+	featureCollection := &GeoJSONFeatureCollection{
+		Type:     "FeatureCollection",
+		Features: []GeoJSONFeature{},
+	}
+	// radius searches drop some of the house holds we read, so we keep reading until the page
+	// is full, there are no house holds left or we have read maxGeoJSONScanBatches batches
+	for batch := 0; len(featureCollection.Features) <= int(page.Limit); batch++ {
+		if batch == maxGeoJSONScanBatches {
+			// the next page picks up after the last house hold we read
+			return featureCollection, &Cursor{ID: params.AfterID}, nil
+		}
+		rows, err := m.DB.GetHouseHoldsForGeoJSON(ctx, params)
+		if err != nil {
+			return nil, nil, translateDBError(ctx, err)
+		}
+		for _, row := range rows {
+			params.AfterID = row.HouseholdID
+			properties := HouseHoldGeoJSONProperty{
+				HouseHoldID:   row.HouseholdID,
+				Name:          row.Name,
+				ProgramID:     row.ProgramID,
+				ProgramName:   row.ProgramName,
+				GeoLocationID: row.GeolocationID,
+				County:        row.County,
+				SubCounty:     row.SubCounty,
+			}
+			// drop the corners of the bounding box that fall outside the radius
+			if isRadiusSearch {
+				distance := haversineDistanceKm(*filter.Latitude, *filter.Longitude, row.Latitude, row.Longitude)
+				if distance > *filter.RadiusKm {
+					continue
+				}
+				properties.DistanceKm = &distance
+			}
+			featureCollection.Features = append(featureCollection.Features, GeoJSONFeature{
+				Type: "Feature",
+				Geometry: GeoJSONPoint{
+					Type:        "Point",
+					Coordinates: [2]float64{row.Longitude, row.Latitude},
+				},
+				Properties: properties,
+			})
+		}
+		if len(rows) < int(params.Limit) {
+			break
+		}
+	}
+	var next *Cursor
+	featureCollection.Features, next = paginate(featureCollection.Features, page, geoJSONFeatureCursor)
+	return featureCollection, next, nil
+}
+
+// geoJSONFeatureCursor() returns the cursor of a house hold feature, which are sorted by ID
+func geoJSONFeatureCursor(feature GeoJSONFeature) Cursor {
+	return Cursor{ID: feature.Properties.HouseHoldID}
+}
+
 // CreateNewHouseHold() creates a new house hold in the database
 // We recieve a pointer to a HouseHold struct and return an error if the house hold already exists or
-// if there was an error creating the house hold
-func (m HouseHoldsManagerModel) CreateNewHouseHold(houseHold *HouseHold) error {
+// if there was an error creating the house hold. Enrolling outside the program's enrollment
+// window, or past its cap or its quota for the county, returns one of ErrEnrollmentNotOpen,
+// ErrEnrollmentClosed, ErrProgramFull or ErrCountyQuotaFull.
+func (m HouseHoldsManagerModel) CreateNewHouseHold(ctx context.Context, houseHold *HouseHold) error {
 	// create context
-	ctx, cancel := contextGenerator(context.Background(), DefaultHouseHoldManDBContextTimeout)
+	ctx, cancel := contextGenerator(ctx, m.Timeout)
 	defer cancel()
-	// create new house hold
-	houseHoldInfo, err := m.DB.CreateNewHousehold(ctx, database.CreateNewHouseholdParams{
-		ProgramID:     houseHold.ProgramID,
-		GeolocationID: houseHold.GeoLocationID,
-		Name:          houseHold.Name,
-	})
-	if err != nil {
-		switch {
-		case err.Error() == `pq: insert or update on table "households" violates foreign key constraint "households_geolocation_id_fkey"`:
-			return ErrGeoLocationDoesNotExist
-		case err.Error() == `pq: insert or update on table "households" violates foreign key constraint "households_program_id_fkey"`:
-			return ErrProgramDoesNotExist
-		default:
+	// create new house hold, publishing a household.created event with it
+	err := withTransaction(ctx, m.Conn, m.DB, func(q *database.Queries) error {
+		geoLocation, err := q.GetGeoLocationById(ctx, houseHold.GeoLocationID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrGeoLocationDoesNotExist
+			}
+			return err
+		}
+		// the program must be open and have room for it, which locks the program until we commit
+		if err := checkEnrollment(ctx, q, houseHold.ProgramID, geoLocation.County); err != nil {
+			return err
+		}
+		houseHoldInfo, err := q.CreateNewHousehold(ctx, database.CreateNewHouseholdParams{
+			ProgramID:     houseHold.ProgramID,
+			GeolocationID: houseHold.GeoLocationID,
+			Name:          houseHold.Name,
+			Latitude:      toNullFloat64(houseHold.Latitude),
+			Longitude:     toNullFloat64(houseHold.Longitude),
+		})
+		if err != nil {
+			return err
+		}
+		// set the new house hold info
+		houseHold.ID = houseHoldInfo.ID
+		houseHold.CreatedAt = houseHoldInfo.CreatedAt
+		// the program it is registered under is its first enrollment
+		_, err = q.CreateHouseholdEnrollment(ctx, database.CreateHouseholdEnrollmentParams{
+			HouseholdID: houseHold.ID,
+			ProgramID:   houseHold.ProgramID,
+		})
+		if err != nil {
 			return err
 		}
+		return createOutboxEvent(ctx, q, EventHouseHoldCreated, houseHold)
+	})
+	if err != nil {
+		// translate constraint violations to our sentinel errors
+		return translateDBError(ctx, err)
 	}
-	// set the new house hold info
-	houseHold.ID = houseHoldInfo.ID
-	houseHold.CreatedAt = houseHoldInfo.CreatedAt
 	return nil
 }
 
@@ -204,9 +590,9 @@ func (m HouseHoldsManagerModel) CreateNewHouseHold(houseHold *HouseHold) error {
 // We recieve a pointer to a HouseHold struct and return an error if the house hold already has a head or
 // if there was an error creating the house hold head.
 // We encrypt the phone number before storing it in the database
-func (m HouseHoldsManagerModel) CreateNewHouseholdHead(houseHoldHead *HouseHoldHead, encryption_key string) error {
+func (m HouseHoldsManagerModel) CreateNewHouseholdHead(ctx context.Context, houseHoldHead *HouseHoldHead, encryption_key string) error {
 	// create context
-	ctx, cancel := contextGenerator(context.Background(), DefaultHouseHoldManDBContextTimeout)
+	ctx, cancel := contextGenerator(ctx, m.Timeout)
 	defer cancel()
 	// handle phone number encryption
 	// decrypt our hex encoded key
@@ -219,29 +605,29 @@ func (m HouseHoldsManagerModel) CreateNewHouseholdHead(houseHoldHead *HouseHoldH
 	if err != nil {
 		return err
 	}
-	// create new house hold head
-	houseHoldHeadInfo, err := m.DB.CreateNewHouseholdHead(ctx, database.CreateNewHouseholdHeadParams{
-		HouseholdID: houseHoldHead.HouseHoldID,
-		Name:        houseHoldHead.Name,
-		NationalID:  houseHoldHead.NationalID,
-		PhoneNumber: encryptedPhoneNumber,
-		Age:         houseHoldHead.Age,
-	})
-	if err != nil {
-		switch {
-		// check if the house hold already has a head, if so return an error
-		case err.Error() == `pq: insert or update on table "household_heads" violates foreign key constraint "household_heads_household_id_fkey"`:
-			return ErrHouseHoldDoesNotExist
-		case err.Error() == `pq: duplicate key value violates unique constraint "household_heads_household_id_key"`:
-			return ErrHouseHoldAlreadyExists
-		default:
+	// create new house hold head, publishing a household.head_assigned event with it
+	err = withTransaction(ctx, m.Conn, m.DB, func(q *database.Queries) error {
+		houseHoldHeadInfo, err := q.CreateNewHouseholdHead(ctx, database.CreateNewHouseholdHeadParams{
+			HouseholdID:    houseHoldHead.HouseHoldID,
+			Name:           houseHoldHead.Name,
+			NationalID:     houseHoldHead.NationalID,
+			NationalIDType: houseHoldHead.NationalIDType,
+			PhoneNumber:    encryptedPhoneNumber,
+			Age:            houseHoldHead.Age,
+		})
+		if err != nil {
 			return err
 		}
+		// set the new house hold head info
+		houseHoldHead.ID = houseHoldHeadInfo.ID
+		houseHoldHead.CreatedAt = houseHoldHeadInfo.CreatedAt
+		houseHoldHead.UpdatedAt = houseHoldHeadInfo.UpdatedAt
+		return createOutboxEvent(ctx, q, EventHouseHoldHeadAssigned, newHouseHoldHeadEvent(houseHoldHead))
+	})
+	if err != nil {
+		// translate constraint violations to our sentinel errors
+		return translateDBError(ctx, err)
 	}
-	// set the new house hold head info
-	houseHoldHead.ID = houseHoldHeadInfo.ID
-	houseHoldHead.CreatedAt = houseHoldHeadInfo.CreatedAt
-	houseHoldHead.UpdatedAt = houseHoldHeadInfo.UpdatedAt
 	// return nil if everything is successful
 	return nil
 }
@@ -249,31 +635,131 @@ func (m HouseHoldsManagerModel) CreateNewHouseholdHead(houseHoldHead *HouseHoldH
 // CreateNewHouseholdMember() creates a new house hold member in the database
 // We recieve a pointer to a HouseHold struct and return an error if the house hold already has a head or
 // if there was an error creating the house hold head.
-func (m HouseHoldsManagerModel) CreateNewHouseholdMember(houseHoldMember *HouseHoldMember) error {
+func (m HouseHoldsManagerModel) CreateNewHouseholdMember(ctx context.Context, houseHoldMember *HouseHoldMember) error {
 	// create context
-	ctx, cancel := contextGenerator(context.Background(), DefaultHouseHoldManDBContextTimeout)
+	ctx, cancel := contextGenerator(ctx, m.Timeout)
 	defer cancel()
-	// create new house hold member
-	houseHoldMemberInfo, err := m.DB.CreateNewHouseholdMember(ctx, database.CreateNewHouseholdMemberParams{
-		HouseholdID: houseHoldMember.HouseHoldID,
-		Name:        houseHoldMember.Name,
-		Age:         houseHoldMember.Age,
-		Relation:    houseHoldMember.Relation,
-	})
-	if err != nil {
-		switch {
-		case err.Error() == `pq: insert or update on table "household_members" violates foreign key constraint "household_members_household_id_fkey"`:
-			return ErrHouseHoldDoesNotExist
-		case err.Error() == `pq: duplicate key value violates unique constraint "unique_household_member"`:
-			return ErrHouseHoldMemberExists
-		default:
+	// create new house hold member, publishing a household.member_added event with it
+	err := withTransaction(ctx, m.Conn, m.DB, func(q *database.Queries) error {
+		houseHoldMemberInfo, err := q.CreateNewHouseholdMember(ctx, database.CreateNewHouseholdMemberParams{
+			HouseholdID: houseHoldMember.HouseHoldID,
+			Name:        houseHoldMember.Name,
+			Age:         houseHoldMember.Age,
+			Relation:    houseHoldMember.Relation,
+		})
+		if err != nil {
 			return err
 		}
+		// set the new house hold member info
+		houseHoldMember.ID = houseHoldMemberInfo.ID
+		houseHoldMember.CreatedAt = houseHoldMemberInfo.CreatedAt
+		houseHoldMember.UpdatedAt = houseHoldMemberInfo.UpdatedAt
+		return createOutboxEvent(ctx, q, EventHouseHoldMemberAdded, houseHoldMember)
+	})
+	if err != nil {
+		// translate constraint violations to our sentinel errors
+		return translateDBError(ctx, err)
 	}
-	// set the new house hold member info
-	houseHoldMember.ID = houseHoldMemberInfo.ID
-	houseHoldMember.CreatedAt = houseHoldMemberInfo.CreatedAt
-	houseHoldMember.UpdatedAt = houseHoldMemberInfo.UpdatedAt
 	// return nil if everything is successful
 	return nil
 }
+
+// NormalizeHouseholdHeadPhoneNumbers() is a one-off migration job that rewrites every stored
+// household head phone number to canonical E.164. As the numbers are encrypted we cannot
+// do this in SQL, so we walk the table in batches, decrypt, normalize and re-encrypt.
+// Numbers that are already canonical are left untouched, which makes the job safe to re-run.
+// Numbers that cannot be normalized are reported back rather than failing the whole run.
+func (m HouseHoldsManagerModel) NormalizeHouseholdHeadPhoneNumbers(ctx context.Context, encryption_key string, normalizer *validator.PhoneNumberNormalizer) (*PhoneNumberMigrationResult, error) {
+	decodedKey, err := DecodeEncryptionKey(encryption_key)
+	if err != nil {
+		return nil, err
+	}
+	result := &PhoneNumberMigrationResult{Invalid: []int32{}}
+	lastID := int32(0)
+	for {
+		// every batch gets its own context so that a large table doesn't hit the timeout
+		batchCtx, cancel := contextGenerator(ctx, m.Timeout)
+		heads, err := m.DB.GetHouseholdHeadPhoneNumbersAfterId(batchCtx, database.GetHouseholdHeadPhoneNumbersAfterIdParams{
+			ID:    lastID,
+			Limit: DefaultPhoneNumberMigrationBatchSize,
+		})
+		if err != nil {
+			cancel()
+			return nil, translateDBError(batchCtx, err)
+		}
+		for _, head := range heads {
+			lastID = head.ID
+			result.Scanned++
+			phoneNumber, err := DecryptData(head.PhoneNumber, decodedKey)
+			if err != nil {
+				cancel()
+				return nil, fmt.Errorf("household head %d: %w", head.ID, err)
+			}
+			normalizedPhoneNumber, err := normalizer.Normalize(phoneNumber)
+			if err != nil {
+				result.Invalid = append(result.Invalid, head.ID)
+				continue
+			}
+			if normalizedPhoneNumber == phoneNumber {
+				result.Unchanged++
+				continue
+			}
+			encryptedPhoneNumber, err := EncryptData(normalizedPhoneNumber, decodedKey)
+			if err != nil {
+				cancel()
+				return nil, err
+			}
+			err = m.DB.UpdateHouseholdHeadPhoneNumber(batchCtx, database.UpdateHouseholdHeadPhoneNumberParams{
+				ID:          head.ID,
+				PhoneNumber: encryptedPhoneNumber,
+			})
+			if err != nil {
+				cancel()
+				return nil, translateDBError(batchCtx, err)
+			}
+			result.Updated++
+		}
+		cancel()
+		if len(heads) < DefaultPhoneNumberMigrationBatchSize {
+			break
+		}
+	}
+	return result, nil
+}
+
+// CheckHouseholdHeadPhoneNumbers() is an integrity check that decrypts every stored household
+// head phone number with the key, walking the table in batches like the normalization job.
+// Nothing is written. The heads whose numbers fail to decrypt, e.g because they were stored
+// under another key or have been corrupted, are reported back.
+func (m HouseHoldsManagerModel) CheckHouseholdHeadPhoneNumbers(ctx context.Context, encryption_key string) (*PhoneNumberCheckResult, error) {
+	decodedKey, err := DecodeEncryptionKey(encryption_key)
+	if err != nil {
+		return nil, err
+	}
+	result := &PhoneNumberCheckResult{Undecryptable: []int32{}}
+	lastID := int32(0)
+	for {
+		// every batch gets its own context so that a large table doesn't hit the timeout
+		batchCtx, cancel := contextGenerator(ctx, m.Timeout)
+		heads, err := m.DB.GetHouseholdHeadPhoneNumbersAfterId(batchCtx, database.GetHouseholdHeadPhoneNumbersAfterIdParams{
+			ID:    lastID,
+			Limit: DefaultPhoneNumberMigrationBatchSize,
+		})
+		if err != nil {
+			cancel()
+			return nil, translateDBError(batchCtx, err)
+		}
+		cancel()
+		for _, head := range heads {
+			lastID = head.ID
+			result.Scanned++
+			if _, err := DecryptData(head.PhoneNumber, decodedKey); err != nil {
+				result.Undecryptable = append(result.Undecryptable, head.ID)
+			}
+		}
+		if len(heads) < DefaultPhoneNumberMigrationBatchSize {
+			break
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,157 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Blue-Davinci/SocialAid/internal/database"
+)
+
+type IdempotencyManagerModel struct {
+	DB *database.Queries
+	// Timeout bounds every query, on top of the caller's context
+	Timeout time.Duration
+}
+
+const (
+	DefaultIdempotencyManDBContextTimeout = 5 * time.Second
+)
+
+var (
+	ErrIdempotencyKeyExists   = errors.New("idempotency key is already in use")
+	ErrIdempotencyKeyNotFound = errors.New("idempotency key not found")
+)
+
+// IdempotencyRecord is a request made with an Idempotency-Key header along with, once it
+// has been processed, the response we replay when the request is retried. Keys are scoped
+// to the user, anonymous requests share the user ID 0.
+type IdempotencyRecord struct {
+	ID          int32
+	UserID      int32
+	Key         string
+	RequestHash []byte
+	// StatusCode is 0 while the request is being processed
+	StatusCode   int
+	ContentType  string
+	ResponseBody []byte
+	CreatedAt    time.Time
+	ExpiresAt    time.Time
+	// LockedUntil is the end of the lease of a request being processed, nil once completed
+	LockedUntil *time.Time
+}
+
+// InProgress() reports whether the original request is still being processed
+func (r *IdempotencyRecord) InProgress() bool {
+	return r.StatusCode == 0
+}
+
+// Abandoned() reports whether the original request is still in progress past its lease,
+// i.e it crashed or timed out, so that a retry may reclaim the key
+func (r *IdempotencyRecord) Abandoned() bool {
+	return r.InProgress() && r.LockedUntil != nil && !r.LockedUntil.After(time.Now())
+}
+
+// ReserveIdempotencyKey() stores the key, user and request hash before the request is
+// processed, taking over the key if its previous record has expired or was abandoned past
+// its lease. The unique constraint makes this atomic, so of several concurrent requests with
+// the same key only one gets to reserve it, the others get ErrIdempotencyKeyExists.
+func (m IdempotencyManagerModel) ReserveIdempotencyKey(ctx context.Context, record *IdempotencyRecord) error {
+	// create context
+	ctx, cancel := contextGenerator(ctx, m.Timeout)
+	defer cancel()
+	recordInfo, err := m.DB.CreateIdempotencyKey(ctx, database.CreateIdempotencyKeyParams{
+		UserID:         record.UserID,
+		IdempotencyKey: record.Key,
+		RequestHash:    record.RequestHash,
+		ExpiresAt:      record.ExpiresAt,
+		LockedUntil:    toNullTime(record.LockedUntil),
+	})
+	if err != nil {
+		switch {
+		// the conflicting record is still live
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrIdempotencyKeyExists
+		default:
+			return translateDBError(ctx, err)
+		}
+	}
+	record.ID = recordInfo.ID
+	record.CreatedAt = recordInfo.CreatedAt
+	return nil
+}
+
+// GetIdempotencyKey() gets the user's live record for the key
+func (m IdempotencyManagerModel) GetIdempotencyKey(ctx context.Context, userID int32, key string) (*IdempotencyRecord, error) {
+	// create context
+	ctx, cancel := contextGenerator(ctx, m.Timeout)
+	defer cancel()
+	recordInfo, err := m.DB.GetIdempotencyKey(ctx, database.GetIdempotencyKeyParams{
+		UserID:         userID,
+		IdempotencyKey: key,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrIdempotencyKeyNotFound
+		default:
+			return nil, translateDBError(ctx, err)
+		}
+	}
+	return &IdempotencyRecord{
+		ID:           recordInfo.ID,
+		UserID:       recordInfo.UserID,
+		Key:          recordInfo.IdempotencyKey,
+		RequestHash:  recordInfo.RequestHash,
+		StatusCode:   int(recordInfo.StatusCode.Int32),
+		ContentType:  recordInfo.ContentType,
+		ResponseBody: recordInfo.ResponseBody,
+		CreatedAt:    recordInfo.CreatedAt,
+		ExpiresAt:    recordInfo.ExpiresAt,
+		LockedUntil:  fromNullTime(recordInfo.LockedUntil),
+	}, nil
+}
+
+// CompleteIdempotencyKey() stores the response of a reserved key, ending its lease. A key
+// reclaimed by a retry has a new ID, so completing the abandoned reservation changes nothing.
+func (m IdempotencyManagerModel) CompleteIdempotencyKey(ctx context.Context, record *IdempotencyRecord) error {
+	// create context
+	ctx, cancel := contextGenerator(ctx, m.Timeout)
+	defer cancel()
+	err := m.DB.CompleteIdempotencyKey(ctx, database.CompleteIdempotencyKeyParams{
+		ID:           record.ID,
+		StatusCode:   sql.NullInt32{Int32: int32(record.StatusCode), Valid: true},
+		ContentType:  record.ContentType,
+		ResponseBody: record.ResponseBody,
+	})
+	if err != nil {
+		return translateDBError(ctx, err)
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey() deletes a reserved key whose request failed, so that the client
+// can retry it
+func (m IdempotencyManagerModel) ReleaseIdempotencyKey(ctx context.Context, id int32) error {
+	// create context
+	ctx, cancel := contextGenerator(ctx, m.Timeout)
+	defer cancel()
+	err := m.DB.DeleteIdempotencyKeyById(ctx, id)
+	if err != nil {
+		return translateDBError(ctx, err)
+	}
+	return nil
+}
+
+// DeleteExpiredIdempotencyKeys() purges the expired records, returning how many were deleted
+func (m IdempotencyManagerModel) DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	// create context
+	ctx, cancel := contextGenerator(ctx, m.Timeout)
+	defer cancel()
+	deleted, err := m.DB.DeleteExpiredIdempotencyKeys(ctx)
+	if err != nil {
+		return 0, translateDBError(ctx, err)
+	}
+	return deleted, nil
+}
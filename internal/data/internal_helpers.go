@@ -5,13 +5,16 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"database/sql"
 	"encoding/base64"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"time"
 
+	"github.com/Blue-Davinci/SocialAid/internal/database"
 	"github.com/Blue-Davinci/SocialAid/internal/validator"
 )
 
@@ -21,6 +24,19 @@ const (
 	KeyLength32 = 32 // 256 bits
 )
 
+// Kenya's bounding box, padded slightly so that border locations are accepted.
+// All captured coordinates must fall within it.
+const (
+	KenyaMinLatitude  = -4.9
+	KenyaMaxLatitude  = 5.1
+	KenyaMinLongitude = 33.8
+	KenyaMaxLongitude = 42.0
+	// earthRadiusKm is the mean radius of the earth used by the haversine formula
+	earthRadiusKm = 6371.0
+	// kmPerDegreeLatitude is the approximate distance covered by one degree of latitude
+	kmPerDegreeLatitude = 111.32
+)
+
 var (
 	ErrInvalidEncryptionKeyLength = errors.New("invalid key length, must be 16, 24, or 32 bytes")
 )
@@ -33,7 +49,133 @@ func contextGenerator(ctx context.Context, timeout time.Duration) (context.Conte
 }
 
 func ValidateURLID(v *validator.Validator, parameterID int64, fieldName string) {
-	v.Check(parameterID > 0, fieldName, "must be a valid ID")
+	v.ValidID(fieldName, parameterID)
+}
+
+// ValidateCoordinates() checks that a latitude/longitude pair is either fully absent or
+// fully present and inside Kenya's bounding box.
+func ValidateCoordinates(v *validator.Validator, latitude, longitude *float64) {
+	if latitude == nil && longitude == nil {
+		return
+	}
+	v.CrossField(latitude != nil, "latitude", "must be provided together with longitude")
+	v.CrossField(longitude != nil, "longitude", "must be provided together with latitude")
+	if latitude != nil {
+		v.CheckCode(*latitude >= KenyaMinLatitude && *latitude <= KenyaMaxLatitude, "latitude", validator.CodeOutOfRange, "must be within Kenya")
+	}
+	if longitude != nil {
+		v.CheckCode(*longitude >= KenyaMinLongitude && *longitude <= KenyaMaxLongitude, "longitude", validator.CodeOutOfRange, "must be within Kenya")
+	}
+}
+
+/**
+====================================================================
+Geospatial Functions
+====================================================================
+**/
+// haversineDistanceKm() returns the great-circle distance in kilometres between two points.
+// We do the math in Go so that we work on stock Postgres without PostGIS.
+func haversineDistanceKm(lat1, lon1, lat2, lon2 float64) float64 {
+	dLat := degreesToRadians(lat2 - lat1)
+	dLon := degreesToRadians(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(degreesToRadians(lat1))*math.Cos(degreesToRadians(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return earthRadiusKm * 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// boundingBox() returns the min/max latitude and longitude of a box enclosing the circle of
+// the given radius around a point. It is used as a cheap, index friendly pre-filter in SQL
+// before the exact haversine distance is applied.
+func boundingBox(latitude, longitude, radiusKm float64) (minLat, maxLat, minLon, maxLon float64) {
+	latDelta := radiusKm / kmPerDegreeLatitude
+	// guard against the division blowing up near the poles
+	lonDelta := radiusKm / (kmPerDegreeLatitude * math.Max(math.Cos(degreesToRadians(latitude)), 0.01))
+	return latitude - latDelta, latitude + latDelta, longitude - lonDelta, longitude + lonDelta
+}
+
+func degreesToRadians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
+
+// fromNullTime() converts a nullable timestamp to a pointer, nil when NULL
+func fromNullTime(value sql.NullTime) *time.Time {
+	if !value.Valid {
+		return nil
+	}
+	return &value.Time
+}
+
+// fromNullFloat64() converts a nullable float to a pointer, nil when NULL
+func fromNullFloat64(value sql.NullFloat64) *float64 {
+	if !value.Valid {
+		return nil
+	}
+	return &value.Float64
+}
+
+// toNullFloat64() converts an optional float to a sql.NullFloat64 for our sqlc params
+func toNullFloat64(value *float64) sql.NullFloat64 {
+	if value == nil {
+		return sql.NullFloat64{}
+	}
+	return sql.NullFloat64{Float64: *value, Valid: true}
+}
+
+// toNullTime() converts an optional timestamp to a sql.NullTime for our sqlc params
+func toNullTime(value *time.Time) sql.NullTime {
+	if value == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: *value, Valid: true}
+}
+
+// fromNullInt32() converts a nullable int to a pointer, nil when NULL
+func fromNullInt32(value sql.NullInt32) *int32 {
+	if !value.Valid {
+		return nil
+	}
+	return &value.Int32
+}
+
+// toOptionalNullInt32() converts an optional int to a sql.NullInt32, unlike toNullInt32()
+// zero is kept
+func toOptionalNullInt32(value *int32) sql.NullInt32 {
+	if value == nil {
+		return sql.NullInt32{}
+	}
+	return sql.NullInt32{Int32: *value, Valid: true}
+}
+
+// toNullInt32() converts a status code to a sql.NullInt32, NULL when 0
+func toNullInt32(value int) sql.NullInt32 {
+	if value == 0 {
+		return sql.NullInt32{}
+	}
+	return sql.NullInt32{Int32: int32(value), Valid: true}
+}
+
+// toNullString() converts an optional string to a sql.NullString, NULL when empty
+func toNullString(value string) sql.NullString {
+	if value == "" {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: value, Valid: true}
+}
+
+// withTransaction() runs fn with the queries bound to a new transaction, which is committed
+// if fn succeeds and rolled back otherwise. The error is returned as is for the caller to
+// translate.
+func withTransaction(ctx context.Context, conn *sql.DB, queries *database.Queries, fn func(q *database.Queries) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// a no-op once the transaction has been committed
+	defer tx.Rollback()
+	if err := fn(queries.WithTx(tx)); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 /**
@@ -41,8 +183,9 @@ func ValidateURLID(v *validator.Validator, parameterID int64, fieldName string)
 Encryption Functions
 ====================================================================
 **/
-// generateSecurityKey() generates a cryptographically secure AES key of the given length
-func generateSecurityKey(keyLength int) (string, error) {
+// GenerateSecurityKey() generates a cryptographically secure, hex encoded AES key of the given
+// length, suitable for the data encryption key. socialaidctl exposes it as gen-key.
+func GenerateSecurityKey(keyLength int) (string, error) {
 	if keyLength != KeyLength16 && keyLength != KeyLength24 && keyLength != KeyLength32 {
 		return "", ErrInvalidEncryptionKeyLength
 	}
@@ -70,6 +213,20 @@ func DecodeEncryptionKey(encryptionkey string) ([]byte, error) {
 	return decodedKey, nil
 }
 
+// CheckEncryptionKey() checks that a hex encoded key decodes to a valid AES key length
+func CheckEncryptionKey(encryptionkey string) error {
+	decodedKey, err := DecodeEncryptionKey(encryptionkey)
+	if err != nil {
+		return err
+	}
+	switch len(decodedKey) {
+	case KeyLength16, KeyLength24, KeyLength32:
+		return nil
+	default:
+		return ErrInvalidEncryptionKeyLength
+	}
+}
+
 // EncryptData() encrypts the given data using the provided key and returns the encrypted data as a base64 encoded string
 // The key must be a 16, 24, or 32 byte slice to select AES-128, AES-192, or AES-256 encryption
 func EncryptData(data string, key []byte) (string, error) {
@@ -133,3 +290,12 @@ func DecryptData(encryptedData string, key []byte) (string, error) {
 	// Return the decrypted data as a string
 	return string(decrypted), nil
 }
+
+// decryptPhoneNumber() decrypts a household head's stored phone number. Erased heads have
+// none, for them we return an empty string rather than failing.
+func decryptPhoneNumber(encryptedPhoneNumber string, key []byte) (string, error) {
+	if encryptedPhoneNumber == "" {
+		return "", nil
+	}
+	return DecryptData(encryptedPhoneNumber, key)
+}
@@ -0,0 +1,1717 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Blue-Davinci/SocialAid/internal/validator"
+)
+
+// memoryDB is the shared state of the in-memory stores. Like the Postgres schema it enforces
+// the unique and foreign key constraints across tables, so the stores share a single lock.
+type memoryDB struct {
+	mu               sync.Mutex
+	programs         map[int32]*Program
+	geoLocations     map[int32]*GeoLocation
+	houseHolds       map[int32]*HouseHold
+	houseHoldHeads   map[int32]*HouseHoldHead
+	houseHoldMembers map[int32]*HouseHoldMember
+	enrollments      map[int32]*Enrollment
+	users            map[int32]*User
+	apiKeys          map[int32]*memoryApiKey
+	idempotencyKeys  map[int32]*IdempotencyRecord
+	// the users_permissions table, the codes granted to each user
+	userPermissions map[int32]map[string]bool
+	// the outbox and webhooks, secrets are stored encrypted
+	outboxEvents         map[int64]*memoryOutboxEvent
+	webhookSubscriptions map[int32]*WebhookSubscription
+	webhookDeliveries    map[int64]*WebhookDeliveryRecord
+	webhookAttempts      []*WebhookDeliveryAttempt
+	// data subject requests, national IDs are stored encrypted until the request is decided
+	dataSubjectRequests map[int32]*DataSubjectRequest
+	piiReveals          []*PIIReveal
+	// lastIDs emulates the SERIAL sequence of each table
+	lastIDs map[string]int32
+}
+
+// memoryApiKey is a row of the api_keys table
+type memoryApiKey struct {
+	ApiKeyRecord
+	hash [sha256.Size]byte
+}
+
+// memoryOutboxEvent is a row of the outbox_events table
+type memoryOutboxEvent struct {
+	WebhookEvent
+	dispatched bool
+}
+
+// MemoryProgramsModel is the in-memory ProgramStore
+type MemoryProgramsModel struct{ db *memoryDB }
+
+// MemoryGeoLocationsModel is the in-memory GeoLocationStore
+type MemoryGeoLocationsModel struct{ db *memoryDB }
+
+// MemoryHouseHoldsModel is the in-memory HouseHoldStore
+type MemoryHouseHoldsModel struct{ db *memoryDB }
+
+// MemoryAuthModel is the in-memory AuthStore. Users are added with AddUser().
+type MemoryAuthModel struct{ db *memoryDB }
+
+// MemoryIdempotencyModel is the in-memory IdempotencyStore
+type MemoryIdempotencyModel struct{ db *memoryDB }
+
+// MemoryWebhooksModel is the in-memory WebhookStore. The other in-memory stores write their
+// events to its outbox under the shared lock, which stands in for the transaction.
+type MemoryWebhooksModel struct{ db *memoryDB }
+
+// MemoryDataSubjectsModel is the in-memory DataSubjectStore
+type MemoryDataSubjectsModel struct{ db *memoryDB }
+
+// MemoryPIIRevealsModel is the in-memory PIIRevealStore
+type MemoryPIIRevealsModel struct{ db *memoryDB }
+
+var (
+	_ ProgramStore     = (*MemoryProgramsModel)(nil)
+	_ GeoLocationStore = (*MemoryGeoLocationsModel)(nil)
+	_ HouseHoldStore   = (*MemoryHouseHoldsModel)(nil)
+	_ AuthStore        = (*MemoryAuthModel)(nil)
+	_ IdempotencyStore = (*MemoryIdempotencyModel)(nil)
+	_ WebhookStore     = (*MemoryWebhooksModel)(nil)
+	_ DataSubjectStore = (*MemoryDataSubjectsModel)(nil)
+	_ PIIRevealStore   = (*MemoryPIIRevealsModel)(nil)
+)
+
+// NewMemoryModels() returns Models backed by empty in-memory stores. They mirror the
+// constraints of our schema and return the same sentinel errors as the Postgres models:
+// unique program names and sub locations, the foreign keys, one head per house hold, one
+// enrollment per house hold and program, the national ID type check and the trigger adding
+// each head as a member of its house hold.
+// They are meant for tests and local development, nothing is persisted.
+func NewMemoryModels() Models {
+	db := &memoryDB{
+		programs:         map[int32]*Program{},
+		geoLocations:     map[int32]*GeoLocation{},
+		houseHolds:       map[int32]*HouseHold{},
+		houseHoldHeads:   map[int32]*HouseHoldHead{},
+		houseHoldMembers: map[int32]*HouseHoldMember{},
+		enrollments:      map[int32]*Enrollment{},
+		users:            map[int32]*User{},
+		apiKeys:          map[int32]*memoryApiKey{},
+		idempotencyKeys:  map[int32]*IdempotencyRecord{},
+		userPermissions:  map[int32]map[string]bool{},
+		lastIDs:          map[string]int32{},
+		// webhooks
+		outboxEvents:         map[int64]*memoryOutboxEvent{},
+		webhookSubscriptions: map[int32]*WebhookSubscription{},
+		webhookDeliveries:    map[int64]*WebhookDeliveryRecord{},
+		// data subject requests
+		dataSubjectRequests: map[int32]*DataSubjectRequest{},
+	}
+	return Models{
+		Program:     &MemoryProgramsModel{db: db},
+		GeoLocation: &MemoryGeoLocationsModel{db: db},
+		HouseHold:   &MemoryHouseHoldsModel{db: db},
+		Auth:        &MemoryAuthModel{db: db},
+		Idempotency: &MemoryIdempotencyModel{db: db},
+		Webhook:     &MemoryWebhooksModel{db: db},
+		DataSubject: &MemoryDataSubjectsModel{db: db},
+		PIIReveal:   &MemoryPIIRevealsModel{db: db},
+	}
+}
+
+// nextID() returns the next ID of the table
+func (db *memoryDB) nextID(table string) int32 {
+	db.lastIDs[table]++
+	return db.lastIDs[table]
+}
+
+// memoryTimestamp() returns the current time at the precision of our TIMESTAMP(0) columns
+func memoryTimestamp() time.Time {
+	return time.Now().Truncate(time.Second)
+}
+
+// checkContext() fails like a cancelled or timed out query if ctx has already ended
+func checkContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return translateDBError(ctx, err)
+	}
+	return nil
+}
+
+// GetProgramById() gets a program by its ID
+func (m MemoryProgramsModel) GetProgramById(ctx context.Context, id int32) (*Program, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	program, ok := m.db.programs[id]
+	if !ok {
+		return nil, ErrProgramDoesNotExist
+	}
+	programCopy := *program
+	programCopy.CountyQuotas = maps.Clone(program.CountyQuotas)
+	return &programCopy, nil
+}
+
+func (m MemoryProgramsModel) CreateNewProgram(ctx context.Context, program *Program) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	// programs_name_key
+	if m.db.programNameTaken(program.Name, 0) {
+		return ErrDuplicateProgram
+	}
+	program.ID = m.db.nextID("programs")
+	program.CreatedAt = memoryTimestamp()
+	program.UpdatedAt = program.CreatedAt
+	if err := m.db.insertOutboxEvent(EventProgramCreated, program); err != nil {
+		return err
+	}
+	programCopy := *program
+	programCopy.CountyQuotas = maps.Clone(program.CountyQuotas)
+	m.db.programs[program.ID] = &programCopy
+	return nil
+}
+
+// UpdateProgramById() updates a program by its ID
+func (m MemoryProgramsModel) UpdateProgramById(ctx context.Context, program *Program) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	stored, ok := m.db.programs[program.ID]
+	if !ok {
+		return ErrProgramDoesNotExist
+	}
+	if m.db.programNameTaken(program.Name, program.ID) {
+		return ErrDuplicateProgram
+	}
+	program.UpdatedAt = memoryTimestamp()
+	if err := m.db.insertOutboxEvent(EventProgramUpdated, program); err != nil {
+		return err
+	}
+	stored.Name = program.Name
+	stored.Category = program.Category
+	stored.Description = program.Description
+	stored.EnrollmentOpensAt = program.EnrollmentOpensAt
+	stored.EnrollmentClosesAt = program.EnrollmentClosesAt
+	stored.HouseHoldCap = program.HouseHoldCap
+	stored.CountyQuotas = maps.Clone(program.CountyQuotas)
+	stored.UpdatedAt = program.UpdatedAt
+	return nil
+}
+
+// GetAllPrograms() gets every program ordered by ID, without their county quotas
+func (m MemoryProgramsModel) GetAllPrograms(ctx context.Context) ([]*Program, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	programs := make([]*Program, 0, len(m.db.programs))
+	for _, program := range m.db.programs {
+		programCopy := *program
+		programCopy.CountyQuotas = nil
+		programs = append(programs, &programCopy)
+	}
+	sort.Slice(programs, func(i, j int) bool { return programs[i].ID < programs[j].ID })
+	return programs, nil
+}
+
+// GetProgramCapacity() gets how many more house holds the program can take, overall and per
+// county
+func (m MemoryProgramsModel) GetProgramCapacity(ctx context.Context, id int32) (*ProgramCapacity, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	program, ok := m.db.programs[id]
+	if !ok {
+		return nil, ErrProgramDoesNotExist
+	}
+	return newProgramCapacity(program, m.db.enrolledByCounty(id), time.Now()), nil
+}
+
+// enrolledByCounty() counts the house holds enrolled in the program per county, leaving out
+// those that have exited it
+func (db *memoryDB) enrolledByCounty(programID int32) map[string]int64 {
+	enrolled := map[string]int64{}
+	for _, enrollment := range db.enrollments {
+		if enrollment.ProgramID == programID && enrollment.Status != EnrollmentStatusExited {
+			houseHold := db.houseHolds[enrollment.HouseHoldID]
+			enrolled[db.geoLocations[houseHold.GeoLocationID].County]++
+		}
+	}
+	return enrolled
+}
+
+// enrolledIn() reports whether the house hold is enrolled in the program and has not exited it
+func (db *memoryDB) enrolledIn(houseHoldID, programID int32) bool {
+	for _, enrollment := range db.enrollments {
+		if enrollment.HouseHoldID == houseHoldID && enrollment.ProgramID == programID {
+			return enrollment.Status != EnrollmentStatusExited
+		}
+	}
+	return false
+}
+
+// checkEnrollment() checks that the program is open for enrollment and has room for one more
+// house hold in the county, like checkEnrollment() does in SQL. Holding the lock stands in
+// for locking the program row.
+func (db *memoryDB) checkEnrollment(programID int32, county string) error {
+	program := db.programs[programID]
+	enrolledByCounty := db.enrolledByCounty(program.ID)
+	limits := enrollmentLimits{
+		OpensAt:  program.EnrollmentOpensAt,
+		ClosesAt: program.EnrollmentClosesAt,
+		Cap:      program.HouseHoldCap,
+	}
+	for _, enrolled := range enrolledByCounty {
+		limits.Enrolled += enrolled
+	}
+	if quota, ok := program.CountyQuotas[county]; ok {
+		limits.CountyQuota = &quota
+		limits.CountyEnrolled = enrolledByCounty[county]
+	}
+	return limits.check(time.Now())
+}
+
+// programNameTaken() reports whether a program other than exceptID already has the name
+func (db *memoryDB) programNameTaken(name string, exceptID int32) bool {
+	for _, program := range db.programs {
+		if program.Name == name && program.ID != exceptID {
+			return true
+		}
+	}
+	return false
+}
+
+func (m MemoryGeoLocationsModel) CreateNewGeoLocation(ctx context.Context, geoLocation *GeoLocation) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	// geolocations_sub_location_key
+	for _, stored := range m.db.geoLocations {
+		if stored.SubLocation == geoLocation.SubLocation {
+			return ErrDuplicateGeoLocation
+		}
+	}
+	geoLocation.ID = m.db.nextID("geolocations")
+	geoLocation.CreatedAt = memoryTimestamp()
+	geoLocationCopy := *geoLocation
+	m.db.geoLocations[geoLocation.ID] = &geoLocationCopy
+	return nil
+}
+
+// GetAllGeoLocations() gets every geo location ordered by ID
+func (m MemoryGeoLocationsModel) GetAllGeoLocations(ctx context.Context) ([]*GeoLocation, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	geoLocations := make([]*GeoLocation, 0, len(m.db.geoLocations))
+	for _, geoLocation := range m.db.geoLocations {
+		geoLocationCopy := *geoLocation
+		geoLocations = append(geoLocations, &geoLocationCopy)
+	}
+	sort.Slice(geoLocations, func(i, j int) bool { return geoLocations[i].ID < geoLocations[j].ID })
+	return geoLocations, nil
+}
+
+// GetHouseholdHeadByHouseholdId() retrieves a house hold head by the house hold id
+func (m MemoryHouseHoldsModel) GetHouseholdHeadByHouseholdId(ctx context.Context, houseHoldID int32) (*HouseHoldHead, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	head := m.db.headOf(houseHoldID)
+	if head == nil {
+		return nil, ErrHouseHoldHeadDoesNotExist
+	}
+	headCopy := *head
+	return &headCopy, nil
+}
+
+// GetHouseHoldInformation() retrieves a house hold by the house hold id, along with the
+// related records selected by includes. As with the inner join in SQL, a house hold without
+// a head is reported as not existing.
+func (m MemoryHouseHoldsModel) GetHouseHoldInformation(ctx context.Context, houseHoldID int32, includes HouseHoldIncludes, encryption_key string) (*EnrichedHouseHold, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	houseHold, ok := m.db.houseHolds[houseHoldID]
+	head := m.db.headOf(houseHoldID)
+	if !ok || head == nil {
+		return nil, ErrHouseHoldDoesNotExist
+	}
+	program := m.db.programs[houseHold.ProgramID]
+	geoLocation := m.db.geoLocations[houseHold.GeoLocationID]
+	decodedKey, err := DecodeEncryptionKey(encryption_key)
+	if err != nil {
+		return nil, err
+	}
+	decryptedPhoneNumber, err := decryptPhoneNumber(head.PhoneNumber, decodedKey)
+	if err != nil {
+		return nil, err
+	}
+	// the last change to the house hold, its program, head, members or enrollments
+	updatedAt := houseHold.CreatedAt
+	for _, changedAt := range []time.Time{program.UpdatedAt, head.UpdatedAt} {
+		if changedAt.After(updatedAt) {
+			updatedAt = changedAt
+		}
+	}
+	members := []*HouseHoldMember{}
+	for _, member := range m.db.houseHoldMembers {
+		if member.HouseHoldID == houseHoldID {
+			memberCopy := *member
+			members = append(members, &memberCopy)
+			if member.UpdatedAt.After(updatedAt) {
+				updatedAt = member.UpdatedAt
+			}
+		}
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].ID < members[j].ID })
+	enrollments := []*Enrollment{}
+	for _, enrollment := range m.db.enrollments {
+		if enrollment.HouseHoldID == houseHoldID {
+			enrollmentCopy := *enrollment
+			enrollmentCopy.ProgramName = m.db.programs[enrollment.ProgramID].Name
+			enrollments = append(enrollments, &enrollmentCopy)
+			if enrollment.UpdatedAt.After(updatedAt) {
+				updatedAt = enrollment.UpdatedAt
+			}
+		}
+	}
+	sort.Slice(enrollments, func(i, j int) bool { return enrollments[i].ID < enrollments[j].ID })
+	enrichedHouseHold := &EnrichedHouseHold{
+		HouseHoldID:          houseHold.ID,
+		ProgramID:            program.ID,
+		ProgramName:          program.Name,
+		GeoLocationID:        geoLocation.ID,
+		County:               geoLocation.County,
+		SubCounty:            geoLocation.SubCounty,
+		HouseHoldHeadID:      head.ID,
+		HouseHoldHeadName:    head.Name,
+		PhoneNumber:          decryptedPhoneNumber,
+		HouseHoldMemberCount: int64(len(members)),
+		Enrollments:          enrollments,
+		UpdatedAt:            updatedAt,
+	}
+	if includes.Members {
+		enrichedHouseHold.Members = members
+	}
+	if includes.Head {
+		headCopy := *head
+		headCopy.PhoneNumber = decryptedPhoneNumber
+		enrichedHouseHold.Head = &headCopy
+	}
+	if includes.Program {
+		programCopy := *program
+		// like the SQL store, without the county quotas
+		programCopy.CountyQuotas = nil
+		enrichedHouseHold.Program = &programCopy
+	}
+	if includes.GeoLocation {
+		geoLocationCopy := *geoLocation
+		enrichedHouseHold.GeoLocation = &geoLocationCopy
+	}
+	return enrichedHouseHold, nil
+}
+
+// GetHouseHoldsGeoJSON() retrieves the house holds matching the filter as a GeoJSON
+// FeatureCollection, falling back to the geo location's coordinates and paginated on the
+// ID like the SQL query. Radius searches stop after reading as many house holds within the
+// bounding box as maxGeoJSONScanBatches batches of the SQL store hold.
+func (m MemoryHouseHoldsModel) GetHouseHoldsGeoJSON(ctx context.Context, filter *HouseHoldGeoFilter, page Page) (*GeoJSONFeatureCollection, *Cursor, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, nil, err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	featureCollection := &GeoJSONFeatureCollection{
+		Type:     "FeatureCollection",
+		Features: []GeoJSONFeature{},
+	}
+	isRadiusSearch := filter.Latitude != nil && filter.Longitude != nil && filter.RadiusKm != nil
+	// by default we search the whole country
+	minLatitude, maxLatitude, minLongitude, maxLongitude := KenyaMinLatitude, KenyaMaxLatitude, KenyaMinLongitude, KenyaMaxLongitude
+	if isRadiusSearch {
+		minLatitude, maxLatitude, minLongitude, maxLongitude = boundingBox(*filter.Latitude, *filter.Longitude, *filter.RadiusKm)
+	}
+	// the house holds the SQL store would have read, and the ID of the last of them
+	maxScanned := maxGeoJSONScanBatches * (int(page.Limit) + 1)
+	scanned, lastScannedID := 0, int32(0)
+	for _, houseHold := range m.db.sortedHouseHolds() {
+		// one more than asked for, to tell whether there is a next page
+		if len(featureCollection.Features) > int(page.Limit) || scanned == maxScanned {
+			break
+		}
+		program := m.db.programs[houseHold.ProgramID]
+		geoLocation := m.db.geoLocations[houseHold.GeoLocationID]
+		latitude, longitude := houseHold.Latitude, houseHold.Longitude
+		if latitude == nil {
+			latitude = geoLocation.Latitude
+		}
+		if longitude == nil {
+			longitude = geoLocation.Longitude
+		}
+		switch {
+		case houseHold.ID <= page.AfterID():
+			continue
+		case latitude == nil || longitude == nil:
+			continue
+		case filter.ProgramID != 0 && !m.db.enrolledIn(houseHold.ID, filter.ProgramID):
+			continue
+		case filter.GeoLocationID != 0 && houseHold.GeoLocationID != filter.GeoLocationID:
+			continue
+		case filter.County != "" && geoLocation.County != filter.County:
+			continue
+		case filter.SubCounty != "" && geoLocation.SubCounty != filter.SubCounty:
+			continue
+		case *latitude < minLatitude || *latitude > maxLatitude || *longitude < minLongitude || *longitude > maxLongitude:
+			continue
+		}
+		scanned, lastScannedID = scanned+1, houseHold.ID
+		properties := HouseHoldGeoJSONProperty{
+			HouseHoldID:   houseHold.ID,
+			Name:          houseHold.Name,
+			ProgramID:     program.ID,
+			ProgramName:   program.Name,
+			GeoLocationID: geoLocation.ID,
+			County:        geoLocation.County,
+			SubCounty:     geoLocation.SubCounty,
+		}
+		if isRadiusSearch {
+			distance := haversineDistanceKm(*filter.Latitude, *filter.Longitude, *latitude, *longitude)
+			if distance > *filter.RadiusKm {
+				continue
+			}
+			properties.DistanceKm = &distance
+		}
+		featureCollection.Features = append(featureCollection.Features, GeoJSONFeature{
+			Type: "Feature",
+			Geometry: GeoJSONPoint{
+				Type:        "Point",
+				Coordinates: [2]float64{*longitude, *latitude},
+			},
+			Properties: properties,
+		})
+	}
+	if len(featureCollection.Features) <= int(page.Limit) && scanned == maxScanned {
+		// the next page picks up after the last house hold we read
+		return featureCollection, &Cursor{ID: lastScannedID}, nil
+	}
+	var next *Cursor
+	featureCollection.Features, next = paginate(featureCollection.Features, page, geoJSONFeatureCursor)
+	return featureCollection, next, nil
+}
+
+// CreateNewHouseHold() creates a new house hold, checking that its program and geo location exist
+func (m MemoryHouseHoldsModel) CreateNewHouseHold(ctx context.Context, houseHold *HouseHold) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	// households_program_id_fkey and households_geolocation_id_fkey
+	if _, ok := m.db.programs[houseHold.ProgramID]; !ok {
+		return ErrProgramDoesNotExist
+	}
+	if _, ok := m.db.geoLocations[houseHold.GeoLocationID]; !ok {
+		return ErrGeoLocationDoesNotExist
+	}
+	if err := m.db.checkEnrollment(houseHold.ProgramID, m.db.geoLocations[houseHold.GeoLocationID].County); err != nil {
+		return err
+	}
+	houseHold.ID = m.db.nextID("households")
+	houseHold.CreatedAt = memoryTimestamp()
+	if err := m.db.insertOutboxEvent(EventHouseHoldCreated, houseHold); err != nil {
+		return err
+	}
+	houseHoldCopy := *houseHold
+	m.db.houseHolds[houseHold.ID] = &houseHoldCopy
+	// the program it is registered under is its first enrollment
+	m.db.insertEnrollment(&Enrollment{HouseHoldID: houseHold.ID, ProgramID: houseHold.ProgramID})
+	return nil
+}
+
+// EnrollHouseHold() enrolls an existing house hold in another program, checking the
+// program's enrollment window, cap and county quota like the SQL store
+func (m MemoryHouseHoldsModel) EnrollHouseHold(ctx context.Context, enrollment *Enrollment) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	// household_enrollments_household_id_fkey and household_enrollments_program_id_fkey
+	houseHold, ok := m.db.houseHolds[enrollment.HouseHoldID]
+	if !ok {
+		return ErrHouseHoldDoesNotExist
+	}
+	if _, ok := m.db.programs[enrollment.ProgramID]; !ok {
+		return ErrProgramDoesNotExist
+	}
+	if err := m.db.checkEnrollment(enrollment.ProgramID, m.db.geoLocations[houseHold.GeoLocationID].County); err != nil {
+		return err
+	}
+	// household_enrollments_household_id_program_id_key
+	for _, stored := range m.db.enrollments {
+		if stored.HouseHoldID == enrollment.HouseHoldID && stored.ProgramID == enrollment.ProgramID {
+			return ErrHouseHoldAlreadyEnrolled
+		}
+	}
+	m.db.insertEnrollment(enrollment)
+	// roll the enrollment back if its event can't be written
+	if err := m.db.insertOutboxEvent(EventHouseHoldEnrolled, enrollment); err != nil {
+		delete(m.db.enrollments, enrollment.ID)
+		return err
+	}
+	return nil
+}
+
+// UpdateEnrollmentStatus() changes the status of the house hold's enrollment, which can no
+// longer change once exited
+func (m MemoryHouseHoldsModel) UpdateEnrollmentStatus(ctx context.Context, enrollment *Enrollment) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	stored, ok := m.db.enrollments[enrollment.ID]
+	if !ok || stored.HouseHoldID != enrollment.HouseHoldID {
+		return ErrEnrollmentDoesNotExist
+	}
+	if stored.Status == EnrollmentStatusExited {
+		return ErrEnrollmentExited
+	}
+	updated := *stored
+	updated.Status = enrollment.Status
+	updated.ExitReason = enrollment.ExitReason
+	updated.ExitedAt = nil
+	updated.UpdatedAt = memoryTimestamp()
+	if enrollment.Status == EnrollmentStatusExited {
+		exitedAt := updated.UpdatedAt
+		updated.ExitedAt = &exitedAt
+	}
+	*enrollment = updated
+	if err := m.db.insertOutboxEvent(EventHouseHoldEnrollmentUpdated, enrollment); err != nil {
+		return err
+	}
+	m.db.enrollments[enrollment.ID] = &updated
+	return nil
+}
+
+// CreateNewHouseholdHead() creates a new house hold head with an encrypted phone number and,
+// like the add_household_head_as_member trigger, adds the head as a member of the house hold.
+// If the member can't be added the head isn't created either.
+func (m MemoryHouseHoldsModel) CreateNewHouseholdHead(ctx context.Context, houseHoldHead *HouseHoldHead, encryption_key string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	decodedKey, err := DecodeEncryptionKey(encryption_key)
+	if err != nil {
+		return err
+	}
+	encryptedPhoneNumber, err := EncryptData(houseHoldHead.PhoneNumber, decodedKey)
+	if err != nil {
+		return err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	// household_heads_national_id_type_check
+	if !validator.PermittedValue(houseHoldHead.NationalIDType, validator.IDTypes...) {
+		return fmt.Errorf("%w: %s", ErrCheckViolation, "household_heads_national_id_type_check")
+	}
+	// household_heads_household_id_fkey and household_heads_household_id_key
+	if _, ok := m.db.houseHolds[houseHoldHead.HouseHoldID]; !ok {
+		return ErrHouseHoldDoesNotExist
+	}
+	if m.db.headOf(houseHoldHead.HouseHoldID) != nil {
+		return ErrHouseHoldAlreadyExists
+	}
+	// the trigger's insert, which fails the whole statement on unique_household_member
+	if m.db.memberNameTaken(houseHoldHead.HouseHoldID, houseHoldHead.Name) {
+		return ErrHouseHoldMemberExists
+	}
+	houseHoldHead.ID = m.db.nextID("household_heads")
+	houseHoldHead.CreatedAt = memoryTimestamp()
+	houseHoldHead.UpdatedAt = houseHoldHead.CreatedAt
+	if err := m.db.insertOutboxEvent(EventHouseHoldHeadAssigned, newHouseHoldHeadEvent(houseHoldHead)); err != nil {
+		return err
+	}
+	headCopy := *houseHoldHead
+	headCopy.PhoneNumber = encryptedPhoneNumber
+	m.db.houseHoldHeads[houseHoldHead.ID] = &headCopy
+	m.db.insertMember(&HouseHoldMember{
+		HouseHoldID: houseHoldHead.HouseHoldID,
+		Name:        houseHoldHead.Name,
+		Age:         houseHoldHead.Age,
+		Relation:    "Head",
+	})
+	return nil
+}
+
+// CreateNewHouseholdMember() creates a new house hold member
+func (m MemoryHouseHoldsModel) CreateNewHouseholdMember(ctx context.Context, houseHoldMember *HouseHoldMember) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	// household_members_household_id_fkey and unique_household_member
+	if _, ok := m.db.houseHolds[houseHoldMember.HouseHoldID]; !ok {
+		return ErrHouseHoldDoesNotExist
+	}
+	if m.db.memberNameTaken(houseHoldMember.HouseHoldID, houseHoldMember.Name) {
+		return ErrHouseHoldMemberExists
+	}
+	m.db.insertMember(houseHoldMember)
+	// roll the member back if its event can't be written
+	if err := m.db.insertOutboxEvent(EventHouseHoldMemberAdded, houseHoldMember); err != nil {
+		delete(m.db.houseHoldMembers, houseHoldMember.ID)
+		return err
+	}
+	return nil
+}
+
+// NormalizeHouseholdHeadPhoneNumbers() rewrites every stored phone number to E.164, see
+// HouseHoldsManagerModel.NormalizeHouseholdHeadPhoneNumbers()
+func (m MemoryHouseHoldsModel) NormalizeHouseholdHeadPhoneNumbers(ctx context.Context, encryption_key string, normalizer *validator.PhoneNumberNormalizer) (*PhoneNumberMigrationResult, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	decodedKey, err := DecodeEncryptionKey(encryption_key)
+	if err != nil {
+		return nil, err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	result := &PhoneNumberMigrationResult{Invalid: []int32{}}
+	for _, id := range m.db.sortedHeadIDs() {
+		head := m.db.houseHoldHeads[id]
+		// erased heads have no phone number
+		if head.ErasedAt != nil {
+			continue
+		}
+		result.Scanned++
+		phoneNumber, err := DecryptData(head.PhoneNumber, decodedKey)
+		if err != nil {
+			return nil, fmt.Errorf("household head %d: %w", id, err)
+		}
+		normalizedPhoneNumber, err := normalizer.Normalize(phoneNumber)
+		if err != nil {
+			result.Invalid = append(result.Invalid, id)
+			continue
+		}
+		if normalizedPhoneNumber == phoneNumber {
+			result.Unchanged++
+			continue
+		}
+		encryptedPhoneNumber, err := EncryptData(normalizedPhoneNumber, decodedKey)
+		if err != nil {
+			return nil, err
+		}
+		head.PhoneNumber = encryptedPhoneNumber
+		head.UpdatedAt = memoryTimestamp()
+		result.Updated++
+	}
+	return result, nil
+}
+
+// CheckHouseholdHeadPhoneNumbers() decrypts every stored phone number, see
+// HouseHoldsManagerModel.CheckHouseholdHeadPhoneNumbers()
+func (m MemoryHouseHoldsModel) CheckHouseholdHeadPhoneNumbers(ctx context.Context, encryption_key string) (*PhoneNumberCheckResult, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	decodedKey, err := DecodeEncryptionKey(encryption_key)
+	if err != nil {
+		return nil, err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	result := &PhoneNumberCheckResult{Undecryptable: []int32{}}
+	for _, id := range m.db.sortedHeadIDs() {
+		head := m.db.houseHoldHeads[id]
+		// erased heads have no phone number
+		if head.ErasedAt != nil {
+			continue
+		}
+		result.Scanned++
+		if _, err := DecryptData(head.PhoneNumber, decodedKey); err != nil {
+			result.Undecryptable = append(result.Undecryptable, id)
+		}
+	}
+	return result, nil
+}
+
+// sortedHeadIDs() returns the IDs of the house hold heads in order
+func (db *memoryDB) sortedHeadIDs() []int32 {
+	ids := make([]int32, 0, len(db.houseHoldHeads))
+	for id := range db.houseHoldHeads {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// headOf() returns the head of the house hold, or nil if it has none
+func (db *memoryDB) headOf(houseHoldID int32) *HouseHoldHead {
+	for _, head := range db.houseHoldHeads {
+		if head.HouseHoldID == houseHoldID {
+			return head
+		}
+	}
+	return nil
+}
+
+// memberNameTaken() reports whether the house hold already has a member with the name
+func (db *memoryDB) memberNameTaken(houseHoldID int32, name string) bool {
+	for _, member := range db.houseHoldMembers {
+		if member.HouseHoldID == houseHoldID && member.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// insertEnrollment() stores a copy of the enrollment as active, setting its ID and timestamps
+func (db *memoryDB) insertEnrollment(enrollment *Enrollment) {
+	enrollment.ID = db.nextID("household_enrollments")
+	enrollment.Status = EnrollmentStatusActive
+	enrollment.EnrolledAt = memoryTimestamp()
+	enrollment.CreatedAt = enrollment.EnrolledAt
+	enrollment.UpdatedAt = enrollment.EnrolledAt
+	enrollmentCopy := *enrollment
+	db.enrollments[enrollment.ID] = &enrollmentCopy
+}
+
+// insertMember() stores a copy of the member, setting its ID and timestamps
+func (db *memoryDB) insertMember(member *HouseHoldMember) {
+	member.ID = db.nextID("household_members")
+	member.CreatedAt = memoryTimestamp()
+	member.UpdatedAt = member.CreatedAt
+	memberCopy := *member
+	db.houseHoldMembers[member.ID] = &memberCopy
+}
+
+// sortedHouseHolds() returns the house holds ordered by ID
+func (db *memoryDB) sortedHouseHolds() []*HouseHold {
+	houseHolds := make([]*HouseHold, 0, len(db.houseHolds))
+	for _, houseHold := range db.houseHolds {
+		houseHolds = append(houseHolds, houseHold)
+	}
+	sort.Slice(houseHolds, func(i, j int) bool { return houseHolds[i].ID < houseHolds[j].ID })
+	return houseHolds
+}
+
+// GetForApiKey() returns the user for a given plaintext api key, ignoring revoked keys and
+// disabled users
+func (m MemoryAuthModel) GetForApiKey(ctx context.Context, apiKey string) (*User, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	hash := sha256.Sum256([]byte(apiKey))
+	for _, key := range m.db.apiKeys {
+		if key.hash != hash || key.RevokedAt != nil {
+			continue
+		}
+		user := m.db.users[key.UserID]
+		if user.DisabledAt != nil {
+			break
+		}
+		userCopy := *user
+		userCopy.ApiKey = Apikey{ID: key.ID, Plaintext: apiKey, Hash: hash[:]}
+		return &userCopy, nil
+	}
+	return nil, ErrUserNotFound
+}
+
+// GenerateToken() generates a new random API key along with its hash
+func (m MemoryAuthModel) GenerateToken() (*Apikey, error) {
+	return newApikey()
+}
+
+// CreateUser() creates a new user with a unique, case insensitive email like the CITEXT column
+func (m MemoryAuthModel) CreateUser(ctx context.Context, user *User) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	// users_email_key
+	if m.db.userByEmail(user.Email) != nil {
+		return ErrDuplicateEmail
+	}
+	m.db.insertUser(user)
+	return nil
+}
+
+// GetUserByEmail() gets a user, including disabled ones, by their email address
+func (m MemoryAuthModel) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	user := m.db.userByEmail(email)
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+	userCopy := *user
+	return &userCopy, nil
+}
+
+// DisableUser() disables the user, keeping the time of the first call
+func (m MemoryAuthModel) DisableUser(ctx context.Context, email string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	user := m.db.userByEmail(email)
+	if user == nil {
+		return ErrUserNotFound
+	}
+	now := memoryTimestamp()
+	if user.DisabledAt == nil {
+		user.DisabledAt = &now
+	}
+	user.UpdatedAt = now
+	return nil
+}
+
+// IssueApiKey() generates and stores a new API key for the user
+func (m MemoryAuthModel) IssueApiKey(ctx context.Context, userID int32) (*Apikey, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	apiKey, err := newApikey()
+	if err != nil {
+		return nil, err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	// api_keys_user_id_fkey
+	if _, ok := m.db.users[userID]; !ok {
+		return nil, ErrUserNotFound
+	}
+	apiKey.ID = m.db.insertApiKey(userID, apiKey.Plaintext)
+	return apiKey, nil
+}
+
+// GetApiKeysForUser() lists the user's API keys, including revoked ones, ordered by ID
+func (m MemoryAuthModel) GetApiKeysForUser(ctx context.Context, userID int32) ([]*ApiKeyRecord, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	records := []*ApiKeyRecord{}
+	for _, key := range m.db.apiKeys {
+		if key.UserID == userID {
+			record := key.ApiKeyRecord
+			records = append(records, &record)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+	return records, nil
+}
+
+// RevokeApiKey() revokes an active API key by its ID
+func (m MemoryAuthModel) RevokeApiKey(ctx context.Context, id int32) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	key, ok := m.db.apiKeys[id]
+	if !ok || key.RevokedAt != nil {
+		return ErrApiKeyNotFound
+	}
+	now := memoryTimestamp()
+	key.RevokedAt = &now
+	return nil
+}
+
+// GetAllPermissionsForUser() returns the permissions granted to the user, ordered by code
+func (m MemoryAuthModel) GetAllPermissionsForUser(ctx context.Context, userID int32) (Permissions, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	permissions := Permissions{}
+	for code := range m.db.userPermissions[userID] {
+		permissions = append(permissions, code)
+	}
+	sort.Strings(permissions)
+	return permissions, nil
+}
+
+// AddPermissionForUser() grants the permission to the user. Like the query, codes missing
+// from the permissions table are ignored.
+func (m MemoryAuthModel) AddPermissionForUser(ctx context.Context, userID int32, code string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	if !validator.PermittedValue(code, KnownPermissions...) {
+		return nil
+	}
+	// users_permissions_user_id_fkey
+	if _, ok := m.db.users[userID]; !ok {
+		return ErrUserNotFound
+	}
+	if m.db.userPermissions[userID] == nil {
+		m.db.userPermissions[userID] = map[string]bool{}
+	}
+	m.db.userPermissions[userID][code] = true
+	return nil
+}
+
+// RemovePermissionForUser() takes the permission away from the user
+func (m MemoryAuthModel) RemovePermissionForUser(ctx context.Context, userID int32, code string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	delete(m.db.userPermissions[userID], code)
+	return nil
+}
+
+// AddUser() registers a user with the plaintext API key, taking the place of the seed data in
+// the users migration. The user's ID and timestamps are set.
+func (m MemoryAuthModel) AddUser(user *User, apiKey string) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	m.db.insertUser(user)
+	m.db.insertApiKey(user.ID, apiKey)
+}
+
+// userByEmail() returns the user with the email, compared case insensitively, or nil
+func (db *memoryDB) userByEmail(email string) *User {
+	for _, user := range db.users {
+		if strings.EqualFold(user.Email, email) {
+			return user
+		}
+	}
+	return nil
+}
+
+// insertUser() stores a copy of the user, setting its ID and timestamps
+func (db *memoryDB) insertUser(user *User) {
+	user.ID = db.nextID("users")
+	user.CreatedAt = memoryTimestamp()
+	user.UpdatedAt = user.CreatedAt
+	userCopy := *user
+	db.users[user.ID] = &userCopy
+}
+
+// insertApiKey() stores the hash of the plaintext API key for the user and returns its ID
+func (db *memoryDB) insertApiKey(userID int32, apiKey string) int32 {
+	key := &memoryApiKey{
+		ApiKeyRecord: ApiKeyRecord{
+			ID:        db.nextID("api_keys"),
+			UserID:    userID,
+			CreatedAt: memoryTimestamp(),
+		},
+		hash: sha256.Sum256([]byte(apiKey)),
+	}
+	db.apiKeys[key.ID] = key
+	return key.ID
+}
+
+// ReserveIdempotencyKey() reserves the user's key unless it has a live record, see
+// IdempotencyManagerModel.ReserveIdempotencyKey()
+func (m MemoryIdempotencyModel) ReserveIdempotencyKey(ctx context.Context, record *IdempotencyRecord) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	// idempotency_keys_user_id_idempotency_key_key, taking over an expired or abandoned
+	// record under a new ID
+	if stored := m.db.idempotencyRecord(record.UserID, record.Key); stored != nil {
+		if stored.ExpiresAt.After(time.Now()) && !stored.Abandoned() {
+			return ErrIdempotencyKeyExists
+		}
+		delete(m.db.idempotencyKeys, stored.ID)
+	}
+	record.ID = m.db.nextID("idempotency_keys")
+	record.CreatedAt = memoryTimestamp()
+	recordCopy := *record
+	m.db.idempotencyKeys[record.ID] = &recordCopy
+	return nil
+}
+
+// GetIdempotencyKey() gets the user's live record for the key
+func (m MemoryIdempotencyModel) GetIdempotencyKey(ctx context.Context, userID int32, key string) (*IdempotencyRecord, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	stored := m.db.idempotencyRecord(userID, key)
+	if stored == nil || !stored.ExpiresAt.After(time.Now()) {
+		return nil, ErrIdempotencyKeyNotFound
+	}
+	recordCopy := *stored
+	return &recordCopy, nil
+}
+
+// CompleteIdempotencyKey() stores the response of a reserved key
+func (m MemoryIdempotencyModel) CompleteIdempotencyKey(ctx context.Context, record *IdempotencyRecord) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	if stored, ok := m.db.idempotencyKeys[record.ID]; ok {
+		stored.StatusCode = record.StatusCode
+		stored.ContentType = record.ContentType
+		stored.ResponseBody = append([]byte(nil), record.ResponseBody...)
+		stored.LockedUntil = nil
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey() deletes a reserved key
+func (m MemoryIdempotencyModel) ReleaseIdempotencyKey(ctx context.Context, id int32) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	delete(m.db.idempotencyKeys, id)
+	return nil
+}
+
+// DeleteExpiredIdempotencyKeys() purges the expired records
+func (m MemoryIdempotencyModel) DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	if err := checkContext(ctx); err != nil {
+		return 0, err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	deleted := int64(0)
+	now := time.Now()
+	for id, record := range m.db.idempotencyKeys {
+		if !record.ExpiresAt.After(now) {
+			delete(m.db.idempotencyKeys, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// idempotencyRecord() returns the user's record for the key, expired or not, or nil
+func (db *memoryDB) idempotencyRecord(userID int32, key string) *IdempotencyRecord {
+	for _, record := range db.idempotencyKeys {
+		if record.UserID == userID && record.Key == key {
+			return record
+		}
+	}
+	return nil
+}
+
+// insertOutboxEvent() writes an event to the outbox, see createOutboxEvent(). The caller holds
+// the lock and makes its change only if this succeeds.
+func (db *memoryDB) insertOutboxEvent(eventType string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	event := &memoryOutboxEvent{
+		WebhookEvent: WebhookEvent{
+			ID:        int64(db.nextID("outbox_events")),
+			Type:      eventType,
+			CreatedAt: memoryTimestamp(),
+			Data:      payload,
+		},
+	}
+	db.outboxEvents[event.ID] = event
+	return nil
+}
+
+// CreateWebhookSubscription() creates a subscription with an encrypted secret
+func (m MemoryWebhooksModel) CreateWebhookSubscription(ctx context.Context, subscription *WebhookSubscription, encryption_key string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	decodedKey, err := DecodeEncryptionKey(encryption_key)
+	if err != nil {
+		return err
+	}
+	encryptedSecret, err := EncryptData(subscription.Secret, decodedKey)
+	if err != nil {
+		return err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	if subscription.EventTypes == nil {
+		subscription.EventTypes = []string{}
+	}
+	subscription.ID = m.db.nextID("webhook_subscriptions")
+	subscription.Active = true
+	subscription.CreatedAt = memoryTimestamp()
+	subscription.UpdatedAt = subscription.CreatedAt
+	subscriptionCopy := *subscription
+	subscriptionCopy.Secret = encryptedSecret
+	subscriptionCopy.EventTypes = append([]string{}, subscription.EventTypes...)
+	m.db.webhookSubscriptions[subscription.ID] = &subscriptionCopy
+	return nil
+}
+
+// GetAllWebhookSubscriptions() gets every subscription ordered by ID, without the secrets
+func (m MemoryWebhooksModel) GetAllWebhookSubscriptions(ctx context.Context) ([]*WebhookSubscription, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	subscriptions := make([]*WebhookSubscription, 0, len(m.db.webhookSubscriptions))
+	for _, subscription := range m.db.webhookSubscriptions {
+		subscriptionCopy := *subscription
+		subscriptionCopy.Secret = ""
+		subscriptionCopy.EventTypes = append([]string{}, subscription.EventTypes...)
+		subscriptions = append(subscriptions, &subscriptionCopy)
+	}
+	sort.Slice(subscriptions, func(i, j int) bool { return subscriptions[i].ID < subscriptions[j].ID })
+	return subscriptions, nil
+}
+
+// DisableWebhookSubscription() disables an active subscription, marking its pending
+// deliveries dead
+func (m MemoryWebhooksModel) DisableWebhookSubscription(ctx context.Context, id int32) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	subscription, ok := m.db.webhookSubscriptions[id]
+	if !ok || !subscription.Active {
+		return ErrWebhookSubscriptionNotFound
+	}
+	now := memoryTimestamp()
+	subscription.Active = false
+	subscription.UpdatedAt = now
+	for _, delivery := range m.db.webhookDeliveries {
+		if delivery.SubscriptionID == id && delivery.Status == WebhookDeliveryPending {
+			delivery.Status = WebhookDeliveryDead
+			delivery.LastError = "subscription disabled"
+			delivery.UpdatedAt = now
+		}
+	}
+	return nil
+}
+
+// FanOutOutboxEvents() creates the deliveries of up to limit undispatched events, oldest first
+func (m MemoryWebhooksModel) FanOutOutboxEvents(ctx context.Context, limit int32) (int, error) {
+	if err := checkContext(ctx); err != nil {
+		return 0, err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	events := []*memoryOutboxEvent{}
+	for _, event := range m.db.outboxEvents {
+		if !event.dispatched {
+			events = append(events, event)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].ID < events[j].ID })
+	if len(events) > int(limit) {
+		events = events[:limit]
+	}
+	now := memoryTimestamp()
+	for _, event := range events {
+		for _, subscription := range m.db.sortedWebhookSubscriptions() {
+			wanted := len(subscription.EventTypes) == 0 || validator.PermittedValue(event.Type, subscription.EventTypes...)
+			if !subscription.Active || !wanted {
+				continue
+			}
+			delivery := &WebhookDeliveryRecord{
+				ID:             int64(m.db.nextID("webhook_deliveries")),
+				SubscriptionID: subscription.ID,
+				EventID:        event.ID,
+				EventType:      event.Type,
+				Status:         WebhookDeliveryPending,
+				NextAttemptAt:  now,
+				CreatedAt:      now,
+				UpdatedAt:      now,
+			}
+			m.db.webhookDeliveries[delivery.ID] = delivery
+		}
+		event.dispatched = true
+	}
+	return len(events), nil
+}
+
+// ClaimDueWebhookDeliveries() leases up to limit due pending deliveries until leaseUntil
+func (m MemoryWebhooksModel) ClaimDueWebhookDeliveries(ctx context.Context, limit int32, leaseUntil time.Time, encryption_key string) ([]*WebhookDelivery, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	decodedKey, err := DecodeEncryptionKey(encryption_key)
+	if err != nil {
+		return nil, err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	now := time.Now()
+	due := []*WebhookDeliveryRecord{}
+	for _, delivery := range m.db.webhookDeliveries {
+		if delivery.Status == WebhookDeliveryPending && !delivery.NextAttemptAt.After(now) {
+			due = append(due, delivery)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].NextAttemptAt.Before(due[j].NextAttemptAt) })
+	if len(due) > int(limit) {
+		due = due[:limit]
+	}
+	deliveries := make([]*WebhookDelivery, 0, len(due))
+	for _, delivery := range due {
+		subscription := m.db.webhookSubscriptions[delivery.SubscriptionID]
+		secret, err := DecryptData(subscription.Secret, decodedKey)
+		if err != nil {
+			return nil, fmt.Errorf("webhook subscription %d: %w", subscription.ID, err)
+		}
+		event := m.db.outboxEvents[delivery.EventID].WebhookEvent
+		deliveries = append(deliveries, &WebhookDelivery{
+			ID:             delivery.ID,
+			SubscriptionID: subscription.ID,
+			URL:            subscription.URL,
+			Secret:         secret,
+			Attempts:       delivery.Attempts,
+			Event:          event,
+		})
+	}
+	// only lease them once we know the whole batch can be returned
+	for _, delivery := range due {
+		delivery.NextAttemptAt = leaseUntil
+		delivery.UpdatedAt = memoryTimestamp()
+	}
+	return deliveries, nil
+}
+
+// RecordWebhookDeliveryAttempt() logs the attempt and updates its delivery
+func (m MemoryWebhooksModel) RecordWebhookDeliveryAttempt(ctx context.Context, attempt *WebhookDeliveryAttempt, status string, nextAttemptAt time.Time) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	// webhook_deliveries_status_check
+	if !validator.PermittedValue(status, WebhookDeliveryPending, WebhookDeliveryDelivered, WebhookDeliveryDead) {
+		return fmt.Errorf("%w: %s", ErrCheckViolation, "webhook_deliveries_status_check")
+	}
+	// webhook_delivery_attempts_delivery_id_fkey
+	delivery, ok := m.db.webhookDeliveries[attempt.DeliveryID]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrForeignKeyViolation, "webhook_delivery_attempts_delivery_id_fkey")
+	}
+	now := memoryTimestamp()
+	delivery.Status = status
+	delivery.Attempts = attempt.Attempt
+	delivery.NextAttemptAt = nextAttemptAt
+	delivery.LastStatusCode = attempt.StatusCode
+	delivery.LastError = attempt.Error
+	delivery.UpdatedAt = now
+	attempt.ID = int64(m.db.nextID("webhook_delivery_attempts"))
+	attempt.AttemptedAt = now
+	attemptCopy := *attempt
+	// the duration is stored in whole milliseconds
+	attemptCopy.Duration = attempt.Duration.Truncate(time.Millisecond)
+	m.db.webhookAttempts = append(m.db.webhookAttempts, &attemptCopy)
+	return nil
+}
+
+// GetWebhookDeliveries() lists up to limit of the subscription's most recent deliveries
+func (m MemoryWebhooksModel) GetWebhookDeliveries(ctx context.Context, subscriptionID int32, status string, limit int32) ([]*WebhookDeliveryRecord, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	deliveries := []*WebhookDeliveryRecord{}
+	for _, delivery := range m.db.webhookDeliveries {
+		if delivery.SubscriptionID == subscriptionID && (status == "" || delivery.Status == status) {
+			deliveryCopy := *delivery
+			deliveries = append(deliveries, &deliveryCopy)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].ID > deliveries[j].ID })
+	if len(deliveries) > int(limit) {
+		deliveries = deliveries[:limit]
+	}
+	return deliveries, nil
+}
+
+// GetWebhookDeliveryAttempts() gets the delivery log of a delivery, oldest attempt first
+func (m MemoryWebhooksModel) GetWebhookDeliveryAttempts(ctx context.Context, deliveryID int64) ([]*WebhookDeliveryAttempt, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	attempts := []*WebhookDeliveryAttempt{}
+	for _, attempt := range m.db.webhookAttempts {
+		if attempt.DeliveryID == deliveryID {
+			attemptCopy := *attempt
+			attempts = append(attempts, &attemptCopy)
+		}
+	}
+	return attempts, nil
+}
+
+// RetryWebhookDelivery() requeues a dead delivery of an active subscription
+func (m MemoryWebhooksModel) RetryWebhookDelivery(ctx context.Context, id int64) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	delivery, ok := m.db.webhookDeliveries[id]
+	if !ok || delivery.Status != WebhookDeliveryDead || !m.db.webhookSubscriptions[delivery.SubscriptionID].Active {
+		return ErrWebhookDeliveryNotRetryable
+	}
+	now := memoryTimestamp()
+	delivery.Status = WebhookDeliveryPending
+	delivery.Attempts = 0
+	delivery.NextAttemptAt = now
+	delivery.UpdatedAt = now
+	return nil
+}
+
+// sortedWebhookSubscriptions() returns the subscriptions ordered by ID
+func (db *memoryDB) sortedWebhookSubscriptions() []*WebhookSubscription {
+	subscriptions := make([]*WebhookSubscription, 0, len(db.webhookSubscriptions))
+	for _, subscription := range db.webhookSubscriptions {
+		subscriptions = append(subscriptions, subscription)
+	}
+	sort.Slice(subscriptions, func(i, j int) bool { return subscriptions[i].ID < subscriptions[j].ID })
+	return subscriptions
+}
+
+// CreateDataSubjectRequest() records a pending request with an encrypted national ID, see
+// DataSubjectsManagerModel.CreateDataSubjectRequest()
+func (m MemoryDataSubjectsModel) CreateDataSubjectRequest(ctx context.Context, request *DataSubjectRequest, encryption_key string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	decodedKey, err := DecodeEncryptionKey(encryption_key)
+	if err != nil {
+		return err
+	}
+	encryptedNationalID, err := EncryptData(request.NationalID, decodedKey)
+	if err != nil {
+		return err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	// data_subject_requests_kind_check and data_subject_requests_requested_by_fkey
+	if !validator.PermittedValue(request.Kind, DataSubjectExportRequest, DataSubjectErasureRequest) {
+		return fmt.Errorf("%w: %s", ErrCheckViolation, "data_subject_requests_kind_check")
+	}
+	if _, ok := m.db.users[request.RequestedBy]; !ok {
+		return ErrUserNotFound
+	}
+	heads := m.db.dataSubjectHeads(request.NationalID)
+	request.HeadsFound = int32(len(heads))
+	request.MembersFound = int32(len(m.db.dataSubjectMembers(heads)))
+	request.ID = m.db.nextID("data_subject_requests")
+	request.Status = DataSubjectRequestPending
+	request.CreatedAt = memoryTimestamp()
+	request.UpdatedAt = request.CreatedAt
+	requestCopy := *request
+	requestCopy.NationalID = encryptedNationalID
+	m.db.dataSubjectRequests[request.ID] = &requestCopy
+	return nil
+}
+
+// GetAllDataSubjectRequests() lists the requests newest first, without the national IDs
+func (m MemoryDataSubjectsModel) GetAllDataSubjectRequests(ctx context.Context, status string) ([]*DataSubjectRequest, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	requests := []*DataSubjectRequest{}
+	for _, request := range m.db.dataSubjectRequests {
+		if status != "" && request.Status != status {
+			continue
+		}
+		requestCopy := *request
+		requestCopy.NationalID = ""
+		requestCopy.RequestedByEmail = m.db.users[request.RequestedBy].Email
+		if decider, ok := m.db.users[request.DecidedBy]; ok {
+			requestCopy.DecidedByEmail = decider.Email
+		}
+		requests = append(requests, &requestCopy)
+	}
+	sort.Slice(requests, func(i, j int) bool { return requests[i].ID > requests[j].ID })
+	return requests, nil
+}
+
+// ApproveDataSubjectRequest() approves and runs a pending request, see
+// DataSubjectsManagerModel.ApproveDataSubjectRequest()
+func (m MemoryDataSubjectsModel) ApproveDataSubjectRequest(ctx context.Context, id int32, approverID int32, encryption_key string) (*DataSubjectRequest, *DataSubjectExport, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, nil, err
+	}
+	decodedKey, err := DecodeEncryptionKey(encryption_key)
+	if err != nil {
+		return nil, nil, err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	request, err := m.db.pendingDataSubjectRequest(id, approverID)
+	if err != nil {
+		return nil, nil, err
+	}
+	nationalID, err := DecryptData(request.NationalID, decodedKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	heads := m.db.dataSubjectHeads(nationalID)
+	members := m.db.dataSubjectMembers(heads)
+	var export *DataSubjectExport
+	switch request.Kind {
+	case DataSubjectExportRequest:
+		export, err = m.db.exportDataSubject(nationalID, heads, members, decodedKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		export.RequestID = request.ID
+	case DataSubjectErasureRequest:
+		if err := m.db.eraseDataSubject(heads, members); err != nil {
+			return nil, nil, err
+		}
+	}
+	now := memoryTimestamp()
+	request.Status = DataSubjectRequestCompleted
+	request.NationalID = ""
+	request.DecidedBy = approverID
+	request.DecidedAt = &now
+	request.HeadsFound = int32(len(heads))
+	request.MembersFound = int32(len(members))
+	request.UpdatedAt = now
+	requestCopy := *request
+	return &requestCopy, export, nil
+}
+
+// RejectDataSubjectRequest() rejects a pending request, clearing its national ID
+func (m MemoryDataSubjectsModel) RejectDataSubjectRequest(ctx context.Context, id int32, deciderID int32) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	request, err := m.db.pendingDataSubjectRequest(id, deciderID)
+	if err != nil {
+		return err
+	}
+	now := memoryTimestamp()
+	request.Status = DataSubjectRequestRejected
+	request.NationalID = ""
+	request.DecidedBy = deciderID
+	request.DecidedAt = &now
+	request.UpdatedAt = now
+	return nil
+}
+
+// pendingDataSubjectRequest() returns the pending request the user may decide, applying the
+// data_subject_requests_decided_by_fkey and data_subject_requests_decided_by_check constraints
+func (db *memoryDB) pendingDataSubjectRequest(id int32, deciderID int32) (*DataSubjectRequest, error) {
+	request, ok := db.dataSubjectRequests[id]
+	if !ok || request.Status != DataSubjectRequestPending {
+		return nil, ErrDataSubjectRequestNotFound
+	}
+	if request.RequestedBy == deciderID {
+		return nil, ErrDataSubjectRequestSelfDecision
+	}
+	if _, ok := db.users[deciderID]; !ok {
+		return nil, ErrUserNotFound
+	}
+	return request, nil
+}
+
+// dataSubjectHeads() returns the heads with the national ID that have not been erased,
+// ordered by ID
+func (db *memoryDB) dataSubjectHeads(nationalID string) []*HouseHoldHead {
+	heads := []*HouseHoldHead{}
+	for _, id := range db.sortedHeadIDs() {
+		head := db.houseHoldHeads[id]
+		if head.NationalID == nationalID && head.ErasedAt == nil {
+			heads = append(heads, head)
+		}
+	}
+	return heads
+}
+
+// dataSubjectMembers() returns the member records the trigger added for the heads, ordered
+// by ID
+func (db *memoryDB) dataSubjectMembers(heads []*HouseHoldHead) []*HouseHoldMember {
+	members := []*HouseHoldMember{}
+	for _, head := range heads {
+		for _, member := range db.houseHoldMembers {
+			if member.HouseHoldID == head.HouseHoldID && member.Relation == "Head" && member.ErasedAt == nil {
+				members = append(members, member)
+			}
+		}
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].ID < members[j].ID })
+	return members
+}
+
+// exportDataSubject() builds the export bundle of the heads and their member records
+func (db *memoryDB) exportDataSubject(nationalID string, heads []*HouseHoldHead, members []*HouseHoldMember, decodedKey []byte) (*DataSubjectExport, error) {
+	export := &DataSubjectExport{
+		NationalID:  nationalID,
+		GeneratedAt: time.Now(),
+		HouseHolds:  make([]*DataSubjectHouseHold, 0, len(heads)),
+	}
+	for _, head := range heads {
+		phoneNumber, err := decryptPhoneNumber(head.PhoneNumber, decodedKey)
+		if err != nil {
+			return nil, err
+		}
+		houseHold := db.houseHolds[head.HouseHoldID]
+		geoLocation := db.geoLocations[houseHold.GeoLocationID]
+		exported := &DataSubjectHouseHold{
+			HouseHold:     *houseHold,
+			ProgramName:   db.programs[houseHold.ProgramID].Name,
+			County:        geoLocation.County,
+			SubCounty:     geoLocation.SubCounty,
+			Location:      geoLocation.Location,
+			SubLocation:   geoLocation.SubLocation,
+			Head:          *head,
+			MemberRecords: []*HouseHoldMember{},
+		}
+		exported.Head.PhoneNumber = phoneNumber
+		for _, member := range members {
+			if member.HouseHoldID == head.HouseHoldID {
+				memberCopy := *member
+				exported.MemberRecords = append(exported.MemberRecords, &memberCopy)
+			}
+		}
+		export.HouseHolds = append(export.HouseHolds, exported)
+	}
+	return export, nil
+}
+
+// eraseDataSubject() scrubs the heads, their member records, the names of their house holds
+// and the events published about them, like the EraseDataSubject* queries
+func (db *memoryDB) eraseDataSubject(heads []*HouseHoldHead, members []*HouseHoldMember) error {
+	erasedName := func(id int32) string { return fmt.Sprintf("[erased #%d]", id) }
+	// scrub the outbox first, so that a failure leaves the records untouched
+	scrubbed := map[int64]json.RawMessage{}
+	for _, event := range db.outboxEvents {
+		var payload map[string]json.RawMessage
+		if err := json.Unmarshal(event.Data, &payload); err != nil {
+			return err
+		}
+		var id int32
+		if err := json.Unmarshal(payload["id"], &id); err != nil {
+			continue
+		}
+		for _, head := range heads {
+			if (event.Type == EventHouseHoldHeadAssigned && id == head.ID) || (event.Type == EventHouseHoldCreated && id == head.HouseHoldID) {
+				payload["name"], _ = json.Marshal(erasedName(id))
+				data, err := json.Marshal(payload)
+				if err != nil {
+					return err
+				}
+				scrubbed[event.ID] = data
+			}
+		}
+	}
+	for eventID, data := range scrubbed {
+		db.outboxEvents[eventID].Data = data
+	}
+	now := memoryTimestamp()
+	for _, member := range members {
+		member.Name = erasedName(member.ID)
+		member.ErasedAt = &now
+		member.UpdatedAt = now
+	}
+	for _, head := range heads {
+		db.houseHolds[head.HouseHoldID].Name = erasedName(head.HouseHoldID)
+		head.Name = erasedName(head.ID)
+		head.NationalID = ""
+		head.PhoneNumber = ""
+		head.ErasedAt = &now
+		head.UpdatedAt = now
+	}
+	return nil
+}
+
+// RecordPIIReveal() logs a phone number being returned in full
+func (m MemoryPIIRevealsModel) RecordPIIReveal(ctx context.Context, reveal *PIIReveal) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	// pii_reveals_source_check, pii_reveals_user_id_fkey and pii_reveals_household_id_fkey
+	if !validator.PermittedValue(reveal.Source, PIIRevealSourcePermission, PIIRevealSourceEndpoint) {
+		return fmt.Errorf("%w: %s", ErrCheckViolation, "pii_reveals_source_check")
+	}
+	if _, ok := m.db.users[reveal.UserID]; !ok {
+		return ErrUserNotFound
+	}
+	if _, ok := m.db.houseHolds[reveal.HouseHoldID]; !ok {
+		return ErrHouseHoldDoesNotExist
+	}
+	reveal.ID = int64(m.db.nextID("pii_reveals"))
+	reveal.CreatedAt = memoryTimestamp()
+	revealCopy := *reveal
+	revealCopy.UserEmail = ""
+	m.db.piiReveals = append(m.db.piiReveals, &revealCopy)
+	return nil
+}
+
+// GetPIIReveals() lists the most recent reveals matching the filter, newest first
+func (m MemoryPIIRevealsModel) GetPIIReveals(ctx context.Context, filter *PIIRevealFilter) ([]*PIIReveal, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	reveals := []*PIIReveal{}
+	// the reveals are appended in ID order
+	for i := len(m.db.piiReveals) - 1; i >= 0 && len(reveals) < int(filter.Limit); i-- {
+		reveal := m.db.piiReveals[i]
+		if (filter.HouseHoldID != 0 && reveal.HouseHoldID != filter.HouseHoldID) || (filter.UserID != 0 && reveal.UserID != filter.UserID) {
+			continue
+		}
+		revealCopy := *reveal
+		revealCopy.UserEmail = m.db.users[reveal.UserID].Email
+		reveals = append(reveals, &revealCopy)
+	}
+	return reveals, nil
+}
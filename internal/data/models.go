@@ -1,19 +1,160 @@
 package data
 
-import "github.com/Blue-Davinci/SocialAid/internal/database"
+import (
+	"context"
+	"database/sql"
+	"time"
 
+	"github.com/Blue-Davinci/SocialAid/internal/database"
+	"github.com/Blue-Davinci/SocialAid/internal/validator"
+)
+
+// Models holds our stores. Handlers depend on these interfaces rather than on the Postgres
+// backed models, so that they can be run against the in-memory stores from NewMemoryModels().
 type Models struct {
-	Program     *ProgramsManagerModel
-	GeoLocation *GeoLocationsManagerModel
-	HouseHold   *HouseHoldsManagerModel
-	Auth        *AuthManagerModel
+	Program     ProgramStore
+	GeoLocation GeoLocationStore
+	HouseHold   HouseHoldStore
+	Auth        AuthStore
+	Idempotency IdempotencyStore
+	Webhook     WebhookStore
+	DataSubject DataSubjectStore
+	PIIReveal   PIIRevealStore
+}
+
+// ProgramStore creates, reads and updates programs
+type ProgramStore interface {
+	GetProgramById(ctx context.Context, id int32) (*Program, error)
+	CreateNewProgram(ctx context.Context, program *Program) error
+	UpdateProgramById(ctx context.Context, program *Program) error
+	GetAllPrograms(ctx context.Context) ([]*Program, error)
+	GetProgramCapacity(ctx context.Context, id int32) (*ProgramCapacity, error)
+}
+
+// GeoLocationStore creates and lists geo locations
+type GeoLocationStore interface {
+	CreateNewGeoLocation(ctx context.Context, geoLocation *GeoLocation) error
+	GetAllGeoLocations(ctx context.Context) ([]*GeoLocation, error)
+}
+
+// HouseHoldStore manages house holds along with their heads and members
+type HouseHoldStore interface {
+	GetHouseholdHeadByHouseholdId(ctx context.Context, houseHoldID int32) (*HouseHoldHead, error)
+	GetHouseHoldInformation(ctx context.Context, houseHoldID int32, includes HouseHoldIncludes, encryption_key string) (*EnrichedHouseHold, error)
+	GetHouseHoldsGeoJSON(ctx context.Context, filter *HouseHoldGeoFilter, page Page) (*GeoJSONFeatureCollection, *Cursor, error)
+	CreateNewHouseHold(ctx context.Context, houseHold *HouseHold) error
+	CreateNewHouseholdHead(ctx context.Context, houseHoldHead *HouseHoldHead, encryption_key string) error
+	CreateNewHouseholdMember(ctx context.Context, houseHoldMember *HouseHoldMember) error
+	EnrollHouseHold(ctx context.Context, enrollment *Enrollment) error
+	UpdateEnrollmentStatus(ctx context.Context, enrollment *Enrollment) error
+	NormalizeHouseholdHeadPhoneNumbers(ctx context.Context, encryption_key string, normalizer *validator.PhoneNumberNormalizer) (*PhoneNumberMigrationResult, error)
+	CheckHouseholdHeadPhoneNumbers(ctx context.Context, encryption_key string) (*PhoneNumberCheckResult, error)
+}
+
+// AuthStore looks up users by API key and manages users and their keys
+type AuthStore interface {
+	GetForApiKey(ctx context.Context, apiKey string) (*User, error)
+	GenerateToken() (*Apikey, error)
+	CreateUser(ctx context.Context, user *User) error
+	GetUserByEmail(ctx context.Context, email string) (*User, error)
+	DisableUser(ctx context.Context, email string) error
+	IssueApiKey(ctx context.Context, userID int32) (*Apikey, error)
+	GetApiKeysForUser(ctx context.Context, userID int32) ([]*ApiKeyRecord, error)
+	RevokeApiKey(ctx context.Context, id int32) error
+	GetAllPermissionsForUser(ctx context.Context, userID int32) (Permissions, error)
+	AddPermissionForUser(ctx context.Context, userID int32, code string) error
+	RemovePermissionForUser(ctx context.Context, userID int32, code string) error
+}
+
+// IdempotencyStore keeps the responses replayed for requests sent with an Idempotency-Key
+type IdempotencyStore interface {
+	ReserveIdempotencyKey(ctx context.Context, record *IdempotencyRecord) error
+	GetIdempotencyKey(ctx context.Context, userID int32, key string) (*IdempotencyRecord, error)
+	CompleteIdempotencyKey(ctx context.Context, record *IdempotencyRecord) error
+	ReleaseIdempotencyKey(ctx context.Context, id int32) error
+	DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error)
+}
+
+// WebhookStore manages the webhook subscriptions and the delivery of the events written to
+// the outbox by the other stores
+type WebhookStore interface {
+	CreateWebhookSubscription(ctx context.Context, subscription *WebhookSubscription, encryption_key string) error
+	GetAllWebhookSubscriptions(ctx context.Context) ([]*WebhookSubscription, error)
+	DisableWebhookSubscription(ctx context.Context, id int32) error
+	FanOutOutboxEvents(ctx context.Context, limit int32) (int, error)
+	ClaimDueWebhookDeliveries(ctx context.Context, limit int32, leaseUntil time.Time, encryption_key string) ([]*WebhookDelivery, error)
+	RecordWebhookDeliveryAttempt(ctx context.Context, attempt *WebhookDeliveryAttempt, status string, nextAttemptAt time.Time) error
+	GetWebhookDeliveries(ctx context.Context, subscriptionID int32, status string, limit int32) ([]*WebhookDeliveryRecord, error)
+	GetWebhookDeliveryAttempts(ctx context.Context, deliveryID int64) ([]*WebhookDeliveryAttempt, error)
+	RetryWebhookDelivery(ctx context.Context, id int64) error
+}
+
+// DataSubjectStore records the data subjects' export and erasure requests and runs them
+// once approved
+type DataSubjectStore interface {
+	CreateDataSubjectRequest(ctx context.Context, request *DataSubjectRequest, encryption_key string) error
+	GetAllDataSubjectRequests(ctx context.Context, status string) ([]*DataSubjectRequest, error)
+	ApproveDataSubjectRequest(ctx context.Context, id int32, approverID int32, encryption_key string) (*DataSubjectRequest, *DataSubjectExport, error)
+	RejectDataSubjectRequest(ctx context.Context, id int32, deciderID int32) error
+}
+
+// PIIRevealStore logs the phone numbers returned in full
+type PIIRevealStore interface {
+	RecordPIIReveal(ctx context.Context, reveal *PIIReveal) error
+	GetPIIReveals(ctx context.Context, filter *PIIRevealFilter) ([]*PIIReveal, error)
+}
+
+// make sure the Postgres backed models satisfy our store interfaces
+var (
+	_ ProgramStore     = (*ProgramsManagerModel)(nil)
+	_ GeoLocationStore = (*GeoLocationsManagerModel)(nil)
+	_ HouseHoldStore   = (*HouseHoldsManagerModel)(nil)
+	_ AuthStore        = (*AuthManagerModel)(nil)
+	_ IdempotencyStore = (*IdempotencyManagerModel)(nil)
+	_ WebhookStore     = (*WebhooksManagerModel)(nil)
+	_ DataSubjectStore = (*DataSubjectsManagerModel)(nil)
+	_ PIIRevealStore   = (*PIIRevealsManagerModel)(nil)
+)
+
+// ModelTimeouts holds the per-model query timeouts
+type ModelTimeouts struct {
+	Program     time.Duration
+	GeoLocation time.Duration
+	HouseHold   time.Duration
+	Auth        time.Duration
+	Idempotency time.Duration
+	Webhook     time.Duration
+	DataSubject time.Duration
+	PIIReveal   time.Duration
+}
+
+// DefaultModelTimeouts() returns the timeouts we use unless configured otherwise
+func DefaultModelTimeouts() ModelTimeouts {
+	return ModelTimeouts{
+		Program:     DefaultProgramManDBContextTimeout,
+		GeoLocation: DefaultGeoLocManDBContextTimeout,
+		HouseHold:   DefaultHouseHoldManDBContextTimeout,
+		Auth:        DefaultAuthManDBContextTimeout,
+		Idempotency: DefaultIdempotencyManDBContextTimeout,
+		Webhook:     DefaultWebhookManDBContextTimeout,
+		DataSubject: DefaultDataSubjectManDBContextTimeout,
+		PIIReveal:   DefaultPIIRevealManDBContextTimeout,
+	}
 }
 
-func NewModels(db *database.Queries) Models {
+// NewModels() returns the Postgres backed models. The models that run several statements
+// together, e.g writing to the outbox along with the change, get the pool itself as well to
+// run them in transactions.
+func NewModels(db *sql.DB, timeouts ModelTimeouts) Models {
+	queries := database.New(db)
 	return Models{
-		Program:     &ProgramsManagerModel{DB: db},
-		GeoLocation: &GeoLocationsManagerModel{DB: db},
-		HouseHold:   &HouseHoldsManagerModel{DB: db},
-		Auth:        &AuthManagerModel{DB: db},
+		Program:     &ProgramsManagerModel{DB: queries, Conn: db, Timeout: timeouts.Program},
+		GeoLocation: &GeoLocationsManagerModel{DB: queries, Timeout: timeouts.GeoLocation},
+		HouseHold:   &HouseHoldsManagerModel{DB: queries, Conn: db, Timeout: timeouts.HouseHold},
+		Auth:        &AuthManagerModel{DB: queries, Timeout: timeouts.Auth},
+		Idempotency: &IdempotencyManagerModel{DB: queries, Timeout: timeouts.Idempotency},
+		Webhook:     &WebhooksManagerModel{DB: queries, Conn: db, Timeout: timeouts.Webhook},
+		DataSubject: &DataSubjectsManagerModel{DB: queries, Conn: db, Timeout: timeouts.DataSubject},
+		PIIReveal:   &PIIRevealsManagerModel{DB: queries, Timeout: timeouts.PIIReveal},
 	}
 }
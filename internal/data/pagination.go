@@ -0,0 +1,82 @@
+package data
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+
+	"github.com/Blue-Davinci/SocialAid/internal/validator"
+)
+
+const (
+	// DefaultPageLimit is the number of records in a page when the client doesn't ask for one
+	DefaultPageLimit = 100
+	// MaxPageLimit is the largest page a client may ask for
+	MaxPageLimit = 1000
+)
+
+// ErrInvalidCursor is returned for cursors that we did not hand out
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor is the position in a keyset paginated list just after the last record of a page:
+// the value of the key the list is sorted by and the ID of that record, which breaks ties
+// between records with the same key. Lists sorted by ID alone leave the key empty. The next
+// page is read with WHERE (key, id) > (cursor key, cursor ID), which stays as fast on the
+// last page as on the first, unlike OFFSET.
+type Cursor struct {
+	Key string `json:"k,omitempty"`
+	ID  int32  `json:"i"`
+}
+
+// Page selects a page of a keyset paginated list
+type Page struct {
+	// After is the cursor of the previous page, nil for the first page
+	After *Cursor
+	Limit int32
+}
+
+// AfterID() returns the ID of the cursor the page starts after, 0 for the first page
+func (p Page) AfterID() int32 {
+	if p.After == nil {
+		return 0
+	}
+	return p.After.ID
+}
+
+// Encode() returns the cursor as an opaque string for the client to send back, clients are
+// not meant to build or read cursors themselves
+func (c Cursor) Encode() string {
+	// a struct of a string and an int always marshals
+	js, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+// DecodeCursor() reads a cursor returned by Encode()
+func DecodeCursor(encoded string) (*Cursor, error) {
+	js, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var cursor Cursor
+	if err := json.Unmarshal(js, &cursor); err != nil || cursor.ID < 1 {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
+
+// ValidatePageLimit() validates the page size asked for. It takes the limit as parsed, before
+// it is narrowed to the int32 of Page, which would wrap e.g 4294967297 round to 1.
+func ValidatePageLimit(v *validator.Validator, limit int) {
+	validator.InRange(v, "limit", limit, 1, MaxPageLimit)
+}
+
+// paginate() trims the records read for a page, which are read one past its limit to tell
+// whether there is another page, and returns the cursor of the next page, nil on the last
+func paginate[T any](records []T, page Page, cursor func(T) Cursor) ([]T, *Cursor) {
+	if len(records) <= int(page.Limit) {
+		return records, nil
+	}
+	records = records[:page.Limit]
+	next := cursor(records[len(records)-1])
+	return records, &next
+}
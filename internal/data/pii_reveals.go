@@ -0,0 +1,129 @@
+package data
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/Blue-Davinci/SocialAid/internal/database"
+	"github.com/Blue-Davinci/SocialAid/internal/validator"
+)
+
+type PIIRevealsManagerModel struct {
+	DB *database.Queries
+	// Timeout bounds every query, on top of the caller's context
+	Timeout time.Duration
+}
+
+const (
+	DefaultPIIRevealManDBContextTimeout = 5 * time.Second
+	// MaxPIIRevealReasonLength is the longest reason we accept for a reveal
+	MaxPIIRevealReasonLength = 1000
+	// phone numbers are masked apart from their first and last few characters, e.g the
+	// country code and the network prefix of +254712345678 in +2547******78
+	phoneNumberMaskPrefix = 5
+	phoneNumberMaskSuffix = 2
+	// phoneNumberMaskHidden is the fewest characters hidden when the prefix is kept
+	phoneNumberMaskHidden = 6
+)
+
+// How a phone number came to be returned in full: to a user with the pii:read permission,
+// or through the reveal endpoint with a reason
+const (
+	PIIRevealSourcePermission = "permission"
+	PIIRevealSourceEndpoint   = "reveal"
+)
+
+// PIIReveal records a phone number being returned in full: who saw it, for which house
+// hold, when and why
+type PIIReveal struct {
+	ID     int64 `json:"id"`
+	UserID int32 `json:"user_id"`
+	// UserEmail is only set when listing the reveals
+	UserEmail   string    `json:"user_email,omitempty"`
+	HouseHoldID int32     `json:"house_hold_id"`
+	Source      string    `json:"source"`
+	Reason      string    `json:"reason,omitempty"`
+	RequestID   string    `json:"request_id,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// PIIRevealFilter narrows down the reveals listed, a zero ID matches every house hold or user
+type PIIRevealFilter struct {
+	HouseHoldID int32
+	UserID      int32
+	Limit       int32
+}
+
+// ValidatePIIRevealReason() checks the reason given for revealing a phone number
+func ValidatePIIRevealReason(v *validator.Validator, reason string) {
+	v.Required("reason", strings.TrimSpace(reason))
+	v.MaxLength("reason", reason, MaxPIIRevealReasonLength)
+}
+
+// MaskPhoneNumber() hides all but the first and last few characters of a phone number, e.g
+// +254712345678 becomes +2547******78. Numbers too short to keep the prefix only keep the
+// suffix, and empty numbers, e.g those of erased heads, stay empty.
+func MaskPhoneNumber(phoneNumber string) string {
+	switch {
+	case len(phoneNumber) <= phoneNumberMaskSuffix:
+		return strings.Repeat("*", len(phoneNumber))
+	case len(phoneNumber) < phoneNumberMaskPrefix+phoneNumberMaskHidden+phoneNumberMaskSuffix:
+		return strings.Repeat("*", len(phoneNumber)-phoneNumberMaskSuffix) + phoneNumber[len(phoneNumber)-phoneNumberMaskSuffix:]
+	default:
+		return phoneNumber[:phoneNumberMaskPrefix] +
+			strings.Repeat("*", len(phoneNumber)-phoneNumberMaskPrefix-phoneNumberMaskSuffix) +
+			phoneNumber[len(phoneNumber)-phoneNumberMaskSuffix:]
+	}
+}
+
+// RecordPIIReveal() logs a phone number being returned in full. Callers record the reveal
+// before returning the number, so that nothing is revealed without a record of it.
+func (m PIIRevealsManagerModel) RecordPIIReveal(ctx context.Context, reveal *PIIReveal) error {
+	// create context
+	ctx, cancel := contextGenerator(ctx, m.Timeout)
+	defer cancel()
+	revealInfo, err := m.DB.CreatePIIReveal(ctx, database.CreatePIIRevealParams{
+		UserID:      reveal.UserID,
+		HouseholdID: reveal.HouseHoldID,
+		Source:      reveal.Source,
+		Reason:      reveal.Reason,
+		RequestID:   reveal.RequestID,
+	})
+	if err != nil {
+		// translate constraint violations to our sentinel errors
+		return translateDBError(ctx, err)
+	}
+	reveal.ID = revealInfo.ID
+	reveal.CreatedAt = revealInfo.CreatedAt
+	return nil
+}
+
+// GetPIIReveals() lists the most recent reveals matching the filter, newest first
+func (m PIIRevealsManagerModel) GetPIIReveals(ctx context.Context, filter *PIIRevealFilter) ([]*PIIReveal, error) {
+	// create context
+	ctx, cancel := contextGenerator(ctx, m.Timeout)
+	defer cancel()
+	rows, err := m.DB.GetPIIReveals(ctx, database.GetPIIRevealsParams{
+		HouseholdID: filter.HouseHoldID,
+		UserID:      filter.UserID,
+		Limit:       filter.Limit,
+	})
+	if err != nil {
+		return nil, translateDBError(ctx, err)
+	}
+	reveals := make([]*PIIReveal, 0, len(rows))
+	for _, row := range rows {
+		reveals = append(reveals, &PIIReveal{
+			ID:          row.ID,
+			UserID:      row.UserID,
+			UserEmail:   row.UserEmail,
+			HouseHoldID: row.HouseholdID,
+			Source:      row.Source,
+			Reason:      row.Reason,
+			RequestID:   row.RequestID,
+			CreatedAt:   row.CreatedAt,
+		})
+	}
+	return reveals, nil
+}
@@ -4,6 +4,10 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/Blue-Davinci/SocialAid/internal/database"
@@ -12,14 +16,26 @@ import (
 
 type ProgramsManagerModel struct {
 	DB *database.Queries
+	// Conn begins the transactions that write the outbox along with the change
+	Conn *sql.DB
+	// Timeout bounds every query, on top of the caller's context
+	Timeout time.Duration
 }
 
 const (
 	DefaultProgramManDBContextTimeout = 5 * time.Second
+	// MaxProgramCountyQuotas is the most county quotas a program may have, Kenya has 47 counties
+	MaxProgramCountyQuotas = 100
 )
 
 var (
 	ErrDuplicateProgram = errors.New("program's name already exists, please choose another one")
+	// the reasons a house hold can't be enrolled in a program, see CreateNewHouseHold() and
+	// EnrollHouseHold()
+	ErrEnrollmentNotOpen = errors.New("enrollment in the program has not opened yet")
+	ErrEnrollmentClosed  = errors.New("enrollment in the program has closed")
+	ErrProgramFull       = errors.New("the program has no places left")
+	ErrCountyQuotaFull   = errors.New("the program has no places left in the county")
 )
 
 type Program struct {
@@ -29,21 +45,140 @@ type Program struct {
 	Description string    `json:"description"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
+	// house holds may only be enrolled between EnrollmentOpensAt and EnrollmentClosesAt and
+	// up to HouseHoldCap, nil means there is no such limit
+	EnrollmentOpensAt  *time.Time `json:"enrollment_opens_at,omitempty"`
+	EnrollmentClosesAt *time.Time `json:"enrollment_closes_at,omitempty"`
+	HouseHoldCap       *int32     `json:"household_cap,omitempty"`
+	// CountyQuotas caps the house holds enrolled per county, counties without a quota are
+	// only limited by HouseHoldCap
+	CountyQuotas map[string]int32 `json:"county_quotas,omitempty"`
+}
+
+// ProgramCapacity is how many more house holds a program can take, overall and per county
+type ProgramCapacity struct {
+	ProgramID          int32      `json:"program_id"`
+	EnrollmentOpensAt  *time.Time `json:"enrollment_opens_at,omitempty"`
+	EnrollmentClosesAt *time.Time `json:"enrollment_closes_at,omitempty"`
+	// IsOpen is whether the enrollment window is open now
+	IsOpen       bool   `json:"is_open"`
+	HouseHoldCap *int32 `json:"household_cap,omitempty"`
+	Enrolled     int64  `json:"enrolled"`
+	// Remaining is nil when the program has no cap
+	Remaining *int64 `json:"remaining,omitempty"`
+	// Counties lists the counties with a quota or with house holds enrolled, by name
+	Counties []CountyCapacity `json:"counties"`
+}
+
+type CountyCapacity struct {
+	County   string `json:"county"`
+	Quota    *int32 `json:"quota,omitempty"`
+	Enrolled int64  `json:"enrolled"`
+	// Remaining is the smaller of what is left of the county's quota and of the program's
+	// cap, nil when neither is limited
+	Remaining *int64 `json:"remaining,omitempty"`
+}
+
+// enrollmentLimits holds what decides whether a house hold can be enrolled in a program
+type enrollmentLimits struct {
+	OpensAt  *time.Time
+	ClosesAt *time.Time
+	Cap      *int32
+	Enrolled int64
+	// CountyQuota is the quota of the house hold's county, nil when it has none
+	CountyQuota    *int32
+	CountyEnrolled int64
 }
 
 func ValidateProgram(v *validator.Validator, p *Program) {
-	v.Check(p.Name != "", "name", "must be provided")
-	v.Check(p.Category != "", "category", "must be provided")
-	v.Check(p.Description != "", "description", "must be provided")
-	v.Check(len(p.Name) <= 255, "name", "must not be more than 500 bytes long")
-	v.Check(len(p.Category) <= 255, "category", "must not be more than 500 bytes long")
-	v.Check(len(p.Description) <= 1000, "description", "must not be more than 1000 bytes long")
+	v.Required("name", p.Name)
+	v.Required("category", p.Category)
+	v.Required("description", p.Description)
+	v.MaxLength("name", p.Name, 255)
+	v.MaxLength("category", p.Category, 255)
+	v.MaxLength("description", p.Description, 1000)
+	if p.EnrollmentOpensAt != nil && p.EnrollmentClosesAt != nil {
+		v.CrossField(p.EnrollmentClosesAt.After(*p.EnrollmentOpensAt), "enrollment_closes_at", "must be after enrollment_opens_at")
+	}
+	if p.HouseHoldCap != nil {
+		validator.Min(v, "household_cap", *p.HouseHoldCap, 0)
+	}
+	v.CheckCode(len(p.CountyQuotas) <= MaxProgramCountyQuotas, "county_quotas", validator.CodeTooLong, fmt.Sprintf("must not have more than %d counties", MaxProgramCountyQuotas))
+	// sorted, so that the same input always reports the same error
+	for _, county := range slices.Sorted(maps.Keys(p.CountyQuotas)) {
+		quota := p.CountyQuotas[county]
+		v.Check(strings.TrimSpace(county) != "", "county_quotas", "must not have a blank county")
+		v.CheckCode(len(county) <= 255, "county_quotas", validator.CodeTooLong, "must not have a county longer than 255 bytes")
+		v.CheckCode(quota >= 0, "county_quotas", validator.CodeOutOfRange, "must not have a negative quota")
+		if p.HouseHoldCap != nil {
+			v.CrossField(quota <= *p.HouseHoldCap, "county_quotas", "must not have a quota larger than household_cap")
+		}
+	}
+}
+
+// check() returns the reason a house hold can't be enrolled at now, or nil if it can
+func (l enrollmentLimits) check(now time.Time) error {
+	switch {
+	case l.OpensAt != nil && now.Before(*l.OpensAt):
+		return ErrEnrollmentNotOpen
+	case l.ClosesAt != nil && !now.Before(*l.ClosesAt):
+		return ErrEnrollmentClosed
+	case l.Cap != nil && l.Enrolled >= int64(*l.Cap):
+		return ErrProgramFull
+	case l.CountyQuota != nil && l.CountyEnrolled >= int64(*l.CountyQuota):
+		return ErrCountyQuotaFull
+	}
+	return nil
+}
+
+// newProgramCapacity() works out the capacity of the program at now from the house holds
+// enrolled in it per county
+func newProgramCapacity(program *Program, enrolledByCounty map[string]int64, now time.Time) *ProgramCapacity {
+	capacity := &ProgramCapacity{
+		ProgramID:          program.ID,
+		EnrollmentOpensAt:  program.EnrollmentOpensAt,
+		EnrollmentClosesAt: program.EnrollmentClosesAt,
+		IsOpen:             enrollmentLimits{OpensAt: program.EnrollmentOpensAt, ClosesAt: program.EnrollmentClosesAt}.check(now) == nil,
+		HouseHoldCap:       program.HouseHoldCap,
+		Counties:           []CountyCapacity{},
+	}
+	for _, enrolled := range enrolledByCounty {
+		capacity.Enrolled += enrolled
+	}
+	if program.HouseHoldCap != nil {
+		remaining := max(int64(*program.HouseHoldCap)-capacity.Enrolled, 0)
+		capacity.Remaining = &remaining
+	}
+	counties := slices.Collect(maps.Keys(enrolledByCounty))
+	for county := range program.CountyQuotas {
+		if _, ok := enrolledByCounty[county]; !ok {
+			counties = append(counties, county)
+		}
+	}
+	slices.Sort(counties)
+	for _, county := range counties {
+		countyCapacity := CountyCapacity{
+			County:    county,
+			Enrolled:  enrolledByCounty[county],
+			Remaining: capacity.Remaining,
+		}
+		if quota, ok := program.CountyQuotas[county]; ok {
+			countyCapacity.Quota = &quota
+			remaining := max(int64(quota)-countyCapacity.Enrolled, 0)
+			if capacity.Remaining != nil {
+				remaining = min(remaining, *capacity.Remaining)
+			}
+			countyCapacity.Remaining = &remaining
+		}
+		capacity.Counties = append(capacity.Counties, countyCapacity)
+	}
+	return capacity
 }
 
-// GetProgramById() gets a program by its ID
-func (m ProgramsManagerModel) GetProgramById(id int32) (*Program, error) {
+// GetProgramById() gets a program by its ID along with its county quotas
+func (m ProgramsManagerModel) GetProgramById(ctx context.Context, id int32) (*Program, error) {
 	// create context
-	ctx, cancel := contextGenerator(context.Background(), DefaultProgramManDBContextTimeout)
+	ctx, cancel := contextGenerator(ctx, m.Timeout)
 	defer cancel()
 	programInfo, err := m.DB.GetProgramById(ctx, id)
 	if err != nil {
@@ -51,66 +186,204 @@ func (m ProgramsManagerModel) GetProgramById(id int32) (*Program, error) {
 		case errors.Is(err, sql.ErrNoRows):
 			return nil, ErrProgramDoesNotExist
 		default:
-			return nil, err
+			return nil, translateDBError(ctx, err)
 		}
 	}
-	program := Program{
-		ID:          programInfo.ID,
-		Name:        programInfo.Name,
-		Category:    programInfo.Category,
-		Description: programInfo.Description,
-		CreatedAt:   programInfo.CreatedAt,
-		UpdatedAt:   programInfo.UpdatedAt,
+	program := programFromRow(programInfo)
+	quotaRows, err := m.DB.GetProgramCountyQuotas(ctx, id)
+	if err != nil {
+		return nil, translateDBError(ctx, err)
+	}
+	for _, quota := range quotaRows {
+		if program.CountyQuotas == nil {
+			program.CountyQuotas = make(map[string]int32, len(quotaRows))
+		}
+		program.CountyQuotas[quota.County] = quota.Quota
 	}
-	return &program, nil
+	return program, nil
 }
 
-func (m ProgramsManagerModel) CreateNewProgram(program *Program) error {
+// CreateNewProgram() creates a program with its county quotas and publishes a
+// program.created event
+func (m ProgramsManagerModel) CreateNewProgram(ctx context.Context, program *Program) error {
 	// create context
-	ctx, cancel := contextGenerator(context.Background(), DefaultProgramManDBContextTimeout)
+	ctx, cancel := contextGenerator(ctx, m.Timeout)
 	defer cancel()
-	programInfo, err := m.DB.CreateNewProgram(ctx, database.CreateNewProgramParams{
-		Name:        program.Name,
-		Category:    program.Category,
-		Description: program.Description,
+	err := withTransaction(ctx, m.Conn, m.DB, func(q *database.Queries) error {
+		programInfo, err := q.CreateNewProgram(ctx, database.CreateNewProgramParams{
+			Name:               program.Name,
+			Category:           program.Category,
+			Description:        program.Description,
+			EnrollmentOpensAt:  toNullTime(program.EnrollmentOpensAt),
+			EnrollmentClosesAt: toNullTime(program.EnrollmentClosesAt),
+			HouseholdCap:       toOptionalNullInt32(program.HouseHoldCap),
+		})
+		if err != nil {
+			return err
+		}
+		// set the new program info
+		program.ID = programInfo.ID
+		program.CreatedAt = programInfo.CreatedAt
+		program.UpdatedAt = programInfo.UpdatedAt
+		if err := setProgramCountyQuotas(ctx, q, program); err != nil {
+			return err
+		}
+		return createOutboxEvent(ctx, q, EventProgramCreated, program)
 	})
 	if err != nil {
-		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "programs_name_key"`:
-			return ErrDuplicateProgram
-		default:
+		// translate constraint violations to our sentinel errors
+		return translateDBError(ctx, err)
+	}
+	// no error so return nil
+	return nil
+}
+
+// UpdateProgramById() updates a program by its ID, replacing its county quotas, and
+// publishes a program.updated event
+func (m ProgramsManagerModel) UpdateProgramById(ctx context.Context, program *Program) error {
+	// create context
+	ctx, cancel := contextGenerator(ctx, m.Timeout)
+	defer cancel()
+	err := withTransaction(ctx, m.Conn, m.DB, func(q *database.Queries) error {
+		programInfo, err := q.UpdateProgramById(ctx, database.UpdateProgramByIdParams{
+			ID:                 program.ID,
+			Name:               program.Name,
+			Category:           program.Category,
+			Description:        program.Description,
+			EnrollmentOpensAt:  toNullTime(program.EnrollmentOpensAt),
+			EnrollmentClosesAt: toNullTime(program.EnrollmentClosesAt),
+			HouseholdCap:       toOptionalNullInt32(program.HouseHoldCap),
+		})
+		if err != nil {
 			return err
 		}
+		// set the new program info
+		program.UpdatedAt = programInfo
+		if err := q.DeleteProgramCountyQuotas(ctx, program.ID); err != nil {
+			return err
+		}
+		if err := setProgramCountyQuotas(ctx, q, program); err != nil {
+			return err
+		}
+		return createOutboxEvent(ctx, q, EventProgramUpdated, program)
+	})
+	if err != nil {
+		// translate constraint violations to our sentinel errors
+		return translateDBError(ctx, err)
 	}
-	// set the new program info
-	program.ID = programInfo.ID
-	program.CreatedAt = programInfo.CreatedAt
-	program.UpdatedAt = programInfo.UpdatedAt
 	// no error so return nil
 	return nil
 }
 
-// UpdateProgramById() updates a program by its ID
-func (m ProgramsManagerModel) UpdateProgramById(program *Program) error {
+// GetAllPrograms() gets every program ordered by ID, without their county quotas. It is
+// meant for admin tooling, the table is small enough that we don't page through it.
+func (m ProgramsManagerModel) GetAllPrograms(ctx context.Context) ([]*Program, error) {
 	// create context
-	ctx, cancel := contextGenerator(context.Background(), DefaultProgramManDBContextTimeout)
+	ctx, cancel := contextGenerator(ctx, m.Timeout)
 	defer cancel()
-	programInfo, err := m.DB.UpdateProgramById(ctx, database.UpdateProgramByIdParams{
-		ID:          program.ID,
-		Name:        program.Name,
-		Category:    program.Category,
-		Description: program.Description,
-	})
+	programRows, err := m.DB.GetAllPrograms(ctx)
 	if err != nil {
-		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "programs_name_key"`:
-			return ErrDuplicateProgram
-		default:
+		return nil, translateDBError(ctx, err)
+	}
+	programs := make([]*Program, 0, len(programRows))
+	for _, programInfo := range programRows {
+		programs = append(programs, programFromRow(programInfo))
+	}
+	return programs, nil
+}
+
+// GetProgramCapacity() gets how many more house holds the program can take, overall and per
+// county
+func (m ProgramsManagerModel) GetProgramCapacity(ctx context.Context, id int32) (*ProgramCapacity, error) {
+	program, err := m.GetProgramById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	// create context
+	ctx, cancel := contextGenerator(ctx, m.Timeout)
+	defer cancel()
+	countyRows, err := m.DB.CountHouseholdsByCountyForProgram(ctx, id)
+	if err != nil {
+		return nil, translateDBError(ctx, err)
+	}
+	enrolledByCounty := make(map[string]int64, len(countyRows))
+	for _, row := range countyRows {
+		enrolledByCounty[row.County] = row.Enrolled
+	}
+	return newProgramCapacity(program, enrolledByCounty, time.Now()), nil
+}
+
+// checkEnrollment() checks that the program is open for enrollment and has room for one more
+// house hold, overall and in the house hold's county. It locks the program for the rest of
+// the transaction, so that concurrent enrollments in the program wait for this one to
+// finish and then count it, and two of them can't both take the last place.
+func checkEnrollment(ctx context.Context, q *database.Queries, programID int32, county string) error {
+	program, err := q.GetProgramEnrollmentLimitsForUpdate(ctx, programID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrProgramDoesNotExist
+		}
+		return err
+	}
+	limits := enrollmentLimits{
+		OpensAt:  fromNullTime(program.EnrollmentOpensAt),
+		ClosesAt: fromNullTime(program.EnrollmentClosesAt),
+		Cap:      fromNullInt32(program.HouseholdCap),
+	}
+	if limits.Cap != nil {
+		limits.Enrolled, err = q.CountHouseholdsByProgramId(ctx, programID)
+		if err != nil {
+			return err
+		}
+	}
+	quota, err := q.GetProgramCountyQuota(ctx, database.GetProgramCountyQuotaParams{
+		ProgramID: programID,
+		County:    county,
+	})
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		// the county has no quota
+	case err != nil:
+		return err
+	default:
+		limits.CountyQuota = &quota
+		limits.CountyEnrolled, err = q.CountHouseholdsByProgramIdAndCounty(ctx, database.CountHouseholdsByProgramIdAndCountyParams{
+			ProgramID: programID,
+			County:    county,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return limits.check(time.Now())
+}
+
+// setProgramCountyQuotas() stores the program's county quotas, in the order of the counties
+func setProgramCountyQuotas(ctx context.Context, q *database.Queries, program *Program) error {
+	for _, county := range slices.Sorted(maps.Keys(program.CountyQuotas)) {
+		err := q.CreateProgramCountyQuota(ctx, database.CreateProgramCountyQuotaParams{
+			ProgramID: program.ID,
+			County:    county,
+			Quota:     program.CountyQuotas[county],
+		})
+		if err != nil {
 			return err
 		}
 	}
-	// set the new program info
-	program.UpdatedAt = programInfo
-	// no error so return nil
 	return nil
 }
+
+// programFromRow() converts a program row, which doesn't hold the county quotas
+func programFromRow(programInfo database.Program) *Program {
+	return &Program{
+		ID:                 programInfo.ID,
+		Name:               programInfo.Name,
+		Category:           programInfo.Category,
+		Description:        programInfo.Description,
+		CreatedAt:          programInfo.CreatedAt,
+		UpdatedAt:          programInfo.UpdatedAt,
+		EnrollmentOpensAt:  fromNullTime(programInfo.EnrollmentOpensAt),
+		EnrollmentClosesAt: fromNullTime(programInfo.EnrollmentClosesAt),
+		HouseHoldCap:       fromNullInt32(programInfo.HouseholdCap),
+	}
+}
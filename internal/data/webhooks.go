@@ -0,0 +1,439 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/Blue-Davinci/SocialAid/internal/database"
+	"github.com/Blue-Davinci/SocialAid/internal/validator"
+)
+
+type WebhooksManagerModel struct {
+	DB *database.Queries
+	// Conn begins the transactions that fan out the outbox and record delivery attempts
+	Conn *sql.DB
+	// Timeout bounds every query, on top of the caller's context
+	Timeout time.Duration
+}
+
+const (
+	DefaultWebhookManDBContextTimeout = 5 * time.Second
+	// MaxWebhookURLLength is the longest subscription URL we accept
+	MaxWebhookURLLength = 2048
+)
+
+// The events we publish to webhook subscribers
+const (
+	EventProgramCreated        = "program.created"
+	EventProgramUpdated        = "program.updated"
+	EventHouseHoldCreated      = "household.created"
+	EventHouseHoldHeadAssigned = "household.head_assigned"
+	EventHouseHoldMemberAdded  = "household.member_added"
+	// an existing house hold enrolled in another program, or its enrollment changing status
+	EventHouseHoldEnrolled          = "household.enrolled"
+	EventHouseHoldEnrollmentUpdated = "household.enrollment_updated"
+)
+
+// WebhookEventTypes lists every event type a subscription can filter on
+var WebhookEventTypes = []string{
+	EventProgramCreated,
+	EventProgramUpdated,
+	EventHouseHoldCreated,
+	EventHouseHoldHeadAssigned,
+	EventHouseHoldMemberAdded,
+	EventHouseHoldEnrolled,
+	EventHouseHoldEnrollmentUpdated,
+}
+
+// The states of a delivery. Pending deliveries are retried with a backoff until they are
+// delivered or have used up their attempts, after which they are dead.
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliveryDelivered = "delivered"
+	WebhookDeliveryDead      = "dead"
+)
+
+var (
+	ErrWebhookSubscriptionNotFound = errors.New("webhook subscription not found or already disabled")
+	ErrWebhookDeliveryNotRetryable = errors.New("webhook delivery not found, not dead or its subscription is disabled")
+)
+
+// WebhookSubscription is a partner's URL along with the events it wants. No event types
+// means every event. The secret signs the payloads, it is only set when the subscription
+// is created and never read back.
+type WebhookSubscription struct {
+	ID         int32     `json:"id"`
+	URL        string    `json:"url"`
+	Secret     string    `json:"-"`
+	EventTypes []string  `json:"event_types"`
+	Active     bool      `json:"active"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// WebhookEvent is the body we send to subscribers. The ID is unique per event, delivery is
+// at least once so receivers should use it to drop duplicates.
+type WebhookEvent struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// WebhookDelivery is a delivery claimed by the dispatcher, with the decrypted secret of its
+// subscription
+type WebhookDelivery struct {
+	ID             int64
+	SubscriptionID int32
+	URL            string
+	Secret         string
+	// Attempts is how many attempts have been made before this one
+	Attempts int32
+	Event    WebhookEvent
+}
+
+// WebhookDeliveryRecord is a row of the deliveries listing
+type WebhookDeliveryRecord struct {
+	ID             int64
+	SubscriptionID int32
+	EventID        int64
+	EventType      string
+	Status         string
+	Attempts       int32
+	NextAttemptAt  time.Time
+	// LastStatusCode is 0 if no response was received
+	LastStatusCode int
+	LastError      string
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// WebhookDeliveryAttempt is an entry of the delivery log
+type WebhookDeliveryAttempt struct {
+	ID         int64
+	DeliveryID int64
+	Attempt    int32
+	// StatusCode is 0 if no response was received, e.g the connection was refused
+	StatusCode  int
+	Error       string
+	Duration    time.Duration
+	AttemptedAt time.Time
+}
+
+// houseHoldHeadEvent is the data of household.head_assigned events. We leave out the phone
+// number and national ID, partners can look the head up through the API if they need them.
+type houseHoldHeadEvent struct {
+	ID             int32     `json:"id"`
+	HouseHoldID    int32     `json:"house_hold_id"`
+	Name           string    `json:"name"`
+	NationalIDType string    `json:"national_id_type"`
+	Age            int32     `json:"age"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// newHouseHoldHeadEvent() returns the event data of a head
+func newHouseHoldHeadEvent(h *HouseHoldHead) houseHoldHeadEvent {
+	return houseHoldHeadEvent{
+		ID:             h.ID,
+		HouseHoldID:    h.HouseHoldID,
+		Name:           h.Name,
+		NationalIDType: h.NationalIDType,
+		Age:            h.Age,
+		CreatedAt:      h.CreatedAt,
+	}
+}
+
+// ValidateWebhookSubscription() validates the subscription's URL and event types
+func ValidateWebhookSubscription(v *validator.Validator, s *WebhookSubscription) {
+	v.Required("url", s.URL)
+	v.MaxLength("url", s.URL, MaxWebhookURLLength)
+	if s.URL != "" {
+		u, err := url.Parse(s.URL)
+		v.CheckCode(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "url", validator.CodeInvalidFormat, "must be an http or https URL")
+	}
+	for _, eventType := range s.EventTypes {
+		if !validator.PermittedValue(eventType, WebhookEventTypes...) {
+			v.AddFieldError("event_types", validator.CodeOutOfRange, fmt.Sprintf("%q is not a known event type", eventType))
+		}
+	}
+}
+
+// createOutboxEvent() writes an event to the outbox using the queries of the transaction that
+// makes the change, so that the event is published if and only if the change is committed
+func createOutboxEvent(ctx context.Context, q *database.Queries, eventType string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return q.CreateOutboxEvent(ctx, database.CreateOutboxEventParams{
+		EventType: eventType,
+		Payload:   payload,
+	})
+}
+
+// CreateWebhookSubscription() creates a subscription, encrypting its secret like we do the
+// phone numbers
+func (m WebhooksManagerModel) CreateWebhookSubscription(ctx context.Context, subscription *WebhookSubscription, encryption_key string) error {
+	// create context
+	ctx, cancel := contextGenerator(ctx, m.Timeout)
+	defer cancel()
+	decodedKey, err := DecodeEncryptionKey(encryption_key)
+	if err != nil {
+		return err
+	}
+	encryptedSecret, err := EncryptData(subscription.Secret, decodedKey)
+	if err != nil {
+		return err
+	}
+	if subscription.EventTypes == nil {
+		subscription.EventTypes = []string{}
+	}
+	subscriptionInfo, err := m.DB.CreateWebhookSubscription(ctx, database.CreateWebhookSubscriptionParams{
+		Url:        subscription.URL,
+		Secret:     encryptedSecret,
+		EventTypes: subscription.EventTypes,
+	})
+	if err != nil {
+		return translateDBError(ctx, err)
+	}
+	subscription.ID = subscriptionInfo.ID
+	subscription.Active = subscriptionInfo.Active
+	subscription.CreatedAt = subscriptionInfo.CreatedAt
+	subscription.UpdatedAt = subscriptionInfo.UpdatedAt
+	return nil
+}
+
+// GetAllWebhookSubscriptions() gets every subscription, including disabled ones, ordered by
+// ID. The secrets are left out.
+func (m WebhooksManagerModel) GetAllWebhookSubscriptions(ctx context.Context) ([]*WebhookSubscription, error) {
+	// create context
+	ctx, cancel := contextGenerator(ctx, m.Timeout)
+	defer cancel()
+	subscriptionRows, err := m.DB.GetAllWebhookSubscriptions(ctx)
+	if err != nil {
+		return nil, translateDBError(ctx, err)
+	}
+	subscriptions := make([]*WebhookSubscription, 0, len(subscriptionRows))
+	for _, subscriptionInfo := range subscriptionRows {
+		subscriptions = append(subscriptions, &WebhookSubscription{
+			ID:         subscriptionInfo.ID,
+			URL:        subscriptionInfo.Url,
+			EventTypes: subscriptionInfo.EventTypes,
+			Active:     subscriptionInfo.Active,
+			CreatedAt:  subscriptionInfo.CreatedAt,
+			UpdatedAt:  subscriptionInfo.UpdatedAt,
+		})
+	}
+	return subscriptions, nil
+}
+
+// DisableWebhookSubscription() stops a subscription receiving events. Its pending deliveries
+// are marked dead, they can be retried if it is enabled again.
+func (m WebhooksManagerModel) DisableWebhookSubscription(ctx context.Context, id int32) error {
+	// create context
+	ctx, cancel := contextGenerator(ctx, m.Timeout)
+	defer cancel()
+	err := withTransaction(ctx, m.Conn, m.DB, func(q *database.Queries) error {
+		disabled, err := q.DisableWebhookSubscriptionById(ctx, id)
+		if err != nil {
+			return err
+		}
+		if disabled == 0 {
+			return ErrWebhookSubscriptionNotFound
+		}
+		return q.CancelPendingWebhookDeliveries(ctx, database.CancelPendingWebhookDeliveriesParams{
+			SubscriptionID: id,
+			LastError:      "subscription disabled",
+		})
+	})
+	if err != nil {
+		return translateDBError(ctx, err)
+	}
+	return nil
+}
+
+// FanOutOutboxEvents() creates a delivery of each of up to limit events waiting in the outbox
+// to every active subscription that wants it, and marks the events dispatched. Events held
+// by another dispatcher are skipped. It returns how many events were fanned out.
+func (m WebhooksManagerModel) FanOutOutboxEvents(ctx context.Context, limit int32) (int, error) {
+	// create context
+	ctx, cancel := contextGenerator(ctx, m.Timeout)
+	defer cancel()
+	fannedOut := 0
+	err := withTransaction(ctx, m.Conn, m.DB, func(q *database.Queries) error {
+		events, err := q.GetUndispatchedOutboxEvents(ctx, limit)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			_, err := q.CreateWebhookDeliveriesForEvent(ctx, database.CreateWebhookDeliveriesForEventParams{
+				EventID:   event.ID,
+				EventType: event.EventType,
+			})
+			if err != nil {
+				return err
+			}
+			if err := q.MarkOutboxEventDispatched(ctx, event.ID); err != nil {
+				return err
+			}
+		}
+		fannedOut = len(events)
+		return nil
+	})
+	if err != nil {
+		return 0, translateDBError(ctx, err)
+	}
+	return fannedOut, nil
+}
+
+// ClaimDueWebhookDeliveries() claims up to limit pending deliveries that are due, leasing
+// them until leaseUntil so that no other dispatcher sends them meanwhile. The lease must
+// outlast the delivery, otherwise the event may be sent twice.
+func (m WebhooksManagerModel) ClaimDueWebhookDeliveries(ctx context.Context, limit int32, leaseUntil time.Time, encryption_key string) ([]*WebhookDelivery, error) {
+	// create context
+	ctx, cancel := contextGenerator(ctx, m.Timeout)
+	defer cancel()
+	decodedKey, err := DecodeEncryptionKey(encryption_key)
+	if err != nil {
+		return nil, err
+	}
+	deliveryRows, err := m.DB.ClaimDueWebhookDeliveries(ctx, database.ClaimDueWebhookDeliveriesParams{
+		LeaseUntil: leaseUntil,
+		Limit:      limit,
+	})
+	if err != nil {
+		return nil, translateDBError(ctx, err)
+	}
+	deliveries := make([]*WebhookDelivery, 0, len(deliveryRows))
+	for _, deliveryInfo := range deliveryRows {
+		secret, err := DecryptData(deliveryInfo.Secret, decodedKey)
+		if err != nil {
+			return nil, fmt.Errorf("webhook subscription %d: %w", deliveryInfo.SubscriptionID, err)
+		}
+		deliveries = append(deliveries, &WebhookDelivery{
+			ID:             deliveryInfo.ID,
+			SubscriptionID: deliveryInfo.SubscriptionID,
+			URL:            deliveryInfo.Url,
+			Secret:         secret,
+			Attempts:       deliveryInfo.Attempts,
+			Event: WebhookEvent{
+				ID:        deliveryInfo.EventID,
+				Type:      deliveryInfo.EventType,
+				CreatedAt: deliveryInfo.EventCreatedAt,
+				Data:      deliveryInfo.Payload,
+			},
+		})
+	}
+	return deliveries, nil
+}
+
+// RecordWebhookDeliveryAttempt() adds the attempt to the delivery log and moves its delivery
+// to the status, to be attempted again at nextAttemptAt if it is still pending
+func (m WebhooksManagerModel) RecordWebhookDeliveryAttempt(ctx context.Context, attempt *WebhookDeliveryAttempt, status string, nextAttemptAt time.Time) error {
+	// create context
+	ctx, cancel := contextGenerator(ctx, m.Timeout)
+	defer cancel()
+	statusCode := toNullInt32(attempt.StatusCode)
+	err := withTransaction(ctx, m.Conn, m.DB, func(q *database.Queries) error {
+		err := q.UpdateWebhookDelivery(ctx, database.UpdateWebhookDeliveryParams{
+			ID:             attempt.DeliveryID,
+			Status:         status,
+			Attempts:       attempt.Attempt,
+			NextAttemptAt:  nextAttemptAt,
+			LastStatusCode: statusCode,
+			LastError:      attempt.Error,
+		})
+		if err != nil {
+			return err
+		}
+		return q.CreateWebhookDeliveryAttempt(ctx, database.CreateWebhookDeliveryAttemptParams{
+			DeliveryID: attempt.DeliveryID,
+			Attempt:    attempt.Attempt,
+			StatusCode: statusCode,
+			Error:      attempt.Error,
+			DurationMs: int32(attempt.Duration.Milliseconds()),
+		})
+	})
+	if err != nil {
+		return translateDBError(ctx, err)
+	}
+	return nil
+}
+
+// GetWebhookDeliveries() lists up to limit of the subscription's most recent deliveries, only
+// those with the status unless it is empty
+func (m WebhooksManagerModel) GetWebhookDeliveries(ctx context.Context, subscriptionID int32, status string, limit int32) ([]*WebhookDeliveryRecord, error) {
+	// create context
+	ctx, cancel := contextGenerator(ctx, m.Timeout)
+	defer cancel()
+	deliveryRows, err := m.DB.GetWebhookDeliveriesForSubscription(ctx, database.GetWebhookDeliveriesForSubscriptionParams{
+		SubscriptionID: subscriptionID,
+		Status:         status,
+		Limit:          limit,
+	})
+	if err != nil {
+		return nil, translateDBError(ctx, err)
+	}
+	deliveries := make([]*WebhookDeliveryRecord, 0, len(deliveryRows))
+	for _, deliveryInfo := range deliveryRows {
+		deliveries = append(deliveries, &WebhookDeliveryRecord{
+			ID:             deliveryInfo.ID,
+			SubscriptionID: deliveryInfo.SubscriptionID,
+			EventID:        deliveryInfo.EventID,
+			EventType:      deliveryInfo.EventType,
+			Status:         deliveryInfo.Status,
+			Attempts:       deliveryInfo.Attempts,
+			NextAttemptAt:  deliveryInfo.NextAttemptAt,
+			LastStatusCode: int(deliveryInfo.LastStatusCode.Int32),
+			LastError:      deliveryInfo.LastError,
+			CreatedAt:      deliveryInfo.CreatedAt,
+			UpdatedAt:      deliveryInfo.UpdatedAt,
+		})
+	}
+	return deliveries, nil
+}
+
+// GetWebhookDeliveryAttempts() gets the delivery log of a delivery, oldest attempt first
+func (m WebhooksManagerModel) GetWebhookDeliveryAttempts(ctx context.Context, deliveryID int64) ([]*WebhookDeliveryAttempt, error) {
+	// create context
+	ctx, cancel := contextGenerator(ctx, m.Timeout)
+	defer cancel()
+	attemptRows, err := m.DB.GetWebhookDeliveryAttempts(ctx, deliveryID)
+	if err != nil {
+		return nil, translateDBError(ctx, err)
+	}
+	attempts := make([]*WebhookDeliveryAttempt, 0, len(attemptRows))
+	for _, attemptInfo := range attemptRows {
+		attempts = append(attempts, &WebhookDeliveryAttempt{
+			ID:          attemptInfo.ID,
+			DeliveryID:  attemptInfo.DeliveryID,
+			Attempt:     attemptInfo.Attempt,
+			StatusCode:  int(attemptInfo.StatusCode.Int32),
+			Error:       attemptInfo.Error,
+			Duration:    time.Duration(attemptInfo.DurationMs) * time.Millisecond,
+			AttemptedAt: attemptInfo.AttemptedAt,
+		})
+	}
+	return attempts, nil
+}
+
+// RetryWebhookDelivery() requeues a dead delivery, e.g once the partner has fixed their
+// endpoint. It gets a fresh set of attempts.
+func (m WebhooksManagerModel) RetryWebhookDelivery(ctx context.Context, id int64) error {
+	// create context
+	ctx, cancel := contextGenerator(ctx, m.Timeout)
+	defer cancel()
+	requeued, err := m.DB.RetryWebhookDelivery(ctx, id)
+	if err != nil {
+		return translateDBError(ctx, err)
+	}
+	if requeued == 0 {
+		return ErrWebhookDeliveryNotRetryable
+	}
+	return nil
+}
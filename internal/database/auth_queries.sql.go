@@ -7,30 +7,259 @@ package database
 
 import (
 	"context"
+	"time"
 )
 
+const addPermissionForUser = `-- name: AddPermissionForUser :execrows
+INSERT INTO users_permissions (user_id, permission_id)
+SELECT $1, id
+FROM permissions
+WHERE code = $2
+ON CONFLICT DO NOTHING
+`
+
+type AddPermissionForUserParams struct {
+	UserID int32
+	Code   string
+}
+
+// Granting a permission the user already holds is a no-op.
+func (q *Queries) AddPermissionForUser(ctx context.Context, arg AddPermissionForUserParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, addPermissionForUser, arg.UserID, arg.Code)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const createApiKey = `-- name: CreateApiKey :one
+INSERT INTO api_keys (user_id, hash)
+VALUES ($1, $2)
+RETURNING id, created_at
+`
+
+type CreateApiKeyParams struct {
+	UserID int32
+	Hash   []byte
+}
+
+type CreateApiKeyRow struct {
+	ID        int32
+	CreatedAt time.Time
+}
+
+func (q *Queries) CreateApiKey(ctx context.Context, arg CreateApiKeyParams) (CreateApiKeyRow, error) {
+	row := q.db.QueryRowContext(ctx, createApiKey, arg.UserID, arg.Hash)
+	var i CreateApiKeyRow
+	err := row.Scan(&i.ID, &i.CreatedAt)
+	return i, err
+}
+
+const createUser = `-- name: CreateUser :one
+INSERT INTO users (email, name)
+VALUES ($1, $2)
+RETURNING id, created_at, updated_at
+`
+
+type CreateUserParams struct {
+	Email string
+	Name  string
+}
+
+type CreateUserRow struct {
+	ID        int32
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (CreateUserRow, error) {
+	row := q.db.QueryRowContext(ctx, createUser, arg.Email, arg.Name)
+	var i CreateUserRow
+	err := row.Scan(&i.ID, &i.CreatedAt, &i.UpdatedAt)
+	return i, err
+}
+
+const disableUserByEmail = `-- name: DisableUserByEmail :one
+UPDATE users
+SET
+    disabled_at = COALESCE(disabled_at, NOW()),
+    updated_at = NOW()
+WHERE email = $1
+RETURNING id
+`
+
+func (q *Queries) DisableUserByEmail(ctx context.Context, email string) (int32, error) {
+	row := q.db.QueryRowContext(ctx, disableUserByEmail, email)
+	var id int32
+	err := row.Scan(&id)
+	return id, err
+}
+
+const getAllPermissionsForUser = `-- name: GetAllPermissionsForUser :many
+SELECT p.code
+FROM permissions p
+JOIN users_permissions up ON up.permission_id = p.id
+WHERE up.user_id = $1
+ORDER BY p.code
+`
+
+func (q *Queries) GetAllPermissionsForUser(ctx context.Context, userID int32) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, getAllPermissionsForUser, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			return nil, err
+		}
+		items = append(items, code)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getApiKeysByUserId = `-- name: GetApiKeysByUserId :many
+SELECT
+    id,
+    user_id,
+    hash,
+    created_at,
+    revoked_at
+FROM api_keys
+WHERE user_id = $1
+ORDER BY id
+`
+
+func (q *Queries) GetApiKeysByUserId(ctx context.Context, userID int32) ([]ApiKey, error) {
+	rows, err := q.db.QueryContext(ctx, getApiKeysByUserId, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ApiKey
+	for rows.Next() {
+		var i ApiKey
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Hash,
+			&i.CreatedAt,
+			&i.RevokedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getForApiKey = `-- name: GetForApiKey :one
-SELECT 
+SELECT
+    u.id,
+    u.email,
+    u.name,
+    u.created_at,
+    u.updated_at
+FROM users u
+JOIN api_keys k ON k.user_id = u.id
+WHERE k.hash = $1
+AND k.revoked_at IS NULL
+AND u.disabled_at IS NULL
+`
+
+type GetForApiKeyRow struct {
+	ID        int32
+	Email     string
+	Name      string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (q *Queries) GetForApiKey(ctx context.Context, hash []byte) (GetForApiKeyRow, error) {
+	row := q.db.QueryRowContext(ctx, getForApiKey, hash)
+	var i GetForApiKeyRow
+	err := row.Scan(
+		&i.ID,
+		&i.Email,
+		&i.Name,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getUserByEmail = `-- name: GetUserByEmail :one
+SELECT
     id,
     email,
     name,
-    api_key,
     created_at,
-    updated_at
+    updated_at,
+    disabled_at
 FROM users
-WHERE api_key = $1
+WHERE email = $1
 `
 
-func (q *Queries) GetForApiKey(ctx context.Context, apiKey []byte) (User, error) {
-	row := q.db.QueryRowContext(ctx, getForApiKey, apiKey)
+func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByEmail, email)
 	var i User
 	err := row.Scan(
 		&i.ID,
 		&i.Email,
 		&i.Name,
-		&i.ApiKey,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.DisabledAt,
 	)
 	return i, err
 }
+
+const removePermissionForUser = `-- name: RemovePermissionForUser :execrows
+DELETE FROM users_permissions up
+USING permissions p
+WHERE up.permission_id = p.id
+AND up.user_id = $1
+AND p.code = $2
+`
+
+type RemovePermissionForUserParams struct {
+	UserID int32
+	Code   string
+}
+
+func (q *Queries) RemovePermissionForUser(ctx context.Context, arg RemovePermissionForUserParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, removePermissionForUser, arg.UserID, arg.Code)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const revokeApiKey = `-- name: RevokeApiKey :execrows
+UPDATE api_keys
+SET revoked_at = NOW()
+WHERE id = $1
+AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeApiKey(ctx context.Context, id int32) (int64, error) {
+	result, err := q.db.ExecContext(ctx, revokeApiKey, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
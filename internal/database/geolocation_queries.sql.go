@@ -7,12 +7,13 @@ package database
 
 import (
 	"context"
+	"database/sql"
 	"time"
 )
 
 const createNewGeoLocation = `-- name: CreateNewGeoLocation :one
-INSERT INTO geolocations (county, sub_county, location, sub_location)
-VALUES ($1, $2, $3, $4)
+INSERT INTO geolocations (county, sub_county, location, sub_location, latitude, longitude)
+VALUES ($1, $2, $3, $4, $5, $6)
 RETURNING id, created_at
 `
 
@@ -21,6 +22,8 @@ type CreateNewGeoLocationParams struct {
 	SubCounty   string
 	Location    string
 	SubLocation string
+	Latitude    sql.NullFloat64
+	Longitude   sql.NullFloat64
 }
 
 type CreateNewGeoLocationRow struct {
@@ -34,8 +37,86 @@ func (q *Queries) CreateNewGeoLocation(ctx context.Context, arg CreateNewGeoLoca
 		arg.SubCounty,
 		arg.Location,
 		arg.SubLocation,
+		arg.Latitude,
+		arg.Longitude,
 	)
 	var i CreateNewGeoLocationRow
 	err := row.Scan(&i.ID, &i.CreatedAt)
 	return i, err
 }
+
+const getAllGeoLocations = `-- name: GetAllGeoLocations :many
+SELECT
+    id,
+    county,
+    sub_county,
+    location,
+    sub_location,
+    created_at,
+    latitude,
+    longitude
+FROM geolocations
+ORDER BY id
+`
+
+func (q *Queries) GetAllGeoLocations(ctx context.Context) ([]Geolocation, error) {
+	rows, err := q.db.QueryContext(ctx, getAllGeoLocations)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Geolocation
+	for rows.Next() {
+		var i Geolocation
+		if err := rows.Scan(
+			&i.ID,
+			&i.County,
+			&i.SubCounty,
+			&i.Location,
+			&i.SubLocation,
+			&i.CreatedAt,
+			&i.Latitude,
+			&i.Longitude,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getGeoLocationById = `-- name: GetGeoLocationById :one
+SELECT
+    id,
+    county,
+    sub_county,
+    location,
+    sub_location,
+    created_at,
+    latitude,
+    longitude
+FROM geolocations
+WHERE id = $1
+`
+
+func (q *Queries) GetGeoLocationById(ctx context.Context, id int32) (Geolocation, error) {
+	row := q.db.QueryRowContext(ctx, getGeoLocationById, id)
+	var i Geolocation
+	err := row.Scan(
+		&i.ID,
+		&i.County,
+		&i.SubCounty,
+		&i.Location,
+		&i.SubLocation,
+		&i.CreatedAt,
+		&i.Latitude,
+		&i.Longitude,
+	)
+	return i, err
+}
@@ -7,12 +7,13 @@ package database
 
 import (
 	"context"
+	"database/sql"
 	"time"
 )
 
 const createNewHousehold = `-- name: CreateNewHousehold :one
-INSERT INTO households (program_id, geolocation_id, name) 
-VALUES ($1, $2, $3) 
+INSERT INTO households (program_id, geolocation_id, name, latitude, longitude) 
+VALUES ($1, $2, $3, $4, $5) 
 RETURNING id, created_at
 `
 
@@ -20,6 +21,8 @@ type CreateNewHouseholdParams struct {
 	ProgramID     int32
 	GeolocationID int32
 	Name          string
+	Latitude      sql.NullFloat64
+	Longitude     sql.NullFloat64
 }
 
 type CreateNewHouseholdRow struct {
@@ -28,24 +31,31 @@ type CreateNewHouseholdRow struct {
 }
 
 func (q *Queries) CreateNewHousehold(ctx context.Context, arg CreateNewHouseholdParams) (CreateNewHouseholdRow, error) {
-	row := q.db.QueryRowContext(ctx, createNewHousehold, arg.ProgramID, arg.GeolocationID, arg.Name)
+	row := q.db.QueryRowContext(ctx, createNewHousehold,
+		arg.ProgramID,
+		arg.GeolocationID,
+		arg.Name,
+		arg.Latitude,
+		arg.Longitude,
+	)
 	var i CreateNewHouseholdRow
 	err := row.Scan(&i.ID, &i.CreatedAt)
 	return i, err
 }
 
 const createNewHouseholdHead = `-- name: CreateNewHouseholdHead :one
-INSERT INTO household_heads (household_id, name, national_id, phone_number, age)
-VALUES ($1, $2, $3, $4, $5)
+INSERT INTO household_heads (household_id, name, national_id, national_id_type, phone_number, age)
+VALUES ($1, $2, $3, $4, $5, $6)
 RETURNING id, created_at, updated_at
 `
 
 type CreateNewHouseholdHeadParams struct {
-	HouseholdID int32
-	Name        string
-	NationalID  string
-	PhoneNumber string
-	Age         int32
+	HouseholdID    int32
+	Name           string
+	NationalID     string
+	NationalIDType string
+	PhoneNumber    string
+	Age            int32
 }
 
 type CreateNewHouseholdHeadRow struct {
@@ -59,6 +69,7 @@ func (q *Queries) CreateNewHouseholdHead(ctx context.Context, arg CreateNewHouse
 		arg.HouseholdID,
 		arg.Name,
 		arg.NationalID,
+		arg.NationalIDType,
 		arg.PhoneNumber,
 		arg.Age,
 	)
@@ -109,7 +120,8 @@ SELECT
     hh.id AS household_head_id, 
     hh.name AS household_head_name, 
     hh.phone_number,
-    COUNT(hm.id) AS household_member_count
+    COUNT(hm.id) AS household_member_count,
+    GREATEST(h.created_at, p.updated_at, hh.updated_at, MAX(hm.updated_at))::TIMESTAMPTZ AS updated_at
 FROM households h
 JOIN programs p ON h.program_id = p.id
 JOIN geolocations g ON h.geolocation_id = g.id
@@ -130,8 +142,10 @@ type GetHouseHoldInformationRow struct {
 	HouseholdHeadName    string
 	PhoneNumber          string
 	HouseholdMemberCount int64
+	UpdatedAt            time.Time
 }
 
+// updated_at is the last change to the house hold, its program, head or members.
 func (q *Queries) GetHouseHoldInformation(ctx context.Context, id int32) (GetHouseHoldInformationRow, error) {
 	row := q.db.QueryRowContext(ctx, getHouseHoldInformation, id)
 	var i GetHouseHoldInformationRow
@@ -146,6 +160,7 @@ func (q *Queries) GetHouseHoldInformation(ctx context.Context, id int32) (GetHou
 		&i.HouseholdHeadName,
 		&i.PhoneNumber,
 		&i.HouseholdMemberCount,
+		&i.UpdatedAt,
 	)
 	return i, err
 }
@@ -159,7 +174,9 @@ SELECT
     phone_number,
     age,
     created_at,
-    updated_at
+    updated_at,
+    national_id_type,
+    erased_at
 FROM household_heads
 WHERE household_id = $1
 `
@@ -176,6 +193,221 @@ func (q *Queries) GetHouseholdHeadByHouseholdId(ctx context.Context, householdID
 		&i.Age,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.NationalIDType,
+		&i.ErasedAt,
 	)
 	return i, err
 }
+
+const getHouseholdMembersByHouseholdId = `-- name: GetHouseholdMembersByHouseholdId :many
+SELECT
+    id,
+    household_id,
+    name,
+    age,
+    relation,
+    created_at,
+    updated_at,
+    erased_at
+FROM household_members
+WHERE household_id = $1
+ORDER BY id
+`
+
+func (q *Queries) GetHouseholdMembersByHouseholdId(ctx context.Context, householdID int32) ([]HouseholdMember, error) {
+	rows, err := q.db.QueryContext(ctx, getHouseholdMembersByHouseholdId, householdID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []HouseholdMember
+	for rows.Next() {
+		var i HouseholdMember
+		if err := rows.Scan(
+			&i.ID,
+			&i.HouseholdID,
+			&i.Name,
+			&i.Age,
+			&i.Relation,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.ErasedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getHouseHoldsForGeoJSON = `-- name: GetHouseHoldsForGeoJSON :many
+SELECT
+    h.id AS household_id,
+    h.name,
+    h.program_id,
+    p.name AS program_name,
+    h.geolocation_id,
+    g.county,
+    g.sub_county,
+    COALESCE(h.latitude, g.latitude)::DOUBLE PRECISION AS latitude,
+    COALESCE(h.longitude, g.longitude)::DOUBLE PRECISION AS longitude
+FROM households h
+JOIN programs p ON h.program_id = p.id
+JOIN geolocations g ON h.geolocation_id = g.id
+WHERE COALESCE(h.latitude, g.latitude) IS NOT NULL
+AND COALESCE(h.longitude, g.longitude) IS NOT NULL
+AND ($1::INT = 0 OR EXISTS (
+    SELECT 1 FROM household_enrollments e
+    WHERE e.household_id = h.id
+    AND e.program_id = $1::INT
+    AND e.status <> 'exited'
+))
+AND ($2::INT = 0 OR h.geolocation_id = $2::INT)
+AND ($3::TEXT = '' OR g.county = $3::TEXT)
+AND ($4::TEXT = '' OR g.sub_county = $4::TEXT)
+AND COALESCE(h.latitude, g.latitude) BETWEEN $5::DOUBLE PRECISION AND $6::DOUBLE PRECISION
+AND COALESCE(h.longitude, g.longitude) BETWEEN $7::DOUBLE PRECISION AND $8::DOUBLE PRECISION
+AND h.id > $9::INT
+ORDER BY h.id
+LIMIT $10
+`
+
+type GetHouseHoldsForGeoJSONParams struct {
+	ProgramID     int32
+	GeolocationID int32
+	County        string
+	SubCounty     string
+	MinLatitude   float64
+	MaxLatitude   float64
+	MinLongitude  float64
+	MaxLongitude  float64
+	AfterID       int32
+	Limit         int32
+}
+
+type GetHouseHoldsForGeoJSONRow struct {
+	HouseholdID   int32
+	Name          string
+	ProgramID     int32
+	ProgramName   string
+	GeolocationID int32
+	County        string
+	SubCounty     string
+	Latitude      float64
+	Longitude     float64
+}
+
+// Keyset paginated on the ID, pass 0 as after_id for the first page. program_id matches the
+// house holds enrolled in the program and not exited, whichever program they registered under.
+func (q *Queries) GetHouseHoldsForGeoJSON(ctx context.Context, arg GetHouseHoldsForGeoJSONParams) ([]GetHouseHoldsForGeoJSONRow, error) {
+	rows, err := q.db.QueryContext(ctx, getHouseHoldsForGeoJSON,
+		arg.ProgramID,
+		arg.GeolocationID,
+		arg.County,
+		arg.SubCounty,
+		arg.MinLatitude,
+		arg.MaxLatitude,
+		arg.MinLongitude,
+		arg.MaxLongitude,
+		arg.AfterID,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetHouseHoldsForGeoJSONRow
+	for rows.Next() {
+		var i GetHouseHoldsForGeoJSONRow
+		if err := rows.Scan(
+			&i.HouseholdID,
+			&i.Name,
+			&i.ProgramID,
+			&i.ProgramName,
+			&i.GeolocationID,
+			&i.County,
+			&i.SubCounty,
+			&i.Latitude,
+			&i.Longitude,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getHouseholdHeadPhoneNumbersAfterId = `-- name: GetHouseholdHeadPhoneNumbersAfterId :many
+SELECT
+    id,
+    phone_number
+FROM household_heads
+WHERE id > $1
+AND erased_at IS NULL
+ORDER BY id
+LIMIT $2
+`
+
+type GetHouseholdHeadPhoneNumbersAfterIdParams struct {
+	ID    int32
+	Limit int32
+}
+
+type GetHouseholdHeadPhoneNumbersAfterIdRow struct {
+	ID          int32
+	PhoneNumber string
+}
+
+// Erased heads have no phone number and are skipped.
+func (q *Queries) GetHouseholdHeadPhoneNumbersAfterId(ctx context.Context, arg GetHouseholdHeadPhoneNumbersAfterIdParams) ([]GetHouseholdHeadPhoneNumbersAfterIdRow, error) {
+	rows, err := q.db.QueryContext(ctx, getHouseholdHeadPhoneNumbersAfterId, arg.ID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetHouseholdHeadPhoneNumbersAfterIdRow
+	for rows.Next() {
+		var i GetHouseholdHeadPhoneNumbersAfterIdRow
+		if err := rows.Scan(&i.ID, &i.PhoneNumber); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const updateHouseholdHeadPhoneNumber = `-- name: UpdateHouseholdHeadPhoneNumber :exec
+UPDATE household_heads
+SET
+    phone_number = $2,
+    updated_at = NOW()
+WHERE id = $1
+`
+
+type UpdateHouseholdHeadPhoneNumberParams struct {
+	ID          int32
+	PhoneNumber string
+}
+
+func (q *Queries) UpdateHouseholdHeadPhoneNumber(ctx context.Context, arg UpdateHouseholdHeadPhoneNumberParams) error {
+	_, err := q.db.ExecContext(ctx, updateHouseholdHeadPhoneNumber, arg.ID, arg.PhoneNumber)
+	return err
+}
@@ -5,9 +5,34 @@
 package database
 
 import (
+	"database/sql"
+	"encoding/json"
 	"time"
 )
 
+type ApiKey struct {
+	ID        int32
+	UserID    int32
+	Hash      []byte
+	CreatedAt time.Time
+	RevokedAt sql.NullTime
+}
+
+type DataSubjectRequest struct {
+	ID           int32
+	Kind         string
+	NationalID   sql.NullString
+	Reason       string
+	Status       string
+	RequestedBy  int32
+	DecidedBy    sql.NullInt32
+	DecidedAt    sql.NullTime
+	HeadsFound   int32
+	MembersFound int32
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
 type Geolocation struct {
 	ID          int32
 	County      string
@@ -15,6 +40,8 @@ type Geolocation struct {
 	Location    string
 	SubLocation string
 	CreatedAt   time.Time
+	Latitude    sql.NullFloat64
+	Longitude   sql.NullFloat64
 }
 
 type Household struct {
@@ -23,19 +50,35 @@ type Household struct {
 	GeolocationID int32
 	Name          string
 	CreatedAt     time.Time
+	Latitude      sql.NullFloat64
+	Longitude     sql.NullFloat64
 }
 
-type HouseholdHead struct {
+type HouseholdEnrollment struct {
 	ID          int32
 	HouseholdID int32
-	Name        string
-	NationalID  string
-	PhoneNumber string
-	Age         int32
+	ProgramID   int32
+	Status      string
+	EnrolledAt  time.Time
+	ExitReason  sql.NullString
+	ExitedAt    sql.NullTime
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
 }
 
+type HouseholdHead struct {
+	ID             int32
+	HouseholdID    int32
+	Name           string
+	NationalID     string
+	PhoneNumber    string
+	Age            int32
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	NationalIDType string
+	ErasedAt       sql.NullTime
+}
+
 type HouseholdMember struct {
 	ID          int32
 	HouseholdID int32
@@ -44,22 +87,106 @@ type HouseholdMember struct {
 	Relation    string
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
+	ErasedAt    sql.NullTime
 }
 
-type Program struct {
-	ID          int32
-	Name        string
-	Category    string
-	Description string
+type IdempotencyKey struct {
+	ID             int32
+	UserID         int32
+	IdempotencyKey string
+	RequestHash    []byte
+	StatusCode     sql.NullInt32
+	ContentType    string
+	ResponseBody   []byte
+	CreatedAt      time.Time
+	ExpiresAt      time.Time
+	LockedUntil    sql.NullTime
+}
+
+type OutboxEvent struct {
+	ID           int64
+	EventType    string
+	Payload      json.RawMessage
+	CreatedAt    time.Time
+	DispatchedAt sql.NullTime
+}
+
+type Permission struct {
+	ID   int32
+	Code string
+}
+
+type PiiReveal struct {
+	ID          int64
+	UserID      int32
+	HouseholdID int32
+	Source      string
+	Reason      string
+	RequestID   string
 	CreatedAt   time.Time
-	UpdatedAt   time.Time
+}
+
+type Program struct {
+	ID                 int32
+	Name               string
+	Category           string
+	Description        string
+	CreatedAt          time.Time
+	UpdatedAt          time.Time
+	EnrollmentOpensAt  sql.NullTime
+	EnrollmentClosesAt sql.NullTime
+	HouseholdCap       sql.NullInt32
+}
+
+type ProgramCountyQuota struct {
+	ProgramID int32
+	County    string
+	Quota     int32
 }
 
 type User struct {
-	ID        int32
-	Email     string
-	Name      string
-	ApiKey    []byte
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	ID         int32
+	Email      string
+	Name       string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	DisabledAt sql.NullTime
+}
+
+type UsersPermission struct {
+	UserID       int32
+	PermissionID int32
+}
+
+type WebhookDelivery struct {
+	ID             int64
+	SubscriptionID int32
+	EventID        int64
+	Status         string
+	Attempts       int32
+	NextAttemptAt  time.Time
+	LastStatusCode sql.NullInt32
+	LastError      string
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+type WebhookDeliveryAttempt struct {
+	ID          int64
+	DeliveryID  int64
+	Attempt     int32
+	StatusCode  sql.NullInt32
+	Error       string
+	DurationMs  int32
+	AttemptedAt time.Time
+}
+
+type WebhookSubscription struct {
+	ID         int32
+	Url        string
+	Secret     string
+	EventTypes []string
+	Active     bool
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
 }
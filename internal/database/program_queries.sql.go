@@ -7,19 +7,101 @@ package database
 
 import (
 	"context"
+	"database/sql"
 	"time"
 )
 
+const countHouseholdsByCountyForProgram = `-- name: CountHouseholdsByCountyForProgram :many
+SELECT
+    g.county,
+    COUNT(e.id) AS enrolled
+FROM household_enrollments e
+JOIN households h ON h.id = e.household_id
+JOIN geolocations g ON h.geolocation_id = g.id
+WHERE e.program_id = $1
+AND e.status <> 'exited'
+GROUP BY g.county
+ORDER BY g.county
+`
+
+type CountHouseholdsByCountyForProgramRow struct {
+	County   string
+	Enrolled int64
+}
+
+func (q *Queries) CountHouseholdsByCountyForProgram(ctx context.Context, programID int32) ([]CountHouseholdsByCountyForProgramRow, error) {
+	rows, err := q.db.QueryContext(ctx, countHouseholdsByCountyForProgram, programID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []CountHouseholdsByCountyForProgramRow
+	for rows.Next() {
+		var i CountHouseholdsByCountyForProgramRow
+		if err := rows.Scan(&i.County, &i.Enrolled); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const countHouseholdsByProgramId = `-- name: CountHouseholdsByProgramId :one
+SELECT COUNT(*)
+FROM household_enrollments
+WHERE program_id = $1
+AND status <> 'exited'
+`
+
+// Exited house holds no longer take up a place in the program.
+func (q *Queries) CountHouseholdsByProgramId(ctx context.Context, programID int32) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countHouseholdsByProgramId, programID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countHouseholdsByProgramIdAndCounty = `-- name: CountHouseholdsByProgramIdAndCounty :one
+SELECT COUNT(*)
+FROM household_enrollments e
+JOIN households h ON h.id = e.household_id
+JOIN geolocations g ON h.geolocation_id = g.id
+WHERE e.program_id = $1
+AND e.status <> 'exited'
+AND g.county = $2
+`
+
+type CountHouseholdsByProgramIdAndCountyParams struct {
+	ProgramID int32
+	County    string
+}
+
+func (q *Queries) CountHouseholdsByProgramIdAndCounty(ctx context.Context, arg CountHouseholdsByProgramIdAndCountyParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countHouseholdsByProgramIdAndCounty, arg.ProgramID, arg.County)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const createNewProgram = `-- name: CreateNewProgram :one
-INSERT INTO programs (name, category, description) 
-VALUES ($1, $2, $3) 
+INSERT INTO programs (name, category, description, enrollment_opens_at, enrollment_closes_at, household_cap)
+VALUES ($1, $2, $3, $4, $5, $6)
 RETURNING id, created_at, updated_at
 `
 
 type CreateNewProgramParams struct {
-	Name        string
-	Category    string
-	Description string
+	Name               string
+	Category           string
+	Description        string
+	EnrollmentOpensAt  sql.NullTime
+	EnrollmentClosesAt sql.NullTime
+	HouseholdCap       sql.NullInt32
 }
 
 type CreateNewProgramRow struct {
@@ -29,20 +111,104 @@ type CreateNewProgramRow struct {
 }
 
 func (q *Queries) CreateNewProgram(ctx context.Context, arg CreateNewProgramParams) (CreateNewProgramRow, error) {
-	row := q.db.QueryRowContext(ctx, createNewProgram, arg.Name, arg.Category, arg.Description)
+	row := q.db.QueryRowContext(ctx, createNewProgram,
+		arg.Name,
+		arg.Category,
+		arg.Description,
+		arg.EnrollmentOpensAt,
+		arg.EnrollmentClosesAt,
+		arg.HouseholdCap,
+	)
 	var i CreateNewProgramRow
 	err := row.Scan(&i.ID, &i.CreatedAt, &i.UpdatedAt)
 	return i, err
 }
 
+const createProgramCountyQuota = `-- name: CreateProgramCountyQuota :exec
+INSERT INTO program_county_quotas (program_id, county, quota)
+VALUES ($1, $2, $3)
+`
+
+type CreateProgramCountyQuotaParams struct {
+	ProgramID int32
+	County    string
+	Quota     int32
+}
+
+func (q *Queries) CreateProgramCountyQuota(ctx context.Context, arg CreateProgramCountyQuotaParams) error {
+	_, err := q.db.ExecContext(ctx, createProgramCountyQuota, arg.ProgramID, arg.County, arg.Quota)
+	return err
+}
+
+const deleteProgramCountyQuotas = `-- name: DeleteProgramCountyQuotas :exec
+DELETE FROM program_county_quotas
+WHERE program_id = $1
+`
+
+func (q *Queries) DeleteProgramCountyQuotas(ctx context.Context, programID int32) error {
+	_, err := q.db.ExecContext(ctx, deleteProgramCountyQuotas, programID)
+	return err
+}
+
+const getAllPrograms = `-- name: GetAllPrograms :many
+SELECT
+    id,
+    name,
+    category,
+    description,
+    created_at,
+    updated_at,
+    enrollment_opens_at,
+    enrollment_closes_at,
+    household_cap
+FROM programs
+ORDER BY id
+`
+
+func (q *Queries) GetAllPrograms(ctx context.Context) ([]Program, error) {
+	rows, err := q.db.QueryContext(ctx, getAllPrograms)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Program
+	for rows.Next() {
+		var i Program
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Category,
+			&i.Description,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.EnrollmentOpensAt,
+			&i.EnrollmentClosesAt,
+			&i.HouseholdCap,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getProgramById = `-- name: GetProgramById :one
-SELECT 
+SELECT
     id,
     name,
     category,
     description,
     created_at,
-    updated_at
+    updated_at,
+    enrollment_opens_at,
+    enrollment_closes_at,
+    household_cap
 FROM programs
 WHERE id = $1
 `
@@ -57,26 +223,111 @@ func (q *Queries) GetProgramById(ctx context.Context, id int32) (Program, error)
 		&i.Description,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.EnrollmentOpensAt,
+		&i.EnrollmentClosesAt,
+		&i.HouseholdCap,
 	)
 	return i, err
 }
 
+const getProgramCountyQuota = `-- name: GetProgramCountyQuota :one
+SELECT quota
+FROM program_county_quotas
+WHERE program_id = $1
+AND county = $2
+`
+
+type GetProgramCountyQuotaParams struct {
+	ProgramID int32
+	County    string
+}
+
+func (q *Queries) GetProgramCountyQuota(ctx context.Context, arg GetProgramCountyQuotaParams) (int32, error) {
+	row := q.db.QueryRowContext(ctx, getProgramCountyQuota, arg.ProgramID, arg.County)
+	var quota int32
+	err := row.Scan(&quota)
+	return quota, err
+}
+
+const getProgramCountyQuotas = `-- name: GetProgramCountyQuotas :many
+SELECT
+    program_id,
+    county,
+    quota
+FROM program_county_quotas
+WHERE program_id = $1
+ORDER BY county
+`
+
+func (q *Queries) GetProgramCountyQuotas(ctx context.Context, programID int32) ([]ProgramCountyQuota, error) {
+	rows, err := q.db.QueryContext(ctx, getProgramCountyQuotas, programID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ProgramCountyQuota
+	for rows.Next() {
+		var i ProgramCountyQuota
+		if err := rows.Scan(&i.ProgramID, &i.County, &i.Quota); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getProgramEnrollmentLimitsForUpdate = `-- name: GetProgramEnrollmentLimitsForUpdate :one
+SELECT
+    enrollment_opens_at,
+    enrollment_closes_at,
+    household_cap
+FROM programs
+WHERE id = $1
+FOR UPDATE
+`
+
+type GetProgramEnrollmentLimitsForUpdateRow struct {
+	EnrollmentOpensAt  sql.NullTime
+	EnrollmentClosesAt sql.NullTime
+	HouseholdCap       sql.NullInt32
+}
+
+// Locks the program, so that enrollments in it are checked and made one at a time.
+func (q *Queries) GetProgramEnrollmentLimitsForUpdate(ctx context.Context, id int32) (GetProgramEnrollmentLimitsForUpdateRow, error) {
+	row := q.db.QueryRowContext(ctx, getProgramEnrollmentLimitsForUpdate, id)
+	var i GetProgramEnrollmentLimitsForUpdateRow
+	err := row.Scan(&i.EnrollmentOpensAt, &i.EnrollmentClosesAt, &i.HouseholdCap)
+	return i, err
+}
+
 const updateProgramById = `-- name: UpdateProgramById :one
 UPDATE programs
-SET 
+SET
     name = $2,
     category = $3,
     description = $4,
+    enrollment_opens_at = $5,
+    enrollment_closes_at = $6,
+    household_cap = $7,
     updated_at = NOW()
 WHERE id = $1
 RETURNING updated_at
 `
 
 type UpdateProgramByIdParams struct {
-	ID          int32
-	Name        string
-	Category    string
-	Description string
+	ID                 int32
+	Name               string
+	Category           string
+	Description        string
+	EnrollmentOpensAt  sql.NullTime
+	EnrollmentClosesAt sql.NullTime
+	HouseholdCap       sql.NullInt32
 }
 
 func (q *Queries) UpdateProgramById(ctx context.Context, arg UpdateProgramByIdParams) (time.Time, error) {
@@ -85,6 +336,9 @@ func (q *Queries) UpdateProgramById(ctx context.Context, arg UpdateProgramByIdPa
 		arg.Name,
 		arg.Category,
 		arg.Description,
+		arg.EnrollmentOpensAt,
+		arg.EnrollmentClosesAt,
+		arg.HouseholdCap,
 	)
 	var updated_at time.Time
 	err := row.Scan(&updated_at)
@@ -6,6 +6,7 @@ import (
 )
 
 // InitJSONLogger initializes and returns a new zap logger in JSON format.
+// Every entry passes through the redacting core, so secrets and PII are masked.
 func InitJSONLogger() (*zap.Logger, error) {
 	config := zap.Config{
 		Encoding:         "json",
@@ -27,5 +28,7 @@ func InitJSONLogger() (*zap.Logger, error) {
 		},
 	}
 
-	return config.Build()
+	return config.Build(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return NewRedactingCore(core, DefaultRedactionConfig())
+	}))
 }
@@ -1,10 +1,80 @@
 -- name: GetForApiKey :one
-SELECT 
+SELECT
+    u.id,
+    u.email,
+    u.name,
+    u.created_at,
+    u.updated_at
+FROM users u
+JOIN api_keys k ON k.user_id = u.id
+WHERE k.hash = $1
+AND k.revoked_at IS NULL
+AND u.disabled_at IS NULL;
+
+-- name: CreateUser :one
+INSERT INTO users (email, name)
+VALUES ($1, $2)
+RETURNING id, created_at, updated_at;
+
+-- name: GetUserByEmail :one
+SELECT
     id,
     email,
     name,
-    api_key,
     created_at,
-    updated_at
+    updated_at,
+    disabled_at
 FROM users
-WHERE api_key = $1;
\ No newline at end of file
+WHERE email = $1;
+
+-- name: DisableUserByEmail :one
+UPDATE users
+SET
+    disabled_at = COALESCE(disabled_at, NOW()),
+    updated_at = NOW()
+WHERE email = $1
+RETURNING id;
+
+-- name: CreateApiKey :one
+INSERT INTO api_keys (user_id, hash)
+VALUES ($1, $2)
+RETURNING id, created_at;
+
+-- name: GetApiKeysByUserId :many
+SELECT
+    id,
+    user_id,
+    hash,
+    created_at,
+    revoked_at
+FROM api_keys
+WHERE user_id = $1
+ORDER BY id;
+
+-- name: RevokeApiKey :execrows
+UPDATE api_keys
+SET revoked_at = NOW()
+WHERE id = $1
+AND revoked_at IS NULL;
+
+-- name: GetAllPermissionsForUser :many
+SELECT p.code
+FROM permissions p
+JOIN users_permissions up ON up.permission_id = p.id
+WHERE up.user_id = $1
+ORDER BY p.code;
+
+-- name: AddPermissionForUser :execrows
+-- Granting a permission the user already holds is a no-op.
+INSERT INTO users_permissions (user_id, permission_id)
+SELECT sqlc.arg('user_id'), id
+FROM permissions
+WHERE code = sqlc.arg('code')
+ON CONFLICT DO NOTHING;
+
+-- name: RemovePermissionForUser :execrows
+DELETE FROM users_permissions up
+USING permissions p
+WHERE up.permission_id = p.id
+AND up.user_id = sqlc.arg('user_id')
+AND p.code = sqlc.arg('code');
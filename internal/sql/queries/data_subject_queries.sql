@@ -0,0 +1,176 @@
+-- name: CreateDataSubjectRequest :one
+INSERT INTO data_subject_requests (kind, national_id, reason, requested_by, heads_found, members_found)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id, status, created_at, updated_at;
+
+-- name: GetAllDataSubjectRequests :many
+SELECT
+    r.id,
+    r.kind,
+    r.reason,
+    r.status,
+    r.requested_by,
+    ru.email AS requested_by_email,
+    r.decided_by,
+    du.email AS decided_by_email,
+    r.decided_at,
+    r.heads_found,
+    r.members_found,
+    r.created_at,
+    r.updated_at
+FROM data_subject_requests r
+JOIN users ru ON ru.id = r.requested_by
+LEFT JOIN users du ON du.id = r.decided_by
+WHERE (sqlc.arg('status')::TEXT = '' OR r.status = sqlc.arg('status')::TEXT)
+ORDER BY r.id DESC;
+
+-- name: GetPendingDataSubjectRequestForUpdate :one
+-- Locks the request so that it can't be decided twice at once.
+SELECT
+    id,
+    kind,
+    national_id,
+    reason,
+    status,
+    requested_by,
+    decided_by,
+    decided_at,
+    heads_found,
+    members_found,
+    created_at,
+    updated_at
+FROM data_subject_requests
+WHERE id = $1
+AND status = 'pending'
+FOR UPDATE;
+
+-- name: CompleteDataSubjectRequest :exec
+UPDATE data_subject_requests
+SET
+    status = 'completed',
+    national_id = NULL,
+    decided_by = $2,
+    decided_at = NOW(),
+    heads_found = $3,
+    members_found = $4,
+    updated_at = NOW()
+WHERE id = $1;
+
+-- name: RejectDataSubjectRequest :execrows
+UPDATE data_subject_requests
+SET
+    status = 'rejected',
+    national_id = NULL,
+    decided_by = $2,
+    decided_at = NOW(),
+    updated_at = NOW()
+WHERE id = $1
+AND status = 'pending';
+
+-- name: CountDataSubjectRecords :one
+-- Counts the data subject's heads, and the member records the trigger added for them.
+SELECT
+    COUNT(DISTINCT h.id) AS heads_found,
+    COUNT(m.id) AS members_found
+FROM household_heads h
+LEFT JOIN household_members m ON m.household_id = h.household_id
+    AND m.relation = 'Head'
+    AND m.erased_at IS NULL
+WHERE h.national_id = $1
+AND h.erased_at IS NULL;
+
+-- name: GetDataSubjectHouseholdHeads :many
+SELECT
+    h.id,
+    h.household_id,
+    h.name,
+    h.national_id,
+    h.national_id_type,
+    h.phone_number,
+    h.age,
+    h.created_at,
+    h.updated_at,
+    hh.program_id,
+    p.name AS program_name,
+    hh.geolocation_id,
+    g.county,
+    g.sub_county,
+    g.location,
+    g.sub_location,
+    hh.name AS household_name,
+    hh.latitude,
+    hh.longitude,
+    hh.created_at AS household_created_at
+FROM household_heads h
+JOIN households hh ON hh.id = h.household_id
+JOIN programs p ON p.id = hh.program_id
+JOIN geolocations g ON g.id = hh.geolocation_id
+WHERE h.national_id = $1
+AND h.erased_at IS NULL
+ORDER BY h.id;
+
+-- name: GetDataSubjectHouseholdMembers :many
+-- Gets the member records added for the data subject's heads. The other members of their
+-- house holds are other data subjects.
+SELECT
+    m.id,
+    m.household_id,
+    m.name,
+    m.age,
+    m.relation,
+    m.created_at,
+    m.updated_at
+FROM household_members m
+JOIN household_heads h ON h.household_id = m.household_id
+WHERE h.national_id = $1
+AND h.erased_at IS NULL
+AND m.relation = 'Head'
+AND m.erased_at IS NULL
+ORDER BY m.id;
+
+-- name: EraseDataSubjectOutboxEvents :exec
+-- Scrubs the names in the events published about the data subject's heads and house holds,
+-- the other erase queries match on the heads so this one must run first.
+UPDATE outbox_events e
+SET payload = jsonb_set(e.payload, '{name}', to_jsonb('[erased #' || (e.payload->>'id') || ']'))
+FROM household_heads h
+WHERE h.national_id = $1
+AND h.erased_at IS NULL
+AND (
+    (e.event_type = 'household.head_assigned' AND (e.payload->>'id')::INT = h.id)
+    OR (e.event_type = 'household.created' AND (e.payload->>'id')::INT = h.household_id)
+);
+
+-- name: EraseDataSubjectHouseholds :exec
+-- House holds are usually named after their head.
+UPDATE households hh
+SET name = '[erased #' || hh.id || ']'
+FROM household_heads h
+WHERE h.household_id = hh.id
+AND h.national_id = $1
+AND h.erased_at IS NULL;
+
+-- name: EraseDataSubjectMembers :execrows
+UPDATE household_members m
+SET
+    name = '[erased #' || m.id || ']',
+    erased_at = NOW(),
+    updated_at = NOW()
+FROM household_heads h
+WHERE h.household_id = m.household_id
+AND h.national_id = $1
+AND h.erased_at IS NULL
+AND m.relation = 'Head'
+AND m.erased_at IS NULL;
+
+-- name: EraseDataSubjectHeads :execrows
+-- Keeps the age and ID type, which only count towards the aggregates.
+UPDATE household_heads
+SET
+    name = '[erased #' || id || ']',
+    national_id = '',
+    phone_number = '',
+    erased_at = NOW(),
+    updated_at = NOW()
+WHERE national_id = $1
+AND erased_at IS NULL;
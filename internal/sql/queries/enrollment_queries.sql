@@ -0,0 +1,54 @@
+-- name: CreateHouseholdEnrollment :one
+INSERT INTO household_enrollments (household_id, program_id)
+VALUES ($1, $2)
+RETURNING id, status, enrolled_at, created_at, updated_at;
+
+-- name: GetHouseholdEnrollmentsByHouseholdId :many
+SELECT
+    e.id,
+    e.household_id,
+    e.program_id,
+    p.name AS program_name,
+    e.status,
+    e.enrolled_at,
+    e.exit_reason,
+    e.exited_at,
+    e.created_at,
+    e.updated_at
+FROM household_enrollments e
+JOIN programs p ON p.id = e.program_id
+WHERE e.household_id = $1
+ORDER BY e.id;
+
+-- name: GetHouseholdEnrollmentForUpdate :one
+-- Locks the enrollment, so that concurrent changes to its status are made one at a time.
+SELECT
+    id,
+    household_id,
+    program_id,
+    status,
+    enrolled_at,
+    exit_reason,
+    exited_at,
+    created_at,
+    updated_at
+FROM household_enrollments
+WHERE id = $1
+AND household_id = $2
+FOR UPDATE;
+
+-- name: UpdateHouseholdEnrollmentStatus :one
+UPDATE household_enrollments
+SET
+    status = $2,
+    exit_reason = $3,
+    exited_at = CASE WHEN $2 = 'exited' THEN NOW() END,
+    updated_at = NOW()
+WHERE id = $1
+RETURNING exited_at, updated_at;
+
+-- name: GetHouseholdCountyById :one
+SELECT g.county
+FROM households h
+JOIN geolocations g ON h.geolocation_id = g.id
+WHERE h.id = $1;
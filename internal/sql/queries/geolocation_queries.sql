@@ -1,5 +1,31 @@
 -- name: CreateNewGeoLocation :one
-INSERT INTO geolocations (county, sub_county, location, sub_location)
-VALUES ($1, $2, $3, $4)
+INSERT INTO geolocations (county, sub_county, location, sub_location, latitude, longitude)
+VALUES ($1, $2, $3, $4, $5, $6)
 RETURNING id, created_at;
 
+
+-- name: GetAllGeoLocations :many
+SELECT
+    id,
+    county,
+    sub_county,
+    location,
+    sub_location,
+    created_at,
+    latitude,
+    longitude
+FROM geolocations
+ORDER BY id;
+
+-- name: GetGeoLocationById :one
+SELECT
+    id,
+    county,
+    sub_county,
+    location,
+    sub_location,
+    created_at,
+    latitude,
+    longitude
+FROM geolocations
+WHERE id = $1;
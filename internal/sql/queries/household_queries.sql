@@ -1,11 +1,11 @@
 -- name: CreateNewHousehold :one
-INSERT INTO households (program_id, geolocation_id, name) 
-VALUES ($1, $2, $3) 
+INSERT INTO households (program_id, geolocation_id, name, latitude, longitude) 
+VALUES ($1, $2, $3, $4, $5) 
 RETURNING id, created_at;
 
 -- name: CreateNewHouseholdHead :one
-INSERT INTO household_heads (household_id, name, national_id, phone_number, age)
-VALUES ($1, $2, $3, $4, $5)
+INSERT INTO household_heads (household_id, name, national_id, national_id_type, phone_number, age)
+VALUES ($1, $2, $3, $4, $5, $6)
 RETURNING id, created_at, updated_at;
 
 -- name: CreateNewHouseholdMember :one
@@ -22,11 +22,28 @@ SELECT
     phone_number,
     age,
     created_at,
-    updated_at
+    updated_at,
+    national_id_type,
+    erased_at
 FROM household_heads
 WHERE household_id = $1;
 
+-- name: GetHouseholdMembersByHouseholdId :many
+SELECT
+    id,
+    household_id,
+    name,
+    age,
+    relation,
+    created_at,
+    updated_at,
+    erased_at
+FROM household_members
+WHERE household_id = $1
+ORDER BY id;
+
 -- name: GetHouseHoldInformation :one
+-- updated_at is the last change to the house hold, its program, head or members.
 SELECT 
     h.id AS household_id, 
     h.program_id, 
@@ -37,7 +54,8 @@ SELECT
     hh.id AS household_head_id, 
     hh.name AS household_head_name, 
     hh.phone_number,
-    COUNT(hm.id) AS household_member_count
+    COUNT(hm.id) AS household_member_count,
+    GREATEST(h.created_at, p.updated_at, hh.updated_at, MAX(hm.updated_at))::TIMESTAMPTZ AS updated_at
 FROM households h
 JOIN programs p ON h.program_id = p.id
 JOIN geolocations g ON h.geolocation_id = g.id
@@ -45,3 +63,54 @@ JOIN household_heads hh ON hh.household_id = h.id
 LEFT JOIN household_members hm ON hm.household_id = h.id
 WHERE h.id = $1
 GROUP BY h.id, p.id, g.id, hh.id;
+
+-- name: GetHouseHoldsForGeoJSON :many
+-- Keyset paginated on the ID, pass 0 as after_id for the first page. program_id matches the
+-- house holds enrolled in the program and not exited, whichever program they registered under.
+SELECT
+    h.id AS household_id,
+    h.name,
+    h.program_id,
+    p.name AS program_name,
+    h.geolocation_id,
+    g.county,
+    g.sub_county,
+    COALESCE(h.latitude, g.latitude)::DOUBLE PRECISION AS latitude,
+    COALESCE(h.longitude, g.longitude)::DOUBLE PRECISION AS longitude
+FROM households h
+JOIN programs p ON h.program_id = p.id
+JOIN geolocations g ON h.geolocation_id = g.id
+WHERE COALESCE(h.latitude, g.latitude) IS NOT NULL
+AND COALESCE(h.longitude, g.longitude) IS NOT NULL
+AND (sqlc.arg('program_id')::INT = 0 OR EXISTS (
+    SELECT 1 FROM household_enrollments e
+    WHERE e.household_id = h.id
+    AND e.program_id = sqlc.arg('program_id')::INT
+    AND e.status <> 'exited'
+))
+AND (sqlc.arg('geolocation_id')::INT = 0 OR h.geolocation_id = sqlc.arg('geolocation_id')::INT)
+AND (sqlc.arg('county')::TEXT = '' OR g.county = sqlc.arg('county')::TEXT)
+AND (sqlc.arg('sub_county')::TEXT = '' OR g.sub_county = sqlc.arg('sub_county')::TEXT)
+AND COALESCE(h.latitude, g.latitude) BETWEEN sqlc.arg('min_latitude')::DOUBLE PRECISION AND sqlc.arg('max_latitude')::DOUBLE PRECISION
+AND COALESCE(h.longitude, g.longitude) BETWEEN sqlc.arg('min_longitude')::DOUBLE PRECISION AND sqlc.arg('max_longitude')::DOUBLE PRECISION
+AND h.id > sqlc.arg('after_id')::INT
+ORDER BY h.id
+LIMIT sqlc.arg('limit');
+
+-- name: GetHouseholdHeadPhoneNumbersAfterId :many
+-- Erased heads have no phone number and are skipped.
+SELECT
+    id,
+    phone_number
+FROM household_heads
+WHERE id > $1
+AND erased_at IS NULL
+ORDER BY id
+LIMIT $2;
+
+-- name: UpdateHouseholdHeadPhoneNumber :exec
+UPDATE household_heads
+SET
+    phone_number = $2,
+    updated_at = NOW()
+WHERE id = $1;
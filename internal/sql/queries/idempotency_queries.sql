@@ -0,0 +1,53 @@
+-- name: CreateIdempotencyKey :one
+-- Reserves the key, taking over an expired row or a reservation whose lease has passed. A
+-- taken over row gets a new id, so that the request it was taken from can no longer
+-- complete or release it. Returns no row if the key is still live.
+INSERT INTO idempotency_keys (user_id, idempotency_key, request_hash, expires_at, locked_until)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (user_id, idempotency_key) DO UPDATE
+SET
+    id = DEFAULT,
+    request_hash = EXCLUDED.request_hash,
+    status_code = NULL,
+    content_type = '',
+    response_body = NULL,
+    created_at = NOW(),
+    expires_at = EXCLUDED.expires_at,
+    locked_until = EXCLUDED.locked_until
+WHERE idempotency_keys.expires_at <= NOW()
+OR (idempotency_keys.status_code IS NULL AND idempotency_keys.locked_until <= NOW())
+RETURNING id, created_at;
+
+-- name: GetIdempotencyKey :one
+SELECT
+    id,
+    user_id,
+    idempotency_key,
+    request_hash,
+    status_code,
+    content_type,
+    response_body,
+    created_at,
+    expires_at,
+    locked_until
+FROM idempotency_keys
+WHERE user_id = $1
+AND idempotency_key = $2
+AND expires_at > NOW();
+
+-- name: CompleteIdempotencyKey :exec
+UPDATE idempotency_keys
+SET
+    status_code = $2,
+    content_type = $3,
+    response_body = $4,
+    locked_until = NULL
+WHERE id = $1;
+
+-- name: DeleteIdempotencyKeyById :exec
+DELETE FROM idempotency_keys
+WHERE id = $1;
+
+-- name: DeleteExpiredIdempotencyKeys :execrows
+DELETE FROM idempotency_keys
+WHERE expires_at <= NOW();
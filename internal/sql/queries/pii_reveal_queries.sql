@@ -0,0 +1,22 @@
+-- name: CreatePIIReveal :one
+INSERT INTO pii_reveals (user_id, household_id, source, reason, request_id)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id, created_at;
+
+-- name: GetPIIReveals :many
+-- Lists the most recent reveals, only those of the house hold and user if given.
+SELECT
+    r.id,
+    r.user_id,
+    u.email AS user_email,
+    r.household_id,
+    r.source,
+    r.reason,
+    r.request_id,
+    r.created_at
+FROM pii_reveals r
+JOIN users u ON u.id = r.user_id
+WHERE (sqlc.arg('household_id')::INT = 0 OR r.household_id = sqlc.arg('household_id')::INT)
+AND (sqlc.arg('user_id')::INT = 0 OR r.user_id = sqlc.arg('user_id')::INT)
+ORDER BY r.id DESC
+LIMIT sqlc.arg('limit');
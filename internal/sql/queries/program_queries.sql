@@ -1,25 +1,106 @@
 -- name: CreateNewProgram :one
-INSERT INTO programs (name, category, description) 
-VALUES ($1, $2, $3) 
+INSERT INTO programs (name, category, description, enrollment_opens_at, enrollment_closes_at, household_cap)
+VALUES ($1, $2, $3, $4, $5, $6)
 RETURNING id, created_at, updated_at;
 
 -- name: GetProgramById :one
-SELECT 
+SELECT
     id,
     name,
     category,
     description,
     created_at,
-    updated_at
+    updated_at,
+    enrollment_opens_at,
+    enrollment_closes_at,
+    household_cap
 FROM programs
 WHERE id = $1;
 
 -- name: UpdateProgramById :one
 UPDATE programs
-SET 
+SET
     name = $2,
     category = $3,
     description = $4,
+    enrollment_opens_at = $5,
+    enrollment_closes_at = $6,
+    household_cap = $7,
     updated_at = NOW()
 WHERE id = $1
-RETURNING updated_at;
\ No newline at end of file
+RETURNING updated_at;
+
+-- name: GetAllPrograms :many
+SELECT
+    id,
+    name,
+    category,
+    description,
+    created_at,
+    updated_at,
+    enrollment_opens_at,
+    enrollment_closes_at,
+    household_cap
+FROM programs
+ORDER BY id;
+
+-- name: GetProgramEnrollmentLimitsForUpdate :one
+-- Locks the program, so that enrollments in it are checked and made one at a time.
+SELECT
+    enrollment_opens_at,
+    enrollment_closes_at,
+    household_cap
+FROM programs
+WHERE id = $1
+FOR UPDATE;
+
+-- name: CountHouseholdsByProgramId :one
+-- Exited house holds no longer take up a place in the program.
+SELECT COUNT(*)
+FROM household_enrollments
+WHERE program_id = $1
+AND status <> 'exited';
+
+-- name: CountHouseholdsByProgramIdAndCounty :one
+SELECT COUNT(*)
+FROM household_enrollments e
+JOIN households h ON h.id = e.household_id
+JOIN geolocations g ON h.geolocation_id = g.id
+WHERE e.program_id = $1
+AND e.status <> 'exited'
+AND g.county = $2;
+
+-- name: CountHouseholdsByCountyForProgram :many
+SELECT
+    g.county,
+    COUNT(e.id) AS enrolled
+FROM household_enrollments e
+JOIN households h ON h.id = e.household_id
+JOIN geolocations g ON h.geolocation_id = g.id
+WHERE e.program_id = $1
+AND e.status <> 'exited'
+GROUP BY g.county
+ORDER BY g.county;
+
+-- name: GetProgramCountyQuota :one
+SELECT quota
+FROM program_county_quotas
+WHERE program_id = $1
+AND county = $2;
+
+-- name: GetProgramCountyQuotas :many
+SELECT
+    program_id,
+    county,
+    quota
+FROM program_county_quotas
+WHERE program_id = $1
+ORDER BY county;
+
+-- name: DeleteProgramCountyQuotas :exec
+DELETE FROM program_county_quotas
+WHERE program_id = $1;
+
+-- name: CreateProgramCountyQuota :exec
+INSERT INTO program_county_quotas (program_id, county, quota)
+VALUES ($1, $2, $3);
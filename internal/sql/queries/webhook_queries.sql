@@ -0,0 +1,158 @@
+-- name: CreateOutboxEvent :exec
+INSERT INTO outbox_events (event_type, payload)
+VALUES ($1, $2);
+
+-- name: GetUndispatchedOutboxEvents :many
+-- Locks the oldest events that have yet to be fanned out, skipping those another dispatcher holds.
+SELECT
+    id,
+    event_type,
+    payload,
+    created_at,
+    dispatched_at
+FROM outbox_events
+WHERE dispatched_at IS NULL
+ORDER BY id
+LIMIT $1
+FOR UPDATE SKIP LOCKED;
+
+-- name: MarkOutboxEventDispatched :exec
+UPDATE outbox_events
+SET dispatched_at = NOW()
+WHERE id = $1;
+
+-- name: CreateWebhookSubscription :one
+INSERT INTO webhook_subscriptions (url, secret, event_types)
+VALUES ($1, $2, $3)
+RETURNING id, active, created_at, updated_at;
+
+-- name: GetAllWebhookSubscriptions :many
+SELECT
+    id,
+    url,
+    secret,
+    event_types,
+    active,
+    created_at,
+    updated_at
+FROM webhook_subscriptions
+ORDER BY id;
+
+-- name: DisableWebhookSubscriptionById :execrows
+UPDATE webhook_subscriptions
+SET
+    active = FALSE,
+    updated_at = NOW()
+WHERE id = $1
+AND active;
+
+-- name: CancelPendingWebhookDeliveries :exec
+-- Marks the subscription's pending deliveries dead, e.g when it has been disabled.
+UPDATE webhook_deliveries
+SET
+    status = 'dead',
+    last_error = $2,
+    updated_at = NOW()
+WHERE subscription_id = $1
+AND status = 'pending';
+
+-- name: CreateWebhookDeliveriesForEvent :execrows
+-- Creates a delivery of the event to every active subscription that wants its type.
+INSERT INTO webhook_deliveries (subscription_id, event_id)
+SELECT s.id, sqlc.arg('event_id')::BIGINT
+FROM webhook_subscriptions s
+WHERE s.active
+AND (cardinality(s.event_types) = 0 OR sqlc.arg('event_type')::TEXT = ANY(s.event_types))
+ON CONFLICT (subscription_id, event_id) DO NOTHING;
+
+-- name: ClaimDueWebhookDeliveries :many
+-- Leases the due deliveries until lease_until so that other dispatchers skip them. Deliveries
+-- of a dispatcher that dies mid-flight are picked up again once the lease runs out.
+UPDATE webhook_deliveries d
+SET
+    next_attempt_at = sqlc.arg('lease_until'),
+    updated_at = NOW()
+FROM outbox_events e, webhook_subscriptions s
+WHERE d.id IN (
+    SELECT id FROM webhook_deliveries
+    WHERE status = 'pending'
+    AND next_attempt_at <= NOW()
+    ORDER BY next_attempt_at
+    LIMIT sqlc.arg('limit')
+    FOR UPDATE SKIP LOCKED
+)
+AND e.id = d.event_id
+AND s.id = d.subscription_id
+RETURNING
+    d.id,
+    d.subscription_id,
+    d.event_id,
+    d.attempts,
+    s.url,
+    s.secret,
+    e.event_type,
+    e.payload,
+    e.created_at AS event_created_at;
+
+-- name: UpdateWebhookDelivery :exec
+UPDATE webhook_deliveries
+SET
+    status = $2,
+    attempts = $3,
+    next_attempt_at = $4,
+    last_status_code = $5,
+    last_error = $6,
+    updated_at = NOW()
+WHERE id = $1;
+
+-- name: CreateWebhookDeliveryAttempt :exec
+INSERT INTO webhook_delivery_attempts (delivery_id, attempt, status_code, error, duration_ms)
+VALUES ($1, $2, $3, $4, $5);
+
+-- name: GetWebhookDeliveriesForSubscription :many
+-- Lists the subscription's most recent deliveries, only those with the status if one is given.
+SELECT
+    d.id,
+    d.subscription_id,
+    d.event_id,
+    e.event_type,
+    d.status,
+    d.attempts,
+    d.next_attempt_at,
+    d.last_status_code,
+    d.last_error,
+    d.created_at,
+    d.updated_at
+FROM webhook_deliveries d
+JOIN outbox_events e ON e.id = d.event_id
+WHERE d.subscription_id = sqlc.arg('subscription_id')
+AND (sqlc.arg('status')::TEXT = '' OR d.status = sqlc.arg('status')::TEXT)
+ORDER BY d.id DESC
+LIMIT sqlc.arg('limit');
+
+-- name: GetWebhookDeliveryAttempts :many
+SELECT
+    id,
+    delivery_id,
+    attempt,
+    status_code,
+    error,
+    duration_ms,
+    attempted_at
+FROM webhook_delivery_attempts
+WHERE delivery_id = $1
+ORDER BY id;
+
+-- name: RetryWebhookDelivery :execrows
+-- Requeues a dead delivery of an active subscription with a fresh set of attempts.
+UPDATE webhook_deliveries d
+SET
+    status = 'pending',
+    attempts = 0,
+    next_attempt_at = NOW(),
+    updated_at = NOW()
+FROM webhook_subscriptions s
+WHERE d.id = $1
+AND d.status = 'dead'
+AND s.id = d.subscription_id
+AND s.active;
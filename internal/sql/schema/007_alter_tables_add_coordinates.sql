@@ -0,0 +1,24 @@
+-- +goose Up
+-- Add GPS coordinates to households, captured at enrollment
+ALTER TABLE households
+    ADD COLUMN latitude DOUBLE PRECISION,
+    ADD COLUMN longitude DOUBLE PRECISION;
+
+-- Add GPS coordinates to geolocations, used as a fallback for households without their own
+ALTER TABLE geolocations
+    ADD COLUMN latitude DOUBLE PRECISION,
+    ADD COLUMN longitude DOUBLE PRECISION;
+
+-- Index on the coordinates, used by the bounding box pre-filter of the radius search
+CREATE INDEX idx_households_coordinates ON households(latitude, longitude);
+CREATE INDEX idx_geolocations_coordinates ON geolocations(latitude, longitude);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_geolocations_coordinates;
+DROP INDEX IF EXISTS idx_households_coordinates;
+ALTER TABLE geolocations
+    DROP COLUMN IF EXISTS latitude,
+    DROP COLUMN IF EXISTS longitude;
+ALTER TABLE households
+    DROP COLUMN IF EXISTS latitude,
+    DROP COLUMN IF EXISTS longitude;
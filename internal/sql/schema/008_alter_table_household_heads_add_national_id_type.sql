@@ -0,0 +1,9 @@
+-- +goose Up
+-- Household heads may identify with a Kenyan national ID, a passport or an alien ID
+ALTER TABLE household_heads
+    ADD COLUMN national_id_type VARCHAR(20) NOT NULL DEFAULT 'national_id'
+    CHECK (national_id_type IN ('national_id', 'passport', 'alien_id'));
+
+-- +goose Down
+ALTER TABLE household_heads
+    DROP COLUMN IF EXISTS national_id_type;
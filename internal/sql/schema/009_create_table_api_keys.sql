@@ -0,0 +1,35 @@
+-- +goose Up
+-- Users can be disabled rather than deleted, keeping the history of who did what
+ALTER TABLE users
+    ADD COLUMN disabled_at TIMESTAMP(0) WITH TIME ZONE;
+-- API keys move to their own table so that a user can hold several and revoke them one by one
+CREATE TABLE api_keys (
+    id SERIAL PRIMARY KEY,
+    user_id INT REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+    hash BYTEA UNIQUE NOT NULL, -- the SHA-256 hash of the plaintext key, which we never store
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    revoked_at TIMESTAMP(0) WITH TIME ZONE
+);
+-- Index on user_id
+CREATE INDEX idx_api_keys_user_id ON api_keys(user_id);
+-- Carry over the existing keys
+INSERT INTO api_keys (user_id, hash)
+SELECT id, api_key FROM users;
+ALTER TABLE users
+    DROP COLUMN api_key;
+-- +goose Down
+ALTER TABLE users
+    ADD COLUMN api_key BYTEA;
+-- Restore each user's oldest active key, users without one get an empty hash no key matches
+UPDATE users u
+SET api_key = COALESCE((
+    SELECT k.hash FROM api_keys k
+    WHERE k.user_id = u.id AND k.revoked_at IS NULL
+    ORDER BY k.id
+    LIMIT 1
+), ''::BYTEA);
+ALTER TABLE users
+    ALTER COLUMN api_key SET NOT NULL;
+DROP TABLE IF EXISTS api_keys;
+ALTER TABLE users
+    DROP COLUMN IF EXISTS disabled_at;
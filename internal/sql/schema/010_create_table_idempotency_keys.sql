@@ -0,0 +1,20 @@
+-- +goose Up
+-- Responses to POST requests sent with an Idempotency-Key header, replayed when a client
+-- retries the request. A row without a status_code is a request still being processed.
+CREATE TABLE idempotency_keys (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL, -- 0 for requests without an API key
+    idempotency_key VARCHAR(255) NOT NULL,
+    request_hash BYTEA NOT NULL, -- SHA-256 of the method, path and body
+    status_code INT,
+    content_type TEXT NOT NULL DEFAULT '',
+    response_body BYTEA,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+    CONSTRAINT idempotency_keys_user_id_idempotency_key_key UNIQUE (user_id, idempotency_key)
+);
+-- Index on expires_at, for purging expired keys
+CREATE INDEX idx_idempotency_keys_expires_at ON idempotency_keys(expires_at);
+
+-- +goose Down
+DROP TABLE IF EXISTS idempotency_keys;
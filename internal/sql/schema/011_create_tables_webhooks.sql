@@ -0,0 +1,58 @@
+-- +goose Up
+-- Partner systems subscribe to our events. An empty event_types array means every event.
+-- The secret signs the payloads and is encrypted with the data encryption key.
+CREATE TABLE webhook_subscriptions (
+    id SERIAL PRIMARY KEY,
+    url TEXT NOT NULL,
+    secret TEXT NOT NULL,
+    event_types TEXT[] NOT NULL DEFAULT '{}',
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+-- The transactional outbox. Events are written in the same transaction as the change they
+-- describe, and fanned out to the subscriptions by the dispatcher.
+CREATE TABLE outbox_events (
+    id BIGSERIAL PRIMARY KEY,
+    event_type TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    dispatched_at TIMESTAMP(0) WITH TIME ZONE
+);
+-- Partial index on the events that have yet to be fanned out
+CREATE INDEX idx_outbox_events_undispatched ON outbox_events(id) WHERE dispatched_at IS NULL;
+-- One delivery of an event to a subscription. A delivery that used up its attempts is dead.
+CREATE TABLE webhook_deliveries (
+    id BIGSERIAL PRIMARY KEY,
+    subscription_id INT REFERENCES webhook_subscriptions(id) ON DELETE CASCADE NOT NULL,
+    event_id BIGINT REFERENCES outbox_events(id) ON DELETE CASCADE NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_status_code INT,
+    last_error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    CONSTRAINT webhook_deliveries_subscription_id_event_id_key UNIQUE (subscription_id, event_id),
+    CONSTRAINT webhook_deliveries_status_check CHECK (status IN ('pending', 'delivered', 'dead'))
+);
+-- Partial index on the deliveries the dispatcher polls for
+CREATE INDEX idx_webhook_deliveries_pending ON webhook_deliveries(next_attempt_at) WHERE status = 'pending';
+-- The delivery log, one row per attempt
+CREATE TABLE webhook_delivery_attempts (
+    id BIGSERIAL PRIMARY KEY,
+    delivery_id BIGINT REFERENCES webhook_deliveries(id) ON DELETE CASCADE NOT NULL,
+    attempt INT NOT NULL,
+    status_code INT, -- NULL when no response was received
+    error TEXT NOT NULL DEFAULT '',
+    duration_ms INT NOT NULL,
+    attempted_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+-- Index on delivery_id
+CREATE INDEX idx_webhook_delivery_attempts_delivery_id ON webhook_delivery_attempts(delivery_id);
+
+-- +goose Down
+DROP TABLE IF EXISTS webhook_delivery_attempts;
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS outbox_events;
+DROP TABLE IF EXISTS webhook_subscriptions;
@@ -0,0 +1,37 @@
+-- +goose Up
+-- Access (export) and erasure requests made by data subjects under the Data Protection Act.
+-- A request only runs once another user approves it, and the row is kept as the record of
+-- who asked, who decided and how many records were affected. The national ID is encrypted
+-- with the data encryption key and cleared once the request has been decided.
+CREATE TABLE data_subject_requests (
+    id SERIAL PRIMARY KEY,
+    kind VARCHAR(20) NOT NULL,
+    national_id TEXT,
+    reason TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    requested_by INT REFERENCES users(id) NOT NULL,
+    decided_by INT REFERENCES users(id),
+    decided_at TIMESTAMP(0) WITH TIME ZONE,
+    heads_found INT NOT NULL DEFAULT 0,
+    members_found INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    CONSTRAINT data_subject_requests_kind_check CHECK (kind IN ('export', 'erasure')),
+    CONSTRAINT data_subject_requests_status_check CHECK (status IN ('pending', 'completed', 'rejected')),
+    CONSTRAINT data_subject_requests_decided_by_check CHECK (decided_by <> requested_by)
+);
+-- Index on status
+CREATE INDEX idx_data_subject_requests_status ON data_subject_requests(status);
+-- Erased heads and members keep their rows, so that the household, program and location
+-- counts stay right, but their names, national IDs and phone numbers are scrubbed
+ALTER TABLE household_heads
+    ADD COLUMN erased_at TIMESTAMP(0) WITH TIME ZONE;
+ALTER TABLE household_members
+    ADD COLUMN erased_at TIMESTAMP(0) WITH TIME ZONE;
+
+-- +goose Down
+ALTER TABLE household_members
+    DROP COLUMN IF EXISTS erased_at;
+ALTER TABLE household_heads
+    DROP COLUMN IF EXISTS erased_at;
+DROP TABLE IF EXISTS data_subject_requests;
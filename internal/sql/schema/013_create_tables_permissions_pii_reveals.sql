@@ -0,0 +1,34 @@
+-- +goose Up
+-- Permissions granted to users on top of the access every API key has
+CREATE TABLE permissions (
+    id SERIAL PRIMARY KEY,
+    code TEXT UNIQUE NOT NULL
+);
+CREATE TABLE users_permissions (
+    user_id INT REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+    permission_id INT REFERENCES permissions(id) ON DELETE CASCADE NOT NULL,
+    PRIMARY KEY (user_id, permission_id)
+);
+-- pii:read lets a user read phone numbers in full, everyone else sees them masked
+INSERT INTO permissions (code)
+VALUES ('pii:read');
+-- Every time a phone number is returned in full, either to a user with pii:read or through
+-- the reveal endpoint, which requires a reason. The request ID ties it to the access log.
+CREATE TABLE pii_reveals (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INT REFERENCES users(id) NOT NULL,
+    household_id INT REFERENCES households(id) NOT NULL,
+    source VARCHAR(20) NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    request_id TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    CONSTRAINT pii_reveals_source_check CHECK (source IN ('permission', 'reveal'))
+);
+-- Indexes on the household and the user, the reveals are looked up by either
+CREATE INDEX idx_pii_reveals_household_id ON pii_reveals(household_id, id);
+CREATE INDEX idx_pii_reveals_user_id ON pii_reveals(user_id, id);
+
+-- +goose Down
+DROP TABLE IF EXISTS pii_reveals;
+DROP TABLE IF EXISTS users_permissions;
+DROP TABLE IF EXISTS permissions;
@@ -0,0 +1,28 @@
+-- +goose Up
+-- Enrollment windows and an overall cap on the households a program takes, NULL means
+-- there is no limit
+ALTER TABLE programs
+    ADD COLUMN enrollment_opens_at TIMESTAMP(0) WITH TIME ZONE,
+    ADD COLUMN enrollment_closes_at TIMESTAMP(0) WITH TIME ZONE,
+    ADD COLUMN household_cap INT,
+    ADD CONSTRAINT programs_enrollment_window_check CHECK (enrollment_closes_at > enrollment_opens_at),
+    ADD CONSTRAINT programs_household_cap_check CHECK (household_cap >= 0);
+
+-- Quotas on the households a program takes per county, counties without one are only
+-- limited by the program's cap
+CREATE TABLE program_county_quotas (
+    program_id INT REFERENCES programs(id) ON DELETE CASCADE NOT NULL,
+    county VARCHAR(255) NOT NULL,
+    quota INT NOT NULL,
+    PRIMARY KEY (program_id, county),
+    CONSTRAINT program_county_quotas_quota_check CHECK (quota >= 0)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS program_county_quotas;
+ALTER TABLE programs
+    DROP CONSTRAINT IF EXISTS programs_household_cap_check,
+    DROP CONSTRAINT IF EXISTS programs_enrollment_window_check,
+    DROP COLUMN IF EXISTS household_cap,
+    DROP COLUMN IF EXISTS enrollment_closes_at,
+    DROP COLUMN IF EXISTS enrollment_opens_at;
@@ -0,0 +1,31 @@
+-- +goose Up
+-- The programs each house hold is enrolled in, so that a house hold receiving several
+-- programs is registered once. households.program_id is kept as the program the house hold
+-- was registered under, which is its first enrollment.
+CREATE TABLE household_enrollments (
+    id SERIAL PRIMARY KEY,
+    household_id INT REFERENCES households(id) ON DELETE CASCADE NOT NULL,
+    program_id INT REFERENCES programs(id) ON DELETE CASCADE NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'active',
+    enrolled_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    exit_reason TEXT,
+    exited_at TIMESTAMP(0) WITH TIME ZONE,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    CONSTRAINT household_enrollments_household_id_program_id_key UNIQUE (household_id, program_id),
+    CONSTRAINT household_enrollments_status_check CHECK (status IN ('active', 'suspended', 'exited')),
+    -- exited enrollments, and only those, record when and why the house hold left
+    CONSTRAINT household_enrollments_exit_check CHECK ((status = 'exited') = (exit_reason IS NOT NULL AND exited_at IS NOT NULL))
+);
+
+-- Index on program_id, the unique key covers the house hold's enrollments
+CREATE INDEX idx_household_enrollments_program_id ON household_enrollments(program_id);
+
+-- Every existing house hold is enrolled in the program it was registered under, since it
+-- was registered
+INSERT INTO household_enrollments (household_id, program_id, enrolled_at, created_at, updated_at)
+SELECT id, program_id, created_at, created_at, created_at
+FROM households;
+
+-- +goose Down
+DROP TABLE IF EXISTS household_enrollments;
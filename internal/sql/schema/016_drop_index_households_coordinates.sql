@@ -0,0 +1,8 @@
+-- +goose Up
+-- The radius search filters on COALESCE(h.latitude, g.latitude), falling back to the geo
+-- location across the join, which no index on the households' own coordinates can serve.
+-- The search walks house holds by their primary key instead.
+DROP INDEX IF EXISTS idx_households_coordinates;
+
+-- +goose Down
+CREATE INDEX idx_households_coordinates ON households(latitude, longitude);
@@ -0,0 +1,10 @@
+-- +goose Up
+-- A request holds its reservation only until locked_until. A request that crashed or timed
+-- out before completing its key would otherwise block every retry until the key expires, so
+-- once the lease has passed a retry may reclaim the key. Completed keys have no lease.
+ALTER TABLE idempotency_keys
+    ADD COLUMN locked_until TIMESTAMP(0) WITH TIME ZONE;
+
+-- +goose Down
+ALTER TABLE idempotency_keys
+    DROP COLUMN IF EXISTS locked_until;
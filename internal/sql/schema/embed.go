@@ -0,0 +1,10 @@
+// Package schema embeds our goose migrations so that the API binary can apply them itself
+// without the goose CLI or a checkout of this directory.
+package schema
+
+import "embed"
+
+// FS holds every migration in this directory
+//
+//go:embed *.sql
+var FS embed.FS
@@ -0,0 +1,49 @@
+package validator
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Identity document types we accept for household heads
+const (
+	IDTypeNationalID = "national_id"
+	IDTypePassport   = "passport"
+	IDTypeAlienID    = "alien_id"
+)
+
+// IDTypes lists every permitted identity document type
+var IDTypes = []string{IDTypeNationalID, IDTypePassport, IDTypeAlienID}
+
+// MinimumNationalIDAge is the age at which Kenyans are issued a national ID
+const MinimumNationalIDAge = 18
+
+var (
+	// kenyaNationalIDRX matches a Kenyan national ID number, 7 or 8 digits
+	kenyaNationalIDRX = regexp.MustCompile(`^\d{7,8}$`)
+	// passportRX matches a passport number, one or two letters followed by 6 to 8 digits
+	passportRX = regexp.MustCompile(`^[A-Z]{1,2}\d{6,8}$`)
+	// alienIDRX matches a Kenyan alien (foreign national) registration number
+	alienIDRX = regexp.MustCompile(`^\d{6,9}$`)
+)
+
+// NormalizeNationalID removes the spaces and dashes people add when writing ID numbers
+// and uppercases any letters, so that the same document is always stored the same way.
+func NormalizeNationalID(value string) string {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	return strings.NewReplacer(" ", "", "-", "").Replace(value)
+}
+
+// NationalID checks that the identity document number matches the format of its type.
+// The type is validated against IDTypes under typeKey.
+func (v *Validator) NationalID(key, typeKey, idType, value string) {
+	OneOf(v, typeKey, idType, IDTypes...)
+	switch idType {
+	case IDTypeNationalID:
+		v.CheckCode(Matches(value, kenyaNationalIDRX), key, CodeInvalidNationalID, "must be a valid Kenyan national ID number")
+	case IDTypePassport:
+		v.CheckCode(Matches(value, passportRX), key, CodeInvalidNationalID, "must be a valid passport number")
+	case IDTypeAlienID:
+		v.CheckCode(Matches(value, alienIDRX), key, CodeInvalidNationalID, "must be a valid alien ID number")
+	}
+}
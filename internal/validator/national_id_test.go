@@ -0,0 +1,52 @@
+package validator
+
+import "testing"
+
+func TestNormalizeNationalID(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "12345678", want: "12345678"},
+		{value: " 1234 5678 ", want: "12345678"},
+		{value: "12-345-678", want: "12345678"},
+		{value: "ak 123456", want: "AK123456"},
+	}
+	for _, tt := range tests {
+		if got := NormalizeNationalID(tt.value); got != tt.want {
+			t.Errorf("NormalizeNationalID(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestNationalID(t *testing.T) {
+	tests := []struct {
+		name     string
+		idType   string
+		value    string
+		key      string
+		wantCode string
+	}{
+		{name: "national ID, 8 digits", idType: IDTypeNationalID, value: "12345678"},
+		{name: "national ID, 7 digits", idType: IDTypeNationalID, value: "1234567"},
+		{name: "national ID too short", idType: IDTypeNationalID, value: "123456", key: "national_id", wantCode: CodeInvalidNationalID},
+		{name: "national ID too long", idType: IDTypeNationalID, value: "123456789", key: "national_id", wantCode: CodeInvalidNationalID},
+		{name: "national ID with letters", idType: IDTypeNationalID, value: "A1234567", key: "national_id", wantCode: CodeInvalidNationalID},
+		{name: "passport, one letter", idType: IDTypePassport, value: "A1234567"},
+		{name: "passport, two letters", idType: IDTypePassport, value: "AK123456"},
+		{name: "passport without letters", idType: IDTypePassport, value: "12345678", key: "national_id", wantCode: CodeInvalidNationalID},
+		{name: "passport lower case", idType: IDTypePassport, value: "ak123456", key: "national_id", wantCode: CodeInvalidNationalID},
+		{name: "alien ID, 6 digits", idType: IDTypeAlienID, value: "123456"},
+		{name: "alien ID, 9 digits", idType: IDTypeAlienID, value: "123456789"},
+		{name: "alien ID too long", idType: IDTypeAlienID, value: "1234567890", key: "national_id", wantCode: CodeInvalidNationalID},
+		{name: "unknown type", idType: "driving_licence", value: "12345678", key: "national_id_type", wantCode: CodeNotPermitted},
+		{name: "missing type", idType: "", value: "12345678", key: "national_id_type", wantCode: CodeNotPermitted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkRule(t, tt.key, tt.wantCode, func(v *Validator) {
+				v.NationalID("national_id", "national_id_type", tt.idType, tt.value)
+			})
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package validator
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// KenyaCountryCode is the calling code used for numbers written in Kenya's local formats
+const KenyaCountryCode = "254"
+
+var (
+	ErrInvalidPhoneNumber         = errors.New("must be a valid phone number")
+	ErrPhoneCountryCodeNotAllowed = errors.New("country code is not permitted")
+)
+
+var (
+	// phoneSeparatorRX matches the characters people use to group digits, e.g "0712 345-678"
+	phoneSeparatorRX = regexp.MustCompile(`[\s\-\.\(\)]`)
+	// kenyaLocalRX matches local Kenyan numbers: 07xx/01xx with the trunk prefix, or 7xx/1xx without
+	kenyaLocalRX = regexp.MustCompile(`^0?([17]\d{8})$`)
+	// kenyaSubscriberRX matches a Kenyan subscriber number once the country code is removed
+	kenyaSubscriberRX = regexp.MustCompile(`^[17]\d{8}$`)
+	// e164RX matches a number in E.164 format: a "+" and up to 15 digits, no leading zero
+	e164RX = regexp.MustCompile(`^\+[1-9]\d{6,14}$`)
+)
+
+// PhoneNumberNormalizer converts phone numbers written in the various formats we receive
+// into canonical E.164 form (e.g "+254712345678"). Kenyan numbers are always accepted,
+// in local and international formats. Other countries are only accepted in international
+// format, and only if their calling code has been permitted.
+type PhoneNumberNormalizer struct {
+	countryCodes []string
+}
+
+// NewPhoneNumberNormalizer() creates a normalizer that accepts Kenyan numbers plus numbers from
+// any of the additional calling codes supplied (without the "+", e.g "256" for Uganda).
+func NewPhoneNumberNormalizer(countryCodes ...string) *PhoneNumberNormalizer {
+	permitted := []string{KenyaCountryCode}
+	for _, code := range countryCodes {
+		code = strings.TrimPrefix(strings.TrimSpace(code), "+")
+		if code != "" && !PermittedValue(code, permitted...) {
+			permitted = append(permitted, code)
+		}
+	}
+	return &PhoneNumberNormalizer{countryCodes: permitted}
+}
+
+// CountryCodes() returns the calling codes this normalizer accepts
+func (n *PhoneNumberNormalizer) CountryCodes() []string {
+	return n.countryCodes
+}
+
+// Normalize() returns the E.164 form of the phone number, or an error if it is not a valid
+// number for one of the permitted countries. It is idempotent, so a canonical number is
+// returned unchanged.
+func (n *PhoneNumberNormalizer) Normalize(phoneNumber string) (string, error) {
+	number := phoneSeparatorRX.ReplaceAllString(strings.TrimSpace(phoneNumber), "")
+	if number == "" {
+		return "", ErrInvalidPhoneNumber
+	}
+	// local Kenyan formats: 0712345678, 0112345678, 712345678
+	if matches := kenyaLocalRX.FindStringSubmatch(number); matches != nil {
+		return "+" + KenyaCountryCode + matches[1], nil
+	}
+	// international formats: +254712345678, 00254712345678, 254712345678
+	switch {
+	case strings.HasPrefix(number, "+"):
+	case strings.HasPrefix(number, "00"):
+		number = "+" + strings.TrimPrefix(number, "00")
+	default:
+		number = "+" + number
+	}
+	if !e164RX.MatchString(number) {
+		return "", ErrInvalidPhoneNumber
+	}
+	// Kenyan numbers get the strict check, others only need a permitted calling code
+	if strings.HasPrefix(number, "+"+KenyaCountryCode) {
+		if !kenyaSubscriberRX.MatchString(strings.TrimPrefix(number, "+"+KenyaCountryCode)) {
+			return "", ErrInvalidPhoneNumber
+		}
+		return number, nil
+	}
+	for _, code := range n.countryCodes {
+		if strings.HasPrefix(number, "+"+code) {
+			return number, nil
+		}
+	}
+	return "", ErrPhoneCountryCodeNotAllowed
+}
+
+// PhoneNumber checks that the value is a valid phone number for one of the normalizer's
+// countries and returns its E.164 form. The original value is returned if it is invalid.
+func (v *Validator) PhoneNumber(key string, normalizer *PhoneNumberNormalizer, value string) string {
+	phoneNumber, err := normalizer.Normalize(value)
+	if err != nil {
+		v.AddFieldError(key, CodeInvalidPhoneNumber, err.Error())
+		return value
+	}
+	return phoneNumber
+}
@@ -0,0 +1,81 @@
+package validator
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestPhoneNumberNormalizerNormalize(t *testing.T) {
+	// Uganda is permitted on top of Kenya
+	normalizer := NewPhoneNumberNormalizer("256")
+	tests := []struct {
+		name        string
+		phoneNumber string
+		want        string
+		wantErr     error
+	}{
+		{name: "07xx", phoneNumber: "0712345678", want: "+254712345678"},
+		{name: "01xx", phoneNumber: "0112345678", want: "+254112345678"},
+		{name: "without the trunk prefix", phoneNumber: "712345678", want: "+254712345678"},
+		{name: "2547xx", phoneNumber: "254712345678", want: "+254712345678"},
+		{name: "+2547xx", phoneNumber: "+254712345678", want: "+254712345678"},
+		{name: "+2541xx", phoneNumber: "+254112345678", want: "+254112345678"},
+		{name: "00 prefix", phoneNumber: "00254712345678", want: "+254712345678"},
+		{name: "spaces", phoneNumber: " 0712 345 678 ", want: "+254712345678"},
+		{name: "dashes", phoneNumber: "+254-712-345-678", want: "+254712345678"},
+		{name: "dots and brackets", phoneNumber: "(0712) 345.678", want: "+254712345678"},
+		{name: "permitted foreign", phoneNumber: "+256 712 345678", want: "+256712345678"},
+		{name: "permitted foreign with 00", phoneNumber: "00256712345678", want: "+256712345678"},
+		{name: "foreign not permitted", phoneNumber: "+255712345678", wantErr: ErrPhoneCountryCodeNotAllowed},
+		{name: "foreign in local format", phoneNumber: "0772123456789", wantErr: ErrInvalidPhoneNumber},
+		{name: "local too short", phoneNumber: "071234567", wantErr: ErrInvalidPhoneNumber},
+		{name: "local too long", phoneNumber: "07123456789", wantErr: ErrInvalidPhoneNumber},
+		{name: "kenyan too short", phoneNumber: "+25471234567", wantErr: ErrInvalidPhoneNumber},
+		{name: "kenyan too long", phoneNumber: "+2547123456789", wantErr: ErrInvalidPhoneNumber},
+		{name: "kenyan bad prefix", phoneNumber: "+254212345678", wantErr: ErrInvalidPhoneNumber},
+		{name: "longer than E.164", phoneNumber: "+2561234567890123", wantErr: ErrInvalidPhoneNumber},
+		{name: "letters", phoneNumber: "0712abc678", wantErr: ErrInvalidPhoneNumber},
+		{name: "two plus signs", phoneNumber: "++254712345678", wantErr: ErrInvalidPhoneNumber},
+		{name: "only separators", phoneNumber: " - ", wantErr: ErrInvalidPhoneNumber},
+		{name: "empty", phoneNumber: "", wantErr: ErrInvalidPhoneNumber},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizer.Normalize(tt.phoneNumber)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Normalize(%q) error = %v, want %v", tt.phoneNumber, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Normalize(%q) = %q, want %q", tt.phoneNumber, got, tt.want)
+			}
+			// migrate and check run numbers through again, so canonical numbers must not change
+			if err == nil {
+				if again, err := normalizer.Normalize(got); err != nil || again != got {
+					t.Errorf("Normalize(%q) = %q, %v, want it unchanged", got, again, err)
+				}
+			}
+		})
+	}
+}
+
+func TestNewPhoneNumberNormalizer(t *testing.T) {
+	normalizer := NewPhoneNumberNormalizer("+256", " 255 ", "", "254", "256")
+	if got, want := normalizer.CountryCodes(), []string{"254", "256", "255"}; !slices.Equal(got, want) {
+		t.Errorf("CountryCodes() = %v, want %v", got, want)
+	}
+}
+
+func TestValidatorPhoneNumber(t *testing.T) {
+	normalizer := NewPhoneNumberNormalizer()
+	v := New()
+	if got := v.PhoneNumber("phone_number", normalizer, "0712 345 678"); got != "+254712345678" || !v.Valid() {
+		t.Errorf("PhoneNumber() = %q, errors %v", got, v.Errors)
+	}
+	if got := v.PhoneNumber("phone_number", normalizer, "+256712345678"); got != "+256712345678" {
+		t.Errorf("PhoneNumber() = %q, want the value it was given", got)
+	}
+	if v.Codes["phone_number"] != CodeInvalidPhoneNumber || v.Errors["phone_number"] != ErrPhoneCountryCodeNotAllowed.Error() {
+		t.Errorf("errors = %v, codes %v", v.Errors, v.Codes)
+	}
+}
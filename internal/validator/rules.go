@@ -0,0 +1,101 @@
+package validator
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// This file holds the reusable rules shared by every model's Validate* function. Each rule
+// records a coded error against the given key when the value does not satisfy it.
+
+// Number is the set of numeric types our range rules accept
+type Number interface {
+	~int | ~int32 | ~int64 | ~float64
+}
+
+// Required checks that a string value has been provided
+func (v *Validator) Required(key, value string) {
+	v.CheckCode(strings.TrimSpace(value) != "", key, CodeRequired, "must be provided")
+}
+
+// RequiredID checks that an ID has been provided
+func (v *Validator) RequiredID(key string, value int32) {
+	v.CheckCode(value != 0, key, CodeRequired, "must be provided")
+}
+
+// ValidID checks that an ID is a positive number
+func (v *Validator) ValidID(key string, value int64) {
+	v.CheckCode(value > 0, key, CodeInvalid, "must be a valid ID")
+}
+
+// MinLength checks that a string is at least min bytes long
+func (v *Validator) MinLength(key, value string, min int) {
+	v.CheckCode(len(value) >= min, key, CodeTooShort, fmt.Sprintf("must be at least %d bytes long", min))
+}
+
+// MaxLength checks that a string is no more than max bytes long
+func (v *Validator) MaxLength(key, value string, max int) {
+	v.CheckCode(len(value) <= max, key, CodeTooLong, fmt.Sprintf("must not be more than %d bytes long", max))
+}
+
+// MaxRunes checks that a string is no more than max characters long
+func (v *Validator) MaxRunes(key, value string, max int) {
+	v.CheckCode(utf8.RuneCountInString(value) <= max, key, CodeTooLong, fmt.Sprintf("must not be more than %d characters long", max))
+}
+
+// Pattern checks that a string matches the regular expression. The message describes
+// the expected format, e.g "must only contain letters".
+func (v *Validator) Pattern(key, value string, rx *regexp.Regexp, message string) {
+	v.CheckCode(Matches(value, rx), key, CodeInvalidFormat, message)
+}
+
+// CrossField records an error when a combination of fields is not valid, e.g an age
+// that does not match the type of identity document.
+func (v *Validator) CrossField(ok bool, key, message string) {
+	v.CheckCode(ok, key, CodeInvalidCombination, message)
+}
+
+// InRange checks that a number falls between min and max inclusive
+func InRange[T Number](v *Validator, key string, value, min, max T) {
+	v.CheckCode(value >= min && value <= max, key, CodeOutOfRange, fmt.Sprintf("must be between %v and %v", min, max))
+}
+
+// Min checks that a number is at least min
+func Min[T Number](v *Validator, key string, value, min T) {
+	v.CheckCode(value >= min, key, CodeOutOfRange, fmt.Sprintf("must be at least %v", min))
+}
+
+// OneOf checks that a value is one of the permitted values
+func OneOf[T comparable](v *Validator, key string, value T, permittedValues ...T) {
+	v.CheckCode(PermittedValue(value, permittedValues...), key, CodeNotPermitted, fmt.Sprintf("must be one of %v", permittedValues))
+}
+
+// Date parses a date in the given layout, recording an error and returning the zero time
+// if it cannot be parsed.
+func (v *Validator) Date(key, value, layout string) time.Time {
+	date, err := time.Parse(layout, value)
+	if err != nil {
+		v.AddFieldError(key, CodeInvalidDate, fmt.Sprintf("must be a valid date in the format %s", layout))
+		return time.Time{}
+	}
+	return date
+}
+
+// DateBetween checks that a date falls between min and max inclusive. A zero min or max
+// leaves that side of the range open.
+func (v *Validator) DateBetween(key string, value, min, max time.Time) {
+	if !min.IsZero() {
+		v.CheckCode(!value.Before(min), key, CodeInvalidDate, fmt.Sprintf("must not be before %s", min.Format(time.DateOnly)))
+	}
+	if !max.IsZero() {
+		v.CheckCode(!value.After(max), key, CodeInvalidDate, fmt.Sprintf("must not be after %s", max.Format(time.DateOnly)))
+	}
+}
+
+// NotZero checks that a number has been provided
+func NotZero[T Number](v *Validator, key string, value T) {
+	v.CheckCode(value != 0, key, CodeRequired, "must be provided")
+}
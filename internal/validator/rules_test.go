@@ -0,0 +1,90 @@
+package validator
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+// checkRule() runs the rule against a new validator and checks the code it recorded under
+// key, an empty code meaning the rule passed
+func checkRule(t *testing.T, key, wantCode string, rule func(v *Validator)) {
+	t.Helper()
+	v := New()
+	rule(v)
+	if wantCode == "" {
+		if !v.Valid() {
+			t.Errorf("errors = %v, want none", v.Errors)
+		}
+		return
+	}
+	if v.Codes[key] != wantCode || v.Errors[key] == "" {
+		t.Errorf("errors = %v, codes %v, want a %s error for %s", v.Errors, v.Codes, wantCode, key)
+	}
+}
+
+func TestRules(t *testing.T) {
+	lettersRX := regexp.MustCompile(`^[a-z]+$`)
+	day := func(s string) time.Time {
+		date, _ := time.Parse(time.DateOnly, s)
+		return date
+	}
+	tests := []struct {
+		name     string
+		wantCode string
+		rule     func(v *Validator)
+	}{
+		{name: "Required", rule: func(v *Validator) { v.Required("field", "Otieno") }},
+		{name: "Required empty", wantCode: CodeRequired, rule: func(v *Validator) { v.Required("field", "") }},
+		{name: "Required blank", wantCode: CodeRequired, rule: func(v *Validator) { v.Required("field", " \t ") }},
+		{name: "RequiredID", rule: func(v *Validator) { v.RequiredID("field", 1) }},
+		{name: "RequiredID zero", wantCode: CodeRequired, rule: func(v *Validator) { v.RequiredID("field", 0) }},
+		{name: "ValidID", rule: func(v *Validator) { v.ValidID("field", 1) }},
+		{name: "ValidID zero", wantCode: CodeInvalid, rule: func(v *Validator) { v.ValidID("field", 0) }},
+		{name: "ValidID negative", wantCode: CodeInvalid, rule: func(v *Validator) { v.ValidID("field", -1) }},
+		{name: "MinLength at min", rule: func(v *Validator) { v.MinLength("field", "abc", 3) }},
+		{name: "MinLength short", wantCode: CodeTooShort, rule: func(v *Validator) { v.MinLength("field", "ab", 3) }},
+		{name: "MaxLength at max", rule: func(v *Validator) { v.MaxLength("field", "abc", 3) }},
+		{name: "MaxLength long", wantCode: CodeTooLong, rule: func(v *Validator) { v.MaxLength("field", "abcd", 3) }},
+		// "é" is two bytes, so it fits three characters but not three bytes
+		{name: "MaxLength counts bytes", wantCode: CodeTooLong, rule: func(v *Validator) { v.MaxLength("field", "abé", 3) }},
+		{name: "MaxRunes counts characters", rule: func(v *Validator) { v.MaxRunes("field", "abé", 3) }},
+		{name: "MaxRunes long", wantCode: CodeTooLong, rule: func(v *Validator) { v.MaxRunes("field", "abéd", 3) }},
+		{name: "Pattern", rule: func(v *Validator) { v.Pattern("field", "abc", lettersRX, "must only contain letters") }},
+		{name: "Pattern mismatch", wantCode: CodeInvalidFormat, rule: func(v *Validator) { v.Pattern("field", "ab1", lettersRX, "must only contain letters") }},
+		{name: "CrossField", rule: func(v *Validator) { v.CrossField(true, "field", "must be sent with other") }},
+		{name: "CrossField invalid", wantCode: CodeInvalidCombination, rule: func(v *Validator) { v.CrossField(false, "field", "must be sent with other") }},
+		{name: "InRange at min", rule: func(v *Validator) { InRange(v, "field", 1, 1, 10) }},
+		{name: "InRange at max", rule: func(v *Validator) { InRange(v, "field", 10.0, 1, 10) }},
+		{name: "InRange below", wantCode: CodeOutOfRange, rule: func(v *Validator) { InRange(v, "field", 0, 1, 10) }},
+		{name: "InRange above", wantCode: CodeOutOfRange, rule: func(v *Validator) { InRange(v, "field", int64(11), 1, 10) }},
+		{name: "Min", rule: func(v *Validator) { Min(v, "field", int32(0), 0) }},
+		{name: "Min below", wantCode: CodeOutOfRange, rule: func(v *Validator) { Min(v, "field", int32(-1), 0) }},
+		{name: "OneOf", rule: func(v *Validator) { OneOf(v, "field", "b", "a", "b") }},
+		{name: "OneOf not permitted", wantCode: CodeNotPermitted, rule: func(v *Validator) { OneOf(v, "field", "c", "a", "b") }},
+		{name: "OneOf none permitted", wantCode: CodeNotPermitted, rule: func(v *Validator) { OneOf(v, "field", 1) }},
+		{name: "Date", rule: func(v *Validator) { v.Date("field", "2026-10-19", time.DateOnly) }},
+		{name: "Date invalid", wantCode: CodeInvalidDate, rule: func(v *Validator) { v.Date("field", "19/10/2026", time.DateOnly) }},
+		{name: "DateBetween", rule: func(v *Validator) { v.DateBetween("field", day("2026-10-19"), day("2026-10-19"), day("2026-10-19")) }},
+		{name: "DateBetween open", rule: func(v *Validator) { v.DateBetween("field", day("2026-10-19"), time.Time{}, time.Time{}) }},
+		{name: "DateBetween before", wantCode: CodeInvalidDate, rule: func(v *Validator) { v.DateBetween("field", day("2026-10-18"), day("2026-10-19"), time.Time{}) }},
+		{name: "DateBetween after", wantCode: CodeInvalidDate, rule: func(v *Validator) { v.DateBetween("field", day("2026-10-20"), time.Time{}, day("2026-10-19")) }},
+		{name: "NotZero", rule: func(v *Validator) { NotZero(v, "field", 0.5) }},
+		{name: "NotZero zero", wantCode: CodeRequired, rule: func(v *Validator) { NotZero(v, "field", 0) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkRule(t, "field", tt.wantCode, tt.rule)
+		})
+	}
+}
+
+func TestDateReturnsZeroTimeOnError(t *testing.T) {
+	v := New()
+	if date := v.Date("field", "not a date", time.DateOnly); !date.IsZero() {
+		t.Errorf("Date() = %s, want the zero time", date)
+	}
+	if date := v.Date("other", "2026-10-19", time.DateOnly); date.Format(time.DateOnly) != "2026-10-19" {
+		t.Errorf("Date() = %s, want 2026-10-19", date)
+	}
+}
@@ -4,14 +4,46 @@ import (
 	"regexp"
 )
 
-// Define a new Validator type which contains a map of validation errors.
+// Machine-readable error codes. Every error added to a Validator carries one of these
+// alongside its human readable message, so that clients can localize the messages.
+const (
+	CodeInvalid            = "INVALID"
+	CodeRequired           = "REQUIRED"
+	CodeTooShort           = "TOO_SHORT"
+	CodeTooLong            = "TOO_LONG"
+	CodeOutOfRange         = "OUT_OF_RANGE"
+	CodeInvalidFormat      = "INVALID_FORMAT"
+	CodeNotPermitted       = "NOT_PERMITTED"
+	CodeInvalidDate        = "INVALID_DATE"
+	CodeInvalidNationalID  = "INVALID_NATIONAL_ID"
+	CodeInvalidPhoneNumber = "INVALID_PHONE_NUMBER"
+	CodeInvalidCombination = "INVALID_COMBINATION"
+	CodeNotFound           = "NOT_FOUND"
+	CodeAlreadyExists      = "ALREADY_EXISTS"
+	CodeEnrollmentClosed   = "ENROLLMENT_CLOSED"
+	CodeCapacityReached    = "CAPACITY_REACHED"
+)
+
+// EmailRX is a regular expression for sanity checking the format of email addresses, as
+// recommended by the W3C and Web Hypertext Application Technology Working Group.
+var EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
+
+// Define a new Validator type which contains a map of validation errors, and a
+// matching map of the machine-readable code for each of those errors.
 type Validator struct {
 	Errors map[string]string
+	Codes  map[string]string
 }
 
-// New is a helper which creates a new Validator instance with an empty errors map.
+// FieldError is a single validation error with its code and message
+type FieldError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// New is a helper which creates a new Validator instance with empty errors and codes maps.
 func New() *Validator {
-	return &Validator{Errors: make(map[string]string)}
+	return &Validator{Errors: make(map[string]string), Codes: make(map[string]string)}
 }
 
 // Valid returns true if the errors map doesn't contain any entries.
@@ -20,10 +52,17 @@ func (v *Validator) Valid() bool {
 }
 
 // AddError adds an error message to the map (so long as no entry already exists for
-// the given key).
+// the given key). The error is recorded with the generic INVALID code.
 func (v *Validator) AddError(key, message string) {
+	v.AddFieldError(key, CodeInvalid, message)
+}
+
+// AddFieldError adds an error message and its code to the maps (so long as no entry
+// already exists for the given key).
+func (v *Validator) AddFieldError(key, code, message string) {
 	if _, exists := v.Errors[key]; !exists {
 		v.Errors[key] = message
+		v.Codes[key] = code
 	}
 }
 
@@ -34,6 +73,22 @@ func (v *Validator) Check(ok bool, key, message string) {
 	}
 }
 
+// CheckCode adds an error message and code to the maps only if a validation check is not 'ok'.
+func (v *Validator) CheckCode(ok bool, key, code, message string) {
+	if !ok {
+		v.AddFieldError(key, code, message)
+	}
+}
+
+// FieldErrors returns the errors together with their codes, keyed by field name.
+func (v *Validator) FieldErrors() map[string]FieldError {
+	fieldErrors := make(map[string]FieldError, len(v.Errors))
+	for key, message := range v.Errors {
+		fieldErrors[key] = FieldError{Code: v.Codes[key], Message: message}
+	}
+	return fieldErrors
+}
+
 // Generic function which returns true if a specific value is in a list.
 func PermittedValue[T comparable](value T, permittedValues ...T) bool {
 	for i := range permittedValues {
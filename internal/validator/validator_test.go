@@ -0,0 +1,36 @@
+package validator
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidatorKeepsFirstError(t *testing.T) {
+	v := New()
+	v.CheckCode(true, "name", CodeRequired, "must be provided")
+	if !v.Valid() {
+		t.Fatalf("errors = %v after a passing check", v.Errors)
+	}
+	v.Required("name", "")
+	v.MaxLength("name", "", -1)
+	v.Check(false, "age", "must be a number")
+	want := map[string]FieldError{
+		"name": {Code: CodeRequired, Message: "must be provided"},
+		"age":  {Code: CodeInvalid, Message: "must be a number"},
+	}
+	if got := v.FieldErrors(); !reflect.DeepEqual(got, want) {
+		t.Errorf("FieldErrors() = %v, want %v", got, want)
+	}
+	if v.Valid() {
+		t.Error("Valid() = true with errors")
+	}
+}
+
+func TestUnique(t *testing.T) {
+	if !Unique([]string{"a", "b"}) || !Unique([]int{}) {
+		t.Error("Unique() = false for unique values")
+	}
+	if Unique([]string{"a", "b", "a"}) {
+		t.Error("Unique() = true for a repeated value")
+	}
+}
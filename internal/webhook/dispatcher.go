@@ -0,0 +1,205 @@
+// Package webhook delivers the events written to the outbox to the webhook subscriptions,
+// signing each payload with the subscription's secret and retrying failed deliveries with an
+// exponential backoff until they are delivered or dead.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Blue-Davinci/SocialAid/internal/data"
+	"go.uber.org/zap"
+)
+
+const (
+	// leaseMargin is how long a claimed delivery stays leased beyond the request timeout, to
+	// cover recording the attempt
+	leaseMargin = 30 * time.Second
+	// maxErrorBodyBytes is how much of a failed response's body we keep in the delivery log
+	maxErrorBodyBytes = 512
+)
+
+// Config holds the dispatcher's settings
+type Config struct {
+	// EncryptionKey decrypts the subscriptions' secrets
+	EncryptionKey string
+	// PollInterval is how often we look for new events and due deliveries
+	PollInterval time.Duration
+	// BatchSize bounds the events fanned out and the deliveries sent per poll. The deliveries
+	// of a batch are sent concurrently.
+	BatchSize int32
+	// Timeout bounds each delivery request
+	Timeout time.Duration
+	// MaxAttempts is how often we try a delivery before it is dead
+	MaxAttempts int
+	// BackoffBase is the delay after the first failed attempt, doubling after each failure up
+	// to BackoffMax
+	BackoffBase time.Duration
+	BackoffMax  time.Duration
+}
+
+// Dispatcher fans the outbox out to the subscriptions and sends the deliveries. Several
+// dispatchers may run against the same database, they skip the events and deliveries
+// another one holds.
+type Dispatcher struct {
+	store  data.WebhookStore
+	logger *zap.Logger
+	config Config
+	// Client sends the deliveries. Redirects are not followed, a 3xx counts as a failure.
+	Client *http.Client
+}
+
+// NewDispatcher() returns a dispatcher with its own HTTP client
+func NewDispatcher(store data.WebhookStore, logger *zap.Logger, cfg Config) *Dispatcher {
+	return &Dispatcher{
+		store:  store,
+		logger: logger,
+		config: cfg,
+		Client: &http.Client{
+			Timeout: cfg.Timeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// Run() polls until the context is cancelled. Deliveries in flight when it is cancelled are
+// abandoned and sent again once their lease runs out.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.config.PollInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := d.RunOnce(ctx); err != nil && ctx.Err() == nil {
+			d.logger.Error("error dispatching webhooks", zap.String("error", err.Error()))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce() fans out a batch of events from the outbox, then sends a batch of the due
+// deliveries and waits for them. It returns how many deliveries were attempted.
+func (d *Dispatcher) RunOnce(ctx context.Context) (int, error) {
+	if _, err := d.store.FanOutOutboxEvents(ctx, d.config.BatchSize); err != nil {
+		return 0, fmt.Errorf("fanning out outbox events: %w", err)
+	}
+	leaseUntil := time.Now().Add(d.config.Timeout + leaseMargin)
+	deliveries, err := d.store.ClaimDueWebhookDeliveries(ctx, d.config.BatchSize, leaseUntil, d.config.EncryptionKey)
+	if err != nil {
+		return 0, fmt.Errorf("claiming webhook deliveries: %w", err)
+	}
+	var wg sync.WaitGroup
+	for _, delivery := range deliveries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.deliver(ctx, delivery)
+		}()
+	}
+	wg.Wait()
+	return len(deliveries), nil
+}
+
+// deliver() sends a delivery and records the outcome in the delivery log
+func (d *Dispatcher) deliver(ctx context.Context, delivery *data.WebhookDelivery) {
+	attempt := &data.WebhookDeliveryAttempt{
+		DeliveryID: delivery.ID,
+		Attempt:    delivery.Attempts + 1,
+	}
+	start := time.Now()
+	attempt.StatusCode, attempt.Error = d.send(ctx, delivery)
+	attempt.Duration = time.Since(start)
+	// a delivery cut short by shutdown isn't a failure of the receiver, leave it to be sent
+	// again when the lease runs out
+	if ctx.Err() != nil {
+		return
+	}
+	status, nextAttemptAt := data.WebhookDeliveryDelivered, time.Now()
+	if attempt.Error != "" {
+		status = data.WebhookDeliveryPending
+		nextAttemptAt = nextAttemptAt.Add(d.backoff(int(attempt.Attempt)))
+		if int(attempt.Attempt) >= d.config.MaxAttempts {
+			status = data.WebhookDeliveryDead
+		}
+	}
+	if err := d.store.RecordWebhookDeliveryAttempt(ctx, attempt, status, nextAttemptAt); err != nil {
+		d.logger.Error("error recording webhook delivery attempt",
+			zap.Int64("delivery_id", delivery.ID),
+			zap.String("error", err.Error()))
+		return
+	}
+	switch status {
+	case data.WebhookDeliveryDead:
+		d.logger.Warn("webhook delivery is dead",
+			zap.Int64("delivery_id", delivery.ID),
+			zap.Int32("subscription_id", delivery.SubscriptionID),
+			zap.Int32("attempts", attempt.Attempt),
+			zap.String("error", attempt.Error))
+	case data.WebhookDeliveryPending:
+		d.logger.Info("webhook delivery failed, will retry",
+			zap.Int64("delivery_id", delivery.ID),
+			zap.Int32("subscription_id", delivery.SubscriptionID),
+			zap.Int32("attempt", attempt.Attempt),
+			zap.Time("next_attempt_at", nextAttemptAt),
+			zap.String("error", attempt.Error))
+	}
+}
+
+// send() POSTs the signed event to the subscriber, returning the response's status code, 0 if
+// there was none, and an error message unless it was a 2xx
+func (d *Dispatcher) send(ctx context.Context, delivery *data.WebhookDelivery) (int, string) {
+	body, err := json.Marshal(delivery.Event)
+	if err != nil {
+		return 0, err.Error()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err.Error()
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "SocialAid-Webhooks/1.0")
+	req.Header.Set(EventHeader, delivery.Event.Type)
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(SignatureHeader, Sign(delivery.Secret, time.Now(), body))
+	res, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err.Error()
+	}
+	defer res.Body.Close()
+	responseBody, err := io.ReadAll(io.LimitReader(res.Body, maxErrorBodyBytes))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return res.StatusCode, err.Error()
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Sprintf("unexpected status %d: %s", res.StatusCode, bytes.TrimSpace(responseBody))
+	}
+	return res.StatusCode, ""
+}
+
+// backoff() returns the delay after the attempt failed: BackoffBase doubled for each earlier
+// failure, capped at BackoffMax, plus up to 10% jitter so that the retries of a batch
+// don't all hit a recovering receiver at once
+func (d *Dispatcher) backoff(attempt int) time.Duration {
+	delay := d.config.BackoffBase
+	for i := 1; i < attempt && delay < d.config.BackoffMax; i++ {
+		delay *= 2
+	}
+	delay = min(delay, d.config.BackoffMax)
+	if jitter := int64(delay / 10); jitter > 0 {
+		delay += time.Duration(rand.Int64N(jitter))
+	}
+	return delay
+}
@@ -0,0 +1,223 @@
+package webhook
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Blue-Davinci/SocialAid/internal/data"
+	"go.uber.org/zap"
+)
+
+const testSecret = "whsec_test"
+
+// testReceiver is a subscriber that answers each delivery with the next of its status codes,
+// repeating the last one, and records what it was sent
+type testReceiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []receivedDelivery
+}
+
+type receivedDelivery struct {
+	header http.Header
+	body   []byte
+	at     time.Time
+}
+
+func (rc *testReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.requests = append(rc.requests, receivedDelivery{header: r.Header.Clone(), body: body, at: time.Now()})
+	status := rc.statuses[min(len(rc.requests), len(rc.statuses))-1]
+	if status >= 300 && status < 400 {
+		w.Header().Set("Location", "/moved")
+	}
+	w.WriteHeader(status)
+}
+
+func (rc *testReceiver) received() []receivedDelivery {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return append([]receivedDelivery{}, rc.requests...)
+}
+
+// newTestDispatcher() returns a dispatcher over the in-memory store with one subscription,
+// pointed at a receiver answering with the statuses, and one program.created event in the
+// outbox
+func newTestDispatcher(t *testing.T, cfg Config, statuses ...int) (*Dispatcher, data.WebhookStore, *testReceiver) {
+	t.Helper()
+	receiver := &testReceiver{statuses: statuses}
+	mux := http.NewServeMux()
+	mux.Handle("/hook", receiver)
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the dispatcher followed a redirect")
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	cfg.EncryptionKey = hex.EncodeToString(make([]byte, 32))
+	cfg.BatchSize = 10
+	cfg.Timeout = 5 * time.Second
+	models := data.NewMemoryModels()
+	ctx := context.Background()
+	subscription := &data.WebhookSubscription{URL: server.URL + "/hook", Secret: testSecret}
+	if err := models.Webhook.CreateWebhookSubscription(ctx, subscription, cfg.EncryptionKey); err != nil {
+		t.Fatal(err)
+	}
+	program := &data.Program{Name: "Inua Jamii", Category: "cash transfer", Description: "stipend"}
+	if err := models.Program.CreateNewProgram(ctx, program); err != nil {
+		t.Fatal(err)
+	}
+	return NewDispatcher(models.Webhook, zap.NewNop(), cfg), models.Webhook, receiver
+}
+
+// onlyDelivery() returns the single delivery of the subscription
+func onlyDelivery(t *testing.T, store data.WebhookStore) *data.WebhookDeliveryRecord {
+	t.Helper()
+	deliveries, err := store.GetWebhookDeliveries(context.Background(), 1, "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 {
+		t.Fatalf("got %d deliveries, want 1", len(deliveries))
+	}
+	return deliveries[0]
+}
+
+// runOnce() runs the dispatcher once, failing the test unless it attempted want deliveries
+func runOnce(t *testing.T, d *Dispatcher, want int) {
+	t.Helper()
+	attempted, err := d.RunOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempted != want {
+		t.Fatalf("attempted %d deliveries, want %d", attempted, want)
+	}
+}
+
+func TestDispatcherSignsDeliveries(t *testing.T) {
+	d, store, receiver := newTestDispatcher(t, Config{MaxAttempts: 3, BackoffBase: time.Minute, BackoffMax: time.Hour}, http.StatusNoContent)
+	runOnce(t, d, 1)
+
+	received := receiver.received()
+	if len(received) != 1 {
+		t.Fatalf("receiver got %d requests, want 1", len(received))
+	}
+	delivery := received[0]
+	if err := Verify(testSecret, delivery.header.Get(SignatureHeader), delivery.body, 5*time.Minute, time.Now()); err != nil {
+		t.Errorf("Verify() = %v", err)
+	}
+	var event data.WebhookEvent
+	if err := json.Unmarshal(delivery.body, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != data.EventProgramCreated || delivery.header.Get(EventHeader) != data.EventProgramCreated {
+		t.Errorf("event type = %q, header %q, want %q", event.Type, delivery.header.Get(EventHeader), data.EventProgramCreated)
+	}
+	record := onlyDelivery(t, store)
+	if delivery.header.Get(DeliveryHeader) != strconv.FormatInt(record.ID, 10) {
+		t.Errorf("%s = %q, want %d", DeliveryHeader, delivery.header.Get(DeliveryHeader), record.ID)
+	}
+	if record.Status != data.WebhookDeliveryDelivered || record.Attempts != 1 || record.LastStatusCode != http.StatusNoContent {
+		t.Errorf("delivery = %+v", record)
+	}
+	// a delivered delivery isn't sent again
+	runOnce(t, d, 0)
+}
+
+func TestDispatcherRetriesWithBackoff(t *testing.T) {
+	backoff := 50 * time.Millisecond
+	d, store, receiver := newTestDispatcher(t, Config{MaxAttempts: 3, BackoffBase: backoff, BackoffMax: time.Hour},
+		http.StatusInternalServerError, http.StatusOK)
+	runOnce(t, d, 1)
+
+	record := onlyDelivery(t, store)
+	if record.Status != data.WebhookDeliveryPending || record.Attempts != 1 || record.LastStatusCode != http.StatusInternalServerError {
+		t.Fatalf("delivery = %+v", record)
+	}
+	firstAttempt := receiver.received()[0].at
+	if delay := record.NextAttemptAt.Sub(firstAttempt); delay < backoff || delay > backoff+backoff/10+time.Second {
+		t.Errorf("next attempt in %s, want about %s", delay, backoff)
+	}
+	// not due until the backoff has passed
+	runOnce(t, d, 0)
+
+	time.Sleep(time.Until(record.NextAttemptAt) + 10*time.Millisecond)
+	runOnce(t, d, 1)
+	record = onlyDelivery(t, store)
+	if record.Status != data.WebhookDeliveryDelivered || record.Attempts != 2 {
+		t.Errorf("delivery = %+v", record)
+	}
+	attempts, err := store.GetWebhookDeliveryAttempts(context.Background(), record.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attempts) != 2 || attempts[0].StatusCode != http.StatusInternalServerError || attempts[0].Error == "" || attempts[1].Error != "" {
+		t.Errorf("attempts = %+v", attempts)
+	}
+}
+
+func TestDispatcherMarksDeliveryDead(t *testing.T) {
+	maxAttempts := 3
+	d, store, receiver := newTestDispatcher(t, Config{MaxAttempts: maxAttempts, BackoffBase: time.Millisecond, BackoffMax: time.Millisecond},
+		http.StatusServiceUnavailable)
+	runOnce(t, d, 1)
+	for attempt := 2; attempt <= maxAttempts; attempt++ {
+		record := onlyDelivery(t, store)
+		if record.Status != data.WebhookDeliveryPending {
+			t.Fatalf("delivery = %+v after %d attempts", record, attempt-1)
+		}
+		time.Sleep(time.Until(record.NextAttemptAt) + 5*time.Millisecond)
+		runOnce(t, d, 1)
+	}
+	record := onlyDelivery(t, store)
+	if record.Status != data.WebhookDeliveryDead || int(record.Attempts) != maxAttempts {
+		t.Errorf("delivery = %+v", record)
+	}
+	// dead deliveries are never sent again
+	time.Sleep(5 * time.Millisecond)
+	runOnce(t, d, 0)
+	if got := len(receiver.received()); got != maxAttempts {
+		t.Errorf("receiver got %d requests, want %d", got, maxAttempts)
+	}
+}
+
+func TestDispatcherTreatsRedirectsAsFailures(t *testing.T) {
+	d, store, _ := newTestDispatcher(t, Config{MaxAttempts: 3, BackoffBase: time.Minute, BackoffMax: time.Hour}, http.StatusFound)
+	runOnce(t, d, 1)
+	record := onlyDelivery(t, store)
+	if record.Status != data.WebhookDeliveryPending || record.LastStatusCode != http.StatusFound || record.LastError == "" {
+		t.Errorf("delivery = %+v", record)
+	}
+}
+
+func TestDispatcherBackoff(t *testing.T) {
+	d := &Dispatcher{config: Config{BackoffBase: 30 * time.Second, BackoffMax: 5 * time.Minute}}
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{attempt: 1, want: 30 * time.Second},
+		{attempt: 2, want: time.Minute},
+		{attempt: 3, want: 2 * time.Minute},
+		{attempt: 4, want: 4 * time.Minute},
+		{attempt: 5, want: 5 * time.Minute},
+		{attempt: 20, want: 5 * time.Minute},
+	}
+	for _, tt := range tests {
+		// up to 10% jitter is added
+		if got := d.backoff(tt.attempt); got < tt.want || got >= tt.want+tt.want/10 {
+			t.Errorf("backoff(%d) = %s, want %s plus up to 10%%", tt.attempt, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The headers sent with every delivery
+const (
+	// SignatureHeader carries the timestamp and HMAC-SHA256 signature of the delivery, see Sign()
+	SignatureHeader = "X-SocialAid-Signature"
+	// EventHeader carries the event type, e.g household.created
+	EventHeader = "X-SocialAid-Event"
+	// DeliveryHeader carries the delivery ID, which is the same for every attempt
+	DeliveryHeader = "X-SocialAid-Delivery"
+)
+
+var (
+	ErrInvalidSignature = errors.New("webhook signature is invalid")
+	ErrSignatureExpired = errors.New("webhook signature timestamp is outside the tolerance")
+)
+
+// Sign() returns the value of the signature header for a body sent at timestamp, in the form
+// t=<unix seconds>,v1=<hex HMAC-SHA256 of "<unix seconds>.<body>" keyed with the secret>.
+// Signing the timestamp along with the body lets receivers reject replayed deliveries.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	unix := strconv.FormatInt(timestamp.Unix(), 10)
+	return fmt.Sprintf("t=%s,v1=%s", unix, hex.EncodeToString(signature(secret, unix, body)))
+}
+
+// Verify() checks the signature header of a delivery received at now, rejecting signatures
+// older or newer than the tolerance. Receivers written in Go can use it as is, it also
+// documents the checks receivers in other languages should make.
+func Verify(secret, header string, body []byte, tolerance time.Duration, now time.Time) error {
+	var unix string
+	var signatures [][]byte
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "t":
+			unix = value
+		case "v1":
+			if sig, err := hex.DecodeString(value); err == nil {
+				signatures = append(signatures, sig)
+			}
+		}
+	}
+	seconds, err := strconv.ParseInt(unix, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return ErrInvalidSignature
+	}
+	if age := now.Sub(time.Unix(seconds, 0)); age > tolerance || age < -tolerance {
+		return ErrSignatureExpired
+	}
+	expected := signature(secret, unix, body)
+	for _, sig := range signatures {
+		if hmac.Equal(sig, expected) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+// signature() computes the HMAC-SHA256 of "<unix>.<body>" keyed with the secret
+func signature(secret, unix string, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unix))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
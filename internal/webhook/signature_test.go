@@ -0,0 +1,59 @@
+package webhook
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSignVerify(t *testing.T) {
+	const secret = "whsec_test"
+	body := []byte(`{"id":1,"type":"program.created"}`)
+	signedAt := time.Unix(1760000000, 0)
+	header := Sign(secret, signedAt, body)
+	tolerance := 5 * time.Minute
+
+	tests := []struct {
+		name   string
+		secret string
+		header string
+		body   []byte
+		now    time.Time
+		want   error
+	}{
+		{name: "valid", secret: secret, header: header, body: body, now: signedAt},
+		{name: "at the tolerance", secret: secret, header: header, body: body, now: signedAt.Add(tolerance)},
+		{name: "too old", secret: secret, header: header, body: body, now: signedAt.Add(tolerance + time.Second), want: ErrSignatureExpired},
+		{name: "from the future", secret: secret, header: header, body: body, now: signedAt.Add(-tolerance - time.Second), want: ErrSignatureExpired},
+		{name: "tampered body", secret: secret, header: header, body: []byte(`{"id":2,"type":"program.created"}`), now: signedAt, want: ErrInvalidSignature},
+		{name: "wrong secret", secret: "whsec_other", header: header, body: body, now: signedAt, want: ErrInvalidSignature},
+		{
+			// the timestamp is signed, so it can't be moved forward to replay an old delivery
+			name:   "tampered timestamp",
+			secret: secret,
+			header: "t=1760000600," + header[len("t=1760000000,"):],
+			body:   body,
+			now:    signedAt.Add(10 * time.Minute),
+			want:   ErrInvalidSignature,
+		},
+		{
+			// receivers rotating secrets may be sent several signatures
+			name:   "one of several signatures",
+			secret: secret,
+			header: "t=1760000000,v1=00ff," + header[len("t=1760000000,"):],
+			body:   body,
+			now:    signedAt,
+		},
+		{name: "missing timestamp", secret: secret, header: header[len("t=1760000000,"):], body: body, now: signedAt, want: ErrInvalidSignature},
+		{name: "missing signature", secret: secret, header: "t=1760000000", body: body, now: signedAt, want: ErrInvalidSignature},
+		{name: "empty", secret: secret, header: "", body: body, now: signedAt, want: ErrInvalidSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(tt.secret, tt.header, tt.body, tolerance, tt.now)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Verify() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}